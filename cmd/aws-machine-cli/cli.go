@@ -0,0 +1,343 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/gardener/machine-controller-manager-provider-aws/pkg/aws"
+	"github.com/gardener/machine-controller-manager-provider-aws/pkg/spi"
+	v1alpha1 "github.com/gardener/machine-controller-manager/pkg/apis/machine/v1alpha1"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/driver"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/machinecodes/codes"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/machinecodes/status"
+	"github.com/spf13/pflag"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+const usage = `Usage: aws-machine-cli <command> [flags]
+
+Commands:
+  create          Create the machine with the given name
+  plan            Print the launch request of the machine with the given name without creating it
+  status          Get the status of the machine with the given name or provider ID
+  list            List the machines of the machine class
+  delete          Delete the machine with the given name or provider ID
+  volumes         Get the volume IDs of the given persistent volumes
+  validate        Check the machine class against the resources in AWS
+  permissions     Check the EC2 permissions of the credentials with dry-run calls
+  orphans         Report or delete the resources of the machine class that don't belong to the given machines
+  console-output  Print the serial console output of the VM with the given provider ID
+
+Run 'aws-machine-cli <command> --help' for the flags of a command.
+`
+
+// command is a subcommand calling one operation of the driver
+type command struct {
+	// machine is true if the command acts on a single machine
+	machine bool
+	// volumes is true if the command reads persistent volumes
+	volumes bool
+	// machines is true if the command reads the list of existing machines
+	machines bool
+	// instance is true if the command acts on the VM of a provider ID, only the secret is read
+	instance bool
+	call     func(ctx context.Context, d *aws.Driver, in *input) (interface{}, error)
+}
+
+var commands = map[string]command{
+	"create": {machine: true, call: func(ctx context.Context, d *aws.Driver, in *input) (interface{}, error) {
+		return d.CreateMachine(ctx, &driver.CreateMachineRequest{Machine: in.machine, MachineClass: in.machineClass, Secret: in.secret})
+	}},
+	"plan": {machine: true, call: func(ctx context.Context, d *aws.Driver, in *input) (interface{}, error) {
+		return d.PlanMachine(ctx, &driver.CreateMachineRequest{Machine: in.machine, MachineClass: in.machineClass, Secret: in.secret})
+	}},
+	"status": {machine: true, call: func(ctx context.Context, d *aws.Driver, in *input) (interface{}, error) {
+		return d.GetMachineStatus(ctx, &driver.GetMachineStatusRequest{Machine: in.machine, MachineClass: in.machineClass, Secret: in.secret})
+	}},
+	"list": {call: func(ctx context.Context, d *aws.Driver, in *input) (interface{}, error) {
+		return d.ListMachines(ctx, &driver.ListMachinesRequest{MachineClass: in.machineClass, Secret: in.secret})
+	}},
+	"delete": {machine: true, call: func(ctx context.Context, d *aws.Driver, in *input) (interface{}, error) {
+		return d.DeleteMachine(ctx, &driver.DeleteMachineRequest{Machine: in.machine, MachineClass: in.machineClass, Secret: in.secret})
+	}},
+	"validate": {call: func(ctx context.Context, d *aws.Driver, in *input) (interface{}, error) {
+		errs, err := d.ValidateMachineClass(ctx, in.machineClass, in.secret)
+		if err != nil || len(errs) == 0 {
+			return nil, err
+		}
+		var problems []string
+		for _, e := range errs {
+			problems = append(problems, e.Error())
+		}
+		return problems, status.Error(codes.InvalidArgument, fmt.Sprintf("MachineClass %s doesn't match the resources in AWS", in.machineClass.Name))
+	}},
+	"permissions": {call: func(ctx context.Context, d *aws.Driver, in *input) (interface{}, error) {
+		response, err := d.CheckPermissions(ctx, &aws.PermissionCheckRequest{MachineClass: in.machineClass, Secret: in.secret})
+		if err != nil {
+			return nil, err
+		}
+		if denied := response.Denied(); len(denied) > 0 {
+			return response, status.Error(codes.PermissionDenied, fmt.Sprintf("The credentials of MachineClass %s are denied %s", in.machineClass.Name, strings.Join(denied, ", ")))
+		}
+		return response, nil
+	}},
+	"orphans": {machines: true, call: func(ctx context.Context, d *aws.Driver, in *input) (interface{}, error) {
+		return d.CollectOrphans(ctx, &aws.OrphanCollectionRequest{MachineClass: in.machineClass, Secret: in.secret, Machines: in.machines})
+	}},
+	"console-output": {instance: true, call: func(ctx context.Context, d *aws.Driver, in *input) (interface{}, error) {
+		return d.GetConsoleOutput(ctx, &aws.ConsoleOutputRequest{ProviderID: in.providerID, Secret: in.secret})
+	}},
+	"volumes": {volumes: true, call: func(ctx context.Context, d *aws.Driver, in *input) (interface{}, error) {
+		return d.GetVolumeIDs(ctx, &driver.GetVolumeIDsRequest{PVSpecs: in.pvSpecs})
+	}},
+}
+
+// input holds the objects passed to the driver
+type input struct {
+	machine      *v1alpha1.Machine
+	machineClass *v1alpha1.MachineClass
+	secret       *corev1.Secret
+	pvSpecs      []*corev1.PersistentVolumeSpec
+	machines     []*v1alpha1.Machine
+	providerID   string
+}
+
+// output is printed as JSON or YAML after each command
+type output struct {
+	// Code is the machine code of the driver's response, OK if it succeeded
+	Code string `json:"code"`
+	// Message is the message of the error returned by the driver
+	Message string `json:"message,omitempty"`
+	// Response is the response returned by the driver, or the details of the error
+	Response interface{} `json:"response,omitempty"`
+}
+
+// run executes the command given by args and returns the exit code
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	if args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		fmt.Fprint(stdout, usage)
+		return 0
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", args[0], usage)
+		return 2
+	}
+
+	var (
+		machineClassFile string
+		secretFile       string
+		machineName      string
+		namespace        string
+		providerID       string
+		pvFiles          []string
+		machinesFile     string
+		timeout          time.Duration
+		format           string
+		driverOptions    = aws.NewDriverOptions()
+		pluginSPI        = &spi.PluginSPIImpl{}
+	)
+	fs := pflag.NewFlagSet("aws-machine-cli "+args[0], pflag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&machineClassFile, "machine-class", "", "YAML file of the MachineClass")
+	fs.StringVar(&secretFile, "secret", "", "YAML file of the secret referenced by the MachineClass")
+	if cmd.machine {
+		fs.StringVar(&machineName, "machine-name", "", "Name of the machine")
+		fs.StringVar(&namespace, "namespace", metav1.NamespaceDefault, "Namespace of the machine")
+		fs.StringVar(&providerID, "provider-id", "", "Provider ID of the machine, if it's already known")
+	}
+	if cmd.instance {
+		fs.StringVar(&providerID, "provider-id", "", "Provider ID of the machine")
+	}
+	if cmd.volumes {
+		fs.StringSliceVar(&pvFiles, "pv", nil, "YAML files of the PersistentVolumes")
+	}
+	if cmd.machines {
+		fs.StringVar(&machinesFile, "machines", "", "YAML file of the list of all existing machines, e.g. the output of 'kubectl get machines -o yaml'")
+	}
+	fs.DurationVar(&timeout, "timeout", 5*time.Minute, "Timeout of the command")
+	fs.StringVarP(&format, "output", "o", "json", "Output format, either json or yaml")
+	driverOptions.AddFlags(fs)
+	pluginSPI.EndpointOptions.AddFlags(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		if err == pflag.ErrHelp {
+			return 0
+		}
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 2
+	}
+
+	if format != "json" && format != "yaml" {
+		fmt.Fprintf(stderr, "unknown output format %q\n", format)
+		return 2
+	}
+
+	if cmd.instance && providerID == "" {
+		fmt.Fprintln(stderr, "--provider-id is required")
+		return 2
+	}
+	in, err := readInput(cmd, machineClassFile, secretFile, pvFiles, machinesFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 2
+	}
+	in.providerID = providerID
+	if cmd.machine {
+		if machineName == "" {
+			fmt.Fprintln(stderr, "--machine-name is required")
+			return 2
+		}
+		in.machine = &v1alpha1.Machine{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "machine.sapcloud.io/v1alpha1", Kind: "Machine"},
+			ObjectMeta: metav1.ObjectMeta{Name: machineName, Namespace: namespace},
+			Spec:       v1alpha1.MachineSpec{ProviderID: providerID},
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	response, err := cmd.call(ctx, aws.NewDriver(pluginSPI, driverOptions), in)
+
+	out := output{Code: codes.OK.String(), Response: response}
+	if err != nil {
+		out = output{Code: codes.Unknown.String(), Message: err.Error(), Response: response}
+		if s, ok := status.FromError(err); ok {
+			out = output{Code: s.Code().String(), Message: s.Message(), Response: response}
+		}
+	}
+	if err := printOutput(stdout, format, out); err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 1
+	}
+	if out.Code != codes.OK.String() {
+		return 1
+	}
+	return 0
+}
+
+// printOutput prints the output as indented JSON or as YAML
+func printOutput(w io.Writer, format string, out output) error {
+	if format == "yaml" {
+		data, err := sigsyaml.Marshal(out)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
+
+// readInput reads the YAML files needed by the command
+func readInput(cmd command, machineClassFile, secretFile string, pvFiles []string, machinesFile string) (*input, error) {
+	in := &input{}
+	if cmd.volumes {
+		if len(pvFiles) == 0 {
+			return nil, fmt.Errorf("--pv is required")
+		}
+		for _, file := range pvFiles {
+			pv := &corev1.PersistentVolume{}
+			if err := readYAML(file, pv); err != nil {
+				return nil, err
+			}
+			in.pvSpecs = append(in.pvSpecs, &pv.Spec)
+		}
+		return in, nil
+	}
+
+	if cmd.instance {
+		if secretFile == "" {
+			return nil, fmt.Errorf("--secret is required")
+		}
+		secret, err := readSecret(secretFile)
+		if err != nil {
+			return nil, err
+		}
+		in.secret = secret
+		return in, nil
+	}
+
+	if machineClassFile == "" || secretFile == "" {
+		return nil, fmt.Errorf("--machine-class and --secret are required")
+	}
+	if cmd.machines && machinesFile == "" {
+		return nil, fmt.Errorf("--machines is required")
+	}
+	in.machineClass = &v1alpha1.MachineClass{}
+	if err := readYAML(machineClassFile, in.machineClass); err != nil {
+		return nil, err
+	}
+	secret, err := readSecret(secretFile)
+	if err != nil {
+		return nil, err
+	}
+	in.secret = secret
+
+	if cmd.machines {
+		machineList := &v1alpha1.MachineList{}
+		if err := readYAML(machinesFile, machineList); err != nil {
+			return nil, err
+		}
+		in.machines = make([]*v1alpha1.Machine, 0, len(machineList.Items))
+		for i := range machineList.Items {
+			in.machines = append(in.machines, &machineList.Items[i])
+		}
+	}
+	return in, nil
+}
+
+// readSecret reads the secret from the YAML file
+func readSecret(file string) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	if err := readYAML(file, secret); err != nil {
+		return nil, err
+	}
+	// The API server merges stringData into data, do the same for secrets read from files
+	if len(secret.StringData) > 0 && secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	for key, value := range secret.StringData {
+		secret.Data[key] = []byte(value)
+	}
+	return secret, nil
+}
+
+// readYAML decodes the YAML or JSON file into the given object
+func readYAML(file string, obj interface{}) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if err := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096).Decode(obj); err != nil {
+		return fmt.Errorf("failed to decode %s: %v", file, err)
+	}
+	return nil
+}
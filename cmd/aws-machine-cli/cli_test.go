@@ -0,0 +1,291 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/gardener/machine-controller-manager-provider-aws/pkg/fakeec2"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	machineClassYAML = `apiVersion: machine.sapcloud.io/v1alpha1
+kind: MachineClass
+metadata:
+  name: test-mc
+  namespace: default
+providerSpec:
+  ami: ami-123456789
+  blockDevices:
+  - ebs:
+      volumeSize: 50
+      volumeType: gp2
+  iam:
+    name: test-iam
+  keyName: test-ssh-publickey
+  machineType: m4.large
+  networkInterfaces:
+  - securityGroupIDs:
+    - sg-00002132323
+    subnetID: subnet-123456
+  region: eu-west-1
+  tags:
+    kubernetes.io/cluster/shoot--test: "1"
+    kubernetes.io/role/test: "1"
+secretRef:
+  name: test-secret
+  namespace: default
+`
+	secretYAML = `apiVersion: v1
+kind: Secret
+metadata:
+  name: test-secret
+  namespace: default
+stringData:
+  providerAccessKeyId: dummy-id
+  providerSecretAccessKey: dummy-secret
+  userData: dummy-user-data
+`
+	pvYAML = `apiVersion: v1
+kind: PersistentVolume
+metadata:
+  name: test-pv
+spec:
+  awsElasticBlockStore:
+    volumeID: aws://eu-west-1a/vol-0123456789
+`
+)
+
+var _ = Describe("CLI", func() {
+
+	var (
+		dir      string
+		server   *httptest.Server
+		fake     *fakeec2.EC2
+		stdout   *bytes.Buffer
+		stderr   *bytes.Buffer
+		fileArgs []string
+	)
+
+	writeFile := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		Expect(ioutil.WriteFile(path, []byte(content), 0600)).To(Succeed())
+		return path
+	}
+	runCLI := func(args ...string) (int, output) {
+		stdout.Reset()
+		stderr.Reset()
+		exitCode := run(append(args, "--aws-ec2-endpoint="+server.URL, "--aws-iam-endpoint="+server.URL), stdout, stderr)
+		var out output
+		if stdout.Len() > 0 {
+			Expect(json.Unmarshal(stdout.Bytes(), &out)).To(Succeed())
+		}
+		return exitCode, out
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "aws-machine-cli")
+		Expect(err).ToNot(HaveOccurred())
+		fake = fakeec2.New()
+		fake.AddImage(&ec2.Image{ImageId: aws.String("ami-123456789")})
+		fake.AddInstanceType(&ec2.InstanceTypeInfo{InstanceType: aws.String("m4.large")})
+		fake.AddSubnet(&ec2.Subnet{SubnetId: aws.String("subnet-123456"), VpcId: aws.String("vpc-1")})
+		fake.AddSecurityGroup(&ec2.SecurityGroup{GroupId: aws.String("sg-00002132323"), VpcId: aws.String("vpc-1")})
+		fake.AddKeyPair("test-ssh-publickey")
+		fakeIAM := fakeec2.NewIAM()
+		fakeIAM.AddInstanceProfile("test-iam")
+		fakeServer := fakeec2.NewServer(fake)
+		fakeServer.SetIAM(fakeIAM)
+		server = httptest.NewServer(fakeServer)
+		stdout = &bytes.Buffer{}
+		stderr = &bytes.Buffer{}
+		fileArgs = []string{
+			"--machine-class=" + writeFile("machine-class.yaml", machineClassYAML),
+			"--secret=" + writeFile("secret.yaml", secretYAML),
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("should create, inspect, list and delete a machine", func() {
+		exitCode, out := runCLI(append([]string{"create", "--machine-name=machine-0"}, fileArgs...)...)
+		Expect(exitCode).To(Equal(0), stdout.String()+stderr.String())
+		Expect(out.Code).To(Equal("OK"))
+		providerID := out.Response.(map[string]interface{})["ProviderID"].(string)
+		Expect(providerID).To(HavePrefix("aws:///eu-west-1/i-"))
+
+		exitCode, out = runCLI(append([]string{"status", "--machine-name=machine-0"}, fileArgs...)...)
+		Expect(exitCode).To(Equal(0), stdout.String()+stderr.String())
+		Expect(out.Response).To(HaveKeyWithValue("ProviderID", providerID))
+
+		exitCode, out = runCLI(append([]string{"list"}, fileArgs...)...)
+		Expect(exitCode).To(Equal(0), stdout.String()+stderr.String())
+		Expect(out.Response).To(HaveKeyWithValue("MachineList", map[string]interface{}{providerID: "machine-0"}))
+
+		exitCode, out = runCLI(append([]string{"delete", "--machine-name=machine-0", "--provider-id=" + providerID}, fileArgs...)...)
+		Expect(exitCode).To(Equal(0), stdout.String()+stderr.String())
+		Expect(out.Code).To(Equal("OK"))
+
+		exitCode, out = runCLI(append([]string{"status", "--machine-name=machine-0", "--provider-id=" + providerID}, fileArgs...)...)
+		Expect(exitCode).To(Equal(1))
+		Expect(out.Code).To(Equal("NotFound"))
+		Expect(out.Message).ToNot(BeEmpty())
+	})
+
+	It("should print the launch request of a machine without creating it", func() {
+		exitCode, out := runCLI(append([]string{"plan", "--machine-name=machine-0"}, fileArgs...)...)
+		Expect(exitCode).To(Equal(0), stdout.String()+stderr.String())
+		Expect(out.Code).To(Equal("OK"))
+		Expect(out.Response).To(HaveKeyWithValue("ImageId", "ami-123456789"))
+		Expect(out.Response).To(HaveKeyWithValue("UserData", "<redacted>"))
+		Expect(stdout.String()).ToNot(ContainSubstring("dummy-user-data"))
+		Expect(fake.Calls("RunInstances")).To(BeZero())
+		Expect(fake.Instances()).To(BeEmpty())
+	})
+
+	It("should print the output as YAML", func() {
+		stdout.Reset()
+		stderr.Reset()
+		exitCode := run(append([]string{"plan", "--machine-name=machine-0", "--output=yaml", "--aws-ec2-endpoint=" + server.URL}, fileArgs...), stdout, stderr)
+		Expect(exitCode).To(Equal(0), stdout.String()+stderr.String())
+		var out output
+		Expect(yaml.Unmarshal(stdout.Bytes(), &out)).To(Succeed())
+		Expect(out.Code).To(Equal("OK"))
+		Expect(out.Response).To(HaveKeyWithValue("InstanceType", "m4.large"))
+	})
+
+	It("should validate the machine class against the resources", func() {
+		exitCode, out := runCLI(append([]string{"validate"}, fileArgs...)...)
+		Expect(exitCode).To(Equal(0), stdout.String()+stderr.String())
+		Expect(out.Code).To(Equal("OK"))
+
+		writeFile("machine-class.yaml", strings.Replace(machineClassYAML, "test-ssh-publickey", "other-key", 1))
+		exitCode, out = runCLI(append([]string{"validate"}, fileArgs...)...)
+		Expect(exitCode).To(Equal(1))
+		Expect(out.Code).To(Equal("InvalidArgument"))
+		Expect(out.Response).To(Equal([]interface{}{`providerSpec.keyName: Not found: "other-key"`}))
+	})
+
+	It("should report the EC2 permissions of the credentials", func() {
+		exitCode, out := runCLI(append([]string{"permissions"}, fileArgs...)...)
+		Expect(exitCode).To(Equal(0), stdout.String()+stderr.String())
+		Expect(out.Code).To(Equal("OK"))
+		Expect(out.Response).To(HaveKeyWithValue("permissions", ContainElement(map[string]interface{}{"action": "ec2:RunInstances", "result": "Allowed"})))
+		Expect(fake.Instances()).To(BeEmpty())
+
+		fake.InjectFault(fakeec2.Fault{Operation: "TerminateInstances", Err: fakeec2.NewRequestFailure("UnauthorizedOperation", "You are not authorized to perform this operation.", 403)})
+		exitCode, out = runCLI(append([]string{"permissions"}, fileArgs...)...)
+		Expect(exitCode).To(Equal(1))
+		Expect(out.Code).To(Equal("PermissionDenied"))
+		Expect(out.Message).To(ContainSubstring("ec2:TerminateInstances"))
+		Expect(out.Response).To(HaveKeyWithValue("permissions", ContainElement(map[string]interface{}{
+			"action":  "ec2:TerminateInstances",
+			"result":  "Denied",
+			"message": "You are not authorized to perform this operation.",
+		})))
+	})
+
+	It("should report the resources that don't belong to the given machines", func() {
+		exitCode, out := runCLI(append([]string{"create", "--machine-name=machine-0"}, fileArgs...)...)
+		Expect(exitCode).To(Equal(0), stdout.String()+stderr.String())
+		providerID := out.Response.(map[string]interface{})["ProviderID"].(string)
+		_, out = runCLI(append([]string{"create", "--machine-name=machine-1"}, fileArgs...)...)
+		orphanID := strings.TrimPrefix(out.Response.(map[string]interface{})["ProviderID"].(string), "aws:///eu-west-1/")
+
+		machines := writeFile("machines.yaml", `apiVersion: v1
+kind: List
+items:
+- apiVersion: machine.sapcloud.io/v1alpha1
+  kind: Machine
+  metadata:
+    name: machine-0
+    namespace: default
+  spec:
+    providerID: `+providerID+`
+`)
+		exitCode, out = runCLI(append([]string{"orphans", "--machines=" + machines, "--aws-orphan-grace-period=0"}, fileArgs...)...)
+		Expect(exitCode).To(Equal(0), stdout.String()+stderr.String())
+		Expect(out.Response).To(HaveKeyWithValue("Orphans", ConsistOf(HaveKeyWithValue("ID", orphanID))))
+		instance, _ := fake.Instance(orphanID)
+		Expect(instance.State.Name).To(Equal(aws.String(ec2.InstanceStateNameRunning)))
+	})
+
+	It("should print the console output of a VM", func() {
+		exitCode, out := runCLI(append([]string{"create", "--machine-name=machine-0"}, fileArgs...)...)
+		Expect(exitCode).To(Equal(0), stdout.String()+stderr.String())
+		providerID := out.Response.(map[string]interface{})["ProviderID"].(string)
+		Expect(fake.SetConsoleOutput(providerID[strings.LastIndex(providerID, "/")+1:], "cloud-init finished")).To(Succeed())
+
+		exitCode, out = runCLI("console-output", "--provider-id="+providerID, "--secret="+filepath.Join(dir, "secret.yaml"))
+		Expect(exitCode).To(Equal(0), stdout.String()+stderr.String())
+		Expect(out.Response).To(Equal(map[string]interface{}{"Output": "cloud-init finished", "Truncated": false}))
+	})
+
+	It("should print the volume IDs of persistent volumes", func() {
+		exitCode, out := runCLI("volumes", "--pv="+writeFile("pv.yaml", pvYAML))
+		Expect(exitCode).To(Equal(0), stdout.String()+stderr.String())
+		Expect(out.Response).To(Equal(map[string]interface{}{"VolumeIDs": []interface{}{"vol-0123456789"}}))
+	})
+
+	It("should print the machine code of a failed call", func() {
+		writeFile("machine-class.yaml", strings.Replace(machineClassYAML, "ami-123456789", "ami-987654321", 1))
+		exitCode, out := runCLI(append([]string{"create", "--machine-name=machine-0"}, fileArgs...)...)
+		Expect(exitCode).To(Equal(1))
+		Expect(out.Code).To(Equal("InvalidArgument"))
+	})
+
+	DescribeTable("should print the usage on request",
+		func(args ...string) {
+			Expect(run(args, stdout, stderr)).To(Equal(0))
+			Expect(stdout.String()).To(HavePrefix("Usage: aws-machine-cli"))
+		},
+		Entry("help command", "help"),
+		Entry("short flag", "-h"),
+		Entry("long flag", "--help"),
+	)
+
+	DescribeTable("invalid invocations",
+		func(args ...string) {
+			exitCode, _ := runCLI(args...)
+			Expect(exitCode).To(Equal(2))
+			Expect(stdout.Len()).To(BeZero())
+			Expect(stderr.Len()).ToNot(BeZero())
+		},
+		Entry("no command"),
+		Entry("unknown command", "reboot"),
+		Entry("missing machine class", "list", "--secret=secret.yaml"),
+		Entry("missing machine name", "create", "--machine-class=machine-class.yaml", "--secret=secret.yaml"),
+		Entry("missing persistent volumes", "volumes"),
+		Entry("missing machines", "orphans", "--machine-class=machine-class.yaml", "--secret=secret.yaml"),
+		Entry("missing provider ID", "console-output", "--secret=secret.yaml"),
+		Entry("unknown flag", "list", "--unknown"),
+		Entry("unknown output format", "list", "--output=xml"),
+	)
+})
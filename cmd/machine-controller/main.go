@@ -22,6 +22,7 @@ Modifications Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 
@@ -42,11 +43,23 @@ func main() {
 	s := options.NewMCServer()
 	s.AddFlags(pflag.CommandLine)
 
+	driverOptions := aws.NewDriverOptions()
+	driverOptions.AddFlags(pflag.CommandLine)
+
+	pluginSPI := &spi.PluginSPIImpl{}
+	pluginSPI.EndpointOptions.AddFlags(pflag.CommandLine)
+
 	flag.InitFlags()
 	logs.InitLogs()
 	defer logs.FlushLogs()
 
-	driver := aws.NewAWSDriver(&spi.PluginSPIImpl{})
+	driver := aws.NewDriver(pluginSPI, driverOptions)
+	if driverOptions.CheckPermissions {
+		checkPermissions(context.Background(), s, driver)
+	}
+	if driverOptions.OrphanCollectionInterval > 0 {
+		go collectOrphans(context.Background(), s, driver, driverOptions.OrphanCollectionInterval)
+	}
 
 	if err := app.Run(s, driver); err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
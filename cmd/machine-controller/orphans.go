@@ -0,0 +1,88 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/gardener/machine-controller-manager-provider-aws/pkg/aws"
+	v1alpha1 "github.com/gardener/machine-controller-manager/pkg/apis/machine/v1alpha1"
+	mcmclientset "github.com/gardener/machine-controller-manager/pkg/client/clientset/versioned"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/app/options"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+)
+
+// collectOrphans collects the orphaned resources of all AWS machine classes in the namespace of the controller
+// in the given interval until the context is done. Failed collections are logged and retried with the next run.
+func collectOrphans(ctx context.Context, s *options.MCServer, d *aws.Driver, interval time.Duration) {
+	config, err := controlKubeconfig(s)
+	if err != nil {
+		klog.Errorf("Orphan collection is disabled, the control kubeconfig can't be loaded: %v", err)
+		return
+	}
+	machineClient, err := mcmclientset.NewForConfig(config)
+	if err != nil {
+		klog.Errorf("Orphan collection is disabled: %v", err)
+		return
+	}
+	coreClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		klog.Errorf("Orphan collection is disabled: %v", err)
+		return
+	}
+
+	wait.Until(func() {
+		collectOrphansOnce(ctx, s.Namespace, machineClient, coreClient, d)
+	}, interval, ctx.Done())
+}
+
+// collectOrphansOnce runs one orphan collection for each AWS machine class in the namespace. All machines of the
+// namespace are passed as known, instances created before the ownership tags may belong to any of the classes.
+func collectOrphansOnce(ctx context.Context, namespace string, machineClient mcmclientset.Interface, coreClient kubernetes.Interface, d *aws.Driver) {
+	machineClasses, err := machineClient.MachineV1alpha1().MachineClasses(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		klog.Errorf("Skipping the orphan collection, the machine classes can't be listed: %v", err)
+		return
+	}
+	// The machines are listed after the machine classes, so that machines of new classes are known
+	machineList, err := machineClient.MachineV1alpha1().Machines(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		klog.Errorf("Skipping the orphan collection, the machines can't be listed: %v", err)
+		return
+	}
+	machines := make([]*v1alpha1.Machine, 0, len(machineList.Items))
+	for i := range machineList.Items {
+		machines = append(machines, &machineList.Items[i])
+	}
+
+	for i := range machineClasses.Items {
+		machineClass := &machineClasses.Items[i]
+		if machineClass.Provider != "" && machineClass.Provider != aws.ProviderAWS {
+			continue
+		}
+		secret, err := machineClassSecret(coreClient, machineClass)
+		if err != nil {
+			klog.Errorf("Skipping the orphan collection of MachineClass %s: %v", machineClass.Name, err)
+			continue
+		}
+		// The driver logs the orphans it finds and deletes
+		if _, err := d.CollectOrphans(ctx, &aws.OrphanCollectionRequest{MachineClass: machineClass, Secret: secret, Machines: machines}); err != nil {
+			klog.Errorf("Orphan collection of MachineClass %s failed: %v", machineClass.Name, err)
+		}
+	}
+}
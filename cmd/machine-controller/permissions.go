@@ -0,0 +1,114 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+
+	"github.com/gardener/machine-controller-manager-provider-aws/pkg/aws"
+	v1alpha1 "github.com/gardener/machine-controller-manager/pkg/apis/machine/v1alpha1"
+	mcmclientset "github.com/gardener/machine-controller-manager/pkg/client/clientset/versioned"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/app/options"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog"
+)
+
+// checkPermissions checks the EC2 permissions of the credentials of all AWS machine classes in the namespace of
+// the controller and logs the denied actions. Failures don't prevent the controller from starting, the machines
+// of classes with missing permissions fail with the errors of their EC2 calls.
+func checkPermissions(ctx context.Context, s *options.MCServer, d *aws.Driver) {
+	config, err := controlKubeconfig(s)
+	if err != nil {
+		klog.Errorf("Skipping the permission check, the control kubeconfig can't be loaded: %v", err)
+		return
+	}
+	machineClient, err := mcmclientset.NewForConfig(config)
+	if err != nil {
+		klog.Errorf("Skipping the permission check: %v", err)
+		return
+	}
+	coreClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		klog.Errorf("Skipping the permission check: %v", err)
+		return
+	}
+
+	machineClasses, err := machineClient.MachineV1alpha1().MachineClasses(s.Namespace).List(metav1.ListOptions{})
+	if err != nil {
+		klog.Errorf("Skipping the permission check, the machine classes can't be listed: %v", err)
+		return
+	}
+	for i := range machineClasses.Items {
+		machineClass := &machineClasses.Items[i]
+		if machineClass.Provider != "" && machineClass.Provider != aws.ProviderAWS {
+			continue
+		}
+		secret, err := machineClassSecret(coreClient, machineClass)
+		if err != nil {
+			klog.Errorf("Skipping the permission check of MachineClass %s: %v", machineClass.Name, err)
+			continue
+		}
+		response, err := d.CheckPermissions(ctx, &aws.PermissionCheckRequest{MachineClass: machineClass, Secret: secret})
+		if err != nil {
+			klog.Errorf("Permission check of MachineClass %s failed: %v", machineClass.Name, err)
+			continue
+		}
+		for _, permission := range response.Permissions {
+			switch permission.Result {
+			case aws.PermissionDenied:
+				klog.Errorf("The credentials of MachineClass %s are denied %s: %s", machineClass.Name, permission.Action, permission.Message)
+			case aws.PermissionUnverified:
+				klog.Warningf("Permission %s of the credentials of MachineClass %s couldn't be verified: %s", permission.Action, machineClass.Name, permission.Message)
+			}
+		}
+		if denied := response.Denied(); len(denied) == 0 {
+			klog.Infof("The credentials of MachineClass %s are allowed all checked EC2 actions", machineClass.Name)
+		}
+	}
+}
+
+// controlKubeconfig returns the config of the control cluster the same way app.Run does
+func controlKubeconfig(s *options.MCServer) (*rest.Config, error) {
+	switch s.ControlKubeconfig {
+	case "":
+		return clientcmd.BuildConfigFromFlags("", s.TargetKubeconfig)
+	case "inClusterConfig":
+		return clientcmd.BuildConfigFromFlags("", "")
+	default:
+		return clientcmd.BuildConfigFromFlags("", s.ControlKubeconfig)
+	}
+}
+
+// machineClassSecret returns the secret of the machine class merged with its credentials secret, the same way
+// the machine controller passes them to the driver
+func machineClassSecret(client kubernetes.Interface, machineClass *v1alpha1.MachineClass) (*corev1.Secret, error) {
+	secret := &corev1.Secret{Data: map[string][]byte{}}
+	for _, ref := range []*corev1.SecretReference{machineClass.SecretRef, machineClass.CredentialsSecretRef} {
+		if ref == nil {
+			continue
+		}
+		referenced, err := client.CoreV1().Secrets(ref.Namespace).Get(ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range referenced.Data {
+			secret.Data[key] = value
+		}
+	}
+	return secret, nil
+}
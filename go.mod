@@ -8,7 +8,7 @@ require (
 	github.com/go-git/go-git/v5 v5.2.0
 	github.com/onsi/ginkgo v1.12.0
 	github.com/onsi/gomega v1.9.0
-	github.com/prometheus/client_golang v1.5.1 // indirect
+	github.com/prometheus/client_golang v1.5.1
 	github.com/spf13/pflag v1.0.5
 	github.com/stretchr/testify v1.5.1 // indirect
 	k8s.io/api v0.0.0-20190918155943-95b840bb6a1f
@@ -17,9 +17,13 @@ require (
 	k8s.io/client-go v0.0.0-20190918160344-1fbdaa4c8d90
 	k8s.io/component-base v0.0.0-20190918160511-547f6c5d7090
 	k8s.io/klog v0.4.0
+	sigs.k8s.io/controller-runtime v0.4.0
+	sigs.k8s.io/testing_frameworks v0.1.2
+	sigs.k8s.io/yaml v1.1.0
 )
 
 replace (
+	github.com/googleapis/gnostic => github.com/googleapis/gnostic v0.2.0
 	github.com/onsi/gomega => github.com/onsi/gomega v1.5.0
 	github.com/prometheus/client_golang => github.com/prometheus/client_golang v0.9.2
 	k8s.io/api => k8s.io/api v0.0.0-20190918155943-95b840bb6a1f // kubernetes-1.16.0
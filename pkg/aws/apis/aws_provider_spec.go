@@ -28,8 +28,35 @@ const (
 	// AWSAlternativeSecretAccessKey is a constant for a key name of a secret containing the AWS credentials (secret
 	// access key).
 	AWSAlternativeSecretAccessKey = "secretAccessKey"
+
+	// AWSEC2Endpoint is a constant for a key name of a secret overriding the URL of the EC2 API.
+	AWSEC2Endpoint = "ec2Endpoint"
+	// AWSSTSEndpoint is a constant for a key name of a secret overriding the URL of the STS API.
+	AWSSTSEndpoint = "stsEndpoint"
+	// AWSIAMEndpoint is a constant for a key name of a secret overriding the URL of the IAM API.
+	AWSIAMEndpoint = "iamEndpoint"
+	// AWSUseFIPSEndpoint is a constant for a key name of a secret selecting the FIPS endpoint of the EC2 API.
+	AWSUseFIPSEndpoint = "useFIPSEndpoint"
+	// AWSUseDualStackEndpoint is a constant for a key name of a secret selecting the dual-stack endpoint of the EC2 API.
+	AWSUseDualStackEndpoint = "useDualStackEndpoint"
+	// AWSCABundle is a constant for a key name of a secret containing a PEM encoded CA bundle for the AWS endpoints.
+	AWSCABundle = "caBundle"
+	// AWSProxyURL is a constant for a key name of a secret containing the URL of a proxy for the AWS endpoints.
+	AWSProxyURL = "proxyURL"
+)
+
+const (
+	// TagMachineClass is the key of the reserved tag holding the name of the machine class an instance was created for.
+	TagMachineClass = "mcm.gardener.cloud/machine-class"
+	// TagMachineUID is the key of the reserved tag holding the UID of the machine an instance was created for.
+	TagMachineUID = "mcm.gardener.cloud/machine-uid"
+	// TagControllerID is the key of the reserved tag holding the ID of the controller that created an instance.
+	TagControllerID = "mcm.gardener.cloud/controller-id"
 )
 
+// ReservedTagKeys are the keys of the tags set by the driver, they cannot be set in the provider spec.
+var ReservedTagKeys = []string{TagMachineClass, TagMachineUID, TagControllerID}
+
 //AWSProviderSpec is the spec to be used while parsing the calls.
 type AWSProviderSpec struct {
 	// APIVersion determines the APIversion for the provider APIs
@@ -68,8 +95,33 @@ type AWSProviderSpec struct {
 
 	// Tags to be specified on the EC2 instances
 	Tags map[string]string `json:"tags,omitempty"`
+
+	// CleanupPolicy defines what happens to the volumes and network interfaces left behind by deleted machines
+	CleanupPolicy *AWSCleanupPolicy `json:"cleanupPolicy,omitempty"`
 }
 
+// AWSCleanupPolicy describes what happens to the resources left behind by a deleted machine.
+// Resources are left behind if they are not deleted on termination, or if they were attached after the launch.
+// Only resources tagged with the name of the machine and the cluster tag are considered. Machine deletions fail with
+// Unavailable until the instances are terminated and their resources released, the retried deletion applies the policy.
+type AWSCleanupPolicy struct {
+	// Volumes is the policy for available EBS volumes, defaults to Retain.
+	Volumes AWSResourceCleanupPolicy `json:"volumes,omitempty"`
+
+	// NetworkInterfaces is the policy for available network interfaces, defaults to Retain.
+	NetworkInterfaces AWSResourceCleanupPolicy `json:"networkInterfaces,omitempty"`
+}
+
+// AWSResourceCleanupPolicy is the policy for a type of resources left behind by a deleted machine
+type AWSResourceCleanupPolicy string
+
+const (
+	// CleanupPolicyRetain keeps the resources and reports them
+	CleanupPolicyRetain AWSResourceCleanupPolicy = "Retain"
+	// CleanupPolicyDelete deletes the resources
+	CleanupPolicyDelete AWSResourceCleanupPolicy = "Delete"
+)
+
 // AWSBlockDeviceMappingSpec stores info about AWS block device mappings
 type AWSBlockDeviceMappingSpec struct {
 	// The device name exposed to the machine (for example, /dev/sdh or xvdh).
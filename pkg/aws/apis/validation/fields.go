@@ -0,0 +1,118 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validation
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	awsapi "github.com/gardener/machine-controller-manager-provider-aws/pkg/aws/apis"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+var providerSpecType = reflect.TypeOf(awsapi.AWSProviderSpec{})
+
+// ValidateKnownFields makes sure that the raw provider spec only contains fields of the AWSProviderSpec. Field
+// names are compared case-sensitively, unlike json.Unmarshal, so that misspelled fields such as securityGroupIds
+// don't silently fall back to their defaults. Unknown fields are reported under fldPath, values of the wrong type
+// are left to the decoding of the provider spec.
+func ValidateKnownFields(raw []byte, fldPath *field.Path) field.ErrorList {
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return field.ErrorList{field.Invalid(fldPath, string(raw), fmt.Sprintf("ProviderSpec is malformed: %v", err))}
+	}
+	return validateKnownFields(value, providerSpecType, fldPath)
+}
+
+func validateKnownFields(value interface{}, t reflect.Type, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return allErrs
+		}
+		fields := jsonFields(t)
+		for _, key := range sortedKeys(object) {
+			fieldType, ok := fields[key]
+			if !ok {
+				allErrs = append(allErrs, unknownField(fldPath.Child(key), key, fields))
+				continue
+			}
+			allErrs = append(allErrs, validateKnownFields(object[key], fieldType, fldPath.Child(key))...)
+		}
+	case reflect.Slice:
+		items, ok := value.([]interface{})
+		if !ok {
+			return allErrs
+		}
+		for i, item := range items {
+			allErrs = append(allErrs, validateKnownFields(item, t.Elem(), fldPath.Index(i))...)
+		}
+	case reflect.Map:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return allErrs
+		}
+		for _, key := range sortedKeys(object) {
+			allErrs = append(allErrs, validateKnownFields(object[key], t.Elem(), fldPath.Key(key))...)
+		}
+	}
+	return allErrs
+}
+
+// sortedKeys returns the keys of a JSON object in order, so that errors are reported in a stable order
+func sortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// jsonFields returns the types of the fields of a struct by their JSON names
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		structField := t.Field(i)
+		name := strings.Split(structField.Tag.Get("json"), ",")[0]
+		if name == "-" || structField.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = structField.Name
+		}
+		fields[name] = structField.Type
+	}
+	return fields
+}
+
+// unknownField returns the error of an unknown field, pointing to the known field if only the case differs
+func unknownField(fldPath *field.Path, key string, fields map[string]reflect.Type) *field.Error {
+	for name := range fields {
+		if strings.EqualFold(name, key) {
+			return field.Forbidden(fldPath, fmt.Sprintf("unknown field %q, did you mean %q?", key, name))
+		}
+	}
+	return field.Forbidden(fldPath, fmt.Sprintf("unknown field %q", key))
+}
@@ -0,0 +1,60 @@
+package validation
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+var _ = Describe("Fields", func() {
+
+	providerSpecPath := field.NewPath("providerSpec")
+
+	Describe("#ValidateKnownFields", func() {
+		It("should accept provider specs with known fields only", func() {
+			raw := `{
+				"apiVersion": "mcm.gardener.cloud/v1alpha1",
+				"ami": "ami-123456789",
+				"blockDevices": [{"ebs": {"volumeSize": 50, "volumeType": "gp2", "deleteOnTermination": true}}],
+				"iam": {"name": "test-iam"},
+				"networkInterfaces": [{"securityGroupIDs": ["sg-00002132323"], "subnetID": "subnet-123456"}],
+				"tags": {"kubernetes.io/cluster/shoot--test": "1", "AnyCase": "1"},
+				"cleanupPolicy": {"volumes": "Delete"}
+			}`
+			Expect(ValidateKnownFields([]byte(raw), providerSpecPath)).To(BeEmpty())
+		})
+
+		DescribeTable("should report unknown fields with their paths",
+			func(raw string, expected field.ErrorList) {
+				Expect(ValidateKnownFields([]byte(raw), providerSpecPath)).To(Equal(expected))
+			},
+			Entry("misspelled field",
+				`{"blockDevices": [{"ebs": {"volumSize": 50}}]}`,
+				field.ErrorList{
+					field.Forbidden(providerSpecPath.Child("blockDevices").Index(0).Child("ebs", "volumSize"), `unknown field "volumSize"`),
+				},
+			),
+			Entry("field in the wrong case",
+				`{"networkInterfaces": [{"subnetID": "subnet-123456"}, {"securityGroupIds": ["sg-00002132323"]}]}`,
+				field.ErrorList{
+					field.Forbidden(providerSpecPath.Child("networkInterfaces").Index(1).Child("securityGroupIds"), `unknown field "securityGroupIds", did you mean "securityGroupIDs"?`),
+				},
+			),
+			Entry("several unknown fields in order",
+				`{"spotOptions": {}, "ami": "ami-123456789", "iam": {"role": "test"}}`,
+				field.ErrorList{
+					field.Forbidden(providerSpecPath.Child("iam", "role"), `unknown field "role"`),
+					field.Forbidden(providerSpecPath.Child("spotOptions"), `unknown field "spotOptions"`),
+				},
+			),
+		)
+
+		It("should report malformed provider specs", func() {
+			errs := ValidateKnownFields([]byte(`{"ami": `), providerSpecPath)
+			Expect(errs).To(HaveLen(1))
+			Expect(errs[0].Type).To(Equal(field.ErrorTypeInvalid))
+			Expect(errs[0].Field).To(Equal("providerSpec"))
+		})
+	})
+})
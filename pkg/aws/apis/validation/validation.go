@@ -14,17 +14,20 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-// Package validation - validation is used to validate cloud specific ProviderSpec for AWS
+// Package validation validates the AWS ProviderSpec and its secret. It only depends on the API types, so that
+// webhooks and CLIs can validate machine classes without importing the driver.
 package validation
 
 import (
 	"fmt"
 	"regexp"
-	"strconv"
 	"strings"
 
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
 	awsapi "github.com/gardener/machine-controller-manager-provider-aws/pkg/aws/apis"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 )
 
 const nameFmt string = `[-a-z0-9]+`
@@ -32,36 +35,39 @@ const nameMaxLength int = 63
 
 var nameRegexp = regexp.MustCompile("^" + nameFmt + "$")
 
-// ValidateAWSProviderSpec validates AWS provider spec
-func ValidateAWSProviderSpec(spec *awsapi.AWSProviderSpec, secret *corev1.Secret) []error {
-	var allErrs []error
+// ValidateAWSProviderSpec validates AWS provider spec and its secret. Errors of the spec are reported under
+// fldPath, e.g. providerSpec.networkInterfaces[0].subnetID, errors of the secret under secretRef.
+func ValidateAWSProviderSpec(spec *awsapi.AWSProviderSpec, secret *corev1.Secret, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
 
 	if "" == spec.AMI {
-		allErrs = append(allErrs, fmt.Errorf("AMI is required field"))
+		allErrs = append(allErrs, field.Required(fldPath.Child("ami"), "AMI is required"))
 	}
 	if "" == spec.Region {
-		allErrs = append(allErrs, fmt.Errorf("Region is required field"))
+		allErrs = append(allErrs, field.Required(fldPath.Child("region"), "Region is required"))
 	}
 	if "" == spec.MachineType {
-		allErrs = append(allErrs, fmt.Errorf("MachineType is required field"))
+		allErrs = append(allErrs, field.Required(fldPath.Child("machineType"), "MachineType is required"))
 	}
 	if "" == spec.IAM.Name {
-		allErrs = append(allErrs, fmt.Errorf("IAM Name is required field"))
+		allErrs = append(allErrs, field.Required(fldPath.Child("iam", "name"), "IAM Name is required"))
 	}
 	if "" == spec.KeyName {
-		allErrs = append(allErrs, fmt.Errorf("KeyName is required field"))
+		allErrs = append(allErrs, field.Required(fldPath.Child("keyName"), "KeyName is required"))
 	}
 
-	allErrs = append(allErrs, validateBlockDevices(spec.BlockDevices)...)
-	allErrs = append(allErrs, validateNetworkInterfaces(spec.NetworkInterfaces)...)
-	allErrs = append(allErrs, ValidateSecret(secret)...)
-	allErrs = append(allErrs, validateSpecTags(spec.Tags)...)
+	allErrs = append(allErrs, validateIAMARN(spec.IAM.ARN, spec.Region, fldPath.Child("iam", "arn"))...)
+	allErrs = append(allErrs, validateBlockDevices(spec.BlockDevices, fldPath.Child("blockDevices"))...)
+	allErrs = append(allErrs, validateNetworkInterfaces(spec.NetworkInterfaces, fldPath.Child("networkInterfaces"))...)
+	allErrs = append(allErrs, ValidateSecret(secret, field.NewPath("secretRef"))...)
+	allErrs = append(allErrs, validateSpecTags(spec.Tags, fldPath.Child("tags"))...)
+	allErrs = append(allErrs, validateCleanupPolicy(spec.CleanupPolicy, fldPath.Child("cleanupPolicy"))...)
 
 	return allErrs
 }
 
-func validateSpecTags(tags map[string]string) []error {
-	var allErrs []error
+func validateSpecTags(tags map[string]string, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
 	clusterName := ""
 	nodeRole := ""
 
@@ -73,53 +79,102 @@ func validateSpecTags(tags map[string]string) []error {
 		}
 	}
 
+	for _, key := range awsapi.ReservedTagKeys {
+		if _, ok := tags[key]; ok {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Key(key), "Tag is reserved and cannot be set"))
+		}
+	}
+
 	if clusterName == "" {
-		allErrs = append(allErrs, fmt.Errorf("Tag is required of the form kubernetes.io/cluster/****"))
+		allErrs = append(allErrs, field.Required(fldPath, "Tag is required of the form kubernetes.io/cluster/****"))
 	}
 	if nodeRole == "" {
-		allErrs = append(allErrs, fmt.Errorf("Tag is required of the form kubernetes.io/role/****"))
+		allErrs = append(allErrs, field.Required(fldPath, "Tag is required of the form kubernetes.io/role/****"))
+	}
+	return allErrs
+}
+
+// validateCleanupPolicy makes sure that the policies for the resources left behind are known
+func validateCleanupPolicy(cleanupPolicy *awsapi.AWSCleanupPolicy, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if cleanupPolicy == nil {
+		return allErrs
 	}
+
+	allErrs = append(allErrs, validateResourceCleanupPolicy(cleanupPolicy.Volumes, fldPath.Child("volumes"))...)
+	allErrs = append(allErrs, validateResourceCleanupPolicy(cleanupPolicy.NetworkInterfaces, fldPath.Child("networkInterfaces"))...)
 	return allErrs
 }
 
-func validateBlockDevices(blockDevices []awsapi.AWSBlockDeviceMappingSpec) []error {
+func validateResourceCleanupPolicy(policy awsapi.AWSResourceCleanupPolicy, fldPath *field.Path) field.ErrorList {
+	switch policy {
+	case "", awsapi.CleanupPolicyRetain, awsapi.CleanupPolicyDelete:
+		return nil
+	}
+	return field.ErrorList{field.NotSupported(fldPath, string(policy), []string{string(awsapi.CleanupPolicyRetain), string(awsapi.CleanupPolicyDelete)})}
+}
+
+// validateIAMARN makes sure that an IAM instance profile ARN belongs to the partition of the region
+func validateIAMARN(iamARN string, region string, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
 
-	var allErrs []error
+	if iamARN == "" {
+		return allErrs
+	}
+
+	parsed, err := arn.Parse(iamARN)
+	if err != nil {
+		allErrs = append(allErrs, field.Invalid(fldPath, iamARN, fmt.Sprintf("IAM ARN is invalid: %v", err)))
+		return allErrs
+	}
+
+	if !strings.HasPrefix(parsed.Resource, "instance-profile/") {
+		allErrs = append(allErrs, field.Invalid(fldPath, iamARN, "IAM ARN must reference an instance profile"))
+	}
+
+	if partition, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region); ok && partition.ID() != parsed.Partition {
+		allErrs = append(allErrs, field.Invalid(fldPath, iamARN, fmt.Sprintf("IAM ARN partition %s doesn't match partition %s of region %s", parsed.Partition, partition.ID(), region)))
+	}
+	return allErrs
+}
+
+func validateBlockDevices(blockDevices []awsapi.AWSBlockDeviceMappingSpec, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
 
 	if len(blockDevices) > 1 {
-		allErrs = append(allErrs, fmt.Errorf("Can only specify one (root) block device"))
+		allErrs = append(allErrs, field.TooMany(fldPath, len(blockDevices), 1))
 	} else if len(blockDevices) == 1 {
+		ebsPath := fldPath.Index(0).Child("ebs")
 		if blockDevices[0].Ebs.VolumeSize <= 0 {
-			allErrs = append(allErrs, fmt.Errorf("Please mention a valid ebs volume size"))
+			allErrs = append(allErrs, field.Invalid(ebsPath.Child("volumeSize"), blockDevices[0].Ebs.VolumeSize, "Volume size must be greater than 0"))
 		}
 		if blockDevices[0].Ebs.VolumeType == "" {
-			allErrs = append(allErrs, fmt.Errorf("Please mention a valid ebs volume type"))
+			allErrs = append(allErrs, field.Required(ebsPath.Child("volumeType"), "Volume type is required"))
 		} else if blockDevices[0].Ebs.VolumeType == "io1" && blockDevices[0].Ebs.Iops <= 0 {
-			allErrs = append(allErrs, fmt.Errorf("Please mention a valid ebs volume iops"))
+			allErrs = append(allErrs, field.Invalid(ebsPath.Child("iops"), blockDevices[0].Ebs.Iops, "Iops must be greater than 0 for volume type io1"))
 		}
 	}
 	return allErrs
 }
 
-func validateNetworkInterfaces(networkInterfaces []awsapi.AWSNetworkInterfaceSpec) []error {
-	var allErrs []error
+func validateNetworkInterfaces(networkInterfaces []awsapi.AWSNetworkInterfaceSpec, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
 	if len(networkInterfaces) == 0 {
-		allErrs = append(allErrs, fmt.Errorf("Mention at least one NetworkInterface"))
+		allErrs = append(allErrs, field.Required(fldPath, "Mention at least one NetworkInterface"))
 	} else {
 		for i := range networkInterfaces {
+			idxPath := fldPath.Index(i)
 			if "" == networkInterfaces[i].SubnetID {
-				allErrs = append(allErrs, fmt.Errorf("SubnetID is required"))
+				allErrs = append(allErrs, field.Required(idxPath.Child("subnetID"), "SubnetID is required"))
 			}
 
 			if 0 == len(networkInterfaces[i].SecurityGroupIDs) {
-				allErrs = append(allErrs, fmt.Errorf("Mention at least one securityGroupID"))
+				allErrs = append(allErrs, field.Required(idxPath.Child("securityGroupIDs"), "Mention at least one securityGroupID"))
 			} else {
 				for j := range networkInterfaces[i].SecurityGroupIDs {
 					if "" == networkInterfaces[i].SecurityGroupIDs[j] {
-						output := strings.Join([]string{"securityGroupIDs cannot be blank for networkInterface:", strconv.Itoa(i), " securityGroupID:", strconv.Itoa(j)}, "")
-
-						allErrs = append(allErrs, fmt.Errorf(output))
-
+						allErrs = append(allErrs, field.Required(idxPath.Child("securityGroupIDs").Index(j), "securityGroupID cannot be blank"))
 					}
 				}
 			}
@@ -128,21 +183,22 @@ func validateNetworkInterfaces(networkInterfaces []awsapi.AWSNetworkInterfaceSpe
 	return allErrs
 }
 
-// ValidateSecret makes sure that the supplied secrets contains the required fields
-func ValidateSecret(secret *corev1.Secret) []error {
-	var allErrs []error
+// ValidateSecret makes sure that the supplied secrets contains the required fields. Errors are reported under
+// fldPath, the keys of the secret as its children, e.g. secretRef.userData.
+func ValidateSecret(secret *corev1.Secret, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
 
 	if secret == nil {
-		allErrs = append(allErrs, fmt.Errorf("SecretReference is Nil"))
+		allErrs = append(allErrs, field.Required(fldPath, "Secret is required"))
 	} else {
 		if "" == string(secret.Data[awsapi.AWSAccessKeyID]) && "" == string(secret.Data[awsapi.AWSAlternativeAccessKeyID]) {
-			allErrs = append(allErrs, fmt.Errorf("secret %s or %s is required field", awsapi.AWSAccessKeyID, awsapi.AWSAlternativeAccessKeyID))
+			allErrs = append(allErrs, field.Required(fldPath.Child(awsapi.AWSAccessKeyID), fmt.Sprintf("Secret %s or %s is required", awsapi.AWSAccessKeyID, awsapi.AWSAlternativeAccessKeyID)))
 		}
 		if "" == string(secret.Data[awsapi.AWSSecretAccessKey]) && "" == string(secret.Data[awsapi.AWSAlternativeSecretAccessKey]) {
-			allErrs = append(allErrs, fmt.Errorf("secret %s or %s is required field", awsapi.AWSSecretAccessKey, awsapi.AWSAlternativeSecretAccessKey))
+			allErrs = append(allErrs, field.Required(fldPath.Child(awsapi.AWSSecretAccessKey), fmt.Sprintf("Secret %s or %s is required", awsapi.AWSSecretAccessKey, awsapi.AWSAlternativeSecretAccessKey)))
 		}
 		if "" == string(secret.Data["userData"]) {
-			allErrs = append(allErrs, fmt.Errorf("secret userData is required field"))
+			allErrs = append(allErrs, field.Required(fldPath.Child("userData"), "Secret userData is required"))
 		}
 	}
 
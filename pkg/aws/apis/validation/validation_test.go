@@ -1,17 +1,18 @@
 package validation
 
 import (
-	"fmt"
-
 	awsapi "github.com/gardener/machine-controller-manager-provider-aws/pkg/aws/apis"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 )
 
 var _ = Describe("Validation", func() {
 
+	providerSpecPath := field.NewPath("providerSpec")
+
 	providerSecret := &corev1.Secret{
 		Data: map[string][]byte{
 			"providerAccessKeyId":     []byte("dummy-id"),
@@ -29,7 +30,7 @@ var _ = Describe("Validation", func() {
 		}
 		type expect struct {
 			errToHaveOccurred bool
-			errList           []error
+			errList           field.ErrorList
 		}
 		type data struct {
 			setup  setup
@@ -38,11 +39,12 @@ var _ = Describe("Validation", func() {
 		}
 		DescribeTable("##table",
 			func(data *data) {
-				validationErr := ValidateAWSProviderSpec(data.action.spec, data.action.secret)
+				validationErr := ValidateAWSProviderSpec(data.action.spec, data.action.secret, providerSpecPath)
 
 				if data.expect.errToHaveOccurred {
-					Expect(validationErr).NotTo(Equal(nil))
 					Expect(validationErr).To(Equal(data.expect.errList))
+				} else {
+					Expect(validationErr).To(BeEmpty())
 				}
 
 			},
@@ -159,8 +161,8 @@ var _ = Describe("Validation", func() {
 				},
 				expect: expect{
 					errToHaveOccurred: true,
-					errList: []error{
-						fmt.Errorf("AMI is required field"),
+					errList: field.ErrorList{
+						field.Required(providerSpecPath.Child("ami"), "AMI is required"),
 					},
 				},
 			}),
@@ -199,8 +201,8 @@ var _ = Describe("Validation", func() {
 				},
 				expect: expect{
 					errToHaveOccurred: true,
-					errList: []error{
-						fmt.Errorf("Region is required field"),
+					errList: field.ErrorList{
+						field.Required(providerSpecPath.Child("region"), "Region is required"),
 					},
 				},
 			}),
@@ -239,8 +241,8 @@ var _ = Describe("Validation", func() {
 				},
 				expect: expect{
 					errToHaveOccurred: true,
-					errList: []error{
-						fmt.Errorf("MachineType is required field"),
+					errList: field.ErrorList{
+						field.Required(providerSpecPath.Child("machineType"), "MachineType is required"),
 					},
 				},
 			}),
@@ -277,8 +279,8 @@ var _ = Describe("Validation", func() {
 				},
 				expect: expect{
 					errToHaveOccurred: true,
-					errList: []error{
-						fmt.Errorf("IAM Name is required field"),
+					errList: field.ErrorList{
+						field.Required(providerSpecPath.Child("iam", "name"), "IAM Name is required"),
 					},
 				},
 			}),
@@ -317,8 +319,8 @@ var _ = Describe("Validation", func() {
 				},
 				expect: expect{
 					errToHaveOccurred: true,
-					errList: []error{
-						fmt.Errorf("KeyName is required field"),
+					errList: field.ErrorList{
+						field.Required(providerSpecPath.Child("keyName"), "KeyName is required"),
 					},
 				},
 			}),
@@ -357,8 +359,8 @@ var _ = Describe("Validation", func() {
 				},
 				expect: expect{
 					errToHaveOccurred: true,
-					errList: []error{
-						fmt.Errorf("Tag is required of the form kubernetes.io/cluster/****"),
+					errList: field.ErrorList{
+						field.Required(providerSpecPath.Child("tags"), "Tag is required of the form kubernetes.io/cluster/****"),
 					},
 				},
 			}),
@@ -397,8 +399,8 @@ var _ = Describe("Validation", func() {
 				},
 				expect: expect{
 					errToHaveOccurred: true,
-					errList: []error{
-						fmt.Errorf("Tag is required of the form kubernetes.io/role/****"),
+					errList: field.ErrorList{
+						field.Required(providerSpecPath.Child("tags"), "Tag is required of the form kubernetes.io/role/****"),
 					},
 				},
 			}),
@@ -444,8 +446,8 @@ var _ = Describe("Validation", func() {
 				},
 				expect: expect{
 					errToHaveOccurred: true,
-					errList: []error{
-						fmt.Errorf("Can only specify one (root) block device"),
+					errList: field.ErrorList{
+						field.TooMany(providerSpecPath.Child("blockDevices"), 2, 1),
 					},
 				},
 			}),
@@ -485,8 +487,8 @@ var _ = Describe("Validation", func() {
 				},
 				expect: expect{
 					errToHaveOccurred: true,
-					errList: []error{
-						fmt.Errorf("Please mention a valid ebs volume size"),
+					errList: field.ErrorList{
+						field.Invalid(providerSpecPath.Child("blockDevices").Index(0).Child("ebs", "volumeSize"), int64(-10), "Volume size must be greater than 0"),
 					},
 				},
 			}),
@@ -525,8 +527,8 @@ var _ = Describe("Validation", func() {
 				},
 				expect: expect{
 					errToHaveOccurred: true,
-					errList: []error{
-						fmt.Errorf("Please mention a valid ebs volume type"),
+					errList: field.ErrorList{
+						field.Required(providerSpecPath.Child("blockDevices").Index(0).Child("ebs", "volumeType"), "Volume type is required"),
 					},
 				},
 			}),
@@ -566,8 +568,8 @@ var _ = Describe("Validation", func() {
 				},
 				expect: expect{
 					errToHaveOccurred: true,
-					errList: []error{
-						fmt.Errorf("Please mention a valid ebs volume iops"),
+					errList: field.ErrorList{
+						field.Invalid(providerSpecPath.Child("blockDevices").Index(0).Child("ebs", "iops"), int64(0), "Iops must be greater than 0 for volume type io1"),
 					},
 				},
 			}),
@@ -599,8 +601,8 @@ var _ = Describe("Validation", func() {
 				},
 				expect: expect{
 					errToHaveOccurred: true,
-					errList: []error{
-						fmt.Errorf("Mention at least one NetworkInterface"),
+					errList: field.ErrorList{
+						field.Required(providerSpecPath.Child("networkInterfaces"), "Mention at least one NetworkInterface"),
 					},
 				},
 			}),
@@ -639,8 +641,8 @@ var _ = Describe("Validation", func() {
 				},
 				expect: expect{
 					errToHaveOccurred: true,
-					errList: []error{
-						fmt.Errorf("SubnetID is required"),
+					errList: field.ErrorList{
+						field.Required(providerSpecPath.Child("networkInterfaces").Index(0).Child("subnetID"), "SubnetID is required"),
 					},
 				},
 			}),
@@ -677,8 +679,8 @@ var _ = Describe("Validation", func() {
 				},
 				expect: expect{
 					errToHaveOccurred: true,
-					errList: []error{
-						fmt.Errorf("Mention at least one securityGroupID"),
+					errList: field.ErrorList{
+						field.Required(providerSpecPath.Child("networkInterfaces").Index(0).Child("securityGroupIDs"), "Mention at least one securityGroupID"),
 					},
 				},
 			}),
@@ -723,8 +725,8 @@ var _ = Describe("Validation", func() {
 				},
 				expect: expect{
 					errToHaveOccurred: true,
-					errList: []error{
-						fmt.Errorf("secret providerAccessKeyId or accessKeyID is required field"),
+					errList: field.ErrorList{
+						field.Required(field.NewPath("secretRef", "providerAccessKeyId"), "Secret providerAccessKeyId or accessKeyID is required"),
 					},
 				},
 			}),
@@ -769,8 +771,8 @@ var _ = Describe("Validation", func() {
 				},
 				expect: expect{
 					errToHaveOccurred: true,
-					errList: []error{
-						fmt.Errorf("secret providerSecretAccessKey or secretAccessKey is required field"),
+					errList: field.ErrorList{
+						field.Required(field.NewPath("secretRef", "providerSecretAccessKey"), "Secret providerSecretAccessKey or secretAccessKey is required"),
 					},
 				},
 			}),
@@ -815,8 +817,8 @@ var _ = Describe("Validation", func() {
 				},
 				expect: expect{
 					errToHaveOccurred: true,
-					errList: []error{
-						fmt.Errorf("secret userData is required field"),
+					errList: field.ErrorList{
+						field.Required(field.NewPath("secretRef", "userData"), "Secret userData is required"),
 					},
 				},
 			}),
@@ -856,11 +858,254 @@ var _ = Describe("Validation", func() {
 				},
 				expect: expect{
 					errToHaveOccurred: true,
-					errList: []error{
-						fmt.Errorf("securityGroupIDs cannot be blank for networkInterface:0 securityGroupID:0"),
+					errList: field.ErrorList{
+						field.Required(providerSpecPath.Child("networkInterfaces").Index(0).Child("securityGroupIDs").Index(0), "securityGroupID cannot be blank"),
+					},
+				},
+			}),
+			Entry("IAM ARN in the partition of the region", &data{
+				setup: setup{},
+				action: action{
+					spec: &awsapi.AWSProviderSpec{
+						AMI: "ami-123456789",
+						BlockDevices: []awsapi.AWSBlockDeviceMappingSpec{
+							{
+								Ebs: awsapi.AWSEbsBlockDeviceSpec{
+									VolumeSize: 50,
+									VolumeType: "gp2",
+								},
+							},
+						},
+						IAM: awsapi.AWSIAMProfileSpec{
+							ARN:  "arn:aws-cn:iam::123456789012:instance-profile/test-iam",
+							Name: "test-iam",
+						},
+						Region:      "cn-north-1",
+						MachineType: "m4.large",
+						KeyName:     "test-ssh-publickey",
+						NetworkInterfaces: []awsapi.AWSNetworkInterfaceSpec{
+							{
+								SecurityGroupIDs: []string{
+									"sg-00002132323",
+								},
+								SubnetID: "subnet-123456",
+							},
+						},
+						Tags: map[string]string{
+							"kubernetes.io/cluster/shoot--test": "1",
+							"kubernetes.io/role/test":           "1",
+						},
+					},
+					secret: providerSecret,
+				},
+				expect: expect{
+					errToHaveOccurred: false,
+				},
+			}),
+			Entry("IAM ARN in another partition than the region", &data{
+				setup: setup{},
+				action: action{
+					spec: &awsapi.AWSProviderSpec{
+						AMI: "ami-123456789",
+						BlockDevices: []awsapi.AWSBlockDeviceMappingSpec{
+							{
+								Ebs: awsapi.AWSEbsBlockDeviceSpec{
+									VolumeSize: 50,
+									VolumeType: "gp2",
+								},
+							},
+						},
+						IAM: awsapi.AWSIAMProfileSpec{
+							ARN:  "arn:aws:iam::123456789012:instance-profile/test-iam",
+							Name: "test-iam",
+						},
+						Region:      "us-gov-west-1",
+						MachineType: "m4.large",
+						KeyName:     "test-ssh-publickey",
+						NetworkInterfaces: []awsapi.AWSNetworkInterfaceSpec{
+							{
+								SecurityGroupIDs: []string{
+									"sg-00002132323",
+								},
+								SubnetID: "subnet-123456",
+							},
+						},
+						Tags: map[string]string{
+							"kubernetes.io/cluster/shoot--test": "1",
+							"kubernetes.io/role/test":           "1",
+						},
+					},
+					secret: providerSecret,
+				},
+				expect: expect{
+					errToHaveOccurred: true,
+					errList: field.ErrorList{
+						field.Invalid(providerSpecPath.Child("iam", "arn"), "arn:aws:iam::123456789012:instance-profile/test-iam", "IAM ARN partition aws doesn't match partition aws-us-gov of region us-gov-west-1"),
+					},
+				},
+			}),
+			Entry("IAM ARN not referencing an instance profile", &data{
+				setup: setup{},
+				action: action{
+					spec: &awsapi.AWSProviderSpec{
+						AMI: "ami-123456789",
+						BlockDevices: []awsapi.AWSBlockDeviceMappingSpec{
+							{
+								Ebs: awsapi.AWSEbsBlockDeviceSpec{
+									VolumeSize: 50,
+									VolumeType: "gp2",
+								},
+							},
+						},
+						IAM: awsapi.AWSIAMProfileSpec{
+							ARN:  "arn:aws:iam::123456789012:role/test-iam",
+							Name: "test-iam",
+						},
+						Region:      "eu-west-1",
+						MachineType: "m4.large",
+						KeyName:     "test-ssh-publickey",
+						NetworkInterfaces: []awsapi.AWSNetworkInterfaceSpec{
+							{
+								SecurityGroupIDs: []string{
+									"sg-00002132323",
+								},
+								SubnetID: "subnet-123456",
+							},
+						},
+						Tags: map[string]string{
+							"kubernetes.io/cluster/shoot--test": "1",
+							"kubernetes.io/role/test":           "1",
+						},
+					},
+					secret: providerSecret,
+				},
+				expect: expect{
+					errToHaveOccurred: true,
+					errList: field.ErrorList{
+						field.Invalid(providerSpecPath.Child("iam", "arn"), "arn:aws:iam::123456789012:role/test-iam", "IAM ARN must reference an instance profile"),
+					},
+				},
+			}),
+			Entry("Reserved owner tags set in the provider spec", &data{
+				setup: setup{},
+				action: action{
+					spec: &awsapi.AWSProviderSpec{
+						AMI: "ami-123456789",
+						BlockDevices: []awsapi.AWSBlockDeviceMappingSpec{
+							{
+								Ebs: awsapi.AWSEbsBlockDeviceSpec{
+									VolumeSize: 50,
+									VolumeType: "gp2",
+								},
+							},
+						},
+						IAM: awsapi.AWSIAMProfileSpec{
+							Name: "test-iam",
+						},
+						Region:      "eu-west-1",
+						MachineType: "m4.large",
+						KeyName:     "test-ssh-publickey",
+						NetworkInterfaces: []awsapi.AWSNetworkInterfaceSpec{
+							{
+								SecurityGroupIDs: []string{
+									"sg-00002132323",
+								},
+								SubnetID: "subnet-123456",
+							},
+						},
+						Tags: map[string]string{
+							"kubernetes.io/cluster/shoot--test": "1",
+							"kubernetes.io/role/test":           "1",
+							awsapi.TagMachineClass:              "test-class",
+							awsapi.TagControllerID:              "test-controller",
+						},
+					},
+					secret: providerSecret,
+				},
+				expect: expect{
+					errToHaveOccurred: true,
+					errList: field.ErrorList{
+						field.Forbidden(providerSpecPath.Child("tags").Key("mcm.gardener.cloud/machine-class"), "Tag is reserved and cannot be set"),
+						field.Forbidden(providerSpecPath.Child("tags").Key("mcm.gardener.cloud/controller-id"), "Tag is reserved and cannot be set"),
+					},
+				},
+			}),
+			Entry("Invalid cleanup policy for volumes", &data{
+				setup: setup{},
+				action: action{
+					spec: &awsapi.AWSProviderSpec{
+						AMI: "ami-123456789",
+						BlockDevices: []awsapi.AWSBlockDeviceMappingSpec{
+							{
+								Ebs: awsapi.AWSEbsBlockDeviceSpec{
+									VolumeSize: 50,
+									VolumeType: "gp2",
+								},
+							},
+						},
+						CleanupPolicy: &awsapi.AWSCleanupPolicy{
+							Volumes:           "Destroy",
+							NetworkInterfaces: awsapi.CleanupPolicyDelete,
+						},
+						IAM: awsapi.AWSIAMProfileSpec{
+							Name: "test-iam",
+						},
+						Region:      "eu-west-1",
+						MachineType: "m4.large",
+						KeyName:     "test-ssh-publickey",
+						NetworkInterfaces: []awsapi.AWSNetworkInterfaceSpec{
+							{
+								SecurityGroupIDs: []string{
+									"sg-00002132323",
+								},
+								SubnetID: "subnet-123456",
+							},
+						},
+						Tags: map[string]string{
+							"kubernetes.io/cluster/shoot--test": "1",
+							"kubernetes.io/role/test":           "1",
+						},
+					},
+					secret: providerSecret,
+				},
+				expect: expect{
+					errToHaveOccurred: true,
+					errList: field.ErrorList{
+						field.NotSupported(providerSpecPath.Child("cleanupPolicy", "volumes"), "Destroy", []string{"Retain", "Delete"}),
 					},
 				},
 			}),
 		)
+
+		It("should render the field paths of the errors", func() {
+			errs := ValidateAWSProviderSpec(&awsapi.AWSProviderSpec{
+				AMI:               "ami-123456789",
+				IAM:               awsapi.AWSIAMProfileSpec{Name: "test-iam"},
+				Region:            "eu-west-1",
+				MachineType:       "m4.large",
+				KeyName:           "test-ssh-publickey",
+				NetworkInterfaces: []awsapi.AWSNetworkInterfaceSpec{{SecurityGroupIDs: []string{"sg-00002132323"}}},
+				Tags: map[string]string{
+					"kubernetes.io/cluster/shoot--test": "1",
+					"kubernetes.io/role/test":           "1",
+				},
+			}, providerSecret, field.NewPath("spec", "providerSpec"))
+
+			Expect(errs.ToAggregate().Error()).To(Equal("spec.providerSpec.networkInterfaces[0].subnetID: Required value: SubnetID is required"))
+		})
+	})
+
+	Describe("#ValidateSecret", func() {
+		It("should report the missing keys under the given path", func() {
+			errs := ValidateSecret(&corev1.Secret{Data: map[string][]byte{"userData": []byte("dummy-user-data")}}, field.NewPath("credentials"))
+			Expect(errs).To(Equal(field.ErrorList{
+				field.Required(field.NewPath("credentials", "providerAccessKeyId"), "Secret providerAccessKeyId or accessKeyID is required"),
+				field.Required(field.NewPath("credentials", "providerSecretAccessKey"), "Secret providerSecretAccessKey or secretAccessKey is required"),
+			}))
+		})
+
+		It("should require the secret", func() {
+			Expect(ValidateSecret(nil, field.NewPath("secretRef"))).To(Equal(field.ErrorList{field.Required(field.NewPath("secretRef"), "Secret is required")}))
+		})
 	})
 })
@@ -16,6 +16,9 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/gardener/machine-controller-manager-provider-aws/pkg/fakeec2"
 	v1alpha1 "github.com/gardener/machine-controller-manager/pkg/apis/machine/v1alpha1"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -65,6 +68,12 @@ func newMachine(
 	return machine
 }
 
+func newMachineWithProviderID(index int, providerID string) *v1alpha1.Machine {
+	machine := newMachine(index)
+	machine.Spec.ProviderID = providerID
+	return machine
+}
+
 func newMachineClass(providerSpec []byte) *v1alpha1.MachineClass {
 	return &v1alpha1.MachineClass{
 		ProviderSpec: runtime.RawExtension{
@@ -72,3 +81,41 @@ func newMachineClass(providerSpec []byte) *v1alpha1.MachineClass {
 		},
 	}
 }
+
+func newNamedMachineClass(name string, providerSpec []byte) *v1alpha1.MachineClass {
+	machineClass := newMachineClass(providerSpec)
+	machineClass.Name = name
+	return machineClass
+}
+
+// newFakeEC2 returns an EC2 fake providing the image referenced by the provider specs of the tests
+func newFakeEC2(opts ...fakeec2.Option) *fakeec2.EC2 {
+	fake := fakeec2.New(opts...)
+	fake.AddImage(&ec2.Image{ImageId: aws.String("ami-123456789")})
+	return fake
+}
+
+// launchedInstance returns the only instance the fake launched for a machine
+func launchedInstance(fake *fakeec2.EC2, machineName string) *ec2.Instance {
+	var launched []*ec2.Instance
+	for _, instance := range fake.Instances() {
+		for _, tag := range instance.Tags {
+			if *tag.Key == "Name" && *tag.Value == machineName {
+				launched = append(launched, instance)
+			}
+		}
+	}
+	ExpectWithOffset(1, launched).To(HaveLen(1), "instances launched for machine %q", machineName)
+	return launched[0]
+}
+
+// liveInstances returns the IDs of the instances of the fake that aren't terminated
+func liveInstances(fake *fakeec2.EC2) []string {
+	var ids []string
+	for _, instance := range fake.Instances() {
+		if *instance.State.Name != ec2.InstanceStateNameTerminated {
+			ids = append(ids, *instance.InstanceId)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,154 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	api "github.com/gardener/machine-controller-manager-provider-aws/pkg/aws/apis"
+	"k8s.io/klog"
+)
+
+const (
+	// errCodeVolumeNotFound is returned by EC2 if a volume with the given ID doesn't exist
+	errCodeVolumeNotFound = "InvalidVolume.NotFound"
+	// errCodeNetworkInterfaceNotFound is returned by EC2 if a network interface with the given ID doesn't exist
+	errCodeNetworkInterfaceNotFound = "InvalidNetworkInterfaceID.NotFound"
+)
+
+// needsCleanup returns true if a cleanup policy is set for the resources left behind by deleted machines
+func needsCleanup(providerSpec *api.AWSProviderSpec) bool {
+	return providerSpec != nil && providerSpec.CleanupPolicy != nil
+}
+
+// retainsVolumes returns true if the volumes left behind by the machines of a class are kept on purpose, either
+// by the cleanup policy or, without one, by block devices that aren't deleted on termination
+func retainsVolumes(providerSpec *api.AWSProviderSpec) bool {
+	if providerSpec.CleanupPolicy != nil {
+		return providerSpec.CleanupPolicy.Volumes != api.CleanupPolicyDelete
+	}
+	for _, blockDevice := range providerSpec.BlockDevices {
+		if deleteOnTermination := blockDevice.Ebs.DeleteOnTermination; deleteOnTermination != nil && !*deleteOnTermination {
+			return true
+		}
+	}
+	return false
+}
+
+// retainsNetworkInterfaces returns true if the network interfaces left behind by the machines of a class are kept
+// on purpose, either by the cleanup policy or, without one, by network interfaces that aren't deleted on termination
+func retainsNetworkInterfaces(providerSpec *api.AWSProviderSpec) bool {
+	if providerSpec.CleanupPolicy != nil {
+		return providerSpec.CleanupPolicy.NetworkInterfaces != api.CleanupPolicyDelete
+	}
+	for _, networkInterface := range providerSpec.NetworkInterfaces {
+		if deleteOnTermination := networkInterface.DeleteOnTermination; deleteOnTermination != nil && !*deleteOnTermination {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanupResources deletes or retains the available volumes and network interfaces left behind by the
+// instances of a machine as defined by the cleanup policy. It returns a summary of what has been done.
+func (d *Driver) cleanupResources(ctx context.Context, svc ec2iface.EC2API, machineName string, providerSpec *api.AWSProviderSpec, ownerTags map[string]string) (string, error) {
+	var (
+		summary     []string
+		clusterName string
+		filters     []*ec2.Filter
+	)
+
+	clusterName, _ = getClusterAndRoleTagKeys(providerSpec.Tags)
+	filters = []*ec2.Filter{
+		{
+			Name:   aws.String("status"),
+			Values: []*string{aws.String("available")},
+		},
+		{
+			Name:   aws.String("tag:Name"),
+			Values: []*string{aws.String(machineName)},
+		},
+		{
+			Name:   aws.String("tag-key"),
+			Values: []*string{aws.String(clusterName)},
+		},
+	}
+
+	var volumeIDs []string
+	err := svc.DescribeVolumesPagesWithContext(ctx, &ec2.DescribeVolumesInput{Filters: filters}, func(page *ec2.DescribeVolumesOutput, lastPage bool) bool {
+		for _, volume := range page.Volumes {
+			if d.isOwnedBy(volume.Tags, ownerTags) {
+				volumeIDs = append(volumeIDs, *volume.VolumeId)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if len(volumeIDs) > 0 {
+		if providerSpec.CleanupPolicy.Volumes == api.CleanupPolicyDelete {
+			for _, volumeID := range volumeIDs {
+				_, err := svc.DeleteVolumeWithContext(ctx, &ec2.DeleteVolumeInput{VolumeId: aws.String(volumeID)})
+				if err != nil && !isAWSErrorCode(err, errCodeVolumeNotFound) {
+					return "", err
+				}
+			}
+			summary = append(summary, fmt.Sprintf("deleted volumes %s", strings.Join(volumeIDs, ", ")))
+		} else {
+			summary = append(summary, fmt.Sprintf("retained volumes %s", strings.Join(volumeIDs, ", ")))
+		}
+	}
+
+	var networkInterfaceIDs []string
+	err = svc.DescribeNetworkInterfacesPagesWithContext(ctx, &ec2.DescribeNetworkInterfacesInput{Filters: filters}, func(page *ec2.DescribeNetworkInterfacesOutput, lastPage bool) bool {
+		for _, networkInterface := range page.NetworkInterfaces {
+			if d.isOwnedBy(networkInterface.TagSet, ownerTags) {
+				networkInterfaceIDs = append(networkInterfaceIDs, *networkInterface.NetworkInterfaceId)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if len(networkInterfaceIDs) > 0 {
+		if providerSpec.CleanupPolicy.NetworkInterfaces == api.CleanupPolicyDelete {
+			for _, networkInterfaceID := range networkInterfaceIDs {
+				_, err := svc.DeleteNetworkInterfaceWithContext(ctx, &ec2.DeleteNetworkInterfaceInput{NetworkInterfaceId: aws.String(networkInterfaceID)})
+				if err != nil && !isAWSErrorCode(err, errCodeNetworkInterfaceNotFound) {
+					return "", err
+				}
+			}
+			summary = append(summary, fmt.Sprintf("deleted network interfaces %s", strings.Join(networkInterfaceIDs, ", ")))
+		} else {
+			summary = append(summary, fmt.Sprintf("retained network interfaces %s", strings.Join(networkInterfaceIDs, ", ")))
+		}
+	}
+
+	if len(summary) == 0 {
+		return "", nil
+	}
+
+	result := fmt.Sprintf("Cleanup of Machine %q: %s", machineName, strings.Join(summary, "; "))
+	klog.V(2).Info(result)
+	return result, nil
+}
@@ -0,0 +1,89 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/gardener/machine-controller-manager-provider-aws/pkg/conformance"
+	"github.com/gardener/machine-controller-manager-provider-aws/pkg/fakeec2"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// conformanceOperations maps the driver operations to the EC2 operations they depend on
+var conformanceOperations = map[conformance.Operation]string{
+	conformance.OperationCreateMachine:    "RunInstances",
+	conformance.OperationDeleteMachine:    "TerminateInstances",
+	conformance.OperationGetMachineStatus: "DescribeInstances",
+	conformance.OperationListMachines:     "DescribeInstances",
+}
+
+// fakeBackend is the conformance backend of the driver backed by the EC2 fake
+type fakeBackend struct {
+	fake *fakeec2.EC2
+}
+
+func (b *fakeBackend) VMExists(providerID string) (bool, error) {
+	_, instanceID, err := decodeRegionAndProviderID(providerID)
+	if err != nil {
+		return false, err
+	}
+	instance, ok := b.fake.Instance(instanceID)
+	return ok && aws.StringValue(instance.State.Name) != ec2.InstanceStateNameTerminated, nil
+}
+
+func (b *fakeBackend) DeleteVM(providerID string) error {
+	_, instanceID, err := decodeRegionAndProviderID(providerID)
+	if err != nil {
+		return err
+	}
+	_, err = b.fake.TerminateInstances(&ec2.TerminateInstancesInput{InstanceIds: aws.StringSlice([]string{instanceID})})
+	return err
+}
+
+func (b *fakeBackend) FailOperation(operation conformance.Operation) func() {
+	return b.fake.InjectFault(fakeec2.Fault{
+		Operation: conformanceOperations[operation],
+		Err:       fakeec2.NewRequestFailure("RequestLimitExceeded", "Request limit exceeded.", 503),
+	})
+}
+
+// conformanceConfig returns the conformance config of a driver with the given options backed by a new EC2 fake
+func conformanceConfig(options *DriverOptions) *conformance.Config {
+	fake := fakeec2.New()
+	fake.AddImage(&ec2.Image{ImageId: aws.String("ami-123456789")})
+
+	return &conformance.Config{
+		Driver:       NewDriver(fakeec2.NewSessionProvider(fake), options),
+		Backend:      &fakeBackend{fake: fake},
+		MachineClass: newMachineClass([]byte("{\"ami\":\"ami-123456789\",\"blockDevices\":[{\"ebs\":{\"volumeSize\":50,\"volumeType\":\"gp2\"}}],\"iam\":{\"name\":\"test-iam\"},\"keyName\":\"test-ssh-publickey\",\"machineType\":\"m4.large\",\"networkInterfaces\":[{\"securityGroupIDs\":[\"sg-00002132323\"],\"subnetID\":\"subnet-123456\"}],\"region\":\"eu-west-1\",\"tags\":{\"kubernetes.io/cluster/shoot--test\":\"1\",\"kubernetes.io/role/test\":\"1\"}}")),
+		Secret: &corev1.Secret{
+			Data: map[string][]byte{
+				"providerAccessKeyId":     []byte("dummy-id"),
+				"providerSecretAccessKey": []byte("dummy-secret"),
+				"userData":                []byte("dummy-user-data"),
+			},
+		},
+	}
+}
+
+var _ = conformance.DescribeDriver("Driver", func() *conformance.Config {
+	return conformanceConfig(NewDriverOptions())
+})
+
+var _ = conformance.DescribeDriver("Driver without instance cache", func() *conformance.Config {
+	options := NewDriverOptions()
+	options.InstanceCacheTTL = 0
+	return conformanceConfig(options)
+})
@@ -0,0 +1,167 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/gardener/machine-controller-manager-provider-aws/pkg/aws/apis/validation"
+	"github.com/gardener/machine-controller-manager/pkg/apis/machine/v1alpha1"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/machinecodes/codes"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/machinecodes/status"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/klog"
+)
+
+// ConsoleOutputRequest is the request to fetch the serial console output of the instance of a machine
+type ConsoleOutputRequest struct {
+	// ProviderID is the provider ID of the machine
+	ProviderID string
+	// Secret is the secret of the machine class
+	Secret *corev1.Secret
+	// Limit is the maximum number of bytes returned, the default of the driver is used if it is zero
+	Limit int
+}
+
+// ConsoleOutputResponse is the response with the serial console output of an instance
+type ConsoleOutputResponse struct {
+	// Output is the end of the decoded console output
+	Output string
+	// Truncated is set if the beginning of the output has been cut off to stay within the limit
+	Truncated bool
+}
+
+// GetConsoleOutput fetches the serial console output of the instance of a machine.
+// EC2 only keeps the most recent output, it is empty until the instance has booted.
+func (d *Driver) GetConsoleOutput(ctx context.Context, req *ConsoleOutputRequest) (*ConsoleOutputResponse, error) {
+	region, instanceID, err := decodeRegionAndProviderID(req.ProviderID)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if errs := validation.ValidateSecret(req.Secret, field.NewPath("secretRef")); len(errs) > 0 {
+		return nil, fieldErrorsToStatus(codes.InvalidArgument, "Error while validating Secret", errs)
+	}
+
+	ctx, cancel := withOperationTimeout(ctx, d.options.GetMachineStatusTimeout)
+	defer cancel()
+
+	svc, err := d.createSVC(req.Secret, region)
+	if err != nil {
+		return nil, awsErrorToStatus(ctx, err)
+	}
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = d.options.ConsoleOutputLimit
+	}
+
+	output, truncated, err := getConsoleOutput(ctx, svc, instanceID, limit)
+	if err != nil {
+		return nil, awsErrorToStatus(ctx, err)
+	}
+	return &ConsoleOutputResponse{Output: output, Truncated: truncated}, nil
+}
+
+// getConsoleOutput returns the last limit bytes of the decoded console output of an instance
+func getConsoleOutput(ctx context.Context, svc ec2iface.EC2API, instanceID string, limit int) (string, bool, error) {
+	response, err := svc.GetConsoleOutputWithContext(ctx, &ec2.GetConsoleOutputInput{
+		InstanceId: aws.String(instanceID),
+	})
+	if err != nil {
+		return "", false, err
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(aws.StringValue(response.Output))
+	if err != nil {
+		return "", false, fmt.Errorf("console output of VM %q couldn't be decoded: %v", instanceID, err)
+	}
+
+	truncated := false
+	if limit > 0 && len(decoded) > limit {
+		decoded = decoded[len(decoded)-limit:]
+		truncated = true
+	}
+	// Cutting the output may split a multi-byte character
+	return strings.ToValidUTF8(string(decoded), ""), truncated, nil
+}
+
+// consoleOutputMessage returns the console output of an instance to be attached to an error message
+func (d *Driver) consoleOutputMessage(ctx context.Context, svc ec2iface.EC2API, instanceID string) string {
+	output, truncated, err := getConsoleOutput(ctx, svc, instanceID, d.options.ConsoleOutputLimit)
+	if err != nil {
+		klog.Warningf("Console output of VM %q couldn't be fetched: %s", instanceID, err.Error())
+		return ""
+	} else if output == "" {
+		return ""
+	}
+
+	if truncated {
+		output = "..." + output
+	}
+	return fmt.Sprintf("\nConsole output of VM %q:\n%s", instanceID, output)
+}
+
+// logConsoleOutput logs the console output of an instance whose machine hasn't joined the cluster
+// long after the instance was launched. The output of each instance is logged once.
+func (d *Driver) logConsoleOutput(ctx context.Context, secret *corev1.Secret, region string, machine *v1alpha1.Machine, instance *ec2.Instance) {
+	phase := machine.Status.CurrentStatus.Phase
+	if phase != v1alpha1.MachinePending && phase != v1alpha1.MachineCrashLoopBackOff {
+		return
+	}
+	if instance.LaunchTime == nil || time.Since(*instance.LaunchTime) < d.options.ConsoleOutputAfter {
+		return
+	}
+
+	instanceID := aws.StringValue(instance.InstanceId)
+	if _, logged := d.consoleOutputs.LoadOrStore(instanceID, true); logged {
+		return
+	}
+
+	svc, err := d.createSVC(secret, region)
+	if err != nil {
+		klog.Warningf("Console output of VM %q couldn't be fetched: %s", instanceID, err.Error())
+		d.consoleOutputs.Delete(instanceID)
+		return
+	}
+
+	output, truncated, err := getConsoleOutput(ctx, svc, instanceID, d.options.ConsoleOutputLimit)
+	if err != nil {
+		klog.Warningf("Console output of VM %q couldn't be fetched: %s", instanceID, err.Error())
+		d.consoleOutputs.Delete(instanceID)
+		return
+	} else if output == "" {
+		// The output may not be available yet, it is fetched again with the next request
+		d.consoleOutputs.Delete(instanceID)
+		return
+	}
+
+	if truncated {
+		output = "..." + output
+	}
+	klog.Warningf("Machine %q hasn't joined the cluster %s after its VM %q was launched, console output:\n%s",
+		machine.Name,
+		time.Since(*instance.LaunchTime).Round(time.Second),
+		instanceID,
+		output,
+	)
+}
@@ -0,0 +1,163 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/gardener/machine-controller-manager-provider-aws/pkg/fakeec2"
+	v1alpha1 "github.com/gardener/machine-controller-manager/pkg/apis/machine/v1alpha1"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/driver"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var _ = Describe("ConsoleOutput", func() {
+
+	var (
+		ctx        = context.Background()
+		fake       *fakeec2.EC2
+		d          *Driver
+		options    *DriverOptions
+		launchedAt time.Time
+		providerID string
+		instanceID string
+
+		providerSpec   = []byte("{\"ami\":\"ami-123456789\",\"blockDevices\":[{\"ebs\":{\"volumeSize\":50,\"volumeType\":\"gp2\"}}],\"iam\":{\"name\":\"test-iam\"},\"keyName\":\"test-ssh-publickey\",\"machineType\":\"m4.large\",\"networkInterfaces\":[{\"securityGroupIDs\":[\"sg-00002132323\"],\"subnetID\":\"subnet-123456\"}],\"region\":\"eu-west-1\",\"tags\":{\"kubernetes.io/cluster/shoot--test\":\"1\",\"kubernetes.io/role/test\":\"1\"}}")
+		providerSecret = &corev1.Secret{
+			Data: map[string][]byte{
+				"providerAccessKeyId":     []byte("dummy-id"),
+				"providerSecretAccessKey": []byte("dummy-secret"),
+				"userData":                []byte("dummy-user-data"),
+			},
+		}
+		consoleOutput = "[    0.000000] Linux version 5.4.0\n[    4.200000] Kernel panic - not syncing: VFS: Unable to mount root fs\n"
+	)
+
+	machineInPhase := func(phase v1alpha1.MachinePhase) *v1alpha1.Machine {
+		machine := newMachineWithProviderID(0, providerID)
+		machine.Status.CurrentStatus.Phase = phase
+		return machine
+	}
+	getStatus := func(machine *v1alpha1.Machine) error {
+		_, err := d.GetMachineStatus(ctx, &driver.GetMachineStatusRequest{
+			Machine:      machine,
+			MachineClass: newMachineClass(providerSpec),
+			Secret:       providerSecret,
+		})
+		return err
+	}
+	isLogged := func() bool {
+		_, logged := d.consoleOutputs.Load(instanceID)
+		return logged
+	}
+
+	BeforeEach(func() {
+		launchedAt = time.Now()
+		fake = fakeec2.New(fakeec2.WithClock(func() time.Time { return launchedAt }))
+		fake.AddImage(&ec2.Image{ImageId: aws.String("ami-123456789")})
+		options = NewDriverOptions()
+		options.CaptureConsoleOutput = true
+	})
+
+	JustBeforeEach(func() {
+		d = NewDriver(fakeec2.NewSessionProvider(fake), options)
+		response, err := d.CreateMachine(ctx, &driver.CreateMachineRequest{
+			Machine:      newMachine(0),
+			MachineClass: newMachineClass(providerSpec),
+			Secret:       providerSecret,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		providerID = response.ProviderID
+		_, instanceID, err = decodeRegionAndProviderID(providerID)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fake.SetConsoleOutput(instanceID, consoleOutput)).To(Succeed())
+	})
+
+	Describe("#GetConsoleOutput", func() {
+		It("should return the decoded console output", func() {
+			response, err := d.GetConsoleOutput(ctx, &ConsoleOutputRequest{
+				ProviderID: providerID,
+				Secret:     providerSecret,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(response).To(Equal(&ConsoleOutputResponse{Output: consoleOutput}))
+		})
+
+		It("should cut off the beginning of the console output beyond the limit", func() {
+			response, err := d.GetConsoleOutput(ctx, &ConsoleOutputRequest{
+				ProviderID: providerID,
+				Secret:     providerSecret,
+				Limit:      20,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(response).To(Equal(&ConsoleOutputResponse{Output: consoleOutput[len(consoleOutput)-20:], Truncated: true}))
+		})
+
+		It("should fail for an invalid provider ID", func() {
+			_, err := d.GetConsoleOutput(ctx, &ConsoleOutputRequest{
+				ProviderID: instanceID,
+				Secret:     providerSecret,
+			})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("machine codes error: code = [InvalidArgument] message = [Unable to decode provider-ID]"))
+		})
+	})
+
+	Describe("#GetMachineStatus", func() {
+		It("should not log the console output of a recently launched machine", func() {
+			Expect(getStatus(machineInPhase(v1alpha1.MachinePending))).ToNot(HaveOccurred())
+			Expect(isLogged()).To(BeFalse())
+		})
+
+		Context("launched long ago", func() {
+			BeforeEach(func() {
+				launchedAt = time.Now().Add(-time.Hour)
+			})
+
+			It("should log the console output of a machine that didn't join once", func() {
+				Expect(getStatus(machineInPhase(v1alpha1.MachinePending))).ToNot(HaveOccurred())
+				Expect(isLogged()).To(BeTrue())
+			})
+
+			It("should not log the console output of a running machine", func() {
+				Expect(getStatus(machineInPhase(v1alpha1.MachineRunning))).ToNot(HaveOccurred())
+				Expect(isLogged()).To(BeFalse())
+			})
+		})
+
+		Context("with status checks", func() {
+			BeforeEach(func() {
+				options.StatusCheckFailureThreshold = 1
+				options.ConsoleOutputLimit = 72
+			})
+
+			JustBeforeEach(func() {
+				Expect(fake.SetInstanceStatus(instanceID, &ec2.InstanceStatus{
+					InstanceStatus: &ec2.InstanceStatusSummary{Status: aws.String("impaired")},
+				})).To(Succeed())
+			})
+
+			It("should attach the console output to failed status checks", func() {
+				err := getStatus(machineInPhase(v1alpha1.MachineRunning))
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("machine codes error: code = [FailedPrecondition] message = [VM \"" + instanceID + "\" failed 1 consecutive status checks: instance status impaired\nConsole output of VM \"" + instanceID + "\":\n...(    4.200000) Kernel panic - not syncing: VFS: Unable to mount root fs\n]"))
+			})
+		})
+	})
+})
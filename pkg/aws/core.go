@@ -19,45 +19,72 @@ package aws
 
 import (
 	"context"
-	"encoding/base64"
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	api "github.com/gardener/machine-controller-manager-provider-aws/pkg/aws/apis"
 	"github.com/gardener/machine-controller-manager-provider-aws/pkg/aws/apis/validation"
 	"github.com/gardener/machine-controller-manager-provider-aws/pkg/spi"
 	v1alpha1 "github.com/gardener/machine-controller-manager/pkg/apis/machine/v1alpha1"
 	"github.com/gardener/machine-controller-manager/pkg/util/provider/driver"
 	"github.com/gardener/machine-controller-manager/pkg/util/provider/machinecodes/codes"
 	"github.com/gardener/machine-controller-manager/pkg/util/provider/machinecodes/status"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	"k8s.io/klog"
 )
 
 // Driver is the driver struct for holding AWS machine information
 type Driver struct {
 	SPI spi.SessionProviderInterface
+
+	options      *DriverOptions
+	sessions     *sessionCache
+	instances    *instanceCache
+	orphans      *orphanTracker
+	statusChecks *statusCheckTracker
+	// consoleOutputs holds the IDs of the instances whose console output has been logged
+	consoleOutputs sync.Map
+	// validatedClasses holds the versions of the machine classes that have been validated against AWS
+	validatedClasses sync.Map
+	// lenientClasses holds the versions of the machine classes whose unknown fields have been logged
+	lenientClasses sync.Map
 }
 
 const (
 	resourceTypeInstance = "instance"
 	resourceTypeVolume   = "volume"
+	// resourceTypeNetworkInterface tags the network interfaces created with the instances
+	resourceTypeNetworkInterface = "network-interface"
+
 	// awsEBSDriverName is the name of the CSI driver for EBS
 	awsEBSDriverName = "ebs.csi.aws.com"
 )
 
 // NewAWSDriver returns an empty AWSDriver object
 func NewAWSDriver(spi spi.SessionProviderInterface) driver.Driver {
+	return NewDriver(spi, NewDriverOptions())
+}
+
+// NewDriver returns an AWS Driver configured with the given options
+func NewDriver(spi spi.SessionProviderInterface, options *DriverOptions) *Driver {
 	return &Driver{
-		SPI: spi,
+		SPI:          spi,
+		options:      options,
+		sessions:     newSessionCache(options.SessionCacheTTL),
+		instances:    newInstanceCache(options),
+		orphans:      newOrphanTracker(),
+		statusChecks: newStatusCheckTracker(),
 	}
 }
 
 // CreateMachine handles a machine creation request
 func (d *Driver) CreateMachine(ctx context.Context, req *driver.CreateMachineRequest) (*driver.CreateMachineResponse, error) {
 	var (
-		exists       bool
-		userData     []byte
 		machine      = req.Machine
 		secret       = req.Secret
 		machineClass = req.MachineClass
@@ -66,102 +93,65 @@ func (d *Driver) CreateMachine(ctx context.Context, req *driver.CreateMachineReq
 	// Log messages to track request
 	klog.V(3).Infof("Machine creation request has been recieved for %q", req.Machine.Name)
 
-	providerSpec, err := decodeProviderSpecAndSecret(machineClass, secret)
+	ctx, cancel := withOperationTimeout(ctx, d.options.CreateMachineTimeout)
+	defer cancel()
+
+	providerSpec, err := d.decodeProviderSpecAndSecret(machineClass, secret, true)
 	if err != nil {
 		return nil, err
 	}
 
 	svc, err := d.createSVC(secret, providerSpec.Region)
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
-	}
-
-	if userData, exists = secret.Data["userData"]; !exists {
-		return nil, status.Error(codes.Internal, "userData doesn't exist")
-	}
-	UserDataEnc := base64.StdEncoding.EncodeToString([]byte(userData))
-
-	var imageIds []*string
-	imageID := aws.String(providerSpec.AMI)
-	imageIds = append(imageIds, imageID)
-
-	describeImagesRequest := ec2.DescribeImagesInput{
-		ImageIds: imageIds,
-	}
-	output, err := svc.DescribeImages(&describeImagesRequest)
-	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
-	} else if len(output.Images) < 1 {
-		return nil, status.Error(codes.Internal, fmt.Sprintf("Image %s not found", *imageID))
+		return nil, awsErrorToStatus(ctx, err)
 	}
+	ownerTags := d.ownerTags(machineClass, machine)
 
-	blkDeviceMappings, err := d.generateBlockDevices(providerSpec.BlockDevices, output.Images[0].RootDeviceName)
+	// Adopt an instance launched by an earlier attempt whose response has been lost
+	instances, terminated, err := d.describeInstancesByName(ctx, svc, machine.Name, providerSpec, ownerTags)
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, err
+	} else if len(instances) > 1 {
+		return nil, multipleInstancesError(instances)
+	} else if len(instances) == 1 {
+		response := &driver.CreateMachineResponse{
+			ProviderID: encodeProviderID(providerSpec.Region, *instances[0].InstanceId),
+			NodeName:   *instances[0].PrivateDnsName,
+		}
+		d.invalidateInstances(secret, providerSpec.Region, machine.Name, *instances[0].InstanceId)
+		klog.V(2).Infof("VM with Provider-ID: %q already exists for Machine: %q, adopting it", response.ProviderID, machine.Name)
+		return response, nil
 	}
 
-	tagInstance, err := d.generateTags(providerSpec.Tags, resourceTypeInstance, req.Machine.Name)
-	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+	if err := d.validateOnFirstUse(ctx, machineClass, secret); err != nil {
+		return nil, err
 	}
 
-	tagVolume, err := d.generateTags(providerSpec.Tags, resourceTypeVolume, req.Machine.Name)
+	attempt := len(terminated)
+	inputConfig, err := d.runInstancesInput(ctx, svc, req, providerSpec, attempt)
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
-	}
-
-	var networkInterfaceSpecs []*ec2.InstanceNetworkInterfaceSpecification
-	for i, netIf := range providerSpec.NetworkInterfaces {
-		spec := &ec2.InstanceNetworkInterfaceSpecification{
-			Groups:                   aws.StringSlice(netIf.SecurityGroupIDs),
-			DeviceIndex:              aws.Int64(int64(i)),
-			AssociatePublicIpAddress: netIf.AssociatePublicIPAddress,
-			DeleteOnTermination:      netIf.DeleteOnTermination,
-			Description:              netIf.Description,
-			SubnetId:                 aws.String(netIf.SubnetID),
-		}
-
-		if netIf.DeleteOnTermination == nil {
-			spec.DeleteOnTermination = aws.Bool(true)
-		}
-
-		networkInterfaceSpecs = append(networkInterfaceSpecs, spec)
-	}
-
-	// Specify the details of the machine that you want to create.
-	inputConfig := ec2.RunInstancesInput{
-		BlockDeviceMappings: blkDeviceMappings,
-		ImageId:             aws.String(providerSpec.AMI),
-		InstanceType:        aws.String(providerSpec.MachineType),
-		MinCount:            aws.Int64(1),
-		MaxCount:            aws.Int64(1),
-		UserData:            &UserDataEnc,
-		KeyName:             aws.String(providerSpec.KeyName),
-		IamInstanceProfile: &ec2.IamInstanceProfileSpecification{
-			Name: &(providerSpec.IAM.Name),
-		},
-		NetworkInterfaces: networkInterfaceSpecs,
-		TagSpecifications: []*ec2.TagSpecification{tagInstance, tagVolume},
+		return nil, err
 	}
 
-	// Set spot price if it has been set
-	if providerSpec.SpotPrice != nil {
-		inputConfig.InstanceMarketOptions = &ec2.InstanceMarketOptionsRequest{
-			MarketType: aws.String(ec2.MarketTypeSpot),
-			SpotOptions: &ec2.SpotMarketOptions{
-				SpotInstanceType: aws.String(ec2.SpotInstanceTypeOneTime),
-			},
-		}
-
-		if *providerSpec.SpotPrice != "" {
-			inputConfig.InstanceMarketOptions.SpotOptions.MaxPrice = providerSpec.SpotPrice
+	// Client tokens stay bound to their instances for 24 hours, while terminated instances are only described
+	// for about an hour. The token of an attempt counted from the described instances may therefore return an
+	// instance terminated earlier, such attempts are skipped.
+	var runResult *ec2.Reservation
+	for skipped := 0; ; skipped++ {
+		runResult, err = svc.RunInstancesWithContext(ctx, inputConfig)
+		if err != nil {
+			return nil, awsErrorToStatus(ctx, err)
+		} else if len(runResult.Instances) > 0 && !isTerminated(runResult.Instances[0]) {
+			break
+		} else if skipped == maxSkippedClientTokens {
+			return nil, status.Error(codes.Internal, fmt.Sprintf("The last %d client tokens of Machine %q are bound to terminated VMs", maxSkippedClientTokens+1, machine.Name))
 		}
-	}
 
-	runResult, err := svc.RunInstances(&inputConfig)
-	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		klog.V(2).Infof("Client token of attempt %d of Machine %q is bound to a terminated VM, skipping it", attempt, machine.Name)
+		attempt++
+		inputConfig.ClientToken = aws.String(generateClientToken(machine, attempt))
 	}
+	d.invalidateInstances(secret, providerSpec.Region, machine.Name, *runResult.Instances[0].InstanceId)
 
 	response := &driver.CreateMachineResponse{
 		ProviderID: encodeProviderID(providerSpec.Region, *runResult.Instances[0].InstanceId),
@@ -178,41 +168,146 @@ func (d *Driver) DeleteMachine(ctx context.Context, req *driver.DeleteMachineReq
 	klog.V(3).Infof("Machine deletion request has been recieved for %q", req.Machine.Name)
 	defer klog.V(3).Infof("Machine deletion request has been processed for %q", req.Machine.Name)
 
-	region, machineID, err := decodeRegionAndProviderID(req.Machine.Spec.ProviderID)
-	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+	ctx, cancel := withOperationTimeout(ctx, d.options.DeleteMachineTimeout)
+	defer cancel()
+
+	var (
+		region      string
+		instanceIDs []string
+	)
+
+	if req.Machine.Spec.ProviderID != "" {
+		var (
+			machineID string
+			err       error
+		)
+		region, machineID, err = decodeRegionAndProviderID(req.Machine.Spec.ProviderID)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		instanceIDs = append(instanceIDs, machineID)
+	}
+
+	if errs := validation.ValidateSecret(req.Secret, field.NewPath("secretRef")); len(errs) > 0 {
+		return nil, fieldErrorsToStatus(codes.InvalidArgument, "Error while validating Secret", errs)
+	}
+
+	// The provider spec is required to find the instances of machines without provider ID
+	// and to clean up the resources they leave behind
+	var providerSpec *api.AWSProviderSpec
+	if req.MachineClass != nil {
+		var err error
+		providerSpec, err = d.decodeProviderSpecAndSecret(req.MachineClass, req.Secret, false)
+		if err != nil && len(instanceIDs) == 0 {
+			return nil, err
+		} else if err != nil {
+			klog.Warningf("Resources of Machine %q are not cleaned up: %s", req.Machine.Name, err.Error())
+		}
 	}
+	ownerTags := d.ownerTags(req.MachineClass, req.Machine)
 
-	validationErr := validation.ValidateSecret(req.Secret)
-	if validationErr != nil {
-		err = fmt.Errorf("%v", validationErr)
-		return nil, status.Error(codes.Internal, err.Error())
+	// Without provider ID, the creation of the machine might have been interrupted before it was
+	// reported. Its instances are searched by the Name tag, so that they aren't leaked.
+	if len(instanceIDs) == 0 {
+		if providerSpec == nil {
+			return nil, status.Error(codes.Internal, "Machine class is required to find the VMs of a machine without provider-ID")
+		}
+		region = providerSpec.Region
 	}
 
 	svc, err := d.createSVC(req.Secret, region)
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, awsErrorToStatus(ctx, err)
 	}
 
-	input := &ec2.TerminateInstancesInput{
-		InstanceIds: []*string{
-			aws.String(machineID),
-		},
-		DryRun: aws.Bool(false),
+	if len(instanceIDs) == 0 {
+		instances, terminated, err := d.describeInstancesByName(ctx, svc, req.Machine.Name, providerSpec, ownerTags)
+		if err != nil {
+			return nil, err
+		}
+		for _, instance := range instances {
+			instanceIDs = append(instanceIDs, *instance.InstanceId)
+		}
+		if len(instanceIDs) == 0 {
+			klog.V(2).Infof("No VM found for Machine %q without provider-ID, nothing to terminate", req.Machine.Name)
+		}
+		// Instances that are still shutting down hold on to their volumes and network interfaces
+		if needsCleanup(providerSpec) {
+			for _, instance := range terminated {
+				if aws.StringValue(instance.State.Name) == ec2.InstanceStateNameShuttingDown {
+					instanceIDs = append(instanceIDs, *instance.InstanceId)
+				}
+			}
+		}
 	}
-	_, err = svc.TerminateInstances(input)
-	if err != nil {
-		klog.Errorf("VM %q for Machine %q couldn't be terminated: %s",
-			req.Machine.Spec.ProviderID,
-			req.Machine.Name,
+
+	if len(instanceIDs) > 0 {
+		terminated, err := d.terminateInstances(ctx, svc, req.Secret, region, req.Machine.Name, instanceIDs, d.options.WaitForTermination)
+		if err != nil {
+			return nil, err
+		}
+		// Resources are only released once the instances are terminated, they are cleaned up by a later call
+		// instead of blocking the deletion
+		if !terminated && needsCleanup(providerSpec) {
+			return nil, status.Error(codes.Unavailable, fmt.Sprintf("VM %s of Machine %q is terminating, its resources are cleaned up once it is terminated", strings.Join(instanceIDs, ", "), req.Machine.Name))
+		}
+	}
+
+	response := &driver.DeleteMachineResponse{}
+	if needsCleanup(providerSpec) {
+		response.LastKnownState, err = d.cleanupResources(ctx, svc, req.Machine.Name, providerSpec, ownerTags)
+		if err != nil {
+			klog.Errorf("Resources of Machine %q couldn't be cleaned up: %s", req.Machine.Name, err.Error())
+			return nil, awsErrorToStatus(ctx, err)
+		}
+	}
+
+	return response, nil
+}
+
+// terminateInstances terminates the instances of a machine, instances that don't exist anymore are ignored.
+// If wait is set, it blocks until the instances are terminated. It returns true if all instances are terminated.
+func (d *Driver) terminateInstances(ctx context.Context, svc ec2iface.EC2API, secret *corev1.Secret, region, machineName string, instanceIDs []string, wait bool) (bool, error) {
+	input := &ec2.TerminateInstancesInput{
+		InstanceIds: aws.StringSlice(instanceIDs),
+		DryRun:      aws.Bool(false),
+	}
+	output, err := svc.TerminateInstancesWithContext(ctx, input)
+	for _, instanceID := range instanceIDs {
+		d.invalidateInstances(secret, region, machineName, instanceID)
+	}
+	if isInstanceNotFound(err) {
+		klog.V(2).Infof("VM %v for Machine %q doesn't exist anymore: %s", instanceIDs, machineName, err.Error())
+		return true, nil
+	} else if err != nil {
+		klog.Errorf("VM %v for Machine %q couldn't be terminated: %s",
+			instanceIDs,
+			machineName,
 			err.Error(),
 		)
-		return nil, status.Error(codes.Internal, err.Error())
+		return false, awsErrorToStatus(ctx, err)
 	}
 
-	klog.V(3).Infof("VM %q for Machine %q was terminated succesfully", req.Machine.Spec.ProviderID, req.Machine.Name)
+	terminated := true
+	for _, stateChange := range output.TerminatingInstances {
+		if stateChange.CurrentState == nil || aws.StringValue(stateChange.CurrentState.Name) != ec2.InstanceStateNameTerminated {
+			terminated = false
+		}
+	}
+	if wait && !terminated {
+		// Block until attached volumes and network interfaces have been released
+		err = svc.WaitUntilInstanceTerminatedWithContext(ctx, &ec2.DescribeInstancesInput{
+			InstanceIds: aws.StringSlice(instanceIDs),
+		})
+		if err != nil {
+			klog.Errorf("VM %v for Machine %q didn't terminate: %s", instanceIDs, machineName, err.Error())
+			return false, awsErrorToStatus(ctx, err)
+		}
+		terminated = true
+	}
 
-	return &driver.DeleteMachineResponse{}, nil
+	klog.V(3).Infof("VM %v for Machine %q was terminated succesfully", instanceIDs, machineName)
+	return terminated, nil
 }
 
 // GetMachineStatus handles a machine get status request
@@ -225,30 +320,50 @@ func (d *Driver) GetMachineStatus(ctx context.Context, req *driver.GetMachineSta
 	// Log messages to track start and end of request
 	klog.V(3).Infof("Get request has been recieved for %q", req.Machine.Name)
 
-	providerSpec, err := decodeProviderSpecAndSecret(machineClass, secret)
+	ctx, cancel := withOperationTimeout(ctx, d.options.GetMachineStatusTimeout)
+	defer cancel()
+
+	providerSpec, err := d.decodeProviderSpecAndSecret(machineClass, secret, false)
 	if err != nil {
 		return nil, err
 	}
 
-	instances, err := d.getInstancesFromMachineName(req.Machine.Name, providerSpec, secret)
+	var (
+		requiredInstance *ec2.Instance
+		region           = providerSpec.Region
+	)
 
-	if err != nil {
-		return nil, err
-	} else if len(instances) > 1 {
-		instanceIDs := []string{}
-		for _, instance := range instances {
-			instanceIDs = append(instanceIDs, *instance.InstanceId)
+	// Prefer the instance ID known from an earlier request over searching by tags
+	if req.Machine.Spec.ProviderID != "" {
+		// The machine UID isn't checked, as machines restored from a backup keep the provider ID but get a new UID
+		requiredInstance, region, err = d.getInstanceFromProviderID(ctx, req.Machine.Spec.ProviderID, providerSpec, secret, d.ownerTags(machineClass, nil))
+		if err != nil {
+			return nil, err
 		}
-
-		errMessage := fmt.Sprintf("AWS plugin is returning multiple VM instances backing this machine object. IDs for all backing VMs - %v ", instanceIDs)
-		return nil, status.Error(codes.OutOfRange, errMessage)
+	} else {
+		instances, err := d.getInstancesFromMachineName(ctx, req.Machine.Name, providerSpec, secret, d.ownerTags(machineClass, req.Machine))
+		if err != nil {
+			return nil, err
+		} else if len(instances) > 1 {
+			return nil, multipleInstancesError(instances)
+		}
+		requiredInstance = instances[0]
 	}
 
-	requiredInstance := instances[0]
+	// The instances of machines that are being deleted aren't checked, so that the deletion proceeds
+	if req.Machine.DeletionTimestamp == nil {
+		if err := d.checkInstanceHealth(ctx, secret, region, requiredInstance); err != nil {
+			klog.V(2).Infof("Machine %q needs to be replaced: %s", req.Machine.Name, err.Error())
+			return nil, err
+		}
+		if d.options.CaptureConsoleOutput {
+			d.logConsoleOutput(ctx, secret, region, req.Machine, requiredInstance)
+		}
+	}
 
 	response := &driver.GetMachineStatusResponse{
 		NodeName:   *requiredInstance.PrivateDnsName,
-		ProviderID: encodeProviderID(providerSpec.Region, *requiredInstance.InstanceId),
+		ProviderID: encodeProviderID(region, *requiredInstance.InstanceId),
 	}
 
 	klog.V(3).Infof("Machine get request has been processed successfully for %q", req.Machine.Name)
@@ -265,25 +380,19 @@ func (d *Driver) ListMachines(ctx context.Context, req *driver.ListMachinesReque
 	// Log messages to track start and end of request
 	klog.V(3).Infof("List machines request has been recieved for %q", machineClass.Name)
 
-	providerSpec, err := decodeProviderSpecAndSecret(machineClass, secret)
+	ctx, cancel := withOperationTimeout(ctx, d.options.ListMachinesTimeout)
+	defer cancel()
+
+	providerSpec, err := d.decodeProviderSpecAndSecret(machineClass, secret, false)
 	if err != nil {
 		return nil, err
 	}
 
-	clusterName := ""
-	nodeRole := ""
-
-	for key := range providerSpec.Tags {
-		if strings.Contains(key, "kubernetes.io/cluster/") {
-			clusterName = key
-		} else if strings.Contains(key, "kubernetes.io/role/") {
-			nodeRole = key
-		}
-	}
+	clusterName, nodeRole := getClusterAndRoleTagKeys(providerSpec.Tags)
 
 	svc, err := d.createSVC(secret, providerSpec.Region)
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, awsErrorToStatus(ctx, err)
 	}
 
 	input := ec2.DescribeInstancesInput{
@@ -310,28 +419,49 @@ func (d *Driver) ListMachines(ctx context.Context, req *driver.ListMachinesReque
 				},
 			},
 		},
+		MaxResults: aws.Int64(d.options.listMachinesPageSize()),
+	}
+
+	// Instances of other machine classes or controllers are filtered by their owner tags. Unless they are
+	// required, instances without owner tags are kept, so they must be filtered after the DescribeInstances call.
+	ownerTags := d.ownerTags(machineClass, nil)
+	if d.options.StrictOwnershipTags {
+		for key, value := range ownerTags {
+			input.Filters = append(input.Filters, &ec2.Filter{
+				Name:   aws.String("tag:" + key),
+				Values: []*string{aws.String(value)},
+			})
+		}
 	}
 
-	runResult, err := svc.DescribeInstances(&input)
-	if err != nil {
-		klog.Errorf("AWS plugin is returning error while describe instances request is sent: %s", err)
-		return nil, status.Error(codes.Internal, err.Error())
-	}
-
+	// Instances are processed page by page, so only the resulting map is kept in memory
 	listOfVMs := make(map[string]string)
-	for _, reservation := range runResult.Reservations {
-		for _, instance := range reservation.Instances {
-
-			machineName := ""
-			for _, tag := range instance.Tags {
-				if *tag.Key == "Name" {
-					machineName = *tag.Value
-					break
+	pages := 0
+	err = svc.DescribeInstancesPagesWithContext(ctx, &input, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+		pages++
+		for _, reservation := range page.Reservations {
+			for _, instance := range reservation.Instances {
+				if !d.isOwnedBy(instance.Tags, ownerTags) {
+					continue
+				}
+
+				machineName := ""
+				for _, tag := range instance.Tags {
+					if *tag.Key == "Name" {
+						machineName = *tag.Value
+						break
+					}
 				}
+				listOfVMs[encodeProviderID(providerSpec.Region, *instance.InstanceId)] = machineName
 			}
-			listOfVMs[encodeProviderID(providerSpec.Region, *instance.InstanceId)] = machineName
 		}
+		return true
+	})
+	if err != nil {
+		klog.Errorf("AWS plugin is returning error while describe instances request is sent: %s", err)
+		return nil, awsErrorToStatus(ctx, err)
 	}
+	klog.V(4).Infof("Listed %d instances in %d DescribeInstances pages", len(listOfVMs), pages)
 
 	klog.V(3).Infof("List machines request has been processed successfully")
 	// Core logic ends here.
@@ -15,12 +15,17 @@ package aws
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
-	"github.com/gardener/machine-controller-manager-provider-aws/pkg/mockclient"
+	"github.com/gardener/machine-controller-manager-provider-aws/pkg/fakeec2"
 	v1alpha1 "github.com/gardener/machine-controller-manager/pkg/apis/machine/v1alpha1"
 	"github.com/gardener/machine-controller-manager/pkg/util/provider/driver"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/machinecodes/codes"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/machinecodes/status"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
@@ -44,12 +49,13 @@ var _ = Describe("MachineServer", func() {
 
 	Describe("#CreateMachine", func() {
 		type setup struct {
+			createMachineRequests []*driver.CreateMachineRequest
+			faults                []fakeec2.Fault
 		}
 		type action struct {
 			machineRequest *driver.CreateMachineRequest
 		}
 		type expect struct {
-			machineResponse   *driver.CreateMachineResponse
 			errToHaveOccurred bool
 			errMessage        string
 		}
@@ -60,22 +66,29 @@ var _ = Describe("MachineServer", func() {
 		}
 		DescribeTable("##table",
 			func(data *data) {
-				mockPluginSPIImpl := &mockclient.MockPluginSPIImpl{FakeInstances: make([]ec2.Instance, 0)}
-				ms := NewAWSDriver(mockPluginSPIImpl)
+				fake := newFakeEC2()
+				ms := NewAWSDriver(fakeec2.NewSessionProvider(fake))
 
 				ctx := context.Background()
-				response, err := ms.CreateMachine(ctx, data.action.machineRequest)
 
-				//klog.Error(err)
-				//klog.Error(data.expect.errMessage)
+				for _, createReq := range data.setup.createMachineRequests {
+					_, err := ms.CreateMachine(ctx, createReq)
+					Expect(err).ToNot(HaveOccurred())
+				}
+				for _, fault := range data.setup.faults {
+					fake.InjectFault(fault)
+				}
+
+				response, err := ms.CreateMachine(ctx, data.action.machineRequest)
 
 				if data.expect.errToHaveOccurred {
 					Expect(err).To(HaveOccurred())
 					Expect(err.Error()).To(Equal(data.expect.errMessage))
 				} else {
 					Expect(err).ToNot(HaveOccurred())
-					Expect(data.expect.machineResponse.ProviderID).To(Equal(response.ProviderID))
-					Expect(data.expect.machineResponse.NodeName).To(Equal(response.NodeName))
+					instance := launchedInstance(fake, data.action.machineRequest.Machine.Name)
+					Expect(response.ProviderID).To(Equal(encodeProviderID("eu-west-1", *instance.InstanceId)))
+					Expect(response.NodeName).To(Equal(*instance.PrivateDnsName))
 				}
 			},
 			Entry("Simple Machine Creation Request", &data{
@@ -87,10 +100,32 @@ var _ = Describe("MachineServer", func() {
 					},
 				},
 				expect: expect{
-					machineResponse: &driver.CreateMachineResponse{
-						ProviderID: "aws:///eu-west-1/i-0123456789-0",
-						NodeName:   "ip-0",
+					errToHaveOccurred: false,
+				},
+			}),
+			Entry("Machine creation request for an existing instance adopts it", &data{
+				setup: setup{
+					createMachineRequests: []*driver.CreateMachineRequest{
+						{
+							Machine:      newMachine(-1),
+							MachineClass: newMachineClass(providerSpec),
+							Secret:       providerSecret,
+						},
+						{
+							Machine:      newMachine(1),
+							MachineClass: newMachineClass(providerSpec),
+							Secret:       providerSecret,
+						},
+					},
+				},
+				action: action{
+					machineRequest: &driver.CreateMachineRequest{
+						Machine:      newMachine(-1),
+						MachineClass: newMachineClass(providerSpec),
+						Secret:       providerSecret,
 					},
+				},
+				expect: expect{
 					errToHaveOccurred: false,
 				},
 			}),
@@ -103,10 +138,6 @@ var _ = Describe("MachineServer", func() {
 					},
 				},
 				expect: expect{
-					machineResponse: &driver.CreateMachineResponse{
-						ProviderID: "aws:///eu-west-1/i-0123456789-0",
-						NodeName:   "ip-0",
-					},
 					errToHaveOccurred: false,
 				},
 			}),
@@ -119,10 +150,6 @@ var _ = Describe("MachineServer", func() {
 					},
 				},
 				expect: expect{
-					machineResponse: &driver.CreateMachineResponse{
-						ProviderID: "aws:///eu-west-1/i-0123456789-0",
-						NodeName:   "ip-0",
-					},
 					errToHaveOccurred: false,
 				},
 			}),
@@ -135,10 +162,6 @@ var _ = Describe("MachineServer", func() {
 					},
 				},
 				expect: expect{
-					machineResponse: &driver.CreateMachineResponse{
-						ProviderID: "aws:///eu-west-1/i-0123456789-0",
-						NodeName:   "ip-0",
-					},
 					errToHaveOccurred: false,
 				},
 			}),
@@ -170,7 +193,7 @@ var _ = Describe("MachineServer", func() {
 				},
 				expect: expect{
 					errToHaveOccurred: true,
-					errMessage:        "machine codes error: code = [Internal] message = [Error while validating ProviderSpec [secret providerAccessKeyId or accessKeyID is required field]]",
+					errMessage:        "machine codes error: code = [InvalidArgument] message = [Error while validating ProviderSpec: secretRef.providerAccessKeyId: Required value: Secret providerAccessKeyId or accessKeyID is required]",
 				},
 			}),
 			Entry("providerSecretAccessKey missing for provider secret", &data{
@@ -188,7 +211,7 @@ var _ = Describe("MachineServer", func() {
 				},
 				expect: expect{
 					errToHaveOccurred: true,
-					errMessage:        "machine codes error: code = [Internal] message = [Error while validating ProviderSpec [secret providerSecretAccessKey or secretAccessKey is required field]]",
+					errMessage:        "machine codes error: code = [InvalidArgument] message = [Error while validating ProviderSpec: secretRef.providerSecretAccessKey: Required value: Secret providerSecretAccessKey or secretAccessKey is required]",
 				},
 			}),
 			Entry("userData missing for provider secret", &data{
@@ -206,7 +229,7 @@ var _ = Describe("MachineServer", func() {
 				},
 				expect: expect{
 					errToHaveOccurred: true,
-					errMessage:        "machine codes error: code = [Internal] message = [Error while validating ProviderSpec [secret userData is required field]]",
+					errMessage:        "machine codes error: code = [InvalidArgument] message = [Error while validating ProviderSpec: secretRef.userData: Required value: Secret userData is required]",
 				},
 			}),
 			Entry("Validation for providerSpec fails. Missing AMI & Region.", &data{
@@ -219,33 +242,33 @@ var _ = Describe("MachineServer", func() {
 				},
 				expect: expect{
 					errToHaveOccurred: true,
-					errMessage:        "machine codes error: code = [Internal] message = [Error while validating ProviderSpec [AMI is required field Region is required field]]",
+					errMessage:        "machine codes error: code = [InvalidArgument] message = [Error while validating ProviderSpec: providerSpec.ami: Required value: AMI is required; providerSpec.region: Required value: Region is required]",
 				},
 			}),
 			Entry("Invalid region that doesn't exist", &data{
 				action: action{
 					machineRequest: &driver.CreateMachineRequest{
 						Machine:      newMachine(-1),
-						MachineClass: newMachineClass([]byte("{\"ami\":\"ami-123456789\",\"blockDevices\":[{\"ebs\":{\"volumeSize\":50,\"volumeType\":\"gp2\"}}],\"iam\":{\"name\":\"test-iam\"},\"keyName\":\"test-ssh-publickey\",\"machineType\":\"m4.large\",\"networkInterfaces\":[{\"securityGroupIDs\":[\"sg-00002132323\"],\"subnetID\":\"subnet-123456\"}],\"region\":\"" + mockclient.FailAtRegion + "\",\"tags\":{\"kubernetes.io/cluster/shoot--test\":\"1\",\"kubernetes.io/role/test\":\"1\"}}")),
+						MachineClass: newMachineClass([]byte("{\"ami\":\"ami-123456789\",\"blockDevices\":[{\"ebs\":{\"volumeSize\":50,\"volumeType\":\"gp2\"}}],\"iam\":{\"name\":\"test-iam\"},\"keyName\":\"test-ssh-publickey\",\"machineType\":\"m4.large\",\"networkInterfaces\":[{\"securityGroupIDs\":[\"sg-00002132323\"],\"subnetID\":\"subnet-123456\"}],\"region\":\"eu-east-2\",\"tags\":{\"kubernetes.io/cluster/shoot--test\":\"1\",\"kubernetes.io/role/test\":\"1\"}}")),
 						Secret:       providerSecret,
 					},
 				},
 				expect: expect{
 					errToHaveOccurred: true,
-					errMessage:        "machine codes error: code = [Internal] message = [Region doesn't exist while trying to create session]",
+					errMessage:        "machine codes error: code = [Internal] message = [Region \"eu-east-2\" doesn't exist while trying to create session]",
 				},
 			}),
 			Entry("Invalid image ID that doesn't exist", &data{
 				action: action{
 					machineRequest: &driver.CreateMachineRequest{
 						Machine:      newMachine(-1),
-						MachineClass: newMachineClass([]byte("{\"ami\":\"" + mockclient.FailQueryAtDescribeImages + "\",\"blockDevices\":[{\"ebs\":{\"volumeSize\":50,\"volumeType\":\"gp2\"}}],\"iam\":{\"name\":\"test-iam\"},\"keyName\":\"test-ssh-publickey\",\"machineType\":\"m4.large\",\"networkInterfaces\":[{\"securityGroupIDs\":[\"sg-00002132323\"],\"subnetID\":\"subnet-123456\"}],\"region\":\"eu-west-1\",\"tags\":{\"kubernetes.io/cluster/shoot--test\":\"1\",\"kubernetes.io/role/test\":\"1\"}}")),
+						MachineClass: newMachineClass([]byte("{\"ami\":\"ami-987654321\",\"blockDevices\":[{\"ebs\":{\"volumeSize\":50,\"volumeType\":\"gp2\"}}],\"iam\":{\"name\":\"test-iam\"},\"keyName\":\"test-ssh-publickey\",\"machineType\":\"m4.large\",\"networkInterfaces\":[{\"securityGroupIDs\":[\"sg-00002132323\"],\"subnetID\":\"subnet-123456\"}],\"region\":\"eu-west-1\",\"tags\":{\"kubernetes.io/cluster/shoot--test\":\"1\",\"kubernetes.io/role/test\":\"1\"}}")),
 						Secret:       providerSecret,
 					},
 				},
 				expect: expect{
 					errToHaveOccurred: true,
-					errMessage:        "machine codes error: code = [Internal] message = [Couldn't find image with given ID]",
+					errMessage:        "machine codes error: code = [InvalidArgument] message = [InvalidAMIID.NotFound: The image id 'ami-987654321' does not exist]",
 				},
 			}),
 			Entry("Name tag cannot be set on AWS instances", &data{
@@ -257,32 +280,51 @@ var _ = Describe("MachineServer", func() {
 					},
 				},
 				expect: expect{
-					machineResponse: &driver.CreateMachineResponse{
-						ProviderID: "aws:///eu-west-1/i-0123456789-0",
-						NodeName:   "ip-0",
-					},
 					errToHaveOccurred: false,
 				},
 			}),
 			Entry("RunInstance call fails", &data{
+				setup: setup{
+					faults: []fakeec2.Fault{
+						{Operation: "RunInstances", Err: fakeec2.NewRequestFailure("InsufficientInstanceCapacity", "We currently do not have sufficient m4.large capacity", 500)},
+					},
+				},
 				action: action{
 					machineRequest: &driver.CreateMachineRequest{
 						Machine:      newMachine(-1),
-						MachineClass: newMachineClass([]byte("{\"ami\":\"" + mockclient.FailQueryAtRunInstances + "\",\"blockDevices\":[{\"ebs\":{\"volumeSize\":50,\"volumeType\":\"gp2\"}}],\"iam\":{\"name\":\"test-iam\"},\"keyName\":\"test-ssh-publickey\",\"machineType\":\"m4.large\",\"networkInterfaces\":[{\"securityGroupIDs\":[\"sg-00002132323\"],\"subnetID\":\"subnet-123456\"}],\"region\":\"eu-west-1\",\"tags\":{\"kubernetes.io/cluster/shoot--test\":\"1\",\"kubernetes.io/role/test\":\"1\"}}")),
+						MachineClass: newMachineClass(providerSpec),
 						Secret:       providerSecret,
 					},
 				},
 				expect: expect{
 					errToHaveOccurred: true,
-					errMessage:        "machine codes error: code = [Internal] message = [Couldn't run instance with given ID]",
+					errMessage:        "machine codes error: code = [ResourceExhausted] message = [InsufficientInstanceCapacity: We currently do not have sufficient m4.large capacity\n\tstatus code: 500, request id: ]",
 				},
 			}),
 		)
 	})
 
 	Describe("#DeleteMachine", func() {
+		cleanupSpec := []byte("{\"ami\":\"ami-123456789\",\"blockDevices\":[{\"ebs\":{\"volumeSize\":50,\"volumeType\":\"gp2\"}}],\"cleanupPolicy\":{\"volumes\":\"Delete\",\"networkInterfaces\":\"Retain\"},\"iam\":{\"name\":\"test-iam\"},\"keyName\":\"test-ssh-publickey\",\"machineType\":\"m4.large\",\"networkInterfaces\":[{\"securityGroupIDs\":[\"sg-00002132323\"],\"subnetID\":\"subnet-123456\"}],\"region\":\"eu-west-1\",\"tags\":{\"kubernetes.io/cluster/shoot--test\":\"1\",\"kubernetes.io/role/test\":\"1\"}}")
+		leftoverTags := func(machineName, clusterTag string) []*ec2.Tag {
+			return []*ec2.Tag{
+				{Key: aws.String("Name"), Value: aws.String(machineName)},
+				{Key: aws.String(clusterTag), Value: aws.String("1")},
+			}
+		}
+		volume := func(id, state, machineName, clusterTag string) *ec2.Volume {
+			return &ec2.Volume{VolumeId: aws.String(id), State: aws.String(state), Tags: leftoverTags(machineName, clusterTag)}
+		}
+		networkInterface := func(id, state, machineName, clusterTag string) *ec2.NetworkInterface {
+			return &ec2.NetworkInterface{NetworkInterfaceId: aws.String(id), Status: aws.String(state), TagSet: leftoverTags(machineName, clusterTag)}
+		}
+
 		type setup struct {
 			createMachineRequest *driver.CreateMachineRequest
+			waitForTermination   bool
+			volumes              []*ec2.Volume
+			networkInterfaces    []*ec2.NetworkInterface
+			faults               []fakeec2.Fault
 		}
 		type action struct {
 			deleteMachineRequest *driver.DeleteMachineRequest
@@ -291,6 +333,13 @@ var _ = Describe("MachineServer", func() {
 			deleteMachineResponse *driver.DeleteMachineResponse
 			errToHaveOccurred     bool
 			errMessage            string
+			// retries is the number of deletions failing with Unavailable while the instance is terminating
+			retries       int
+			instancesLeft int
+			// volumesLeft and networkInterfacesLeft are the IDs of the volumes and network interfaces of the setup
+			// that are left
+			volumesLeft           []string
+			networkInterfacesLeft []string
 		}
 		type data struct {
 			setup  setup
@@ -299,23 +348,70 @@ var _ = Describe("MachineServer", func() {
 		}
 		DescribeTable("##table",
 			func(data *data) {
-				mockPluginSPIImpl := &mockclient.MockPluginSPIImpl{FakeInstances: make([]ec2.Instance, 0)}
-				ms := NewAWSDriver(mockPluginSPIImpl)
+				fake := newFakeEC2()
+				for _, volume := range data.setup.volumes {
+					fake.AddVolume(volume)
+				}
+				for _, networkInterface := range data.setup.networkInterfaces {
+					fake.AddNetworkInterface(networkInterface)
+				}
+				options := NewDriverOptions()
+				options.WaitForTermination = data.setup.waitForTermination
+				ms := NewDriver(fakeec2.NewSessionProvider(fake), options)
 
 				ctx := context.Background()
 
+				deleteMachineRequest := *data.action.deleteMachineRequest
 				if data.setup.createMachineRequest != nil {
-					_, err := ms.CreateMachine(ctx, data.setup.createMachineRequest)
+					response, err := ms.CreateMachine(ctx, data.setup.createMachineRequest)
 					Expect(err).ToNot(HaveOccurred())
+
+					// The fake assigns its own instance IDs, a request for the created machine refers to its instance
+					if deleteMachineRequest.Machine.Name == data.setup.createMachineRequest.Machine.Name && deleteMachineRequest.Machine.Spec.ProviderID != "" {
+						deleteMachineRequest.Machine = deleteMachineRequest.Machine.DeepCopy()
+						deleteMachineRequest.Machine.Spec.ProviderID = response.ProviderID
+					}
+				}
+				for _, fault := range data.setup.faults {
+					fake.InjectFault(fault)
 				}
 
-				_, err := ms.DeleteMachine(ctx, data.action.deleteMachineRequest)
+				for i := 0; i < data.expect.retries; i++ {
+					_, err := ms.DeleteMachine(ctx, &deleteMachineRequest)
+					statusErr, ok := status.FromError(err)
+					Expect(ok).To(BeTrue(), "%v", err)
+					Expect(statusErr.Code()).To(Equal(codes.Unavailable))
+				}
+				response, err := ms.DeleteMachine(ctx, &deleteMachineRequest)
 
 				if data.expect.errToHaveOccurred {
 					Expect(err).To(HaveOccurred())
 					Expect(err.Error()).To(Equal(data.expect.errMessage))
 				} else {
 					Expect(err).ToNot(HaveOccurred())
+					Expect(response).To(Equal(data.expect.deleteMachineResponse))
+					Expect(liveInstances(fake)).To(HaveLen(data.expect.instancesLeft))
+
+					leftovers := map[string]bool{}
+					for _, volume := range data.setup.volumes {
+						leftovers[*volume.VolumeId] = true
+					}
+					for _, networkInterface := range data.setup.networkInterfaces {
+						leftovers[*networkInterface.NetworkInterfaceId] = true
+					}
+					var volumesLeft, networkInterfacesLeft []string
+					for _, volume := range fake.Volumes() {
+						if leftovers[*volume.VolumeId] {
+							volumesLeft = append(volumesLeft, *volume.VolumeId)
+						}
+					}
+					for _, networkInterface := range fake.NetworkInterfaces() {
+						if leftovers[*networkInterface.NetworkInterfaceId] {
+							networkInterfacesLeft = append(networkInterfacesLeft, *networkInterface.NetworkInterfaceId)
+						}
+					}
+					Expect(volumesLeft).To(Equal(data.expect.volumesLeft))
+					Expect(networkInterfacesLeft).To(Equal(data.expect.networkInterfacesLeft))
 				}
 			},
 			Entry("Simple Machine Delete Request", &data{
@@ -360,7 +456,7 @@ var _ = Describe("MachineServer", func() {
 				},
 				expect: expect{
 					errToHaveOccurred: true,
-					errMessage:        "machine codes error: code = [Internal] message = [[secret providerAccessKeyId or accessKeyID is required field]]",
+					errMessage:        "machine codes error: code = [InvalidArgument] message = [Error while validating Secret: secretRef.providerAccessKeyId: Required value: Secret providerAccessKeyId or accessKeyID is required]",
 				},
 			}),
 			Entry("providerSecretAccessKey & userData missing for secret", &data{
@@ -384,91 +480,513 @@ var _ = Describe("MachineServer", func() {
 				},
 				expect: expect{
 					errToHaveOccurred: true,
-					errMessage:        "machine codes error: code = [Internal] message = [[secret providerSecretAccessKey or secretAccessKey is required field secret userData is required field]]",
+					errMessage:        "machine codes error: code = [InvalidArgument] message = [Error while validating Secret: secretRef.providerSecretAccessKey: Required value: Secret providerSecretAccessKey or secretAccessKey is required; secretRef.userData: Required value: Secret userData is required]",
 				},
 			}),
 			Entry("Termination of instance that doesn't exist on provider", &data{
 				setup: setup{},
 				action: action{
-					deleteMachineRequest: &driver.DeleteMachineRequest{
+					deleteMachineRequest: &driver.DeleteMachineRequest{
+						Machine:      newMachine(0),
+						MachineClass: newMachineClass(providerSpec),
+						Secret:       providerSecret,
+					},
+				},
+				expect: expect{
+					deleteMachineResponse: &driver.DeleteMachineResponse{},
+				},
+			}),
+			Entry("Termination of instance that doesn't exist on provider while others exist", &data{
+				setup: setup{
+					createMachineRequest: &driver.CreateMachineRequest{
+						Machine:      newMachine(1),
+						MachineClass: newMachineClass(providerSpec),
+						Secret:       providerSecret,
+					},
+				},
+				action: action{
+					deleteMachineRequest: &driver.DeleteMachineRequest{
+						Machine:      newMachine(0),
+						MachineClass: newMachineClass(providerSpec),
+						Secret:       providerSecret,
+					},
+				},
+				expect: expect{
+					deleteMachineResponse: &driver.DeleteMachineResponse{},
+					instancesLeft:         1,
+				},
+			}),
+			Entry("Termination of instance fails with an AWS error code", &data{
+				setup: setup{
+					faults: []fakeec2.Fault{
+						{Operation: "TerminateInstances", Err: fakeec2.NewRequestFailure("UnauthorizedOperation", "You are not authorized to perform this operation.", 403)},
+					},
+				},
+				action: action{
+					deleteMachineRequest: &driver.DeleteMachineRequest{
+						Machine:      newMachine(0),
+						MachineClass: newMachineClass(providerSpec),
+						Secret:       providerSecret,
+					},
+				},
+				expect: expect{
+					errToHaveOccurred: true,
+					errMessage:        "machine codes error: code = [PermissionDenied] message = [UnauthorizedOperation: You are not authorized to perform this operation.\n\tstatus code: 403, request id: ]",
+				},
+			}),
+			Entry("Termination of instance reported as not found by the provider", &data{
+				setup: setup{
+					faults: []fakeec2.Fault{
+						{Operation: "TerminateInstances", Err: fakeec2.NewRequestFailure(fakeec2.ErrCodeInvalidInstanceIDNotFound, "The instance ID 'i-0123456789-0' does not exist", 400)},
+					},
+				},
+				action: action{
+					deleteMachineRequest: &driver.DeleteMachineRequest{
+						Machine:      newMachine(0),
+						MachineClass: newMachineClass(providerSpec),
+						Secret:       providerSecret,
+					},
+				},
+				expect: expect{
+					deleteMachineResponse: &driver.DeleteMachineResponse{},
+				},
+			}),
+			Entry("Machine delete request without provider-ID terminates the instance found by name", &data{
+				setup: setup{
+					createMachineRequest: &driver.CreateMachineRequest{
+						Machine:      newMachine(-1),
+						MachineClass: newMachineClass(providerSpec),
+						Secret:       providerSecret,
+					},
+				},
+				action: action{
+					deleteMachineRequest: &driver.DeleteMachineRequest{
+						Machine:      newMachine(-1),
+						MachineClass: newMachineClass(providerSpec),
+						Secret:       providerSecret,
+					},
+				},
+				expect: expect{
+					deleteMachineResponse: &driver.DeleteMachineResponse{},
+				},
+			}),
+			Entry("Machine delete request without provider-ID and without instance", &data{
+				setup: setup{},
+				action: action{
+					deleteMachineRequest: &driver.DeleteMachineRequest{
+						Machine:      newMachine(-1),
+						MachineClass: newMachineClass(providerSpec),
+						Secret:       providerSecret,
+					},
+				},
+				expect: expect{
+					deleteMachineResponse: &driver.DeleteMachineResponse{},
+				},
+			}),
+			Entry("Machine delete request waiting for the termination", &data{
+				setup: setup{
+					createMachineRequest: &driver.CreateMachineRequest{
+						Machine:      newMachine(0),
+						MachineClass: newMachineClass(providerSpec),
+						Secret:       providerSecret,
+					},
+					waitForTermination: true,
+				},
+				action: action{
+					deleteMachineRequest: &driver.DeleteMachineRequest{
+						Machine:      newMachine(0),
+						MachineClass: newMachineClass(providerSpec),
+						Secret:       providerSecret,
+					},
+				},
+				expect: expect{
+					deleteMachineResponse: &driver.DeleteMachineResponse{},
+				},
+			}),
+			Entry("Machine delete request cleaning up the volumes and network interfaces left behind", &data{
+				setup: setup{
+					createMachineRequest: &driver.CreateMachineRequest{
+						Machine:      newMachine(0),
+						MachineClass: newMachineClass(cleanupSpec),
+						Secret:       providerSecret,
+					},
+					volumes: []*ec2.Volume{
+						volume("vol-1", "available", "machine-0", "kubernetes.io/cluster/shoot--test"),
+						volume("vol-2", "in-use", "machine-0", "kubernetes.io/cluster/shoot--test"),
+						volume("vol-3", "available", "machine-1", "kubernetes.io/cluster/shoot--test"),
+						volume("vol-4", "available", "machine-0", "kubernetes.io/cluster/shoot--other"),
+					},
+					networkInterfaces: []*ec2.NetworkInterface{
+						networkInterface("eni-1", "available", "machine-0", "kubernetes.io/cluster/shoot--test"),
+					},
+				},
+				action: action{
+					deleteMachineRequest: &driver.DeleteMachineRequest{
+						Machine:      newMachine(0),
+						MachineClass: newMachineClass(cleanupSpec),
+						Secret:       providerSecret,
+					},
+				},
+				expect: expect{
+					deleteMachineResponse: &driver.DeleteMachineResponse{
+						LastKnownState: "Cleanup of Machine \"machine-0\": deleted volumes vol-1; retained network interfaces eni-1",
+					},
+					retries:               1,
+					volumesLeft:           []string{"vol-2", "vol-3", "vol-4"},
+					networkInterfacesLeft: []string{"eni-1"},
+				},
+			}),
+			Entry("Machine delete request without provider-ID cleaning up once the instance is terminated", &data{
+				setup: setup{
+					createMachineRequest: &driver.CreateMachineRequest{
+						Machine:      newMachine(-1),
+						MachineClass: newMachineClass(cleanupSpec),
+						Secret:       providerSecret,
+					},
+					volumes: []*ec2.Volume{
+						volume("vol-1", "available", "machine-0", "kubernetes.io/cluster/shoot--test"),
+					},
+				},
+				action: action{
+					deleteMachineRequest: &driver.DeleteMachineRequest{
+						Machine:      newMachine(-1),
+						MachineClass: newMachineClass(cleanupSpec),
+						Secret:       providerSecret,
+					},
+				},
+				expect: expect{
+					deleteMachineResponse: &driver.DeleteMachineResponse{
+						LastKnownState: "Cleanup of Machine \"machine-0\": deleted volumes vol-1",
+					},
+					retries: 1,
+				},
+			}),
+			Entry("Machine delete request cleaning up after an instance that is already gone", &data{
+				setup: setup{
+					volumes: []*ec2.Volume{
+						volume("vol-1", "available", "machine-0", "kubernetes.io/cluster/shoot--test"),
+					},
+				},
+				action: action{
+					deleteMachineRequest: &driver.DeleteMachineRequest{
+						Machine:      newMachine(0),
+						MachineClass: newMachineClass(cleanupSpec),
+						Secret:       providerSecret,
+					},
+				},
+				expect: expect{
+					deleteMachineResponse: &driver.DeleteMachineResponse{
+						LastKnownState: "Cleanup of Machine \"machine-0\": deleted volumes vol-1",
+					},
+				},
+			}),
+		)
+
+		It("should not clean up after an instance without provider-ID while it is shutting down", func() {
+			fake := newFakeEC2(fakeec2.WithLifecycle(fakeec2.Lifecycle{ShuttingDown: time.Hour}))
+			ms := NewDriver(fakeec2.NewSessionProvider(fake), NewDriverOptions())
+			request := &driver.DeleteMachineRequest{
+				Machine:      newMachine(-1),
+				MachineClass: newMachineClass(cleanupSpec),
+				Secret:       providerSecret,
+			}
+			_, err := ms.CreateMachine(context.Background(), &driver.CreateMachineRequest{
+				Machine:      request.Machine,
+				MachineClass: request.MachineClass,
+				Secret:       request.Secret,
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			for i := 0; i < 2; i++ {
+				_, err = ms.DeleteMachine(context.Background(), request)
+				statusErr, ok := status.FromError(err)
+				Expect(ok).To(BeTrue(), "%v", err)
+				Expect(statusErr.Code()).To(Equal(codes.Unavailable))
+			}
+			Expect(fake.Calls("DescribeVolumes")).To(BeZero())
+		})
+	})
+
+	Describe("#GetMachine", func() {
+		deletingMachine := func(index int) *v1alpha1.Machine {
+			machine := newMachine(index)
+			machine.DeletionTimestamp = &v1.Time{Time: time.Now()}
+			return machine
+		}
+		scheduledEvent := func(code, description string) func(*fakeec2.EC2, string) {
+			return func(fake *fakeec2.EC2, instanceID string) {
+				Expect(fake.SetInstanceStatus(instanceID, &ec2.InstanceStatus{
+					Events: []*ec2.InstanceStatusEvent{
+						{
+							Code:        aws.String(code),
+							Description: aws.String(description),
+							NotBefore:   aws.Time(time.Date(2020, 12, 1, 10, 0, 0, 0, time.UTC)),
+						},
+					},
+				})).To(Succeed())
+			}
+		}
+
+		type setup struct {
+			createMachineRequest *driver.CreateMachineRequest
+			// prepare changes the instance created for the machine
+			prepare             func(fake *fakeec2.EC2, instanceID string)
+			checkInstanceEvents bool
+		}
+		type action struct {
+			getMachineRequest *driver.GetMachineStatusRequest
+		}
+		type expect struct {
+			// launched expects the response to describe the instance created for the machine
+			launched          bool
+			errToHaveOccurred bool
+			// errMessage is formatted with the ID of the instance created for the machine, if any
+			errMessage string
+		}
+		type data struct {
+			setup  setup
+			action action
+			expect expect
+		}
+		DescribeTable("##table",
+			func(data *data) {
+				fake := newFakeEC2(fakeec2.WithLifecycle(fakeec2.Lifecycle{Stopping: time.Hour, ShuttingDown: time.Hour}))
+				options := NewDriverOptions()
+				options.CheckInstanceEvents = data.setup.checkInstanceEvents
+				ms := NewDriver(fakeec2.NewSessionProvider(fake), options)
+				ctx := context.Background()
+
+				getMachineRequest := *data.action.getMachineRequest
+				errMessage := data.expect.errMessage
+				var created *driver.CreateMachineResponse
+				if data.setup.createMachineRequest != nil {
+					var err error
+					created, err = ms.CreateMachine(ctx, data.setup.createMachineRequest)
+					Expect(err).ToNot(HaveOccurred())
+					_, instanceID, err := decodeRegionAndProviderID(created.ProviderID)
+					Expect(err).ToNot(HaveOccurred())
+
+					// The fake assigns its own instance IDs, a request with the provider ID of the created machine
+					// refers to its instance
+					if providerID := getMachineRequest.Machine.Spec.ProviderID; providerID != "" && providerID == data.setup.createMachineRequest.Machine.Spec.ProviderID {
+						getMachineRequest.Machine = getMachineRequest.Machine.DeepCopy()
+						getMachineRequest.Machine.Spec.ProviderID = created.ProviderID
+					}
+					if data.setup.prepare != nil {
+						data.setup.prepare(fake, instanceID)
+					}
+					if errMessage != "" {
+						errMessage = fmt.Sprintf(errMessage, instanceID)
+					}
+				}
+
+				response, err := ms.GetMachineStatus(ctx, &getMachineRequest)
+
+				if data.expect.errToHaveOccurred {
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(Equal(errMessage))
+				} else {
+					Expect(err).ToNot(HaveOccurred())
+					if data.expect.launched {
+						Expect(response).To(Equal(&driver.GetMachineStatusResponse{
+							ProviderID: created.ProviderID,
+							NodeName:   created.NodeName,
+						}))
+					}
+				}
+			},
+			Entry("Simple Machine Get Request", &data{
+				setup: setup{
+					createMachineRequest: &driver.CreateMachineRequest{
+						Machine:      newMachine(0),
+						MachineClass: newMachineClass(providerSpec),
+						Secret:       providerSecret,
+					},
+				},
+				action: action{
+					getMachineRequest: &driver.GetMachineStatusRequest{
+						Machine:      newMachine(0),
+						MachineClass: newMachineClass(providerSpec),
+						Secret:       providerSecret,
+					},
+				},
+				expect: expect{
+					launched: true,
+				},
+			}),
+			Entry("providerAccessKeyId missing for secret", &data{
+				action: action{
+					getMachineRequest: &driver.GetMachineStatusRequest{
+						Machine:      newMachine(0),
+						MachineClass: newMachineClass(providerSpec),
+						Secret: &corev1.Secret{
+							Data: map[string][]byte{
+								"providerSecretAccessKey": []byte("dummy-secret"),
+								"userData":                []byte("dummy-user-data"),
+							},
+						},
+					},
+				},
+				expect: expect{
+					errToHaveOccurred: true,
+					errMessage:        "machine codes error: code = [InvalidArgument] message = [Error while validating ProviderSpec: secretRef.providerAccessKeyId: Required value: Secret providerAccessKeyId or accessKeyID is required]",
+				},
+			}),
+			Entry("providerSecretAccessKey missing for secret", &data{
+				action: action{
+					getMachineRequest: &driver.GetMachineStatusRequest{
+						Machine:      newMachine(0),
+						MachineClass: newMachineClass(providerSpec),
+						Secret: &corev1.Secret{
+							Data: map[string][]byte{
+								"providerAccessKeyId": []byte("dummy-id"),
+								"userData":            []byte("dummy-user-data"),
+							},
+						},
+					},
+				},
+				expect: expect{
+					errToHaveOccurred: true,
+					errMessage:        "machine codes error: code = [InvalidArgument] message = [Error while validating ProviderSpec: secretRef.providerSecretAccessKey: Required value: Secret providerSecretAccessKey or secretAccessKey is required]",
+				},
+			}),
+			Entry("userData missing for secret", &data{
+				action: action{
+					getMachineRequest: &driver.GetMachineStatusRequest{
+						Machine:      newMachine(0),
+						MachineClass: newMachineClass(providerSpec),
+						Secret: &corev1.Secret{
+							Data: map[string][]byte{
+								"providerAccessKeyId":     []byte("dummy-id"),
+								"providerSecretAccessKey": []byte("dummy-secret"),
+							},
+						},
+					},
+				},
+				expect: expect{
+					errToHaveOccurred: true,
+					errMessage:        "machine codes error: code = [InvalidArgument] message = [Error while validating ProviderSpec: secretRef.userData: Required value: Secret userData is required]",
+				},
+			}),
+			Entry("Machine deletion where provider-ID is missing", &data{
+				setup: setup{
+					createMachineRequest: &driver.CreateMachineRequest{
+						Machine:      newMachine(0),
+						MachineClass: newMachineClass(providerSpec),
+						Secret:       providerSecret,
+					},
+				},
+				action: action{
+					getMachineRequest: &driver.GetMachineStatusRequest{
+						Machine:      newMachine(-1),
+						MachineClass: newMachineClass(providerSpec),
+						Secret:       providerSecret,
+					},
+				},
+				expect: expect{
+					errToHaveOccurred: false,
+				},
+			}),
+			Entry("Get request without a create request", &data{
+				setup: setup{},
+				action: action{
+					getMachineRequest: &driver.GetMachineStatusRequest{
+						Machine:      newMachine(0),
+						MachineClass: newMachineClass(providerSpec),
+						Secret:       providerSecret,
+					},
+				},
+				expect: expect{
+					errToHaveOccurred: true,
+					errMessage:        "machine codes error: code = [NotFound] message = [AWS plugin is returning no VM instance with ID \"i-0123456789-0\" backing this machine object]",
+				},
+			}),
+			Entry("Get request without provider-ID and without a create request", &data{
+				setup: setup{},
+				action: action{
+					getMachineRequest: &driver.GetMachineStatusRequest{
+						Machine:      newMachine(-1),
+						MachineClass: newMachineClass(providerSpec),
+						Secret:       providerSecret,
+					},
+				},
+				expect: expect{
+					errToHaveOccurred: true,
+					errMessage:        "machine codes error: code = [NotFound] message = [AWS plugin is returning no VM instances backing this machine object]",
+				},
+			}),
+			Entry("Get request with provider-ID finds the instance despite a changed Name tag", &data{
+				setup: setup{
+					createMachineRequest: &driver.CreateMachineRequest{
+						Machine:      newMachine(0),
+						MachineClass: newMachineClass(providerSpec),
+						Secret:       providerSecret,
+					},
+				},
+				action: action{
+					getMachineRequest: &driver.GetMachineStatusRequest{
+						Machine:      newMachineWithProviderID(1, "aws:///eu-west-1/i-0123456789-0"),
+						MachineClass: newMachineClass(providerSpec),
+						Secret:       providerSecret,
+					},
+				},
+				expect: expect{
+					launched: true,
+				},
+			}),
+			Entry("Get request with provider-ID of an instance without the cluster tag", &data{
+				setup: setup{
+					createMachineRequest: &driver.CreateMachineRequest{
+						Machine:      newMachine(0),
+						MachineClass: newMachineClass(providerSpec),
+						Secret:       providerSecret,
+					},
+				},
+				action: action{
+					getMachineRequest: &driver.GetMachineStatusRequest{
 						Machine:      newMachine(0),
-						MachineClass: newMachineClass(providerSpec),
+						MachineClass: newMachineClass([]byte("{\"ami\":\"ami-123456789\",\"blockDevices\":[{\"ebs\":{\"volumeSize\":50,\"volumeType\":\"gp2\"}}],\"iam\":{\"name\":\"test-iam\"},\"keyName\":\"test-ssh-publickey\",\"machineType\":\"m4.large\",\"networkInterfaces\":[{\"securityGroupIDs\":[\"sg-00002132323\"],\"subnetID\":\"subnet-123456\"}],\"region\":\"eu-west-1\",\"tags\":{\"kubernetes.io/cluster/shoot--other\":\"1\",\"kubernetes.io/role/test\":\"1\"}}")),
 						Secret:       providerSecret,
 					},
 				},
 				expect: expect{
-					deleteMachineResponse: &driver.DeleteMachineResponse{},
-					errToHaveOccurred:     true,
-					errMessage:            "machine codes error: code = [Internal] message = [Couldn't find instance with given instance-ID i-0123456789-0]",
+					errToHaveOccurred: true,
+					errMessage:        "machine codes error: code = [FailedPrecondition] message = [VM with ID %q doesn't carry the tags \"kubernetes.io/cluster/shoot--other\" and \"kubernetes.io/role/test\" expected for this machine object]",
 				},
 			}),
-			Entry("Termination of instance that doesn't exist on provider", &data{
+			Entry("Get request for an instance that is being stopped", &data{
 				setup: setup{
 					createMachineRequest: &driver.CreateMachineRequest{
-						Machine:      newMachine(-1),
-						MachineClass: newMachineClass([]byte("{\"ami\":\"" + mockclient.SetInstanceID + "\",\"blockDevices\":[{\"ebs\":{\"volumeSize\":50,\"volumeType\":\"gp2\"}}],\"iam\":{\"name\":\"test-iam\"},\"keyName\":\"" + mockclient.FailQueryAtTerminateInstances + "\",\"machineType\":\"m4.large\",\"networkInterfaces\":[{\"securityGroupIDs\":[\"sg-00002132323\"],\"subnetID\":\"subnet-123456\"}],\"region\":\"eu-west-1\",\"tags\":{\"kubernetes.io/cluster/shoot--test\":\"1\",\"kubernetes.io/role/test\":\"1\"}}")),
+						Machine:      newMachine(0),
+						MachineClass: newMachineClass(providerSpec),
 						Secret:       providerSecret,
 					},
+					prepare: func(fake *fakeec2.EC2, instanceID string) {
+						_, err := fake.StopInstances(&ec2.StopInstancesInput{InstanceIds: aws.StringSlice([]string{instanceID})})
+						Expect(err).ToNot(HaveOccurred())
+					},
 				},
 				action: action{
-					deleteMachineRequest: &driver.DeleteMachineRequest{
+					getMachineRequest: &driver.GetMachineStatusRequest{
 						Machine:      newMachine(0),
-						MachineClass: newMachineClass([]byte("{\"ami\":\"" + mockclient.SetInstanceID + "\",\"blockDevices\":[{\"ebs\":{\"volumeSize\":50,\"volumeType\":\"gp2\"}}],\"iam\":{\"name\":\"test-iam\"},\"keyName\":\"" + mockclient.FailQueryAtTerminateInstances + "\",\"machineType\":\"m4.large\",\"networkInterfaces\":[{\"securityGroupIDs\":[\"sg-00002132323\"],\"subnetID\":\"subnet-123456\"}],\"region\":\"eu-west-1\",\"tags\":{\"kubernetes.io/cluster/shoot--test\":\"1\",\"kubernetes.io/role/test\":\"1\"}}")),
+						MachineClass: newMachineClass(providerSpec),
 						Secret:       providerSecret,
 					},
 				},
 				expect: expect{
 					errToHaveOccurred: true,
-					errMessage:        "machine codes error: code = [Internal] message = [Couldn't find instance with given instance-ID i-0123456789-0]",
+					errMessage:        "machine codes error: code = [Aborted] message = [VM %q is stopping: Client.UserInitiatedShutdown: User initiated shutdown]",
 				},
 			}),
-		)
-	})
-
-	Describe("#GetMachine", func() {
-		type setup struct {
-			createMachineRequest *driver.CreateMachineRequest
-		}
-		type action struct {
-			getMachineRequest *driver.GetMachineStatusRequest
-		}
-		type expect struct {
-			getMachineResponse *driver.GetMachineStatusResponse
-			errToHaveOccurred  bool
-			errMessage         string
-		}
-		type data struct {
-			setup  setup
-			action action
-			expect expect
-		}
-		DescribeTable("##table",
-			func(data *data) {
-				mockPluginSPIImpl := &mockclient.MockPluginSPIImpl{FakeInstances: make([]ec2.Instance, 0)}
-				ms := NewAWSDriver(mockPluginSPIImpl)
-				ctx := context.Background()
-
-				if data.setup.createMachineRequest != nil {
-					_, err := ms.CreateMachine(ctx, data.setup.createMachineRequest)
-					Expect(err).ToNot(HaveOccurred())
-				}
-
-				_, err := ms.GetMachineStatus(ctx, data.action.getMachineRequest)
-
-				if data.expect.errToHaveOccurred {
-					Expect(err).To(HaveOccurred())
-					Expect(err.Error()).To(Equal(data.expect.errMessage))
-				} else {
-					Expect(err).ToNot(HaveOccurred())
-				}
-			},
-			Entry("Simple Machine Get Request", &data{
+			Entry("Get request for an instance with a scheduled retirement", &data{
 				setup: setup{
 					createMachineRequest: &driver.CreateMachineRequest{
 						Machine:      newMachine(0),
 						MachineClass: newMachineClass(providerSpec),
 						Secret:       providerSecret,
 					},
+					prepare:             scheduledEvent("instance-retirement", "The instance is running on degraded hardware"),
+					checkInstanceEvents: true,
 				},
 				action: action{
 					getMachineRequest: &driver.GetMachineStatusRequest{
@@ -477,115 +995,120 @@ var _ = Describe("MachineServer", func() {
 						Secret:       providerSecret,
 					},
 				},
-				expect: expect{},
+				expect: expect{
+					errToHaveOccurred: true,
+					errMessage:        "machine codes error: code = [Aborted] message = [VM %q has a scheduled instance-retirement event not before 2020-12-01T10:00:00Z: The instance is running on degraded hardware]",
+				},
 			}),
-			Entry("providerAccessKeyId missing for secret", &data{
+			Entry("Get request for an instance with a scheduled retirement without checking instance events", &data{
 				setup: setup{
 					createMachineRequest: &driver.CreateMachineRequest{
 						Machine:      newMachine(0),
 						MachineClass: newMachineClass(providerSpec),
 						Secret:       providerSecret,
 					},
+					prepare: scheduledEvent("instance-retirement", "The instance is running on degraded hardware"),
 				},
 				action: action{
 					getMachineRequest: &driver.GetMachineStatusRequest{
 						Machine:      newMachine(0),
 						MachineClass: newMachineClass(providerSpec),
-						Secret: &corev1.Secret{
-							Data: map[string][]byte{
-								"providerSecretAccessKey": []byte("dummy-secret"),
-								"userData":                []byte("dummy-user-data"),
-							},
-						},
+						Secret:       providerSecret,
 					},
 				},
 				expect: expect{
-					errToHaveOccurred: true,
-					errMessage:        "machine codes error: code = [Internal] message = [Error while validating ProviderSpec [secret providerAccessKeyId or accessKeyID is required field]]",
+					launched: true,
 				},
 			}),
-			Entry("providerSecretAccessKey missing for secret", &data{
+			Entry("Get request for an instance with a completed reboot", &data{
 				setup: setup{
 					createMachineRequest: &driver.CreateMachineRequest{
 						Machine:      newMachine(0),
 						MachineClass: newMachineClass(providerSpec),
 						Secret:       providerSecret,
 					},
+					prepare:             scheduledEvent("system-reboot", "[Completed] Scheduled reboot"),
+					checkInstanceEvents: true,
 				},
 				action: action{
 					getMachineRequest: &driver.GetMachineStatusRequest{
 						Machine:      newMachine(0),
 						MachineClass: newMachineClass(providerSpec),
-						Secret: &corev1.Secret{
-							Data: map[string][]byte{
-								"providerAccessKeyId": []byte("dummy-id"),
-								"userData":            []byte("dummy-user-data"),
-							},
-						},
+						Secret:       providerSecret,
 					},
 				},
 				expect: expect{
-					errToHaveOccurred: true,
-					errMessage:        "machine codes error: code = [Internal] message = [Error while validating ProviderSpec [secret providerSecretAccessKey or secretAccessKey is required field]]",
+					launched: true,
 				},
 			}),
-			Entry("userData missing for secret", &data{
+			Entry("Get request for a spot instance that is being reclaimed", &data{
 				setup: setup{
 					createMachineRequest: &driver.CreateMachineRequest{
 						Machine:      newMachine(0),
-						MachineClass: newMachineClass(providerSpec),
+						MachineClass: newMachineClass([]byte("{\"ami\":\"ami-123456789\",\"blockDevices\":[{\"ebs\":{\"volumeSize\":50,\"volumeType\":\"gp2\"}}],\"iam\":{\"name\":\"test-iam\"},\"keyName\":\"test-ssh-publickey\",\"machineType\":\"m4.large\",\"networkInterfaces\":[{\"securityGroupIDs\":[\"sg-00002132323\"],\"subnetID\":\"subnet-123456\"}],\"region\":\"eu-west-1\",\"spotPrice\":\"\",\"tags\":{\"kubernetes.io/cluster/shoot--test\":\"1\",\"kubernetes.io/role/test\":\"1\"}}")),
 						Secret:       providerSecret,
 					},
+					prepare: func(fake *fakeec2.EC2, instanceID string) {
+						Expect(fake.InterruptSpotInstance(instanceID)).To(Succeed())
+					},
 				},
 				action: action{
 					getMachineRequest: &driver.GetMachineStatusRequest{
 						Machine:      newMachine(0),
-						MachineClass: newMachineClass(providerSpec),
-						Secret: &corev1.Secret{
-							Data: map[string][]byte{
-								"providerAccessKeyId":     []byte("dummy-id"),
-								"providerSecretAccessKey": []byte("dummy-secret"),
-							},
-						},
+						MachineClass: newMachineClass([]byte("{\"ami\":\"ami-123456789\",\"blockDevices\":[{\"ebs\":{\"volumeSize\":50,\"volumeType\":\"gp2\"}}],\"iam\":{\"name\":\"test-iam\"},\"keyName\":\"test-ssh-publickey\",\"machineType\":\"m4.large\",\"networkInterfaces\":[{\"securityGroupIDs\":[\"sg-00002132323\"],\"subnetID\":\"subnet-123456\"}],\"region\":\"eu-west-1\",\"spotPrice\":\"\",\"tags\":{\"kubernetes.io/cluster/shoot--test\":\"1\",\"kubernetes.io/role/test\":\"1\"}}")),
+						Secret:       providerSecret,
 					},
 				},
 				expect: expect{
 					errToHaveOccurred: true,
-					errMessage:        "machine codes error: code = [Internal] message = [Error while validating ProviderSpec [secret userData is required field]]",
+					errMessage:        "machine codes error: code = [Aborted] message = [VM %q is shutting-down: Server.SpotInstanceTermination: Spot instance termination]",
 				},
 			}),
-			Entry("Machine deletion where provider-ID is missing", &data{
+			Entry("Get request for an instance that is shutting down", &data{
 				setup: setup{
 					createMachineRequest: &driver.CreateMachineRequest{
 						Machine:      newMachine(0),
 						MachineClass: newMachineClass(providerSpec),
 						Secret:       providerSecret,
 					},
+					prepare: func(fake *fakeec2.EC2, instanceID string) {
+						_, err := fake.TerminateInstances(&ec2.TerminateInstancesInput{InstanceIds: aws.StringSlice([]string{instanceID})})
+						Expect(err).ToNot(HaveOccurred())
+					},
 				},
 				action: action{
 					getMachineRequest: &driver.GetMachineStatusRequest{
-						Machine:      newMachine(-1),
+						Machine:      newMachine(0),
 						MachineClass: newMachineClass(providerSpec),
 						Secret:       providerSecret,
 					},
 				},
 				expect: expect{
-					errToHaveOccurred: false,
+					errToHaveOccurred: true,
+					errMessage:        "machine codes error: code = [NotFound] message = [AWS plugin is returning no VM instance with ID %q backing this machine object]",
 				},
 			}),
-			Entry("Get request without a create request", &data{
-				setup: setup{},
+			Entry("Get request for a stopped instance of a machine that is being deleted", &data{
+				setup: setup{
+					createMachineRequest: &driver.CreateMachineRequest{
+						Machine:      newMachine(0),
+						MachineClass: newMachineClass(providerSpec),
+						Secret:       providerSecret,
+					},
+					prepare: func(fake *fakeec2.EC2, instanceID string) {
+						_, err := fake.StopInstances(&ec2.StopInstancesInput{InstanceIds: aws.StringSlice([]string{instanceID})})
+						Expect(err).ToNot(HaveOccurred())
+					},
+				},
 				action: action{
 					getMachineRequest: &driver.GetMachineStatusRequest{
-						Machine:      newMachine(0),
+						Machine:      deletingMachine(0),
 						MachineClass: newMachineClass(providerSpec),
 						Secret:       providerSecret,
 					},
 				},
 				expect: expect{
-					getMachineResponse: &driver.GetMachineStatusResponse{},
-					errToHaveOccurred:  true,
-					errMessage:         "machine codes error: code = [NotFound] message = [AWS plugin is returning no VM instances backing this machine object]",
+					launched: true,
 				},
 			}),
 		)
@@ -594,14 +1117,18 @@ var _ = Describe("MachineServer", func() {
 	Describe("#ListMachines", func() {
 		type setup struct {
 			createMachineRequest []*driver.CreateMachineRequest
+			listPageSize         int64
+			faults               []fakeec2.Fault
 		}
 		type action struct {
 			listMachineRequest *driver.ListMachinesRequest
 		}
 		type expect struct {
-			listMachineResponse *driver.ListMachinesResponse
-			errToHaveOccurred   bool
-			errMessage          string
+			// machineNames are the names of the machines whose instances are listed
+			machineNames      []string
+			describeCalls     int
+			errToHaveOccurred bool
+			errMessage        string
 		}
 		type data struct {
 			setup  setup
@@ -610,14 +1137,22 @@ var _ = Describe("MachineServer", func() {
 		}
 		DescribeTable("##table",
 			func(data *data) {
-				mockPluginSPIImpl := &mockclient.MockPluginSPIImpl{FakeInstances: make([]ec2.Instance, 0)}
-				ms := NewAWSDriver(mockPluginSPIImpl)
+				fake := newFakeEC2()
+				options := NewDriverOptions()
+				if data.setup.listPageSize != 0 {
+					options.ListMachinesPageSize = data.setup.listPageSize
+				}
+				ms := NewDriver(fakeec2.NewSessionProvider(fake), options)
 				ctx := context.Background()
 
 				for _, createReq := range data.setup.createMachineRequest {
 					_, err := ms.CreateMachine(ctx, createReq)
 					Expect(err).ToNot(HaveOccurred())
 				}
+				for _, fault := range data.setup.faults {
+					fake.InjectFault(fault)
+				}
+				calls := fake.Calls("DescribeInstances")
 
 				listResponse, err := ms.ListMachines(ctx, data.action.listMachineRequest)
 
@@ -626,8 +1161,14 @@ var _ = Describe("MachineServer", func() {
 					Expect(err.Error()).To(Equal(data.expect.errMessage))
 				} else {
 					Expect(err).ToNot(HaveOccurred())
-					Expect(len(listResponse.MachineList)).To(Equal(len(data.expect.listMachineResponse.MachineList)))
-					//Expect(listResponse.MachineList).To(Equal(data.expect.listMachineResponse))
+					machineList := map[string]string{}
+					for _, machineName := range data.expect.machineNames {
+						machineList[encodeProviderID("eu-west-1", *launchedInstance(fake, machineName).InstanceId)] = machineName
+					}
+					Expect(listResponse.MachineList).To(Equal(machineList))
+					if data.expect.describeCalls > 0 {
+						Expect(fake.Calls("DescribeInstances") - calls).To(Equal(data.expect.describeCalls))
+					}
 				}
 			},
 			Entry("Simple Machine List Request", &data{
@@ -658,14 +1199,162 @@ var _ = Describe("MachineServer", func() {
 				},
 				expect: expect{
 					errToHaveOccurred: false,
-					listMachineResponse: &driver.ListMachinesResponse{
-						MachineList: map[string]string{
-							"aws:///eu-west-1/i-0123456789-0": "machine-0",
-							"aws:///eu-west-1/i-0123456789-1": "machine-1",
-							"aws:///eu-west-1/i-0123456789-2": "machine-2",
+					machineNames:      []string{"machine-0", "machine-1", "machine-2"},
+				},
+			}),
+			Entry("Machine list request spanning several DescribeInstances pages", &data{
+				setup: setup{
+					createMachineRequest: []*driver.CreateMachineRequest{
+						{
+							Machine:      newMachine(0),
+							MachineClass: newMachineClass(providerSpec),
+							Secret:       providerSecret,
+						},
+						{
+							Machine:      newMachine(1),
+							MachineClass: newMachineClass(providerSpec),
+							Secret:       providerSecret,
+						},
+						{
+							Machine:      newMachine(2),
+							MachineClass: newMachineClass(providerSpec),
+							Secret:       providerSecret,
+						},
+						{
+							Machine:      newMachine(3),
+							MachineClass: newMachineClass(providerSpec),
+							Secret:       providerSecret,
+						},
+						{
+							Machine:      newMachine(4),
+							MachineClass: newMachineClass(providerSpec),
+							Secret:       providerSecret,
+						},
+						{
+							Machine:      newMachine(5),
+							MachineClass: newMachineClass(providerSpec),
+							Secret:       providerSecret,
+						},
+						{
+							Machine:      newMachine(6),
+							MachineClass: newMachineClass(providerSpec),
+							Secret:       providerSecret,
+						},
+					},
+					listPageSize: 5,
+				},
+				action: action{
+					listMachineRequest: &driver.ListMachinesRequest{
+						MachineClass: newMachineClass(providerSpec),
+						Secret:       providerSecret,
+					},
+				},
+				expect: expect{
+					machineNames:  []string{"machine-0", "machine-1", "machine-2", "machine-3", "machine-4", "machine-5", "machine-6"},
+					describeCalls: 2,
+				},
+			}),
+			Entry("Machine list request with a page size below the EC2 minimum", &data{
+				setup: setup{
+					createMachineRequest: []*driver.CreateMachineRequest{
+						{
+							Machine:      newMachine(0),
+							MachineClass: newMachineClass(providerSpec),
+							Secret:       providerSecret,
+						},
+						{
+							Machine:      newMachine(1),
+							MachineClass: newMachineClass(providerSpec),
+							Secret:       providerSecret,
+						},
+						{
+							Machine:      newMachine(2),
+							MachineClass: newMachineClass(providerSpec),
+							Secret:       providerSecret,
+						},
+						{
+							Machine:      newMachine(3),
+							MachineClass: newMachineClass(providerSpec),
+							Secret:       providerSecret,
+						},
+						{
+							Machine:      newMachine(4),
+							MachineClass: newMachineClass(providerSpec),
+							Secret:       providerSecret,
+						},
+						{
+							Machine:      newMachine(5),
+							MachineClass: newMachineClass(providerSpec),
+							Secret:       providerSecret,
+						},
+						{
+							Machine:      newMachine(6),
+							MachineClass: newMachineClass(providerSpec),
+							Secret:       providerSecret,
+						},
+						{
+							Machine:      newMachine(7),
+							MachineClass: newMachineClass(providerSpec),
+							Secret:       providerSecret,
+						},
+						{
+							Machine:      newMachine(8),
+							MachineClass: newMachineClass(providerSpec),
+							Secret:       providerSecret,
+						},
+						{
+							Machine:      newMachine(9),
+							MachineClass: newMachineClass(providerSpec),
+							Secret:       providerSecret,
+						},
+						{
+							Machine:      newMachine(10),
+							MachineClass: newMachineClass(providerSpec),
+							Secret:       providerSecret,
+						},
+						{
+							Machine:      newMachine(11),
+							MachineClass: newMachineClass(providerSpec),
+							Secret:       providerSecret,
+						},
+					},
+					listPageSize: 1,
+				},
+				action: action{
+					listMachineRequest: &driver.ListMachinesRequest{
+						MachineClass: newMachineClass(providerSpec),
+						Secret:       providerSecret,
+					},
+				},
+				expect: expect{
+					machineNames:  []string{"machine-0", "machine-1", "machine-2", "machine-3", "machine-4", "machine-5", "machine-6", "machine-7", "machine-8", "machine-9", "machine-10", "machine-11"},
+					describeCalls: 3,
+				},
+			}),
+			Entry("Machine list request only lists the instances of the machine class", &data{
+				setup: setup{
+					createMachineRequest: []*driver.CreateMachineRequest{
+						{
+							Machine:      newMachine(0),
+							MachineClass: newNamedMachineClass("class-a", providerSpec),
+							Secret:       providerSecret,
+						},
+						{
+							Machine:      newMachine(1),
+							MachineClass: newNamedMachineClass("class-b", providerSpec),
+							Secret:       providerSecret,
 						},
 					},
 				},
+				action: action{
+					listMachineRequest: &driver.ListMachinesRequest{
+						MachineClass: newNamedMachineClass("class-a", providerSpec),
+						Secret:       providerSecret,
+					},
+				},
+				expect: expect{
+					machineNames: []string{"machine-0"},
+				},
 			}),
 			Entry("Unexpected end of JSON input", &data{
 				setup: setup{},
@@ -695,7 +1384,7 @@ var _ = Describe("MachineServer", func() {
 				},
 				expect: expect{
 					errToHaveOccurred: true,
-					errMessage:        "machine codes error: code = [Internal] message = [Error while validating ProviderSpec [secret providerAccessKeyId or accessKeyID is required field]]",
+					errMessage:        "machine codes error: code = [InvalidArgument] message = [Error while validating ProviderSpec: secretRef.providerAccessKeyId: Required value: Secret providerAccessKeyId or accessKeyID is required]",
 				},
 			}),
 			Entry("providerSecretAccessKey missing for secret", &data{
@@ -713,7 +1402,7 @@ var _ = Describe("MachineServer", func() {
 				},
 				expect: expect{
 					errToHaveOccurred: true,
-					errMessage:        "machine codes error: code = [Internal] message = [Error while validating ProviderSpec [secret providerSecretAccessKey or secretAccessKey is required field]]",
+					errMessage:        "machine codes error: code = [InvalidArgument] message = [Error while validating ProviderSpec: secretRef.providerSecretAccessKey: Required value: Secret providerSecretAccessKey or secretAccessKey is required]",
 				},
 			}),
 			Entry("userData missing for secret", &data{
@@ -731,7 +1420,7 @@ var _ = Describe("MachineServer", func() {
 				},
 				expect: expect{
 					errToHaveOccurred: true,
-					errMessage:        "machine codes error: code = [Internal] message = [Error while validating ProviderSpec [secret userData is required field]]",
+					errMessage:        "machine codes error: code = [InvalidArgument] message = [Error while validating ProviderSpec: secretRef.userData: Required value: Secret userData is required]",
 				},
 			}),
 
@@ -744,20 +1433,20 @@ var _ = Describe("MachineServer", func() {
 				},
 				expect: expect{
 					errToHaveOccurred: true,
-					errMessage:        "machine codes error: code = [Internal] message = [Error while validating ProviderSpec [AMI is required field Region is required field]]",
+					errMessage:        "machine codes error: code = [InvalidArgument] message = [Error while validating ProviderSpec: providerSpec.ami: Required value: AMI is required; providerSpec.region: Required value: Region is required]",
 				},
 			}),
 
 			Entry("Region doesn't exist", &data{
 				action: action{
 					listMachineRequest: &driver.ListMachinesRequest{
-						MachineClass: newMachineClass([]byte("{\"ami\":\"ami-123456789\",\"blockDevices\":[{\"ebs\":{\"volumeSize\":50,\"volumeType\":\"gp2\"}}],\"iam\":{\"name\":\"test-iam\"},\"keyName\":\"test-ssh-publickey\",\"machineType\":\"m4.large\",\"networkInterfaces\":[{\"securityGroupIDs\":[\"sg-00002132323\"],\"subnetID\":\"subnet-123456\"}],\"region\":\"" + mockclient.FailAtRegion + "\",\"tags\":{\"kubernetes.io/cluster/shoot--test\":\"1\",\"kubernetes.io/role/test\":\"1\"}}")),
+						MachineClass: newMachineClass([]byte("{\"ami\":\"ami-123456789\",\"blockDevices\":[{\"ebs\":{\"volumeSize\":50,\"volumeType\":\"gp2\"}}],\"iam\":{\"name\":\"test-iam\"},\"keyName\":\"test-ssh-publickey\",\"machineType\":\"m4.large\",\"networkInterfaces\":[{\"securityGroupIDs\":[\"sg-00002132323\"],\"subnetID\":\"subnet-123456\"}],\"region\":\"eu-east-2\",\"tags\":{\"kubernetes.io/cluster/shoot--test\":\"1\",\"kubernetes.io/role/test\":\"1\"}}")),
 						Secret:       providerSecret,
 					},
 				},
 				expect: expect{
 					errToHaveOccurred: true,
-					errMessage:        "machine codes error: code = [Internal] message = [Region doesn't exist while trying to create session]",
+					errMessage:        "machine codes error: code = [Internal] message = [Region \"eu-east-2\" doesn't exist while trying to create session]",
 				},
 			}),
 			Entry("Cluster details missing in machine class", &data{
@@ -769,19 +1458,24 @@ var _ = Describe("MachineServer", func() {
 				},
 				expect: expect{
 					errToHaveOccurred: true,
-					errMessage:        "machine codes error: code = [Internal] message = [Error while validating ProviderSpec [Tag is required of the form kubernetes.io/cluster/****]]",
+					errMessage:        "machine codes error: code = [InvalidArgument] message = [Error while validating ProviderSpec: providerSpec.tags: Required value: Tag is required of the form kubernetes.io/cluster/****]",
 				},
 			}),
 			Entry("Cloud provider returned error while describing instance", &data{
+				setup: setup{
+					faults: []fakeec2.Fault{
+						{Operation: "DescribeInstances", Err: fakeec2.NewRequestFailure("InternalError", "An internal error has occurred", 500)},
+					},
+				},
 				action: action{
 					listMachineRequest: &driver.ListMachinesRequest{
-						MachineClass: newMachineClass([]byte("{\"ami\":\"ami-123456789\",\"blockDevices\":[{\"ebs\":{\"volumeSize\":50,\"volumeType\":\"gp2\"}}],\"iam\":{\"name\":\"test-iam\"},\"keyName\":\"test-ssh-publickey\",\"machineType\":\"m4.large\",\"networkInterfaces\":[{\"securityGroupIDs\":[\"sg-00002132323\"],\"subnetID\":\"subnet-123456\"}],\"region\":\"eu-west-1\",\"tags\":{\"kubernetes.io/cluster/" + mockclient.ReturnErrorAtDescribeInstances + "\":\"1\",\"kubernetes.io/role/test\":\"1\"}}")),
+						MachineClass: newMachineClass([]byte("{\"ami\":\"ami-123456789\",\"blockDevices\":[{\"ebs\":{\"volumeSize\":50,\"volumeType\":\"gp2\"}}],\"iam\":{\"name\":\"test-iam\"},\"keyName\":\"test-ssh-publickey\",\"machineType\":\"m4.large\",\"networkInterfaces\":[{\"securityGroupIDs\":[\"sg-00002132323\"],\"subnetID\":\"subnet-123456\"}],\"region\":\"eu-west-1\",\"tags\":{\"kubernetes.io/cluster/shoot--test\":\"1\",\"kubernetes.io/role/test\":\"1\"}}")),
 						Secret:       providerSecret,
 					},
 				},
 				expect: expect{
 					errToHaveOccurred: true,
-					errMessage:        "machine codes error: code = [Internal] message = [Cloud provider returned error]",
+					errMessage:        "machine codes error: code = [Unavailable] message = [InternalError: An internal error has occurred\n\tstatus code: 500, request id: ]",
 				},
 			}),
 			Entry("List request without a create request", &data{
@@ -792,9 +1486,7 @@ var _ = Describe("MachineServer", func() {
 						Secret:       providerSecret,
 					},
 				},
-				expect: expect{
-					listMachineResponse: &driver.ListMachinesResponse{},
-				},
+				expect: expect{},
 			}),
 		)
 	})
@@ -817,8 +1509,7 @@ var _ = Describe("MachineServer", func() {
 		}
 		DescribeTable("##table",
 			func(data *data) {
-				mockPluginSPIImpl := &mockclient.MockPluginSPIImpl{FakeInstances: make([]ec2.Instance, 0)}
-				ms := NewAWSDriver(mockPluginSPIImpl)
+				ms := NewAWSDriver(fakeec2.NewSessionProvider(newFakeEC2()))
 				ctx := context.Background()
 
 				response, err := ms.GetVolumeIDs(
@@ -978,8 +1669,7 @@ var _ = Describe("MachineServer", func() {
 		}
 		DescribeTable("##table",
 			func(data *data) {
-				mockPluginSPIImpl := &mockclient.MockPluginSPIImpl{FakeInstances: make([]ec2.Instance, 0)}
-				ms := NewAWSDriver(mockPluginSPIImpl)
+				ms := NewAWSDriver(fakeec2.NewSessionProvider(newFakeEC2()))
 				ctx := context.Background()
 
 				_, _ = ms.GenerateMachineClassForMigration(
@@ -1119,4 +1809,141 @@ var _ = Describe("MachineServer", func() {
 		)
 	})
 
+	Describe("#Contexts", func() {
+		var (
+			ms   *Driver
+			fake *fakeec2.EC2
+		)
+
+		BeforeEach(func() {
+			fake = newFakeEC2()
+			ms = NewDriver(fakeec2.NewSessionProvider(fake), NewDriverOptions())
+		})
+
+		It("should report AWS calls with a canceled context as canceled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			_, err := ms.CreateMachine(ctx, &driver.CreateMachineRequest{
+				Machine:      newMachine(-1),
+				MachineClass: newMachineClass(providerSpec),
+				Secret:       providerSecret,
+			})
+			statusErr, ok := status.FromError(err)
+			Expect(ok).To(BeTrue())
+			Expect(statusErr.Code()).To(Equal(codes.Canceled))
+			Expect(fake.Instances()).To(BeEmpty())
+		})
+
+		It("should report AWS calls with an expired context as exceeding the deadline", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+			defer cancel()
+
+			_, err := ms.ListMachines(ctx, &driver.ListMachinesRequest{
+				MachineClass: newMachineClass(providerSpec),
+				Secret:       providerSecret,
+			})
+			statusErr, ok := status.FromError(err)
+			Expect(ok).To(BeTrue())
+			Expect(statusErr.Code()).To(Equal(codes.DeadlineExceeded))
+		})
+
+		It("should set a deadline only for positive operation timeouts", func() {
+			ctx, cancel := withOperationTimeout(context.Background(), time.Minute)
+			defer cancel()
+			deadline, ok := ctx.Deadline()
+			Expect(ok).To(BeTrue())
+			Expect(deadline).To(BeTemporally("~", time.Now().Add(time.Minute), time.Second))
+
+			ctx, cancel = withOperationTimeout(context.Background(), 0)
+			defer cancel()
+			_, ok = ctx.Deadline()
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Describe("#ClientTokens", func() {
+		It("should skip client tokens bound to instances that aren't described anymore", func() {
+			now := time.Now()
+			fake := newFakeEC2(
+				fakeec2.WithClock(func() time.Time { return now }),
+				fakeec2.WithLifecycle(fakeec2.Lifecycle{TerminatedRetention: time.Hour}),
+			)
+			ms := NewDriver(fakeec2.NewSessionProvider(fake), NewDriverOptions())
+			request := &driver.CreateMachineRequest{
+				Machine:      newMachine(-1),
+				MachineClass: newMachineClass(providerSpec),
+				Secret:       providerSecret,
+			}
+
+			first, err := ms.CreateMachine(context.Background(), request)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = ms.DeleteMachine(context.Background(), &driver.DeleteMachineRequest{
+				Machine:      newMachineWithProviderID(-1, first.ProviderID),
+				MachineClass: newMachineClass(providerSpec),
+				Secret:       providerSecret,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			now = now.Add(2 * time.Hour)
+
+			second, err := ms.CreateMachine(context.Background(), request)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(second.ProviderID).ToNot(Equal(first.ProviderID))
+			Expect(liveInstances(fake)).To(ConsistOf(strings.TrimPrefix(second.ProviderID, "aws:///eu-west-1/")))
+			Expect(fake.Calls("RunInstances")).To(Equal(3))
+		})
+	})
+
+	Describe("#UnknownFields", func() {
+		var (
+			ms   *Driver
+			fake *fakeec2.EC2
+		)
+
+		BeforeEach(func() {
+			fake = newFakeEC2()
+			ms = NewDriver(fakeec2.NewSessionProvider(fake), NewDriverOptions())
+		})
+
+		It("should only reject unknown fields when creating machines", func() {
+			response, err := ms.CreateMachine(context.Background(), &driver.CreateMachineRequest{
+				Machine:      newMachine(-1),
+				MachineClass: newMachineClass(providerSpec),
+				Secret:       providerSecret,
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			misspelled := newMachineClass([]byte(strings.Replace(string(providerSpec), "securityGroupIDs", "securityGroupIds", 1)))
+			_, err = ms.CreateMachine(context.Background(), &driver.CreateMachineRequest{
+				Machine:      newMachine(1),
+				MachineClass: misspelled,
+				Secret:       providerSecret,
+			})
+			statusErr, ok := status.FromError(err)
+			Expect(ok).To(BeTrue())
+			Expect(statusErr.Code()).To(Equal(codes.InvalidArgument))
+
+			machine := newMachineWithProviderID(-1, response.ProviderID)
+			_, err = ms.GetMachineStatus(context.Background(), &driver.GetMachineStatusRequest{
+				Machine:      machine,
+				MachineClass: misspelled,
+				Secret:       providerSecret,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			listResponse, err := ms.ListMachines(context.Background(), &driver.ListMachinesRequest{
+				MachineClass: misspelled,
+				Secret:       providerSecret,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(listResponse.MachineList).To(HaveKey(response.ProviderID))
+			_, err = ms.DeleteMachine(context.Background(), &driver.DeleteMachineRequest{
+				Machine:      machine,
+				MachineClass: misspelled,
+				Secret:       providerSecret,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(liveInstances(fake)).To(BeEmpty())
+		})
+	})
+
 })
@@ -14,18 +14,24 @@ limitations under the License.
 package aws
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	api "github.com/gardener/machine-controller-manager-provider-aws/pkg/aws/apis"
 	validation "github.com/gardener/machine-controller-manager-provider-aws/pkg/aws/apis/validation"
 	v1alpha1 "github.com/gardener/machine-controller-manager/pkg/apis/machine/v1alpha1"
 	"github.com/gardener/machine-controller-manager/pkg/util/provider/machinecodes/codes"
 	"github.com/gardener/machine-controller-manager/pkg/util/provider/machinecodes/status"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	"k8s.io/klog"
 )
 
@@ -35,10 +41,18 @@ const (
 
 	// MachineClassKind for MachineClass
 	MachineClassKind = "MachineClass"
+
+	// clientTokenMaxLength is the maximum length of the client token accepted by RunInstances
+	clientTokenMaxLength = 64
+	// maxSkippedClientTokens is the maximum number of client tokens skipped by a machine creation because they are
+	// still bound to terminated instances
+	maxSkippedClientTokens = 20
 )
 
-// decodeProviderSpecAndSecret converts request parameters to api.ProviderSpec & api.Secrets
-func decodeProviderSpecAndSecret(machineClass *v1alpha1.MachineClass, secret *corev1.Secret) (*api.AWSProviderSpec, error) {
+// decodeProviderSpecAndSecret converts request parameters to api.ProviderSpec & api.Secrets.
+// If strict is set, unknown fields in the provider spec are rejected unless lenient decoding is enabled. Requests
+// for existing machines aren't strict, so that machines of a class with unknown fields can still be deleted.
+func (d *Driver) decodeProviderSpecAndSecret(machineClass *v1alpha1.MachineClass, secret *corev1.Secret, strict bool) (*api.AWSProviderSpec, error) {
 	var (
 		providerSpec *api.AWSProviderSpec
 	)
@@ -53,36 +67,112 @@ func decodeProviderSpecAndSecret(machineClass *v1alpha1.MachineClass, secret *co
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
+	if errs := validation.ValidateKnownFields(machineClass.ProviderSpec.Raw, field.NewPath("providerSpec")); len(errs) > 0 {
+		if strict && !d.options.LenientProviderSpecDecoding {
+			return nil, fieldErrorsToStatus(codes.InvalidArgument, "Error while decoding ProviderSpec", errs)
+		}
+		if _, warned := d.lenientClasses.LoadOrStore(machineClassVersionKey(machineClass), struct{}{}); !warned {
+			klog.Warningf("Ignoring unknown fields of MachineClass %q: %v", machineClass.Name, errs.ToAggregate())
+		}
+	}
+
 	// Validate the Spec and Secrets
-	ValidationErr := validation.ValidateAWSProviderSpec(providerSpec, secret)
-	if ValidationErr != nil {
-		err = fmt.Errorf("Error while validating ProviderSpec %v", ValidationErr)
-		return nil, status.Error(codes.Internal, err.Error())
+	if errs := validation.ValidateAWSProviderSpec(providerSpec, secret, field.NewPath("providerSpec")); len(errs) > 0 {
+		return nil, fieldErrorsToStatus(codes.InvalidArgument, "Error while validating ProviderSpec", errs)
 	}
 
 	return providerSpec, nil
 }
 
-// getInstancesFromMachineName extracts AWS Instance object from given machine name
-func (d *Driver) getInstancesFromMachineName(machineName string, providerSpec *api.AWSProviderSpec, secret *corev1.Secret) ([]*ec2.Instance, error) {
-	var (
-		clusterName string
-		nodeRole    string
-		instances   []*ec2.Instance
-	)
+// getInstancesFromMachineName extracts AWS Instance object from given machine name.
+// Lookups are batched and served from the instance cache, the cluster, role and owner tags are checked afterwards.
+func (d *Driver) getInstancesFromMachineName(ctx context.Context, machineName string, providerSpec *api.AWSProviderSpec, secret *corev1.Secret, ownerTags map[string]string) ([]*ec2.Instance, error) {
+	var instances []*ec2.Instance
 
 	svc, err := d.createSVC(secret, providerSpec.Region)
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, awsErrorToStatus(ctx, err)
 	}
 
-	for key := range providerSpec.Tags {
-		if strings.Contains(key, "kubernetes.io/cluster/") {
-			clusterName = key
-		} else if strings.Contains(key, "kubernetes.io/role/") {
-			nodeRole = key
+	candidates, err := d.instances.lookup(ctx, svc, sessionCacheKey(secret, providerSpec.Region), lookupByName, machineName)
+	if err != nil {
+		return nil, awsErrorToStatus(ctx, err)
+	}
+
+	clusterName, nodeRole := getClusterAndRoleTagKeys(providerSpec.Tags)
+	for _, instance := range candidates {
+		if hasTagKeys(instance, clusterName, nodeRole) && d.isOwnedBy(instance.Tags, ownerTags) {
+			instances = append(instances, instance)
 		}
 	}
+	if len(instances) == 0 {
+		errMessage := fmt.Sprintf("AWS plugin is returning no VM instances backing this machine object")
+		return nil, status.Error(codes.NotFound, errMessage)
+	}
+
+	return instances, nil
+}
+
+// getInstanceFromProviderID returns the instance with the ID of the given provider ID. The instance is
+// looked up by its ID instead of the Name tag, though it must still carry the cluster, role and owner tags.
+func (d *Driver) getInstanceFromProviderID(ctx context.Context, providerID string, providerSpec *api.AWSProviderSpec, secret *corev1.Secret, ownerTags map[string]string) (*ec2.Instance, string, error) {
+	region, instanceID, err := decodeRegionAndProviderID(providerID)
+	if err != nil {
+		return nil, "", status.Error(codes.Internal, err.Error())
+	}
+
+	svc, err := d.createSVC(secret, region)
+	if err != nil {
+		return nil, "", awsErrorToStatus(ctx, err)
+	}
+
+	// The ID is looked up by filter rather than by InstanceIds, so that a single terminated
+	// instance doesn't fail the whole batch with InvalidInstanceID.NotFound
+	instances, err := d.instances.lookup(ctx, svc, sessionCacheKey(secret, region), lookupByID, instanceID)
+	if err != nil {
+		return nil, "", awsErrorToStatus(ctx, err)
+	}
+	if len(instances) == 0 {
+		errMessage := fmt.Sprintf("AWS plugin is returning no VM instance with ID %q backing this machine object", instanceID)
+		return nil, "", status.Error(codes.NotFound, errMessage)
+	}
+
+	instance := instances[0]
+	clusterName, nodeRole := getClusterAndRoleTagKeys(providerSpec.Tags)
+	if !hasTagKeys(instance, clusterName, nodeRole) {
+		errMessage := fmt.Sprintf("VM with ID %q doesn't carry the tags %q and %q expected for this machine object", instanceID, clusterName, nodeRole)
+		return nil, "", status.Error(codes.FailedPrecondition, errMessage)
+	}
+	if !d.isOwnedBy(instance.Tags, ownerTags) {
+		errMessage := fmt.Sprintf("VM with ID %q carries owner tags that don't match this machine object", instanceID)
+		return nil, "", status.Error(codes.FailedPrecondition, errMessage)
+	}
+
+	return instance, region, nil
+}
+
+// invalidateInstances drops the cached lookups of the given machine and instance
+func (d *Driver) invalidateInstances(secret *corev1.Secret, region, machineName, instanceID string) {
+	scope := sessionCacheKey(secret, region)
+	d.instances.invalidate(scope, lookupByName, machineName)
+	if instanceID != "" {
+		d.instances.invalidate(scope, lookupByID, instanceID)
+		d.instances.invalidate(scope, lookupStatusByID, instanceID)
+		d.statusChecks.forget(instanceID)
+		d.consoleOutputs.Delete(instanceID)
+	}
+}
+
+// describeInstancesByName returns the instances backing the given machine name that are not terminated,
+// along with the earlier instances that are still described as shutting down or terminated. Unlike getInstancesFromMachineName,
+// the instances are always described, bypassing the instance cache.
+func (d *Driver) describeInstancesByName(ctx context.Context, svc ec2iface.EC2API, machineName string, providerSpec *api.AWSProviderSpec, ownerTags map[string]string) ([]*ec2.Instance, []*ec2.Instance, error) {
+	var (
+		instances  []*ec2.Instance
+		terminated []*ec2.Instance
+	)
+
+	clusterName, nodeRole := getClusterAndRoleTagKeys(providerSpec.Tags)
 
 	input := ec2.DescribeInstancesInput{
 		Filters: []*ec2.Filter{
@@ -104,38 +194,162 @@ func (d *Driver) getInstancesFromMachineName(machineName string, providerSpec *a
 					&nodeRole,
 				},
 			},
-			{
-				Name: aws.String("instance-state-name"),
-				Values: []*string{
-					aws.String("pending"),
-					aws.String("running"),
-					aws.String("stopping"),
-					aws.String("stopped"),
-				},
-			},
 		},
 	}
 
-	runResult, err := svc.DescribeInstances(&input)
+	runResult, err := svc.DescribeInstancesWithContext(ctx, &input)
 	if err != nil {
 		klog.Errorf("AWS plugin is returning error while describe instances request is sent: %s", err)
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, nil, awsErrorToStatus(ctx, err)
 	}
 
 	for _, reservation := range runResult.Reservations {
 		for _, instance := range reservation.Instances {
+			if !d.isOwnedBy(instance.Tags, ownerTags) {
+				continue
+			}
+			if isTerminated(instance) {
+				terminated = append(terminated, instance)
+				continue
+			}
 			instances = append(instances, instance)
 		}
 	}
-	if len(instances) == 0 {
-		errMessage := fmt.Sprintf("AWS plugin is returning no VM instances backing this machine object")
-		return nil, status.Error(codes.NotFound, errMessage)
+
+	return instances, terminated, nil
+}
+
+// isTerminated returns true if the instance is shutting down or terminated
+func isTerminated(instance *ec2.Instance) bool {
+	if instance.State == nil {
+		return false
+	}
+	state := aws.StringValue(instance.State.Name)
+	return state == ec2.InstanceStateNameShuttingDown || state == ec2.InstanceStateNameTerminated
+}
+
+// generateClientToken returns the idempotency token for RunInstances. It is stable for retries of the
+// same creation attempt of a machine, so that a lost response doesn't result in a second instance.
+func generateClientToken(machine *v1alpha1.Machine, attempt int) string {
+	id := string(machine.UID)
+	if id == "" {
+		id = machine.Namespace + "/" + machine.Name
 	}
 
-	return instances, nil
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s/%d", id, attempt)))
+	token := hex.EncodeToString(hash[:])
+	if len(token) > clientTokenMaxLength {
+		token = token[:clientTokenMaxLength]
+	}
+	return token
 }
 
-func (d *Driver) generateBlockDevices(blockDevices []api.AWSBlockDeviceMappingSpec, rootDeviceName *string) ([]*ec2.BlockDeviceMapping, error) {
+// getClusterAndRoleTagKeys returns the keys of the cluster and the role tag
+func getClusterAndRoleTagKeys(tags map[string]string) (string, string) {
+	var (
+		clusterName string
+		nodeRole    string
+	)
+
+	for key := range tags {
+		if strings.Contains(key, "kubernetes.io/cluster/") {
+			clusterName = key
+		} else if strings.Contains(key, "kubernetes.io/role/") {
+			nodeRole = key
+		}
+	}
+	return clusterName, nodeRole
+}
+
+// ownerTags returns the reserved tags identifying the machine class, the machine and the controller
+// an instance is created for. Tags of unknown values are left out.
+func (d *Driver) ownerTags(machineClass *v1alpha1.MachineClass, machine *v1alpha1.Machine) map[string]string {
+	tags := make(map[string]string)
+	if machineClass != nil && machineClass.Name != "" {
+		tags[api.TagMachineClass] = machineClass.Name
+	}
+	if machine != nil && machine.UID != "" {
+		tags[api.TagMachineUID] = string(machine.UID)
+	}
+	if controllerID := d.controllerID(machineClass); controllerID != "" {
+		tags[api.TagControllerID] = controllerID
+	}
+	return tags
+}
+
+// controllerID returns the configured ID of this controller. By default, the ID is derived from the namespace of the
+// machine class, since a controller manages the machines of one namespace. This way, controllers of different
+// deployments sharing an AWS account don't claim each other's instances.
+func (d *Driver) controllerID(machineClass *v1alpha1.MachineClass) string {
+	if d.options.ControllerID != "" {
+		return d.options.ControllerID
+	}
+	if machineClass == nil || machineClass.Namespace == "" {
+		return ""
+	}
+	return defaultControllerIDPrefix + "/" + machineClass.Namespace
+}
+
+// isOwnedBy returns true if the tags of a resource contain the given owner tags. Resources created before the
+// owner tags were introduced lack them, so a missing tag is only rejected with strict ownership tags.
+func (d *Driver) isOwnedBy(tags []*ec2.Tag, ownerTags map[string]string) bool {
+	for key, value := range ownerTags {
+		tagValue, ok := getTag(tags, key)
+		if !ok && !d.options.StrictOwnershipTags {
+			continue
+		}
+		if tagValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+// hasTagKeys returns true if the instance carries all of the given tag keys
+func hasTagKeys(instance *ec2.Instance, keys ...string) bool {
+	for _, key := range keys {
+		found := false
+		for _, tag := range instance.Tags {
+			if aws.StringValue(tag.Key) == key {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// getTagValue returns the value of the tag with the given key or an empty string
+func getTagValue(tags []*ec2.Tag, key string) string {
+	value, _ := getTag(tags, key)
+	return value
+}
+
+// getTag returns the value of the tag with the given key
+func getTag(tags []*ec2.Tag, key string) (string, bool) {
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) == key {
+			return aws.StringValue(tag.Value), true
+		}
+	}
+	return "", false
+}
+
+// multipleInstancesError returns the error for a machine that is backed by more than one instance
+func multipleInstancesError(instances []*ec2.Instance) error {
+	instanceIDs := []string{}
+	for _, instance := range instances {
+		instanceIDs = append(instanceIDs, *instance.InstanceId)
+	}
+
+	errMessage := fmt.Sprintf("AWS plugin is returning multiple VM instances backing this machine object. IDs for all backing VMs - %v ", instanceIDs)
+	return status.Error(codes.OutOfRange, errMessage)
+}
+
+func generateBlockDevices(blockDevices []api.AWSBlockDeviceMappingSpec, rootDeviceName *string) ([]*ec2.BlockDeviceMapping, error) {
 	// If not blockDevices are passed, return an error.
 	if len(blockDevices) == 0 {
 		return nil, fmt.Errorf("No block devices passed")
@@ -184,18 +398,22 @@ func (d *Driver) generateBlockDevices(blockDevices []api.AWSBlockDeviceMappingSp
 	return blkDeviceMappings, nil
 }
 
-func (d *Driver) generateTags(tags map[string]string, resourceType string, machineName string) (*ec2.TagSpecification, error) {
+func generateTags(tags map[string]string, resourceType string, machineName string, ownerTags map[string]string) (*ec2.TagSpecification, error) {
 
-	// Add tags to the created machine
+	// Add tags to the created machine, in the order of their keys so that retried requests are identical
 	tagList := []*ec2.Tag{}
-	for idx, element := range tags {
+	for _, idx := range sortedTagKeys(tags) {
 		if idx == "Name" {
 			// Name tag cannot be set, as its used to identify backing machine object
 			continue
 		}
+		if _, ok := ownerTags[idx]; ok {
+			// Owner tags cannot be overwritten, as they are used to identify the owner of the instance
+			continue
+		}
 		newTag := ec2.Tag{
 			Key:   aws.String(idx),
-			Value: aws.String(element),
+			Value: aws.String(tags[idx]),
 		}
 		tagList = append(tagList, &newTag)
 	}
@@ -204,6 +422,12 @@ func (d *Driver) generateTags(tags map[string]string, resourceType string, machi
 		Value: aws.String(machineName),
 	}
 	tagList = append(tagList, &nameTag)
+	for _, key := range sortedTagKeys(ownerTags) {
+		tagList = append(tagList, &ec2.Tag{
+			Key:   aws.String(key),
+			Value: aws.String(ownerTags[key]),
+		})
+	}
 
 	tagInstance := &ec2.TagSpecification{
 		ResourceType: aws.String(resourceType),
@@ -211,3 +435,13 @@ func (d *Driver) generateTags(tags map[string]string, resourceType string, machi
 	}
 	return tagInstance, nil
 }
+
+// sortedTagKeys returns the keys of the tags in ascending order
+func sortedTagKeys(tags map[string]string) []string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -25,6 +25,11 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	api "github.com/gardener/machine-controller-manager-provider-aws/pkg/aws/apis"
+	v1alpha1 "github.com/gardener/machine-controller-manager/pkg/apis/machine/v1alpha1"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/machinecodes/codes"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/machinecodes/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 var (
@@ -36,14 +41,13 @@ var _ = Describe("CoreUtils", func() {
 	Context("#generateTags", func() {
 
 		It("should convert multiples tags successfully", func() {
-			awsDriver := &Driver{}
 			tags := map[string]string{
 				"tag-1": "value-tag-1",
 				"tag-2": "value-tag-2",
 				"tag-3": "value-tag-3",
 			}
 
-			tagsGenerated, err := awsDriver.generateTags(tags, resourceTypeInstance, testMachine)
+			tagsGenerated, err := generateTags(tags, resourceTypeInstance, testMachine, nil)
 			expectedTags := &ec2.TagSpecification{
 				ResourceType: aws.String("instance"),
 				Tags: []*ec2.Tag{
@@ -72,10 +76,9 @@ var _ = Describe("CoreUtils", func() {
 		})
 
 		It("should convert zero tags successfully", func() {
-			awsDriver := &Driver{}
 			tags := map[string]string{}
 
-			tagsGenerated, err := awsDriver.generateTags(tags, resourceTypeInstance, testMachine)
+			tagsGenerated, err := generateTags(tags, resourceTypeInstance, testMachine, nil)
 			expectedTags := &ec2.TagSpecification{
 				ResourceType: aws.String("instance"),
 				Tags: []*ec2.Tag{
@@ -89,12 +92,151 @@ var _ = Describe("CoreUtils", func() {
 			Expect(tagsGenerated).To(Equal(expectedTags))
 			Expect(err).ToNot(HaveOccurred())
 		})
+
+		It("should add owner tags that cannot be overwritten", func() {
+			tags := map[string]string{
+				"tag-1":             "value-tag-1",
+				api.TagMachineClass: "overwritten",
+			}
+			ownerTags := map[string]string{
+				api.TagMachineClass: "test-class",
+				api.TagControllerID: "test-controller",
+			}
+
+			tagsGenerated, err := generateTags(tags, resourceTypeInstance, testMachine, ownerTags)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tagsGenerated.Tags).To(ConsistOf(
+				&ec2.Tag{Key: aws.String("tag-1"), Value: aws.String("value-tag-1")},
+				&ec2.Tag{Key: aws.String("Name"), Value: aws.String(testMachine)},
+				&ec2.Tag{Key: aws.String(api.TagMachineClass), Value: aws.String("test-class")},
+				&ec2.Tag{Key: aws.String(api.TagControllerID), Value: aws.String("test-controller")},
+			))
+		})
+	})
+
+	Context("#isOwnedBy", func() {
+		ownerTags := map[string]string{
+			api.TagMachineClass: "test-class",
+			api.TagControllerID: "test-controller",
+		}
+		newTags := func(tags map[string]string) []*ec2.Tag {
+			var ec2Tags []*ec2.Tag
+			for key, value := range tags {
+				ec2Tags = append(ec2Tags, &ec2.Tag{Key: aws.String(key), Value: aws.String(value)})
+			}
+			return ec2Tags
+		}
+
+		It("should accept instances with matching owner tags", func() {
+			awsDriver := &Driver{options: &DriverOptions{StrictOwnershipTags: true}}
+			Expect(awsDriver.isOwnedBy(newTags(ownerTags), ownerTags)).To(BeTrue())
+		})
+
+		It("should reject instances with conflicting owner tags", func() {
+			awsDriver := &Driver{options: &DriverOptions{}}
+			Expect(awsDriver.isOwnedBy(newTags(map[string]string{api.TagMachineClass: "other-class"}), ownerTags)).To(BeFalse())
+		})
+
+		It("should accept instances without owner tags unless they are strictly required", func() {
+			awsDriver := &Driver{options: &DriverOptions{}}
+			Expect(awsDriver.isOwnedBy(newTags(nil), ownerTags)).To(BeTrue())
+
+			awsDriver.options.StrictOwnershipTags = true
+			Expect(awsDriver.isOwnedBy(newTags(nil), ownerTags)).To(BeFalse())
+		})
+	})
+
+	Context("#controllerID", func() {
+		machineClass := func(namespace string) *v1alpha1.MachineClass {
+			return &v1alpha1.MachineClass{ObjectMeta: metav1.ObjectMeta{Name: "test-class", Namespace: namespace}}
+		}
+
+		It("should derive a default from the namespace of the machine class", func() {
+			awsDriver := &Driver{options: NewDriverOptions()}
+			Expect(awsDriver.controllerID(machineClass("shoot--a"))).To(Equal("machine-controller-manager-provider-aws/shoot--a"))
+			Expect(awsDriver.controllerID(machineClass("shoot--b"))).To(Equal("machine-controller-manager-provider-aws/shoot--b"))
+		})
+
+		It("should use the configured ID", func() {
+			awsDriver := &Driver{options: &DriverOptions{ControllerID: "test-controller"}}
+			Expect(awsDriver.controllerID(machineClass("shoot--a"))).To(Equal("test-controller"))
+		})
+	})
+
+	Context("#generateClientToken", func() {
+
+		It("should be stable for the same machine and attempt", func() {
+			machine := newMachine(0)
+			machine.UID = "2c5a4d6e-9f6c-4d1e-8e8b-1c6f4e4b1f10"
+
+			token := generateClientToken(machine, 0)
+			Expect(token).To(Equal(generateClientToken(machine.DeepCopy(), 0)))
+			Expect(len(token)).To(BeNumerically("<=", clientTokenMaxLength))
+		})
+
+		It("should differ between attempts and machines", func() {
+			machine := newMachine(0)
+			machine.UID = "2c5a4d6e-9f6c-4d1e-8e8b-1c6f4e4b1f10"
+			other := newMachine(0)
+			other.UID = "7d1f3a2b-0c4e-4b5a-9d8e-2f3a4b5c6d7e"
+
+			Expect(generateClientToken(machine, 0)).ToNot(Equal(generateClientToken(machine, 1)))
+			Expect(generateClientToken(machine, 0)).ToNot(Equal(generateClientToken(other, 0)))
+		})
+	})
+
+	Context("#decodeProviderSpecAndSecret", func() {
+		secret := &corev1.Secret{
+			Data: map[string][]byte{
+				"providerAccessKeyId":     []byte("dummy-id"),
+				"providerSecretAccessKey": []byte("dummy-secret"),
+				"userData":                []byte("dummy-user-data"),
+			},
+		}
+		providerSpec := []byte(`{
+			"ami": "ami-123456789",
+			"blockDevices": [{"ebs": {"volumeSize": 50, "volumeType": "gp2"}}],
+			"iam": {"name": "test-iam"},
+			"keyName": "test-ssh-publickey",
+			"machineType": "m4.large",
+			"networkInterfaces": [{"securityGroupIDs": ["sg-00002132323"], "subnetID": "subnet-123456", "associatePublicIpAddress": true}],
+			"region": "eu-west-1",
+			"tags": {"kubernetes.io/cluster/shoot--test": "1", "kubernetes.io/role/test": "1"}
+		}`)
+
+		It("should reject unknown fields naming them", func() {
+			awsDriver := NewDriver(nil, NewDriverOptions())
+
+			_, err := awsDriver.decodeProviderSpecAndSecret(newNamedMachineClass("test-mc", providerSpec), secret, true)
+			statusErr, ok := status.FromError(err)
+			Expect(ok).To(BeTrue(), "%v", err)
+			Expect(statusErr.Code()).To(Equal(codes.InvalidArgument))
+			Expect(statusErr.Message()).To(Equal(`Error while decoding ProviderSpec: providerSpec.networkInterfaces(0).associatePublicIpAddress: ` +
+				`Forbidden: unknown field "associatePublicIpAddress", did you mean "associatePublicIPAddress"?`))
+		})
+
+		It("should ignore unknown fields if lenient decoding is enabled", func() {
+			options := NewDriverOptions()
+			options.LenientProviderSpecDecoding = true
+			awsDriver := NewDriver(nil, options)
+
+			spec, err := awsDriver.decodeProviderSpecAndSecret(newNamedMachineClass("test-mc", providerSpec), secret, true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(spec.NetworkInterfaces[0].SubnetID).To(Equal("subnet-123456"))
+		})
+
+		It("should ignore unknown fields if not strict", func() {
+			awsDriver := NewDriver(nil, NewDriverOptions())
+
+			spec, err := awsDriver.decodeProviderSpecAndSecret(newNamedMachineClass("test-mc", providerSpec), secret, false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(spec.NetworkInterfaces[0].SubnetID).To(Equal("subnet-123456"))
+		})
 	})
 
 	Context("#generateBlockDevices", func() {
 
 		It("should convert multiples blockDevices successfully", func() {
-			awsDriver := &Driver{}
 			disks := []api.AWSBlockDeviceMappingSpec{
 				{
 					DeviceName: "/root",
@@ -127,7 +269,7 @@ var _ = Describe("CoreUtils", func() {
 			}
 
 			rootDevice := aws.String("/dev/sda")
-			disksGenerated, err := awsDriver.generateBlockDevices(disks, rootDevice)
+			disksGenerated, err := generateBlockDevices(disks, rootDevice)
 			expectedDisks := []*ec2.BlockDeviceMapping{
 				{
 					DeviceName: aws.String("/dev/sda"),
@@ -166,7 +308,6 @@ var _ = Describe("CoreUtils", func() {
 		})
 
 		It("should convert single blockDevices without deviceName successfully", func() {
-			awsDriver := &Driver{}
 			disks := []api.AWSBlockDeviceMappingSpec{
 				{
 					Ebs: api.AWSEbsBlockDeviceSpec{
@@ -179,7 +320,7 @@ var _ = Describe("CoreUtils", func() {
 			}
 
 			rootDevice := aws.String("/dev/sda")
-			disksGenerated, err := awsDriver.generateBlockDevices(disks, rootDevice)
+			disksGenerated, err := generateBlockDevices(disks, rootDevice)
 			expectedDisks := []*ec2.BlockDeviceMapping{
 				{
 					DeviceName: aws.String("/dev/sda"),
@@ -198,11 +339,10 @@ var _ = Describe("CoreUtils", func() {
 		})
 
 		It("Convert zero blockDevices should have errored", func() {
-			awsDriver := &Driver{}
 			disks := []api.AWSBlockDeviceMappingSpec{}
 
 			rootDevice := aws.String("/dev/sda")
-			disksGenerated, err := awsDriver.generateBlockDevices(disks, rootDevice)
+			disksGenerated, err := generateBlockDevices(disks, rootDevice)
 			var expectedDisks []*ec2.BlockDeviceMapping
 
 			Expect(disksGenerated).To(Equal(expectedDisks))
@@ -211,7 +351,6 @@ var _ = Describe("CoreUtils", func() {
 		})
 
 		It("should not encrypt blockDevices by default", func() {
-			awsDriver := &Driver{}
 			disks := []api.AWSBlockDeviceMappingSpec{
 				{
 					Ebs: api.AWSEbsBlockDeviceSpec{
@@ -222,7 +361,7 @@ var _ = Describe("CoreUtils", func() {
 			}
 
 			rootDevice := aws.String("/dev/sda")
-			disksGenerated, err := awsDriver.generateBlockDevices(disks, rootDevice)
+			disksGenerated, err := generateBlockDevices(disks, rootDevice)
 			expectedDisks := []*ec2.BlockDeviceMapping{
 				{
 					DeviceName: aws.String("/dev/sda"),
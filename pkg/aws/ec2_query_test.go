@@ -0,0 +1,115 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"net/http/httptest"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/gardener/machine-controller-manager-provider-aws/pkg/fakeec2"
+	"github.com/gardener/machine-controller-manager-provider-aws/pkg/spi"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/driver"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var _ = Describe("Driver using the EC2 Query protocol", func() {
+
+	var (
+		ctx    = context.Background()
+		fake   *fakeec2.EC2
+		server *httptest.Server
+		d      *Driver
+
+		providerSpec   = []byte("{\"ami\":\"ami-123456789\",\"blockDevices\":[{\"ebs\":{\"volumeSize\":50,\"volumeType\":\"gp2\",\"deleteOnTermination\":false}}],\"iam\":{\"name\":\"test-iam\"},\"keyName\":\"test-ssh-publickey\",\"machineType\":\"m4.large\",\"networkInterfaces\":[{\"securityGroupIDs\":[\"sg-00002132323\"],\"subnetID\":\"subnet-123456\"}],\"region\":\"eu-west-1\",\"tags\":{\"kubernetes.io/cluster/shoot--test\":\"1\",\"kubernetes.io/role/test\":\"1\"},\"cleanupPolicy\":{\"volumes\":\"Delete\",\"networkInterfaces\":\"Delete\"}}")
+		providerSecret = &corev1.Secret{
+			Data: map[string][]byte{
+				"providerAccessKeyId":     []byte("dummy-id"),
+				"providerSecretAccessKey": []byte("dummy-secret"),
+				"userData":                []byte("dummy-user-data"),
+			},
+		}
+	)
+
+	BeforeEach(func() {
+		fake = fakeec2.New()
+		fake.AddImage(&ec2.Image{ImageId: aws.String("ami-123456789")})
+		server = httptest.NewServer(fakeec2.NewServer(fake))
+
+		options := NewDriverOptions()
+		options.WaitForTermination = true
+		d = NewDriver(&spi.PluginSPIImpl{EndpointOptions: spi.EndpointOptions{EC2Endpoint: server.URL}}, options)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should create, report, list and delete a machine", func() {
+		machineClass := newMachineClass(providerSpec)
+		createResponse, err := d.CreateMachine(ctx, &driver.CreateMachineRequest{
+			Machine:      newMachine(0),
+			MachineClass: machineClass,
+			Secret:       providerSecret,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(createResponse.NodeName).To(Equal("ip-10-0-0-4.eu-west-1.compute.internal"))
+
+		instances := fake.Instances()
+		Expect(instances).To(HaveLen(1))
+		Expect(*instances[0].InstanceType).To(Equal("m4.large"))
+		Expect(*instances[0].IamInstanceProfile.Arn).To(HaveSuffix("instance-profile/test-iam"))
+		Expect(instances[0].Tags).To(ContainElement(&ec2.Tag{Key: aws.String("kubernetes.io/cluster/shoot--test"), Value: aws.String("1")}))
+
+		machine := newMachineWithProviderID(0, createResponse.ProviderID)
+		statusResponse, err := d.GetMachineStatus(ctx, &driver.GetMachineStatusRequest{
+			Machine:      machine,
+			MachineClass: machineClass,
+			Secret:       providerSecret,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(statusResponse.ProviderID).To(Equal(createResponse.ProviderID))
+
+		listResponse, err := d.ListMachines(ctx, &driver.ListMachinesRequest{
+			MachineClass: machineClass,
+			Secret:       providerSecret,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(listResponse.MachineList).To(Equal(map[string]string{createResponse.ProviderID: machine.Name}))
+
+		_, err = d.DeleteMachine(ctx, &driver.DeleteMachineRequest{
+			Machine:      machine,
+			MachineClass: machineClass,
+			Secret:       providerSecret,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*fake.Instances()[0].State.Name).To(Equal(ec2.InstanceStateNameTerminated))
+		Expect(fake.Volumes()).To(BeEmpty())
+		Expect(fake.NetworkInterfaces()).To(BeEmpty())
+	})
+
+	It("should report the errors of EC2", func() {
+		_, err := d.CreateMachine(ctx, &driver.CreateMachineRequest{
+			Machine:      newMachine(0),
+			MachineClass: newMachineClass([]byte("{\"ami\":\"ami-987654321\",\"blockDevices\":[{\"ebs\":{\"volumeSize\":50,\"volumeType\":\"gp2\"}}],\"iam\":{\"name\":\"test-iam\"},\"keyName\":\"test-ssh-publickey\",\"machineType\":\"m4.large\",\"networkInterfaces\":[{\"securityGroupIDs\":[\"sg-00002132323\"],\"subnetID\":\"subnet-123456\"}],\"region\":\"eu-west-1\",\"tags\":{\"kubernetes.io/cluster/shoot--test\":\"1\",\"kubernetes.io/role/test\":\"1\"}}")),
+			Secret:       providerSecret,
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("InvalidAMIID.NotFound: The image id 'ami-987654321' does not exist"))
+		Expect(fake.Instances()).To(BeEmpty())
+	})
+})
@@ -0,0 +1,181 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/machinecodes/codes"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/machinecodes/status"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+const (
+	// errCodeInstanceIDNotFound is returned by EC2 if an instance with the given ID doesn't exist
+	errCodeInstanceIDNotFound = "InvalidInstanceID.NotFound"
+)
+
+// awsErrorCodes maps the error codes returned by the EC2 API to machine codes.
+// See https://docs.aws.amazon.com/AWSEC2/latest/APIReference/errors-overview.html
+var awsErrorCodes = map[string]codes.Code{
+	// The instance doesn't exist (anymore)
+	errCodeInstanceIDNotFound: codes.NotFound,
+
+	// The request or the machine class is invalid, retrying won't help
+	"InvalidParameter":                codes.InvalidArgument,
+	"InvalidParameterValue":           codes.InvalidArgument,
+	"InvalidParameterCombination":     codes.InvalidArgument,
+	"MissingParameter":                codes.InvalidArgument,
+	"UnknownParameter":                codes.InvalidArgument,
+	"ValidationError":                 codes.InvalidArgument,
+	"InvalidBlockDeviceMapping":       codes.InvalidArgument,
+	"InvalidInput":                    codes.InvalidArgument,
+	"InvalidUserData.Malformed":       codes.InvalidArgument,
+	"Unsupported":                     codes.InvalidArgument,
+	"UnsupportedOperation":            codes.InvalidArgument,
+	"UnsupportedInstanceAttribute":    codes.InvalidArgument,
+	"InvalidIamInstanceProfile":       codes.InvalidArgument,
+	"VPCIdNotSpecified":               codes.InvalidArgument,
+	"IdempotentParameterMismatch":     codes.FailedPrecondition,
+	"IncorrectInstanceState":          codes.FailedPrecondition,
+	"IncorrectState":                  codes.FailedPrecondition,
+	"OperationNotPermitted":           codes.FailedPrecondition,
+	"InvalidVolume.ZoneMismatch":      codes.InvalidArgument,
+	"InvalidNetworkInterface.InUse":   codes.FailedPrecondition,
+	"VolumeInUse":                     codes.FailedPrecondition,
+	"DependencyViolation":             codes.FailedPrecondition,
+	"InvalidAMIID.Unavailable":        codes.InvalidArgument,
+	"InvalidSpotInstanceRequestID":    codes.InvalidArgument,
+	"InvalidKmsKeyId.NotFound":        codes.InvalidArgument,
+	"InvalidCapacityReservationId":    codes.InvalidArgument,
+	"InvalidPlacementGroup.Unknown":   codes.InvalidArgument,
+	"InvalidLaunchTemplateId.Version": codes.InvalidArgument,
+
+	// Quotas and capacity are exhausted
+	"InstanceLimitExceeded":                codes.ResourceExhausted,
+	"VcpuLimitExceeded":                    codes.ResourceExhausted,
+	"VolumeLimitExceeded":                  codes.ResourceExhausted,
+	"MaxSpotInstanceCountExceeded":         codes.ResourceExhausted,
+	"NetworkInterfaceLimitExceeded":        codes.ResourceExhausted,
+	"AddressLimitExceeded":                 codes.ResourceExhausted,
+	"InsufficientInstanceCapacity":         codes.ResourceExhausted,
+	"InsufficientAddressCapacity":          codes.ResourceExhausted,
+	"InsufficientCapacity":                 codes.ResourceExhausted,
+	"InsufficientHostCapacity":             codes.ResourceExhausted,
+	"InsufficientReservedInstanceCapacity": codes.ResourceExhausted,
+	"InsufficientFreeAddressesInSubnet":    codes.ResourceExhausted,
+	"SpotMaxPriceTooLow":                   codes.ResourceExhausted,
+
+	// The credentials are invalid or expired
+	"AuthFailure":                codes.Unauthenticated,
+	"InvalidClientTokenId":       codes.Unauthenticated,
+	"SignatureDoesNotMatch":      codes.Unauthenticated,
+	"IncompleteSignature":        codes.Unauthenticated,
+	"MissingAuthenticationToken": codes.Unauthenticated,
+	"ExpiredToken":               codes.Unauthenticated,
+	"RequestExpired":             codes.Unauthenticated,
+
+	// The credentials are valid but lack permissions
+	"UnauthorizedOperation": codes.PermissionDenied,
+	"AccessDenied":          codes.PermissionDenied,
+	"OptInRequired":         codes.PermissionDenied,
+	"Blocked":               codes.PermissionDenied,
+	"PendingVerification":   codes.PermissionDenied,
+
+	// Throttling and temporary failures of the EC2 API, retrying is expected to succeed
+	"RequestLimitExceeded":         codes.Unavailable,
+	"Throttling":                   codes.Unavailable,
+	"ThrottlingException":          codes.Unavailable,
+	"ServiceUnavailable":           codes.Unavailable,
+	"Unavailable":                  codes.Unavailable,
+	"InternalError":                codes.Unavailable,
+	"InternalFailure":              codes.Unavailable,
+	request.ErrCodeRequestError:    codes.Unavailable,
+	request.ErrCodeResponseTimeout: codes.DeadlineExceeded,
+	request.CanceledErrorCode:      codes.Canceled,
+	request.ErrCodeSerialization:   codes.Internal,
+	request.ErrCodeRead:            codes.Unavailable,
+	request.ParamRequiredErrCode:   codes.InvalidArgument,
+}
+
+// awsErrorToCode returns the machine code for the given error.
+// Errors that are not returned by AWS or whose code is unknown are mapped to codes.Internal.
+func awsErrorToCode(err error) codes.Code {
+	awsErr, ok := err.(awserr.Error)
+	if !ok {
+		return codes.Internal
+	}
+
+	errCode := awsErr.Code()
+	if code, ok := awsErrorCodes[errCode]; ok {
+		return code
+	}
+
+	// Referenced resources (AMI, subnet, security group, key pair, ...) that don't exist or
+	// are malformed are errors in the machine class.
+	if strings.HasSuffix(errCode, ".NotFound") || strings.HasSuffix(errCode, ".Malformed") {
+		return codes.InvalidArgument
+	}
+
+	return codes.Internal
+}
+
+// isInstanceNotFound returns true if the error reports that an instance doesn't exist
+func isInstanceNotFound(err error) bool {
+	return isAWSErrorCode(err, errCodeInstanceIDNotFound)
+}
+
+// isAWSErrorCode returns true if the error is returned by AWS with one of the given codes
+func isAWSErrorCode(err error, errCodes ...string) bool {
+	awsErr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+	for _, code := range errCodes {
+		if awsErr.Code() == code {
+			return true
+		}
+	}
+	return false
+}
+
+// awsErrorToStatus wraps the given error into a machine codes status error.
+// If the context is done, the error is reported as canceled or as exceeding its deadline.
+func awsErrorToStatus(ctx context.Context, err error) error {
+	message := statusMessageReplacer.Replace(err.Error())
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return status.Error(codes.DeadlineExceeded, message)
+	case context.Canceled:
+		return status.Error(codes.Canceled, message)
+	}
+	return status.Error(awsErrorToCode(err), message)
+}
+
+// statusMessageReplacer renders brackets as parentheses, e.g. those of ID lists in AWS errors or of indices in
+// field paths, as machine codes errors delimit their code and message with brackets and can't be decoded if the
+// message contains any
+var statusMessageReplacer = strings.NewReplacer("[", "(", "]", ")")
+
+// fieldErrorsToStatus returns a status error with the given code whose message lists the field errors
+func fieldErrorsToStatus(code codes.Code, message string, errs field.ErrorList) error {
+	var messages []string
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	return status.Error(code, statusMessageReplacer.Replace(message+": "+strings.Join(messages, "; ")))
+}
@@ -0,0 +1,131 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/machinecodes/codes"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/machinecodes/status"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+var _ = Describe("Errors", func() {
+
+	Describe("#awsErrorToCode", func() {
+		DescribeTable("##table",
+			func(err error, expectedCode codes.Code) {
+				Expect(awsErrorToCode(err)).To(Equal(expectedCode))
+			},
+			Entry("non AWS error", fmt.Errorf("something went wrong"), codes.Internal),
+			Entry("unknown AWS error code", awserr.New("SomethingNew", "", nil), codes.Internal),
+
+			Entry("InvalidInstanceID.NotFound", awserr.New("InvalidInstanceID.NotFound", "The instance ID 'i-1' does not exist", nil), codes.NotFound),
+
+			Entry("InvalidParameterValue", awserr.New("InvalidParameterValue", "Invalid value 'm9.large' for InstanceType.", nil), codes.InvalidArgument),
+			Entry("InvalidParameterCombination", awserr.New("InvalidParameterCombination", "", nil), codes.InvalidArgument),
+			Entry("MissingParameter", awserr.New("MissingParameter", "", nil), codes.InvalidArgument),
+			Entry("InvalidAMIID.NotFound", awserr.New("InvalidAMIID.NotFound", "The image id '[ami-1]' does not exist", nil), codes.InvalidArgument),
+			Entry("InvalidAMIID.Malformed", awserr.New("InvalidAMIID.Malformed", "", nil), codes.InvalidArgument),
+			Entry("InvalidSubnetID.NotFound", awserr.New("InvalidSubnetID.NotFound", "", nil), codes.InvalidArgument),
+			Entry("InvalidGroup.NotFound", awserr.New("InvalidGroup.NotFound", "", nil), codes.InvalidArgument),
+			Entry("InvalidKeyPair.NotFound", awserr.New("InvalidKeyPair.NotFound", "", nil), codes.InvalidArgument),
+			Entry("InvalidBlockDeviceMapping", awserr.New("InvalidBlockDeviceMapping", "", nil), codes.InvalidArgument),
+			Entry("IdempotentParameterMismatch", awserr.New("IdempotentParameterMismatch", "", nil), codes.FailedPrecondition),
+			Entry("IncorrectInstanceState", awserr.New("IncorrectInstanceState", "", nil), codes.FailedPrecondition),
+
+			Entry("InstanceLimitExceeded", awserr.New("InstanceLimitExceeded", "Your quota allows for 0 more running instance(s).", nil), codes.ResourceExhausted),
+			Entry("VcpuLimitExceeded", awserr.New("VcpuLimitExceeded", "", nil), codes.ResourceExhausted),
+			Entry("InsufficientInstanceCapacity", awserr.New("InsufficientInstanceCapacity", "We currently do not have sufficient m5.large capacity", nil), codes.ResourceExhausted),
+			Entry("InsufficientFreeAddressesInSubnet", awserr.New("InsufficientFreeAddressesInSubnet", "", nil), codes.ResourceExhausted),
+			Entry("MaxSpotInstanceCountExceeded", awserr.New("MaxSpotInstanceCountExceeded", "", nil), codes.ResourceExhausted),
+
+			Entry("AuthFailure", awserr.New("AuthFailure", "AWS was not able to validate the provided access credentials", nil), codes.Unauthenticated),
+			Entry("InvalidClientTokenId", awserr.New("InvalidClientTokenId", "", nil), codes.Unauthenticated),
+			Entry("SignatureDoesNotMatch", awserr.New("SignatureDoesNotMatch", "", nil), codes.Unauthenticated),
+
+			Entry("UnauthorizedOperation", awserr.New("UnauthorizedOperation", "You are not authorized to perform this operation.", nil), codes.PermissionDenied),
+			Entry("OptInRequired", awserr.New("OptInRequired", "", nil), codes.PermissionDenied),
+
+			Entry("RequestLimitExceeded", awserr.New("RequestLimitExceeded", "Request limit exceeded.", nil), codes.Unavailable),
+			Entry("ServiceUnavailable", awserr.New("ServiceUnavailable", "", nil), codes.Unavailable),
+			Entry("InternalError", awserr.New("InternalError", "", nil), codes.Unavailable),
+			Entry("RequestError", awserr.New("RequestError", "send request failed", nil), codes.Unavailable),
+			Entry("ResponseTimeout", awserr.New("ResponseTimeout", "", nil), codes.DeadlineExceeded),
+			Entry("RequestCanceled", awserr.New("RequestCanceled", "request context canceled", nil), codes.Canceled),
+
+			Entry("request failure", awserr.NewRequestFailure(awserr.New("RequestLimitExceeded", "Request limit exceeded.", nil), 503, "request-id"), codes.Unavailable),
+		)
+	})
+
+	Describe("#awsErrorToStatus", func() {
+		It("should keep the message of the error", func() {
+			err := awserr.New("InvalidAMIID.NotFound", "The image id 'ami-1' does not exist", nil)
+
+			statusErr, ok := status.FromError(awsErrorToStatus(context.Background(), err))
+			Expect(ok).To(BeTrue())
+			Expect(statusErr.Code()).To(Equal(codes.InvalidArgument))
+			Expect(statusErr.Message()).To(Equal(err.Error()))
+		})
+
+		It("should keep the code and message of errors containing brackets", func() {
+			err := awserr.New("InvalidInstanceID.NotFound", "The instance IDs '[i-1, i-2]' do not exist", nil)
+
+			statusErr, ok := status.FromError(awsErrorToStatus(context.Background(), err))
+			Expect(ok).To(BeTrue())
+			Expect(statusErr.Code()).To(Equal(codes.NotFound))
+			Expect(statusErr.Message()).To(Equal("InvalidInstanceID.NotFound: The instance IDs '(i-1, i-2)' do not exist"))
+		})
+
+		It("should report errors of canceled contexts as canceled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			statusErr, ok := status.FromError(awsErrorToStatus(ctx, awserr.New("RequestCanceled", "request context canceled", ctx.Err())))
+			Expect(ok).To(BeTrue())
+			Expect(statusErr.Code()).To(Equal(codes.Canceled))
+		})
+
+		It("should report errors of expired contexts as exceeding the deadline", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+			defer cancel()
+
+			statusErr, ok := status.FromError(awsErrorToStatus(ctx, awserr.New("RequestCanceled", "request context canceled", ctx.Err())))
+			Expect(ok).To(BeTrue())
+			Expect(statusErr.Code()).To(Equal(codes.DeadlineExceeded))
+		})
+	})
+
+	Describe("#fieldErrorsToStatus", func() {
+		It("should list the field errors in a message that can be decoded", func() {
+			err := fieldErrorsToStatus(codes.InvalidArgument, "Error while validating ProviderSpec", field.ErrorList{
+				field.Required(field.NewPath("providerSpec", "networkInterfaces").Index(0).Child("subnetID"), "SubnetID is required"),
+				field.Required(field.NewPath("providerSpec", "ami"), "AMI is required"),
+			})
+
+			statusErr, ok := status.FromError(err)
+			Expect(ok).To(BeTrue())
+			Expect(statusErr.Code()).To(Equal(codes.InvalidArgument))
+			Expect(statusErr.Message()).To(Equal("Error while validating ProviderSpec: " +
+				"providerSpec.networkInterfaces(0).subnetID: Required value: SubnetID is required; " +
+				"providerSpec.ami: Required value: AMI is required"))
+		})
+	})
+})
@@ -0,0 +1,361 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"k8s.io/klog"
+)
+
+// instanceLookupKind is the DescribeInstances filter used to look up instances
+type instanceLookupKind string
+
+const (
+	// lookupByName looks up instances by the value of their Name tag
+	lookupByName instanceLookupKind = "tag:Name"
+	// lookupByID looks up instances by their instance ID
+	lookupByID instanceLookupKind = "instance-id"
+	// lookupStatusByID looks up the status and scheduled events of running instances by their instance ID
+	lookupStatusByID instanceLookupKind = "instance-status"
+
+	// maxFilterValues is the maximum number of values EC2 accepts for a single filter
+	maxFilterValues = 200
+	// maxInstanceStatusIDs is the maximum number of instance IDs per DescribeInstanceStatus call
+	maxInstanceStatusIDs = 100
+)
+
+// instanceCacheEntry are the instances or instance statuses found for a lookup
+type instanceCacheEntry struct {
+	instances []*ec2.Instance
+	statuses  []*ec2.InstanceStatus
+	fetchedAt time.Time
+}
+
+// instanceBatch collects the lookups of a scope that are sent to EC2 together
+type instanceBatch struct {
+	svc     ec2iface.EC2API
+	lookups map[instanceLookupKind]map[string]struct{}
+
+	// done is closed once results and err are set
+	done    chan struct{}
+	results map[string]*instanceCacheEntry
+	err     error
+}
+
+// instanceCacheScope holds the cached instances and the pending batch of a secret and region
+type instanceCacheScope struct {
+	// generation is increased with every invalidation, results of batches started
+	// before an invalidation are not cached
+	generation uint64
+	entries    map[string]*instanceCacheEntry
+	pending    *instanceBatch
+}
+
+// instanceCache batches instance lookups into few DescribeInstances calls and caches their
+// non-empty results for a short freshness window. It is safe for concurrent use.
+type instanceCache struct {
+	mutex sync.Mutex
+	now   func() time.Time
+
+	// ttl is the duration for which looked up instances are served from the cache
+	ttl time.Duration
+	// window is the duration for which lookups are collected before they are sent to EC2
+	window time.Duration
+	// timeout is the deadline of the DescribeInstances calls of a batch
+	timeout time.Duration
+	// pageSize is the number of instances requested per DescribeInstances page
+	pageSize int64
+
+	// scopes maps a hash of the credentials in the secret and the region to its cached instances
+	scopes map[string]*instanceCacheScope
+}
+
+// newInstanceCache returns an instanceCache configured by the given options
+func newInstanceCache(options *DriverOptions) *instanceCache {
+	return &instanceCache{
+		now:      time.Now,
+		ttl:      options.InstanceCacheTTL,
+		window:   options.InstanceLookupBatchWindow,
+		timeout:  options.GetMachineStatusTimeout,
+		pageSize: options.listMachinesPageSize(),
+		scopes:   make(map[string]*instanceCacheScope),
+	}
+}
+
+// lookup returns the instances that match the given kind and value and are neither shutting down nor terminated,
+// apart from interrupted spot instances.
+// Fresh results are served from the cache, otherwise the lookup joins the pending batch of the scope.
+func (c *instanceCache) lookup(ctx context.Context, svc ec2iface.EC2API, scope string, kind instanceLookupKind, value string) ([]*ec2.Instance, error) {
+	entry, err := c.get(ctx, svc, scope, kind, value)
+	if err != nil {
+		return nil, err
+	}
+	return entry.instances, nil
+}
+
+// lookupStatus returns the status of the instance with the given ID, or nil if the instance isn't running,
+// and the time it was fetched from EC2. It is cached and batched like the instances.
+func (c *instanceCache) lookupStatus(ctx context.Context, svc ec2iface.EC2API, scope string, instanceID string) (*ec2.InstanceStatus, time.Time, error) {
+	entry, err := c.get(ctx, svc, scope, lookupStatusByID, instanceID)
+	if err != nil {
+		return nil, time.Time{}, err
+	} else if len(entry.statuses) == 0 {
+		return nil, entry.fetchedAt, nil
+	}
+	return entry.statuses[0], entry.fetchedAt, nil
+}
+
+// get returns the cache entry of a lookup, waiting for the pending batch of the scope if necessary
+func (c *instanceCache) get(ctx context.Context, svc ec2iface.EC2API, scope string, kind instanceLookupKind, value string) (*instanceCacheEntry, error) {
+	key := instanceCacheKey(kind, value)
+
+	c.mutex.Lock()
+	s := c.scope(scope)
+	if entry, ok := s.entries[key]; ok && c.now().Sub(entry.fetchedAt) < c.ttl {
+		c.mutex.Unlock()
+		return entry, nil
+	}
+
+	batch := s.pending
+	if batch == nil {
+		batch = &instanceBatch{
+			svc:     svc,
+			lookups: make(map[instanceLookupKind]map[string]struct{}),
+			done:    make(chan struct{}),
+		}
+		s.pending = batch
+		time.AfterFunc(c.window, func() { c.run(scope, s, batch) })
+	}
+	if batch.lookups[kind] == nil {
+		batch.lookups[kind] = make(map[string]struct{})
+	}
+	batch.lookups[kind][value] = struct{}{}
+	c.mutex.Unlock()
+
+	select {
+	case <-batch.done:
+		if batch.err != nil {
+			return nil, batch.err
+		}
+		return batch.results[key], nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// invalidate drops the cached instances for the given lookups of a scope
+func (c *instanceCache) invalidate(scope string, kind instanceLookupKind, values ...string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	s, ok := c.scopes[scope]
+	if !ok {
+		return
+	}
+	s.generation++
+	for _, value := range values {
+		delete(s.entries, instanceCacheKey(kind, value))
+	}
+}
+
+// scope returns the scope with the given key, creating it if necessary. The caller must hold the mutex.
+func (c *instanceCache) scope(key string) *instanceCacheScope {
+	s, ok := c.scopes[key]
+	if !ok {
+		s = &instanceCacheScope{entries: make(map[string]*instanceCacheEntry)}
+		c.scopes[key] = s
+	}
+	return s
+}
+
+// run sends the lookups of the batch to EC2 and caches the results
+func (c *instanceCache) run(scope string, s *instanceCacheScope, batch *instanceBatch) {
+	c.mutex.Lock()
+	if s.pending == batch {
+		s.pending = nil
+	}
+	generation := s.generation
+	c.mutex.Unlock()
+
+	ctx, cancel := withOperationTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	results, err := c.describe(ctx, batch)
+
+	c.mutex.Lock()
+	now := c.now()
+	for _, entry := range results {
+		entry.fetchedAt = now
+	}
+	if err == nil && c.ttl > 0 && s.generation == generation {
+		for key, entry := range results {
+			// Lookups without instances aren't cached, an instance may have been launched although the response
+			// of its RunInstances call got lost, and it must be found when its machine is deleted
+			if len(entry.instances) == 0 && len(entry.statuses) == 0 {
+				continue
+			}
+			s.entries[key] = entry
+		}
+	}
+	c.purge(now)
+	c.mutex.Unlock()
+
+	batch.results, batch.err = results, err
+	close(batch.done)
+}
+
+// describe runs the paged DescribeInstances and DescribeInstanceStatus calls for the lookups of the batch
+// and groups the results by lookup. Lookups without instances map to an empty result.
+func (c *instanceCache) describe(ctx context.Context, batch *instanceBatch) (map[string]*instanceCacheEntry, error) {
+	results := make(map[string]*instanceCacheEntry)
+
+	for kind, lookups := range batch.lookups {
+		values := make([]string, 0, len(lookups))
+		for value := range lookups {
+			values = append(values, value)
+			results[instanceCacheKey(kind, value)] = &instanceCacheEntry{}
+		}
+
+		if kind == lookupStatusByID {
+			if err := describeStatuses(ctx, batch.svc, values, results); err != nil {
+				klog.Errorf("AWS plugin is returning error while describe instance status request is sent: %s", err)
+				return nil, err
+			}
+			klog.V(4).Infof("Looked up the status of %d instances in one batch", len(values))
+			continue
+		}
+
+		for start := 0; start < len(values); start += maxFilterValues {
+			end := start + maxFilterValues
+			if end > len(values) {
+				end = len(values)
+			}
+
+			input := &ec2.DescribeInstancesInput{
+				Filters: []*ec2.Filter{
+					{
+						Name:   aws.String(string(kind)),
+						Values: aws.StringSlice(values[start:end]),
+					},
+					{
+						Name: aws.String("instance-state-name"),
+						Values: []*string{
+							aws.String("pending"),
+							aws.String("running"),
+							aws.String("shutting-down"),
+							aws.String("stopping"),
+							aws.String("stopped"),
+						},
+					},
+				},
+				MaxResults: aws.Int64(c.pageSize),
+			}
+
+			err := batch.svc.DescribeInstancesPagesWithContext(ctx, input, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+				for _, reservation := range page.Reservations {
+					for _, instance := range reservation.Instances {
+						// Instances that are shutting down are gone, unless EC2 reclaimed them as spot instances,
+						// which must be reported as interrupted
+						if isTerminated(instance) && !isSpotInterrupted(instance) {
+							continue
+						}
+						value := aws.StringValue(instance.InstanceId)
+						if kind == lookupByName {
+							value = getTagValue(instance.Tags, "Name")
+						}
+						if entry, ok := results[instanceCacheKey(kind, value)]; ok {
+							entry.instances = append(entry.instances, instance)
+						}
+					}
+				}
+				return true
+			})
+			if err != nil {
+				klog.Errorf("AWS plugin is returning error while describe instances request is sent: %s", err)
+				return nil, err
+			}
+		}
+
+		klog.V(4).Infof("Looked up %d instances by %s in one batch", len(values), kind)
+	}
+
+	return results, nil
+}
+
+// describeStatuses runs the DescribeInstanceStatus calls for the given instance IDs and adds the statuses to the results
+func describeStatuses(ctx context.Context, svc ec2iface.EC2API, instanceIDs []string, results map[string]*instanceCacheEntry) error {
+	for start := 0; start < len(instanceIDs); start += maxInstanceStatusIDs {
+		end := start + maxInstanceStatusIDs
+		if end > len(instanceIDs) {
+			end = len(instanceIDs)
+		}
+
+		err := describeStatusPages(ctx, svc, instanceIDs[start:end], results)
+		if !isInstanceNotFound(err) {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		// A single instance terminated in the meantime fails the whole call, so the instances are described one by one
+		for _, instanceID := range instanceIDs[start:end] {
+			results[instanceCacheKey(lookupStatusByID, instanceID)].statuses = nil
+			if err := describeStatusPages(ctx, svc, []string{instanceID}, results); err != nil && !isInstanceNotFound(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// describeStatusPages adds the statuses of the given running instances to the results
+func describeStatusPages(ctx context.Context, svc ec2iface.EC2API, instanceIDs []string, results map[string]*instanceCacheEntry) error {
+	input := &ec2.DescribeInstanceStatusInput{
+		InstanceIds: aws.StringSlice(instanceIDs),
+	}
+	return svc.DescribeInstanceStatusPagesWithContext(ctx, input, func(page *ec2.DescribeInstanceStatusOutput, lastPage bool) bool {
+		for _, instanceStatus := range page.InstanceStatuses {
+			if entry, ok := results[instanceCacheKey(lookupStatusByID, aws.StringValue(instanceStatus.InstanceId))]; ok {
+				entry.statuses = append(entry.statuses, instanceStatus)
+			}
+		}
+		return true
+	})
+}
+
+// purge drops expired entries and unused scopes. The caller must hold the mutex.
+func (c *instanceCache) purge(now time.Time) {
+	for key, s := range c.scopes {
+		for entryKey, entry := range s.entries {
+			if now.Sub(entry.fetchedAt) >= c.ttl {
+				delete(s.entries, entryKey)
+			}
+		}
+		if len(s.entries) == 0 && s.pending == nil {
+			delete(c.scopes, key)
+		}
+	}
+}
+
+// instanceCacheKey returns the key of a lookup within a scope
+func instanceCacheKey(kind instanceLookupKind, value string) string {
+	return string(kind) + "=" + value
+}
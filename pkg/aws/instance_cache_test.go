@@ -0,0 +1,240 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/gardener/machine-controller-manager-provider-aws/pkg/fakeec2"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/driver"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/machinecodes/codes"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/machinecodes/status"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var _ = Describe("InstanceCache", func() {
+
+	var (
+		ctx     = context.Background()
+		fake    *fakeec2.EC2
+		d       *Driver
+		options *DriverOptions
+
+		providerSpec   = []byte("{\"ami\":\"ami-123456789\",\"blockDevices\":[{\"ebs\":{\"volumeSize\":50,\"volumeType\":\"gp2\"}}],\"iam\":{\"name\":\"test-iam\"},\"keyName\":\"test-ssh-publickey\",\"machineType\":\"m4.large\",\"networkInterfaces\":[{\"securityGroupIDs\":[\"sg-00002132323\"],\"subnetID\":\"subnet-123456\"}],\"region\":\"eu-west-1\",\"tags\":{\"kubernetes.io/cluster/shoot--test\":\"1\",\"kubernetes.io/role/test\":\"1\"}}")
+		otherSpec      = []byte("{\"ami\":\"ami-123456789\",\"blockDevices\":[{\"ebs\":{\"volumeSize\":50,\"volumeType\":\"gp2\"}}],\"iam\":{\"name\":\"test-iam\"},\"keyName\":\"test-ssh-publickey\",\"machineType\":\"m4.large\",\"networkInterfaces\":[{\"securityGroupIDs\":[\"sg-00002132323\"],\"subnetID\":\"subnet-123456\"}],\"region\":\"eu-west-1\",\"tags\":{\"kubernetes.io/cluster/shoot--other\":\"1\",\"kubernetes.io/role/test\":\"1\"}}")
+		providerSecret = &corev1.Secret{
+			Data: map[string][]byte{
+				"providerAccessKeyId":     []byte("dummy-id"),
+				"providerSecretAccessKey": []byte("dummy-secret"),
+				"userData":                []byte("dummy-user-data"),
+			},
+		}
+	)
+
+	// create creates a machine and returns the provider ID of its instance
+	create := func(index int) string {
+		response, err := d.CreateMachine(ctx, &driver.CreateMachineRequest{
+			Machine:      newMachine(index),
+			MachineClass: newMachineClass(providerSpec),
+			Secret:       providerSecret,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		return response.ProviderID
+	}
+
+	// getStatus requests the status of a machine without provider ID, which is looked up by its Name tag
+	getStatus := func(index int, spec []byte) (*driver.GetMachineStatusResponse, error) {
+		return d.GetMachineStatus(ctx, &driver.GetMachineStatusRequest{
+			Machine:      newMachineWithProviderID(index, ""),
+			MachineClass: newMachineClass(spec),
+			Secret:       providerSecret,
+		})
+	}
+
+	expectNotFound := func(err error) {
+		Expect(err).To(HaveOccurred())
+		statusErr, ok := status.FromError(err)
+		Expect(ok).To(BeTrue())
+		Expect(statusErr.Code()).To(Equal(codes.NotFound))
+	}
+
+	BeforeEach(func() {
+		fake = newFakeEC2()
+		options = NewDriverOptions()
+		options.InstanceLookupBatchWindow = 100 * time.Millisecond
+	})
+
+	JustBeforeEach(func() {
+		d = NewDriver(fakeec2.NewSessionProvider(fake), options)
+	})
+
+	It("should batch concurrent lookups into one DescribeInstances call", func() {
+		providerIDs := make([]string, 20)
+		for i := range providerIDs {
+			providerIDs[i] = create(i)
+		}
+		calls := fake.Calls("DescribeInstances")
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(index int) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				response, err := getStatus(index, providerSpec)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(response.ProviderID).To(Equal(providerIDs[index]))
+			}(i)
+		}
+		wg.Wait()
+
+		Expect(fake.Calls("DescribeInstances") - calls).To(Equal(1))
+	})
+
+	It("should serve lookups from the cache within the freshness window", func() {
+		create(0)
+		_, err := getStatus(0, providerSpec)
+		Expect(err).ToNot(HaveOccurred())
+		calls := fake.Calls("DescribeInstances")
+
+		_, err = getStatus(0, providerSpec)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fake.Calls("DescribeInstances")).To(Equal(calls))
+
+		now := time.Now().Add(time.Hour)
+		d.instances.mutex.Lock()
+		d.instances.now = func() time.Time { return now }
+		d.instances.mutex.Unlock()
+
+		_, err = getStatus(0, providerSpec)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fake.Calls("DescribeInstances")).To(Equal(calls + 1))
+	})
+
+	It("should not cache lookups without instances", func() {
+		_, err := getStatus(0, providerSpec)
+		expectNotFound(err)
+
+		// The instance is launched, but the response of RunInstances is lost, so the lookup isn't invalidated
+		_, err = fake.RunInstances(&ec2.RunInstancesInput{
+			ImageId:  aws.String("ami-123456789"),
+			MinCount: aws.Int64(1),
+			MaxCount: aws.Int64(1),
+			TagSpecifications: []*ec2.TagSpecification{{
+				ResourceType: aws.String(ec2.ResourceTypeInstance),
+				Tags: []*ec2.Tag{
+					{Key: aws.String("Name"), Value: aws.String("machine-0")},
+					{Key: aws.String("kubernetes.io/cluster/shoot--test"), Value: aws.String("1")},
+					{Key: aws.String("kubernetes.io/role/test"), Value: aws.String("1")},
+				},
+			}},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = getStatus(0, providerSpec)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should check the cluster and role tags of cached instances", func() {
+		create(0)
+		_, err := getStatus(0, providerSpec)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = getStatus(0, otherSpec)
+		expectNotFound(err)
+	})
+
+	It("should invalidate the lookups of created machines", func() {
+		_, err := getStatus(0, providerSpec)
+		expectNotFound(err)
+
+		providerID := create(0)
+
+		response, err := getStatus(0, providerSpec)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(response.ProviderID).To(Equal(providerID))
+	})
+
+	It("should invalidate the lookups of deleted machines", func() {
+		providerID := create(0)
+		_, err := getStatus(0, providerSpec)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = d.DeleteMachine(ctx, &driver.DeleteMachineRequest{
+			Machine:      newMachineWithProviderID(0, providerID),
+			MachineClass: newMachineClass(providerSpec),
+			Secret:       providerSecret,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = getStatus(0, providerSpec)
+		expectNotFound(err)
+	})
+
+	It("should look up the statuses of the other instances if one of a batch doesn't exist anymore", func() {
+		_, healthy, err := decodeRegionAndProviderID(create(0))
+		Expect(err).ToNot(HaveOccurred())
+		_, rebooting, err := decodeRegionAndProviderID(create(1))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fake.SetInstanceStatus(rebooting, &ec2.InstanceStatus{
+			Events: []*ec2.InstanceStatusEvent{{Code: aws.String("system-reboot")}},
+		})).To(Succeed())
+		scope := sessionCacheKey(providerSecret, "eu-west-1")
+
+		var wg sync.WaitGroup
+		for _, instanceID := range []string{healthy, rebooting, "i-0000000000000dead"} {
+			wg.Add(1)
+			go func(instanceID string) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				instanceStatus, _, err := d.instances.lookupStatus(ctx, fake, scope, instanceID)
+				Expect(err).ToNot(HaveOccurred())
+				switch instanceID {
+				case healthy:
+					Expect(instanceStatus.Events).To(BeEmpty())
+				case rebooting:
+					Expect(instanceStatus.Events).To(HaveLen(1))
+				default:
+					Expect(instanceStatus).To(BeNil())
+				}
+			}(instanceID)
+		}
+		wg.Wait()
+	})
+
+	Context("with caching disabled", func() {
+		BeforeEach(func() {
+			options.InstanceCacheTTL = 0
+		})
+
+		It("should look up instances for every request", func() {
+			create(0)
+			calls := fake.Calls("DescribeInstances")
+
+			for i := 0; i < 3; i++ {
+				_, err := getStatus(0, providerSpec)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			Expect(fake.Calls("DescribeInstances") - calls).To(Equal(3))
+			Expect(d.instances.scopes).To(BeEmpty())
+		})
+	})
+})
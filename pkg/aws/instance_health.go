@@ -0,0 +1,209 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/machinecodes/codes"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/machinecodes/status"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+const (
+	// stateReasonSpotInstanceTermination is the state reason of spot instances reclaimed by EC2
+	stateReasonSpotInstanceTermination = "Server.SpotInstanceTermination"
+)
+
+// interruptedStates are the states of instances that are being stopped, their machines can't become healthy again
+var interruptedStates = map[string]bool{
+	ec2.InstanceStateNameStopping: true,
+	ec2.InstanceStateNameStopped:  true,
+}
+
+// checkInstanceHealth returns an Aborted error if the instance has been interrupted by EC2 or is being stopped,
+// or, if CheckInstanceEvents is enabled, if EC2 has scheduled a retirement, reboot, stop or maintenance event for
+// it. If enabled, it returns a FailedPrecondition error once the EC2 status checks of the instance failed the
+// configured number of times in a row.
+func (d *Driver) checkInstanceHealth(ctx context.Context, secret *corev1.Secret, region string, instance *ec2.Instance) error {
+	var (
+		instanceID = aws.StringValue(instance.InstanceId)
+		state      string
+	)
+
+	if instance.State != nil {
+		state = aws.StringValue(instance.State.Name)
+	}
+	if interruptedStates[state] || isSpotInterrupted(instance) {
+		errMessage := fmt.Sprintf("VM %q is %s", instanceID, state)
+		if instance.StateReason != nil {
+			errMessage = fmt.Sprintf("%s: %s", errMessage, aws.StringValue(instance.StateReason.Message))
+		}
+		return status.Error(codes.Aborted, errMessage)
+	}
+
+	// Only running instances report their status and scheduled events
+	checkStatus := d.options.StatusCheckFailureThreshold > 0
+	if (!d.options.CheckInstanceEvents && !checkStatus) || state != ec2.InstanceStateNameRunning {
+		return nil
+	}
+
+	svc, err := d.createSVC(secret, region)
+	if err != nil {
+		return awsErrorToStatus(ctx, err)
+	}
+
+	instanceStatus, fetchedAt, err := d.instances.lookupStatus(ctx, svc, sessionCacheKey(secret, region), instanceID)
+	if awsErrorToCode(err) == codes.PermissionDenied {
+		// The status of the instance is an addition, missing permissions must not fail healthy machines
+		klog.Warningf("Status of VM %q couldn't be looked up: %s", instanceID, err.Error())
+		return nil
+	} else if err != nil {
+		return awsErrorToStatus(ctx, err)
+	} else if instanceStatus == nil {
+		return nil
+	}
+
+	if d.options.CheckInstanceEvents {
+		if err := checkScheduledEvents(instanceID, instanceStatus); err != nil {
+			return err
+		}
+	}
+
+	if checkStatus {
+		failures, summary := d.statusChecks.observe(instanceID, instanceStatus, fetchedAt)
+		if failures >= d.options.StatusCheckFailureThreshold {
+			errMessage := fmt.Sprintf("VM %q failed %d consecutive status checks: %s", instanceID, failures, summary)
+			if d.options.CaptureConsoleOutput {
+				errMessage += d.consoleOutputMessage(ctx, svc, instanceID)
+			}
+			return status.Error(codes.FailedPrecondition, statusMessageReplacer.Replace(errMessage))
+		} else if failures > 0 {
+			klog.Warningf("VM %q failed %d of %d allowed consecutive status checks: %s", instanceID, failures, d.options.StatusCheckFailureThreshold, summary)
+		}
+	}
+
+	return nil
+}
+
+// isSpotInterrupted returns true if EC2 reclaimed the capacity of the spot instance
+func isSpotInterrupted(instance *ec2.Instance) bool {
+	return instance.StateReason != nil && aws.StringValue(instance.StateReason.Code) == stateReasonSpotInstanceTermination
+}
+
+// checkScheduledEvents returns an Aborted error if the instance has upcoming scheduled events
+func checkScheduledEvents(instanceID string, instanceStatus *ec2.InstanceStatus) error {
+	for _, event := range instanceStatus.Events {
+		description := aws.StringValue(event.Description)
+		// Completed and canceled events are still reported for some time
+		if strings.HasPrefix(description, "[Completed]") || strings.HasPrefix(description, "[Canceled]") {
+			continue
+		}
+
+		errMessage := fmt.Sprintf("VM %q has a scheduled %s event not before %s: %s",
+			instanceID,
+			aws.StringValue(event.Code),
+			aws.TimeValue(event.NotBefore).UTC().Format(time.RFC3339),
+			description,
+		)
+		return status.Error(codes.Aborted, errMessage)
+	}
+
+	return nil
+}
+
+// statusCheckTracker counts the consecutive failed status checks of instances. It is safe for concurrent use.
+type statusCheckTracker struct {
+	mutex sync.Mutex
+	// instances maps instance IDs with failed status checks to their failures
+	instances map[string]*statusCheckFailures
+}
+
+// statusCheckFailures are the consecutive failed status checks of an instance
+type statusCheckFailures struct {
+	count int
+	// fetchedAt is the time of the last counted status, statuses served from the cache are counted once
+	fetchedAt time.Time
+}
+
+// newStatusCheckTracker returns an empty statusCheckTracker
+func newStatusCheckTracker() *statusCheckTracker {
+	return &statusCheckTracker{instances: make(map[string]*statusCheckFailures)}
+}
+
+// observe records the status of an instance fetched at the given time. It returns the number of consecutive
+// failed status checks and a summary of the failed checks.
+func (t *statusCheckTracker) observe(instanceID string, instanceStatus *ec2.InstanceStatus, fetchedAt time.Time) (int, string) {
+	var summaries []string
+	if summary := statusSummary("system", instanceStatus.SystemStatus); summary != "" {
+		summaries = append(summaries, summary)
+	}
+	if summary := statusSummary("instance", instanceStatus.InstanceStatus); summary != "" {
+		summaries = append(summaries, summary)
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if len(summaries) == 0 {
+		delete(t.instances, instanceID)
+		return 0, ""
+	}
+
+	failures, ok := t.instances[instanceID]
+	if !ok {
+		failures = &statusCheckFailures{}
+		t.instances[instanceID] = failures
+	}
+	if !failures.fetchedAt.Equal(fetchedAt) {
+		failures.count++
+		failures.fetchedAt = fetchedAt
+	}
+	return failures.count, strings.Join(summaries, ", ")
+}
+
+// forget drops the failures of an instance
+func (t *statusCheckTracker) forget(instanceID string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.instances, instanceID)
+}
+
+// statusSummary returns a summary of an impaired status check, or an empty string if the check didn't fail
+func statusSummary(kind string, summary *ec2.InstanceStatusSummary) string {
+	if summary == nil || aws.StringValue(summary.Status) != ec2.SummaryStatusImpaired {
+		return ""
+	}
+
+	var details []string
+	for _, detail := range summary.Details {
+		detailSummary := fmt.Sprintf("%s %s", aws.StringValue(detail.Name), aws.StringValue(detail.Status))
+		if detail.ImpairedSince != nil {
+			detailSummary += " since " + detail.ImpairedSince.UTC().Format(time.RFC3339)
+		}
+		details = append(details, detailSummary)
+	}
+
+	if len(details) == 0 {
+		return fmt.Sprintf("%s status impaired", kind)
+	}
+	return fmt.Sprintf("%s status impaired with %s", kind, strings.Join(details, " and "))
+}
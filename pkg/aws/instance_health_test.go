@@ -0,0 +1,136 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/gardener/machine-controller-manager-provider-aws/pkg/fakeec2"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/driver"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var _ = Describe("StatusChecks", func() {
+
+	var (
+		ctx        = context.Background()
+		fake       *fakeec2.EC2
+		d          *Driver
+		options    *DriverOptions
+		providerID string
+		instanceID string
+
+		providerSpec   = []byte("{\"ami\":\"ami-123456789\",\"blockDevices\":[{\"ebs\":{\"volumeSize\":50,\"volumeType\":\"gp2\"}}],\"iam\":{\"name\":\"test-iam\"},\"keyName\":\"test-ssh-publickey\",\"machineType\":\"m4.large\",\"networkInterfaces\":[{\"securityGroupIDs\":[\"sg-00002132323\"],\"subnetID\":\"subnet-123456\"}],\"region\":\"eu-west-1\",\"tags\":{\"kubernetes.io/cluster/shoot--test\":\"1\",\"kubernetes.io/role/test\":\"1\"}}")
+		providerSecret = &corev1.Secret{
+			Data: map[string][]byte{
+				"providerAccessKeyId":     []byte("dummy-id"),
+				"providerSecretAccessKey": []byte("dummy-secret"),
+				"userData":                []byte("dummy-user-data"),
+			},
+		}
+		impairedStatus = &ec2.InstanceStatus{
+			SystemStatus: &ec2.InstanceStatusSummary{
+				Status: aws.String("ok"),
+			},
+			InstanceStatus: &ec2.InstanceStatusSummary{
+				Status: aws.String("impaired"),
+				Details: []*ec2.InstanceStatusDetails{
+					{
+						Name:          aws.String("reachability"),
+						Status:        aws.String("failed"),
+						ImpairedSince: aws.Time(time.Date(2020, 12, 1, 10, 0, 0, 0, time.UTC)),
+					},
+				},
+			},
+		}
+	)
+
+	getStatus := func() error {
+		_, err := d.GetMachineStatus(ctx, &driver.GetMachineStatusRequest{
+			Machine:      newMachineWithProviderID(0, providerID),
+			MachineClass: newMachineClass(providerSpec),
+			Secret:       providerSecret,
+		})
+		return err
+	}
+
+	BeforeEach(func() {
+		fake = fakeec2.New()
+		fake.AddImage(&ec2.Image{ImageId: aws.String("ami-123456789")})
+		options = NewDriverOptions()
+		options.StatusCheckFailureThreshold = 2
+		options.InstanceCacheTTL = 0
+	})
+
+	JustBeforeEach(func() {
+		d = NewDriver(fakeec2.NewSessionProvider(fake), options)
+		response, err := d.CreateMachine(ctx, &driver.CreateMachineRequest{
+			Machine:      newMachine(0),
+			MachineClass: newMachineClass(providerSpec),
+			Secret:       providerSecret,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		providerID = response.ProviderID
+		_, instanceID, err = decodeRegionAndProviderID(providerID)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fake.SetInstanceStatus(instanceID, impairedStatus)).To(Succeed())
+	})
+
+	It("should report a machine as failed after consecutive failed status checks", func() {
+		Expect(getStatus()).ToNot(HaveOccurred())
+
+		err := getStatus()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(Equal("machine codes error: code = [FailedPrecondition] message = [VM \"" + instanceID + "\" failed 2 consecutive status checks: instance status impaired with reachability failed since 2020-12-01T10:00:00Z]"))
+	})
+
+	It("should reset the failures once the status checks pass again", func() {
+		Expect(getStatus()).ToNot(HaveOccurred())
+
+		Expect(fake.SetInstanceStatus(instanceID, &ec2.InstanceStatus{})).To(Succeed())
+		Expect(getStatus()).ToNot(HaveOccurred())
+
+		Expect(fake.SetInstanceStatus(instanceID, impairedStatus)).To(Succeed())
+		Expect(getStatus()).ToNot(HaveOccurred())
+	})
+
+	Context("with cached instance statuses", func() {
+		BeforeEach(func() {
+			options.InstanceCacheTTL = time.Hour
+		})
+
+		It("should count a cached status check only once", func() {
+			for i := 0; i < 3; i++ {
+				Expect(getStatus()).ToNot(HaveOccurred())
+			}
+		})
+	})
+
+	Context("with status checks disabled", func() {
+		BeforeEach(func() {
+			options.StatusCheckFailureThreshold = 0
+		})
+
+		It("should ignore failed status checks", func() {
+			for i := 0; i < 3; i++ {
+				Expect(getStatus()).ToNot(HaveOccurred())
+			}
+		})
+	})
+})
@@ -0,0 +1,171 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	api "github.com/gardener/machine-controller-manager-provider-aws/pkg/aws/apis"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/driver"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/machinecodes/codes"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/machinecodes/status"
+	"k8s.io/klog"
+)
+
+// RedactedUserData replaces the user data in planned launch requests
+const RedactedUserData = "<redacted>"
+
+// LaunchParameters are the inputs of a launch request that aren't part of the provider spec
+type LaunchParameters struct {
+	// MachineName is set as Name tag of the instance, its volumes and network interfaces
+	MachineName string
+	// RootDeviceName is the root device name of the AMI, it's used for the root block device
+	RootDeviceName *string
+	// UserData is the user data of the instance, it's base64 encoded in the request
+	UserData []byte
+	// OwnerTags are the reserved tags identifying the owner of the instance
+	OwnerTags map[string]string
+	// ClientToken is the idempotency token of the request
+	ClientToken string
+}
+
+// NewRunInstancesInput returns the RunInstances request launching the instance of a machine with the given
+// provider spec. It doesn't call AWS, the AMI must already be resolved to its root device name.
+func NewRunInstancesInput(providerSpec *api.AWSProviderSpec, params LaunchParameters) (*ec2.RunInstancesInput, error) {
+	blkDeviceMappings, err := generateBlockDevices(providerSpec.BlockDevices, params.RootDeviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	var tagSpecifications []*ec2.TagSpecification
+	for _, resourceType := range []string{resourceTypeInstance, resourceTypeVolume, resourceTypeNetworkInterface} {
+		tagSpecification, err := generateTags(providerSpec.Tags, resourceType, params.MachineName, params.OwnerTags)
+		if err != nil {
+			return nil, err
+		}
+		tagSpecifications = append(tagSpecifications, tagSpecification)
+	}
+
+	var networkInterfaceSpecs []*ec2.InstanceNetworkInterfaceSpecification
+	for i, netIf := range providerSpec.NetworkInterfaces {
+		spec := &ec2.InstanceNetworkInterfaceSpecification{
+			Groups:                   aws.StringSlice(netIf.SecurityGroupIDs),
+			DeviceIndex:              aws.Int64(int64(i)),
+			AssociatePublicIpAddress: netIf.AssociatePublicIPAddress,
+			DeleteOnTermination:      netIf.DeleteOnTermination,
+			Description:              netIf.Description,
+			SubnetId:                 aws.String(netIf.SubnetID),
+		}
+
+		if netIf.DeleteOnTermination == nil {
+			spec.DeleteOnTermination = aws.Bool(true)
+		}
+
+		networkInterfaceSpecs = append(networkInterfaceSpecs, spec)
+	}
+
+	// Specify the details of the machine that you want to create.
+	input := &ec2.RunInstancesInput{
+		BlockDeviceMappings: blkDeviceMappings,
+		ImageId:             aws.String(providerSpec.AMI),
+		InstanceType:        aws.String(providerSpec.MachineType),
+		MinCount:            aws.Int64(1),
+		MaxCount:            aws.Int64(1),
+		UserData:            aws.String(base64.StdEncoding.EncodeToString(params.UserData)),
+		KeyName:             aws.String(providerSpec.KeyName),
+		IamInstanceProfile: &ec2.IamInstanceProfileSpecification{
+			Name: aws.String(providerSpec.IAM.Name),
+		},
+		NetworkInterfaces: networkInterfaceSpecs,
+		TagSpecifications: tagSpecifications,
+		ClientToken:       aws.String(params.ClientToken),
+	}
+
+	// Set spot price if it has been set
+	if providerSpec.SpotPrice != nil {
+		input.InstanceMarketOptions = &ec2.InstanceMarketOptionsRequest{
+			MarketType: aws.String(ec2.MarketTypeSpot),
+			SpotOptions: &ec2.SpotMarketOptions{
+				SpotInstanceType: aws.String(ec2.SpotInstanceTypeOneTime),
+			},
+		}
+
+		if *providerSpec.SpotPrice != "" {
+			input.InstanceMarketOptions.SpotOptions.MaxPrice = providerSpec.SpotPrice
+		}
+	}
+
+	return input, nil
+}
+
+// PlanMachine returns the RunInstances request CreateMachine would send for the given machine, with the user
+// data redacted. It resolves the AMI but doesn't launch anything.
+func (d *Driver) PlanMachine(ctx context.Context, req *driver.CreateMachineRequest) (*ec2.RunInstancesInput, error) {
+	klog.V(3).Infof("Machine plan request has been recieved for %q", req.Machine.Name)
+
+	ctx, cancel := withOperationTimeout(ctx, d.options.CreateMachineTimeout)
+	defer cancel()
+
+	providerSpec, err := d.decodeProviderSpecAndSecret(req.MachineClass, req.Secret, true)
+	if err != nil {
+		return nil, err
+	}
+
+	svc, err := d.createSVC(req.Secret, providerSpec.Region)
+	if err != nil {
+		return nil, awsErrorToStatus(ctx, err)
+	}
+
+	input, err := d.runInstancesInput(ctx, svc, req, providerSpec, 0)
+	if err != nil {
+		return nil, err
+	}
+	input.UserData = aws.String(RedactedUserData)
+	return input, nil
+}
+
+// runInstancesInput resolves the AMI of the provider spec and returns the RunInstances request for the
+// given creation attempt of the machine
+func (d *Driver) runInstancesInput(ctx context.Context, svc ec2iface.EC2API, req *driver.CreateMachineRequest, providerSpec *api.AWSProviderSpec, attempt int) (*ec2.RunInstancesInput, error) {
+	userData, exists := req.Secret.Data["userData"]
+	if !exists {
+		return nil, status.Error(codes.Internal, "userData doesn't exist")
+	}
+
+	output, err := svc.DescribeImagesWithContext(ctx, &ec2.DescribeImagesInput{
+		ImageIds: aws.StringSlice([]string{providerSpec.AMI}),
+	})
+	if err != nil {
+		return nil, awsErrorToStatus(ctx, err)
+	} else if len(output.Images) < 1 {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Image %s not found", providerSpec.AMI))
+	}
+
+	input, err := NewRunInstancesInput(providerSpec, LaunchParameters{
+		MachineName:    req.Machine.Name,
+		RootDeviceName: output.Images[0].RootDeviceName,
+		UserData:       userData,
+		OwnerTags:      d.ownerTags(req.MachineClass, req.Machine),
+		ClientToken:    generateClientToken(req.Machine, attempt),
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return input, nil
+}
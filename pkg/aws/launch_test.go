@@ -0,0 +1,178 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"encoding/base64"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	api "github.com/gardener/machine-controller-manager-provider-aws/pkg/aws/apis"
+	"github.com/gardener/machine-controller-manager-provider-aws/pkg/fakeec2"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/driver"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var _ = Describe("Launch", func() {
+
+	Describe("#NewRunInstancesInput", func() {
+		var (
+			providerSpec *api.AWSProviderSpec
+			params       LaunchParameters
+		)
+
+		BeforeEach(func() {
+			providerSpec = &api.AWSProviderSpec{
+				AMI: "ami-123456789",
+				BlockDevices: []api.AWSBlockDeviceMappingSpec{
+					{Ebs: api.AWSEbsBlockDeviceSpec{VolumeSize: 50, VolumeType: "gp2"}},
+				},
+				IAM:         api.AWSIAMProfileSpec{Name: "test-iam"},
+				KeyName:     "test-ssh-publickey",
+				MachineType: "m4.large",
+				NetworkInterfaces: []api.AWSNetworkInterfaceSpec{
+					{SecurityGroupIDs: []string{"sg-00002132323"}, SubnetID: "subnet-123456"},
+				},
+				Region: "eu-west-1",
+				Tags:   map[string]string{"kubernetes.io/cluster/shoot--test": "1"},
+			}
+			params = LaunchParameters{
+				MachineName:    "machine-0",
+				RootDeviceName: aws.String("/dev/xvda"),
+				UserData:       []byte("dummy-user-data"),
+				OwnerTags:      map[string]string{api.TagControllerID: "test-controller"},
+				ClientToken:    "test-token",
+			}
+		})
+
+		It("should render the launch request of the provider spec", func() {
+			tags := []*ec2.Tag{
+				{Key: aws.String("kubernetes.io/cluster/shoot--test"), Value: aws.String("1")},
+				{Key: aws.String("Name"), Value: aws.String("machine-0")},
+				{Key: aws.String(api.TagControllerID), Value: aws.String("test-controller")},
+			}
+
+			input, err := NewRunInstancesInput(providerSpec, params)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(input).To(Equal(&ec2.RunInstancesInput{
+				BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+					{
+						DeviceName: aws.String("/dev/xvda"),
+						Ebs: &ec2.EbsBlockDevice{
+							DeleteOnTermination: aws.Bool(true),
+							Encrypted:           aws.Bool(false),
+							VolumeSize:          aws.Int64(50),
+							VolumeType:          aws.String("gp2"),
+						},
+					},
+				},
+				ImageId:            aws.String("ami-123456789"),
+				InstanceType:       aws.String("m4.large"),
+				MinCount:           aws.Int64(1),
+				MaxCount:           aws.Int64(1),
+				UserData:           aws.String(base64.StdEncoding.EncodeToString([]byte("dummy-user-data"))),
+				KeyName:            aws.String("test-ssh-publickey"),
+				IamInstanceProfile: &ec2.IamInstanceProfileSpecification{Name: aws.String("test-iam")},
+				NetworkInterfaces: []*ec2.InstanceNetworkInterfaceSpecification{
+					{
+						Groups:              aws.StringSlice([]string{"sg-00002132323"}),
+						DeviceIndex:         aws.Int64(0),
+						DeleteOnTermination: aws.Bool(true),
+						SubnetId:            aws.String("subnet-123456"),
+					},
+				},
+				TagSpecifications: []*ec2.TagSpecification{
+					{ResourceType: aws.String("instance"), Tags: tags},
+					{ResourceType: aws.String("volume"), Tags: tags},
+					{ResourceType: aws.String("network-interface"), Tags: tags},
+				},
+				ClientToken: aws.String("test-token"),
+			}))
+		})
+
+		It("should request spot instances with the maximum price", func() {
+			providerSpec.SpotPrice = aws.String("0.5")
+
+			input, err := NewRunInstancesInput(providerSpec, params)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(input.InstanceMarketOptions).To(Equal(&ec2.InstanceMarketOptionsRequest{
+				MarketType: aws.String("spot"),
+				SpotOptions: &ec2.SpotMarketOptions{
+					SpotInstanceType: aws.String("one-time"),
+					MaxPrice:         aws.String("0.5"),
+				},
+			}))
+		})
+
+		It("should fail without block devices", func() {
+			providerSpec.BlockDevices = nil
+
+			_, err := NewRunInstancesInput(providerSpec, params)
+			Expect(err).To(MatchError("No block devices passed"))
+		})
+	})
+
+	Describe("#PlanMachine", func() {
+		var (
+			ctx  = context.Background()
+			fake *fakeec2.EC2
+			d    *Driver
+
+			providerSpec   = []byte("{\"ami\":\"ami-123456789\",\"blockDevices\":[{\"ebs\":{\"volumeSize\":50,\"volumeType\":\"gp2\"}}],\"iam\":{\"name\":\"test-iam\"},\"keyName\":\"test-ssh-publickey\",\"machineType\":\"m4.large\",\"networkInterfaces\":[{\"securityGroupIDs\":[\"sg-00002132323\"],\"subnetID\":\"subnet-123456\"}],\"region\":\"eu-west-1\",\"tags\":{\"kubernetes.io/cluster/shoot--test\":\"1\",\"kubernetes.io/role/test\":\"1\"}}")
+			providerSecret = &corev1.Secret{
+				Data: map[string][]byte{
+					"providerAccessKeyId":     []byte("dummy-id"),
+					"providerSecretAccessKey": []byte("dummy-secret"),
+					"userData":                []byte("dummy-user-data"),
+				},
+			}
+		)
+
+		BeforeEach(func() {
+			fake = fakeec2.New()
+			fake.AddImage(&ec2.Image{ImageId: aws.String("ami-123456789"), RootDeviceName: aws.String("/dev/sda1")})
+			d = NewDriver(fakeec2.NewSessionProvider(fake), NewDriverOptions())
+		})
+
+		It("should render the launch request without launching an instance", func() {
+			input, err := d.PlanMachine(ctx, &driver.CreateMachineRequest{
+				Machine:      newMachine(0),
+				MachineClass: newMachineClass(providerSpec),
+				Secret:       providerSecret,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(input.UserData).To(Equal(aws.String(RedactedUserData)))
+			Expect(input.BlockDeviceMappings[0].DeviceName).To(Equal(aws.String("/dev/sda1")))
+			Expect(input.ClientToken).To(Equal(aws.String(generateClientToken(newMachine(0), 0))))
+			Expect(fake.Calls("RunInstances")).To(BeZero())
+			Expect(fake.Instances()).To(BeEmpty())
+		})
+
+		It("should fail for an unknown AMI", func() {
+			fake = fakeec2.New()
+			d = NewDriver(fakeec2.NewSessionProvider(fake), NewDriverOptions())
+
+			_, err := d.PlanMachine(ctx, &driver.CreateMachineRequest{
+				Machine:      newMachine(0),
+				MachineClass: newMachineClass(providerSpec),
+				Secret:       providerSecret,
+			})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix("machine codes error: code = [InvalidArgument]"))
+		})
+	})
+})
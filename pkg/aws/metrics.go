@@ -0,0 +1,55 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricsNamespace = "mcm"
+	metricsSubsystem = "aws_orphans"
+)
+
+var (
+	// orphanedResourcesDetected counts the orphaned resources found by orphan collections
+	orphanedResourcesDetected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "detected_total",
+		Help:      "Number of orphaned AWS resources detected by orphan collections.",
+	}, []string{"resource"})
+
+	// orphanedResourcesDeleted counts the orphaned resources deleted by orphan collections
+	orphanedResourcesDeleted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "deleted_total",
+		Help:      "Number of orphaned AWS resources deleted by orphan collections.",
+	}, []string{"resource"})
+
+	// orphanedResourcesDeletionFailures counts the orphaned resources that couldn't be deleted
+	orphanedResourcesDeletionFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "deletion_failures_total",
+		Help:      "Number of orphaned AWS resources that couldn't be deleted by orphan collections.",
+	}, []string{"resource"})
+)
+
+func init() {
+	prometheus.MustRegister(orphanedResourcesDetected)
+	prometheus.MustRegister(orphanedResourcesDeleted)
+	prometheus.MustRegister(orphanedResourcesDeletionFailures)
+}
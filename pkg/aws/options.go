@@ -0,0 +1,197 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+const (
+	// defaultSessionCacheTTL is the default duration for which an AWS session and EC2 client are reused
+	defaultSessionCacheTTL = 30 * time.Minute
+
+	// defaultCreateMachineTimeout is the default deadline for the AWS calls of a machine creation
+	defaultCreateMachineTimeout = 5 * time.Minute
+	// defaultDeleteMachineTimeout is the default deadline for the AWS calls of a machine deletion
+	defaultDeleteMachineTimeout = 5 * time.Minute
+	// defaultGetMachineStatusTimeout is the default deadline for the AWS calls of a machine status request
+	defaultGetMachineStatusTimeout = 1 * time.Minute
+	// defaultListMachinesTimeout is the default deadline for the AWS calls of a machine list request
+	defaultListMachinesTimeout = 5 * time.Minute
+
+	// defaultInstanceCacheTTL is the default duration for which looked up instances are served from the cache
+	defaultInstanceCacheTTL = 30 * time.Second
+	// defaultInstanceLookupBatchWindow is the default duration for which instance lookups are collected into one batch
+	defaultInstanceLookupBatchWindow = 50 * time.Millisecond
+
+	// defaultConsoleOutputAfter is the default duration after the launch of an instance after which the console output
+	// of a machine that hasn't joined the cluster is captured
+	defaultConsoleOutputAfter = 15 * time.Minute
+	// defaultConsoleOutputLimit is the default maximum number of bytes of captured console output
+	defaultConsoleOutputLimit = 4096
+
+	// defaultOrphanGracePeriod is the default minimum age of orphaned resources before they are collected
+	defaultOrphanGracePeriod = 1 * time.Hour
+
+	// defaultControllerIDPrefix is the prefix of the default ID of the controller tagged on the created instances,
+	// it's followed by the namespace of the machine class
+	defaultControllerIDPrefix = "machine-controller-manager-provider-aws"
+
+	// defaultListMachinesPageSize is the default number of instances requested per DescribeInstances page
+	defaultListMachinesPageSize = 1000
+	// minListMachinesPageSize and maxListMachinesPageSize are the bounds of MaxResults accepted by DescribeInstances
+	minListMachinesPageSize = 5
+	maxListMachinesPageSize = 1000
+)
+
+// DriverOptions contains the tunables of the AWS driver
+type DriverOptions struct {
+	// SessionCacheTTL is the duration after which a cached AWS session and EC2 client are discarded.
+	// A value of zero disables caching.
+	SessionCacheTTL time.Duration
+
+	// CreateMachineTimeout is the deadline for the AWS calls of a CreateMachine request. A value of zero disables it.
+	CreateMachineTimeout time.Duration
+	// DeleteMachineTimeout is the deadline for the AWS calls of a DeleteMachine request. A value of zero disables it.
+	DeleteMachineTimeout time.Duration
+	// GetMachineStatusTimeout is the deadline for the AWS calls of a GetMachineStatus request. A value of zero disables it.
+	GetMachineStatusTimeout time.Duration
+	// ListMachinesTimeout is the deadline for the AWS calls of a ListMachines request. A value of zero disables it.
+	ListMachinesTimeout time.Duration
+
+	// ListMachinesPageSize is the number of instances requested per DescribeInstances page of a ListMachines request.
+	// It is bounded to the range accepted by EC2.
+	ListMachinesPageSize int64
+
+	// InstanceCacheTTL is the duration for which instances looked up for a machine status are served from the cache.
+	// A value of zero disables caching.
+	InstanceCacheTTL time.Duration
+	// InstanceLookupBatchWindow is the duration for which instance lookups are collected into one DescribeInstances batch.
+	InstanceLookupBatchWindow time.Duration
+	// CheckInstanceEvents makes machine status requests fail with Aborted for instances with scheduled events.
+	CheckInstanceEvents bool
+	// StatusCheckFailureThreshold is the number of consecutive failed EC2 status checks after which machine status
+	// requests fail with FailedPrecondition. A value of zero disables the status checks.
+	StatusCheckFailureThreshold int
+
+	// CaptureConsoleOutput makes machine status requests log the console output of instances whose machines haven't
+	// joined the cluster after ConsoleOutputAfter, and attach it to the errors of failed status checks.
+	CaptureConsoleOutput bool
+	// ConsoleOutputAfter is the duration after the launch of an instance after which its console output is captured.
+	ConsoleOutputAfter time.Duration
+	// ConsoleOutputLimit is the maximum number of bytes of captured console output, earlier output is cut off.
+	ConsoleOutputLimit int
+
+	// WaitForTermination makes DeleteMachine block until the instances are terminated and their volumes
+	// and network interfaces are released, bounded by DeleteMachineTimeout.
+	WaitForTermination bool
+
+	// ControllerID identifies this controller in the ownership tags of the created instances. If it's empty, the ID
+	// is derived from the namespace of the machine class.
+	ControllerID string
+	// StrictOwnershipTags requires instances to carry the ownership tags. By default, instances created before
+	// the ownership tags were introduced are accepted as long as they don't carry conflicting tags.
+	StrictOwnershipTags bool
+
+	// ValidateMachineClasses makes the first machine creation of each version of a machine class validate the
+	// class against the resources in AWS, so that mistakes fail with their field paths instead of RunInstances errors.
+	ValidateMachineClasses bool
+	// LenientProviderSpecDecoding makes unknown fields in provider specs be ignored with a warning instead of
+	// failing machine creations, for machine classes that can't be fixed right away. Requests for existing
+	// machines always ignore unknown fields.
+	LenientProviderSpecDecoding bool
+	// CheckPermissions makes the controller check the EC2 permissions of the credentials of every machine class
+	// with DryRun calls when it starts and log the denied actions.
+	CheckPermissions bool
+
+	// OrphanGracePeriod is the minimum age of orphaned resources before they are collected, younger resources
+	// may still be in use by machines that are being created.
+	OrphanGracePeriod time.Duration
+	// OrphanCollectionDryRun makes orphan collections only report orphaned resources instead of deleting them.
+	OrphanCollectionDryRun bool
+	// OrphanCollectionInterval is the interval in which the controller collects the orphaned resources of all
+	// machine classes. A value of zero, the default, disables the collection.
+	OrphanCollectionInterval time.Duration
+}
+
+// NewDriverOptions returns DriverOptions initialized with the default values
+func NewDriverOptions() *DriverOptions {
+	return &DriverOptions{
+		SessionCacheTTL:         defaultSessionCacheTTL,
+		CreateMachineTimeout:    defaultCreateMachineTimeout,
+		DeleteMachineTimeout:    defaultDeleteMachineTimeout,
+		GetMachineStatusTimeout: defaultGetMachineStatusTimeout,
+		ListMachinesTimeout:     defaultListMachinesTimeout,
+		ListMachinesPageSize:    defaultListMachinesPageSize,
+
+		InstanceCacheTTL:          defaultInstanceCacheTTL,
+		InstanceLookupBatchWindow: defaultInstanceLookupBatchWindow,
+
+		ConsoleOutputAfter: defaultConsoleOutputAfter,
+		ConsoleOutputLimit: defaultConsoleOutputLimit,
+
+		OrphanGracePeriod:      defaultOrphanGracePeriod,
+		OrphanCollectionDryRun: true,
+	}
+}
+
+// AddFlags adds the flags for the AWS driver to the given FlagSet
+func (o *DriverOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.DurationVar(&o.SessionCacheTTL, "aws-session-cache-ttl", o.SessionCacheTTL, "Duration for which AWS sessions and EC2 clients are reused per secret and region. 0 disables caching.")
+	fs.DurationVar(&o.CreateMachineTimeout, "aws-create-machine-timeout", o.CreateMachineTimeout, "Deadline for the AWS calls of a machine creation. 0 disables the deadline.")
+	fs.DurationVar(&o.DeleteMachineTimeout, "aws-delete-machine-timeout", o.DeleteMachineTimeout, "Deadline for the AWS calls of a machine deletion. 0 disables the deadline.")
+	fs.DurationVar(&o.GetMachineStatusTimeout, "aws-get-machine-status-timeout", o.GetMachineStatusTimeout, "Deadline for the AWS calls of a machine status request. 0 disables the deadline.")
+	fs.DurationVar(&o.ListMachinesTimeout, "aws-list-machines-timeout", o.ListMachinesTimeout, "Deadline for the AWS calls of a machine list request. 0 disables the deadline.")
+	fs.Int64Var(&o.ListMachinesPageSize, "aws-list-machines-page-size", o.ListMachinesPageSize, fmt.Sprintf("Number of instances requested per DescribeInstances page when listing machines (%d-%d).", minListMachinesPageSize, maxListMachinesPageSize))
+	fs.DurationVar(&o.InstanceCacheTTL, "aws-instance-cache-ttl", o.InstanceCacheTTL, "Duration for which instances looked up for machine status requests are served from the cache. 0 disables caching.")
+	fs.DurationVar(&o.InstanceLookupBatchWindow, "aws-instance-lookup-batch-window", o.InstanceLookupBatchWindow, "Duration for which instance lookups of machine status requests are collected into one DescribeInstances batch.")
+	fs.BoolVar(&o.CheckInstanceEvents, "aws-check-instance-events", o.CheckInstanceEvents, "Fail machine status requests for instances with scheduled retirement, reboot, stop or maintenance events.")
+	fs.IntVar(&o.StatusCheckFailureThreshold, "aws-status-check-failure-threshold", o.StatusCheckFailureThreshold, "Number of consecutive failed EC2 system or instance status checks after which machine status requests fail. 0 disables the status checks.")
+	fs.BoolVar(&o.CaptureConsoleOutput, "aws-capture-console-output", o.CaptureConsoleOutput, "Log the console output of VMs whose machines don't join the cluster and attach it to failed status checks.")
+	fs.DurationVar(&o.ConsoleOutputAfter, "aws-console-output-after", o.ConsoleOutputAfter, "Duration after the launch of a VM after which the console output is logged if its machine hasn't joined the cluster.")
+	fs.IntVar(&o.ConsoleOutputLimit, "aws-console-output-limit", o.ConsoleOutputLimit, "Maximum number of bytes of captured console output, earlier output is cut off.")
+	fs.BoolVar(&o.WaitForTermination, "aws-wait-for-termination", o.WaitForTermination, "Block machine deletions until the instances are terminated and their volumes and network interfaces are released.")
+	fs.StringVar(&o.ControllerID, "aws-controller-id", o.ControllerID, "ID of this controller, set as ownership tag on the created instances. Defaults to an ID derived from the namespace of the machine classes.")
+	fs.BoolVar(&o.StrictOwnershipTags, "aws-strict-ownership-tags", o.StrictOwnershipTags, "Only consider instances carrying all ownership tags, rejecting instances created before they were introduced.")
+	fs.BoolVar(&o.ValidateMachineClasses, "aws-validate-machine-classes", o.ValidateMachineClasses, "Validate machine classes against the images, instance types, subnets, security groups, key pairs and instance profiles in AWS on their first use.")
+	fs.BoolVar(&o.LenientProviderSpecDecoding, "aws-lenient-provider-spec-decoding", o.LenientProviderSpecDecoding, "Ignore unknown fields in the provider specs of machine classes with a warning instead of rejecting machine creations.")
+	fs.BoolVar(&o.CheckPermissions, "aws-check-permissions", o.CheckPermissions, "Check the EC2 permissions of the credentials of all machine classes with dry-run calls on startup and log the denied actions.")
+	fs.DurationVar(&o.OrphanGracePeriod, "aws-orphan-grace-period", o.OrphanGracePeriod, "Minimum age of orphaned instances, volumes and network interfaces before they are collected.")
+	fs.BoolVar(&o.OrphanCollectionDryRun, "aws-orphan-collection-dry-run", o.OrphanCollectionDryRun, "Only report orphaned instances, volumes and network interfaces instead of deleting them.")
+	fs.DurationVar(&o.OrphanCollectionInterval, "aws-orphan-collection-interval", o.OrphanCollectionInterval, "Interval in which the controller collects the orphaned instances, volumes and network interfaces of all machine classes. 0 disables the collection.")
+}
+
+// listMachinesPageSize returns the configured page size bounded to the range accepted by EC2
+func (o *DriverOptions) listMachinesPageSize() int64 {
+	switch {
+	case o.ListMachinesPageSize < minListMachinesPageSize:
+		return minListMachinesPageSize
+	case o.ListMachinesPageSize > maxListMachinesPageSize:
+		return maxListMachinesPageSize
+	}
+	return o.ListMachinesPageSize
+}
+
+// withOperationTimeout returns a context that is canceled after the given timeout.
+// The context is returned unchanged, apart from being cancelable, if the timeout is zero.
+func withOperationTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
@@ -0,0 +1,385 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	api "github.com/gardener/machine-controller-manager-provider-aws/pkg/aws/apis"
+	"github.com/gardener/machine-controller-manager/pkg/apis/machine/v1alpha1"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/machinecodes/codes"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/machinecodes/status"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+// OrphanCollectionRequest is the request to collect the orphaned resources of a machine class
+type OrphanCollectionRequest struct {
+	// MachineClass is the machine class whose cluster is checked for orphaned resources
+	MachineClass *v1alpha1.MachineClass
+	// Secret is the secret of the machine class
+	Secret *corev1.Secret
+	// Machines are all machine objects that exist. Instances are known if a machine has their provider ID, or
+	// if a machine without provider ID has their name. Volumes and network interfaces are known by machine name.
+	Machines []*v1alpha1.Machine
+}
+
+// Orphan is a resource left behind by a machine that doesn't exist anymore
+type Orphan struct {
+	// ResourceType is the type of the resource: instance, volume or network-interface
+	ResourceType string
+	// ID is the ID of the resource
+	ID string
+	// MachineName is the value of the Name tag of the resource
+	MachineName string
+	// Age is the time since the resource was created, or first detected if EC2 doesn't report its creation time
+	Age time.Duration
+	// Deleted is set if the resource has been deleted
+	Deleted bool
+}
+
+// OrphanCollectionResponse is the response of an orphan collection
+type OrphanCollectionResponse struct {
+	// Orphans are the orphaned resources older than the grace period
+	Orphans []Orphan
+}
+
+// orphanTracker remembers when orphaned resources without creation time were first detected
+type orphanTracker struct {
+	mutex sync.Mutex
+	now   func() time.Time
+
+	// firstSeen maps the scope of a collection to the detection times of its orphans
+	firstSeen map[string]map[string]time.Time
+}
+
+// newOrphanTracker returns an empty orphanTracker
+func newOrphanTracker() *orphanTracker {
+	return &orphanTracker{
+		now:       time.Now,
+		firstSeen: make(map[string]map[string]time.Time),
+	}
+}
+
+// track returns the time since the given resources of a scope were first detected.
+// Resources that are not detected anymore are forgotten.
+func (t *orphanTracker) track(scope string, ids []string) map[string]time.Duration {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var (
+		now       = t.now()
+		previous  = t.firstSeen[scope]
+		firstSeen = make(map[string]time.Time, len(ids))
+		ages      = make(map[string]time.Duration, len(ids))
+	)
+	for _, id := range ids {
+		seen, ok := previous[id]
+		if !ok {
+			seen = now
+		}
+		firstSeen[id] = seen
+		ages[id] = now.Sub(seen)
+	}
+
+	if len(firstSeen) == 0 {
+		delete(t.firstSeen, scope)
+	} else {
+		t.firstSeen[scope] = firstSeen
+	}
+	return ages
+}
+
+// CollectOrphans detects the instances, unattached volumes and unattached network interfaces carrying the cluster
+// tag of the machine class that don't belong to a known machine. Volumes and network interfaces the machine class
+// retains on purpose are never collected. Orphans older than the grace period are returned and, unless the driver
+// runs the collection in dry-run mode, deleted. Failed deletions don't stop the collection, they are returned as
+// one error together with the response.
+func (d *Driver) CollectOrphans(ctx context.Context, req *OrphanCollectionRequest) (*OrphanCollectionResponse, error) {
+	var (
+		machineClass = req.MachineClass
+		secret       = req.Secret
+	)
+
+	klog.V(3).Infof("Orphan collection has been started for %q", machineClass.Name)
+
+	// Without the machine objects every resource would look orphaned
+	if req.Machines == nil {
+		return nil, status.Error(codes.InvalidArgument, "Orphan collection requires the machine objects that exist")
+	}
+
+	ctx, cancel := withOperationTimeout(ctx, d.options.ListMachinesTimeout)
+	defer cancel()
+
+	providerSpec, err := d.decodeProviderSpecAndSecret(machineClass, secret, false)
+	if err != nil {
+		return nil, err
+	}
+
+	svc, err := d.createSVC(secret, providerSpec.Region)
+	if err != nil {
+		return nil, awsErrorToStatus(ctx, err)
+	}
+
+	var (
+		knownInstances = make(map[string]bool)
+		// launchingMachines are the names of the machines without provider ID, their instances may be launching
+		launchingMachines = make(map[string]bool)
+		knownMachines     = make(map[string]bool)
+	)
+	for _, machine := range req.Machines {
+		knownMachines[machine.Name] = true
+		if machine.Spec.ProviderID == "" {
+			launchingMachines[machine.Name] = true
+		} else if _, instanceID, err := decodeRegionAndProviderID(machine.Spec.ProviderID); err == nil {
+			knownInstances[instanceID] = true
+		}
+	}
+
+	orphans, err := d.findOrphanedInstances(ctx, svc, machineClass, providerSpec, knownInstances, launchingMachines)
+	if err != nil {
+		return nil, awsErrorToStatus(ctx, err)
+	}
+	if retainsVolumes(providerSpec) {
+		klog.V(4).Infof("Volumes of machine class %q are retained, they are not collected", machineClass.Name)
+	} else {
+		volumes, err := d.findOrphanedVolumes(ctx, svc, machineClass, providerSpec, knownMachines)
+		if err != nil {
+			return nil, awsErrorToStatus(ctx, err)
+		}
+		orphans = append(orphans, volumes...)
+	}
+	if retainsNetworkInterfaces(providerSpec) {
+		klog.V(4).Infof("Network interfaces of machine class %q are retained, they are not collected", machineClass.Name)
+	} else {
+		networkInterfaces, err := d.findOrphanedNetworkInterfaces(ctx, svc, sessionCacheKey(secret, providerSpec.Region), machineClass, providerSpec, knownMachines)
+		if err != nil {
+			return nil, awsErrorToStatus(ctx, err)
+		}
+		orphans = append(orphans, networkInterfaces...)
+	}
+
+	var (
+		response = &OrphanCollectionResponse{}
+		failures []string
+	)
+	for _, orphan := range orphans {
+		if orphan.Age < d.options.OrphanGracePeriod {
+			klog.V(4).Infof("Orphaned %s %s of Machine %q is within the grace period", orphan.ResourceType, orphan.ID, orphan.MachineName)
+			continue
+		}
+		orphanedResourcesDetected.WithLabelValues(orphan.ResourceType).Inc()
+
+		if d.options.OrphanCollectionDryRun {
+			klog.Infof("Orphaned %s %s of Machine %q is %s old, it is not deleted in dry-run mode", orphan.ResourceType, orphan.ID, orphan.MachineName, orphan.Age.Round(time.Second))
+		} else if err := d.deleteOrphan(ctx, svc, secret, providerSpec.Region, orphan); err != nil {
+			klog.Errorf("Orphaned %s %s of Machine %q couldn't be deleted: %s", orphan.ResourceType, orphan.ID, orphan.MachineName, err.Error())
+			orphanedResourcesDeletionFailures.WithLabelValues(orphan.ResourceType).Inc()
+			failures = append(failures, fmt.Sprintf("%s %s: %s", orphan.ResourceType, orphan.ID, err.Error()))
+		} else {
+			klog.Infof("Orphaned %s %s of Machine %q has been deleted", orphan.ResourceType, orphan.ID, orphan.MachineName)
+			orphanedResourcesDeleted.WithLabelValues(orphan.ResourceType).Inc()
+			orphan.Deleted = true
+		}
+		response.Orphans = append(response.Orphans, orphan)
+	}
+
+	klog.V(3).Infof("Orphan collection for %q found %d orphaned resources", machineClass.Name, len(response.Orphans))
+	if len(failures) > 0 {
+		return response, status.Error(codes.Internal, statusMessageReplacer.Replace(fmt.Sprintf("%d orphaned resources couldn't be deleted: %s", len(failures), strings.Join(failures, "; "))))
+	}
+	return response, nil
+}
+
+// findOrphanedInstances returns the instances of the machine class that are neither known by their ID nor
+// belong to a launching machine
+func (d *Driver) findOrphanedInstances(ctx context.Context, svc ec2iface.EC2API, machineClass *v1alpha1.MachineClass, providerSpec *api.AWSProviderSpec, knownInstances, launchingMachines map[string]bool) ([]Orphan, error) {
+	var (
+		orphans        []Orphan
+		now            = d.orphans.now()
+		ownerTags      = d.ownerTags(machineClass, nil)
+		clusterName, _ = getClusterAndRoleTagKeys(providerSpec.Tags)
+	)
+
+	input := &ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("tag-key"),
+				Values: []*string{aws.String(clusterName)},
+			},
+			{
+				Name: aws.String("instance-state-name"),
+				Values: []*string{
+					aws.String("pending"),
+					aws.String("running"),
+					aws.String("stopping"),
+					aws.String("stopped"),
+				},
+			},
+		},
+		MaxResults: aws.Int64(d.options.listMachinesPageSize()),
+	}
+
+	err := svc.DescribeInstancesPagesWithContext(ctx, input, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+		for _, reservation := range page.Reservations {
+			for _, instance := range reservation.Instances {
+				// Instances of other machine classes or created before the owner tags were introduced are left to
+				// the safety controller of the machine controller manager
+				if !carriesTags(instance.Tags, ownerTags) {
+					continue
+				}
+
+				machineName := getTagValue(instance.Tags, "Name")
+				if knownInstances[aws.StringValue(instance.InstanceId)] || launchingMachines[machineName] {
+					continue
+				}
+
+				orphans = append(orphans, Orphan{
+					ResourceType: resourceTypeInstance,
+					ID:           aws.StringValue(instance.InstanceId),
+					MachineName:  machineName,
+					Age:          now.Sub(aws.TimeValue(instance.LaunchTime)),
+				})
+			}
+		}
+		return true
+	})
+	return orphans, err
+}
+
+// findOrphanedVolumes returns the unattached volumes created by this controller that don't belong to a known machine
+func (d *Driver) findOrphanedVolumes(ctx context.Context, svc ec2iface.EC2API, machineClass *v1alpha1.MachineClass, providerSpec *api.AWSProviderSpec, knownMachines map[string]bool) ([]Orphan, error) {
+	var (
+		orphans []Orphan
+		now     = d.orphans.now()
+	)
+
+	input := &ec2.DescribeVolumesInput{Filters: d.orphanFilters(machineClass, providerSpec)}
+	err := svc.DescribeVolumesPagesWithContext(ctx, input, func(page *ec2.DescribeVolumesOutput, lastPage bool) bool {
+		for _, volume := range page.Volumes {
+			machineName := getTagValue(volume.Tags, "Name")
+			if knownMachines[machineName] {
+				continue
+			}
+
+			orphans = append(orphans, Orphan{
+				ResourceType: resourceTypeVolume,
+				ID:           aws.StringValue(volume.VolumeId),
+				MachineName:  machineName,
+				Age:          now.Sub(aws.TimeValue(volume.CreateTime)),
+			})
+		}
+		return true
+	})
+	return orphans, err
+}
+
+// findOrphanedNetworkInterfaces returns the unattached network interfaces created by this controller that don't
+// belong to a known machine. EC2 doesn't report their creation time, so their age is tracked from their first detection.
+func (d *Driver) findOrphanedNetworkInterfaces(ctx context.Context, svc ec2iface.EC2API, scope string, machineClass *v1alpha1.MachineClass, providerSpec *api.AWSProviderSpec, knownMachines map[string]bool) ([]Orphan, error) {
+	var orphans []Orphan
+
+	input := &ec2.DescribeNetworkInterfacesInput{Filters: d.orphanFilters(machineClass, providerSpec)}
+	err := svc.DescribeNetworkInterfacesPagesWithContext(ctx, input, func(page *ec2.DescribeNetworkInterfacesOutput, lastPage bool) bool {
+		for _, networkInterface := range page.NetworkInterfaces {
+			machineName := getTagValue(networkInterface.TagSet, "Name")
+			if knownMachines[machineName] {
+				continue
+			}
+
+			orphans = append(orphans, Orphan{
+				ResourceType: resourceTypeNetworkInterface,
+				ID:           aws.StringValue(networkInterface.NetworkInterfaceId),
+				MachineName:  machineName,
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(orphans))
+	for _, orphan := range orphans {
+		ids = append(ids, orphan.ID)
+	}
+	ages := d.orphans.track(scope+"/"+resourceTypeNetworkInterface, ids)
+	for i := range orphans {
+		orphans[i].Age = ages[orphans[i].ID]
+	}
+	return orphans, nil
+}
+
+// orphanFilters returns the filters for the unattached volumes and network interfaces of the cluster
+// that have been created by this controller. Resources created by other components, e.g. the volumes
+// of persistent volume claims, also carry the cluster tag and must never be collected.
+func (d *Driver) orphanFilters(machineClass *v1alpha1.MachineClass, providerSpec *api.AWSProviderSpec) []*ec2.Filter {
+	clusterName, _ := getClusterAndRoleTagKeys(providerSpec.Tags)
+	return []*ec2.Filter{
+		{
+			Name:   aws.String("status"),
+			Values: []*string{aws.String("available")},
+		},
+		{
+			Name:   aws.String("tag-key"),
+			Values: []*string{aws.String(clusterName)},
+		},
+		{
+			Name:   aws.String("tag:" + api.TagControllerID),
+			Values: []*string{aws.String(d.controllerID(machineClass))},
+		},
+	}
+}
+
+// deleteOrphan deletes an orphaned resource, resources that don't exist anymore are ignored
+func (d *Driver) deleteOrphan(ctx context.Context, svc ec2iface.EC2API, secret *corev1.Secret, region string, orphan Orphan) error {
+	var err error
+
+	switch orphan.ResourceType {
+	case resourceTypeInstance:
+		_, err = svc.TerminateInstancesWithContext(ctx, &ec2.TerminateInstancesInput{InstanceIds: []*string{aws.String(orphan.ID)}})
+		d.invalidateInstances(secret, region, orphan.MachineName, orphan.ID)
+		if isInstanceNotFound(err) {
+			return nil
+		}
+	case resourceTypeVolume:
+		_, err = svc.DeleteVolumeWithContext(ctx, &ec2.DeleteVolumeInput{VolumeId: aws.String(orphan.ID)})
+		if isAWSErrorCode(err, errCodeVolumeNotFound) {
+			return nil
+		}
+	case resourceTypeNetworkInterface:
+		_, err = svc.DeleteNetworkInterfaceWithContext(ctx, &ec2.DeleteNetworkInterfaceInput{NetworkInterfaceId: aws.String(orphan.ID)})
+		if isAWSErrorCode(err, errCodeNetworkInterfaceNotFound) {
+			return nil
+		}
+	}
+	return err
+}
+
+// carriesTags returns true if all of the given tags are set with the same value
+func carriesTags(tags []*ec2.Tag, required map[string]string) bool {
+	for key, value := range required {
+		if tagValue, ok := getTag(tags, key); !ok || tagValue != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,245 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	api "github.com/gardener/machine-controller-manager-provider-aws/pkg/aws/apis"
+	"github.com/gardener/machine-controller-manager-provider-aws/pkg/fakeec2"
+	"github.com/gardener/machine-controller-manager/pkg/apis/machine/v1alpha1"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/machinecodes/codes"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/machinecodes/status"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var _ = Describe("CollectOrphans", func() {
+
+	var (
+		ctx     = context.Background()
+		now     = time.Now()
+		clock   time.Time
+		fake    *fakeec2.EC2
+		d       *Driver
+		options *DriverOptions
+		request *OrphanCollectionRequest
+
+		known, unknown, young string
+
+		controllerID = defaultControllerIDPrefix + "/" + testNamespace
+
+		providerSpec   = []byte("{\"ami\":\"ami-123456789\",\"blockDevices\":[{\"ebs\":{\"volumeSize\":50,\"volumeType\":\"gp2\"}}],\"iam\":{\"name\":\"test-iam\"},\"keyName\":\"test-ssh-publickey\",\"machineType\":\"m4.large\",\"networkInterfaces\":[{\"securityGroupIDs\":[\"sg-00002132323\"],\"subnetID\":\"subnet-123456\"}],\"region\":\"eu-west-1\",\"tags\":{\"kubernetes.io/cluster/shoot--test\":\"1\",\"kubernetes.io/role/test\":\"1\"}}")
+		providerSecret = &corev1.Secret{
+			Data: map[string][]byte{
+				"providerAccessKeyId":     []byte("dummy-id"),
+				"providerSecretAccessKey": []byte("dummy-secret"),
+				"userData":                []byte("dummy-user-data"),
+			},
+		}
+	)
+
+	machineClass := func(providerSpec []byte) *v1alpha1.MachineClass {
+		machineClass := newNamedMachineClass("test-class", providerSpec)
+		machineClass.Namespace = testNamespace
+		return machineClass
+	}
+	tags := func(machineName string, extraTags ...string) []*ec2.Tag {
+		result := []*ec2.Tag{
+			{Key: aws.String("Name"), Value: aws.String(machineName)},
+			{Key: aws.String("kubernetes.io/cluster/shoot--test"), Value: aws.String("1")},
+		}
+		for i := 0; i+1 < len(extraTags); i += 2 {
+			result = append(result, &ec2.Tag{Key: aws.String(extraTags[i]), Value: aws.String(extraTags[i+1])})
+		}
+		return result
+	}
+	// instance launches an instance of the machine class and returns its ID
+	instance := func(machineName string, age time.Duration) string {
+		clock = now.Add(-age)
+		defer func() { clock = now }()
+
+		reservation, err := fake.RunInstances(&ec2.RunInstancesInput{
+			ImageId:  aws.String("ami-123456789"),
+			MinCount: aws.Int64(1),
+			MaxCount: aws.Int64(1),
+			TagSpecifications: []*ec2.TagSpecification{
+				{
+					ResourceType: aws.String(ec2.ResourceTypeInstance),
+					Tags: tags(machineName,
+						"kubernetes.io/role/test", "1",
+						api.TagMachineClass, "test-class",
+						api.TagControllerID, controllerID,
+					),
+				},
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		return *reservation.Instances[0].InstanceId
+	}
+	volume := func(id, machineName string, age time.Duration, extraTags ...string) {
+		fake.AddVolume(&ec2.Volume{
+			VolumeId:   aws.String(id),
+			CreateTime: aws.Time(now.Add(-age)),
+			Tags:       tags(machineName, extraTags...),
+		})
+	}
+	networkInterface := func(id, machineName string, extraTags ...string) {
+		fake.AddNetworkInterface(&ec2.NetworkInterface{
+			NetworkInterfaceId: aws.String(id),
+			TagSet:             tags(machineName, extraTags...),
+		})
+	}
+	// availableVolumes returns the IDs of the volumes that aren't attached to the instances
+	availableVolumes := func() []string {
+		var ids []string
+		for _, volume := range fake.Volumes() {
+			if *volume.State == ec2.VolumeStateAvailable {
+				ids = append(ids, *volume.VolumeId)
+			}
+		}
+		return ids
+	}
+	orphanIDs := func(response *OrphanCollectionResponse) []string {
+		var ids []string
+		for _, orphan := range response.Orphans {
+			ids = append(ids, orphan.ID)
+		}
+		return ids
+	}
+
+	BeforeEach(func() {
+		clock = now
+		fake = newFakeEC2(fakeec2.WithClock(func() time.Time { return clock }))
+		known = instance("machine-0", 2*time.Hour)
+		unknown = instance("machine-1", 2*time.Hour)
+		young = instance("machine-2", time.Minute)
+		volume("vol-orphan", "machine-3", 2*time.Hour, api.TagControllerID, controllerID)
+		volume("vol-known", "machine-0", 2*time.Hour, api.TagControllerID, controllerID)
+		volume("vol-young", "machine-3", time.Minute, api.TagControllerID, controllerID)
+		volume("vol-pvc", "kubernetes-dynamic-pvc-1", 2*time.Hour)
+		networkInterface("eni-orphan", "machine-3", api.TagControllerID, controllerID)
+		networkInterface("eni-foreign", "machine-3", api.TagControllerID, "other-controller")
+
+		options = NewDriverOptions()
+		machine := newMachineWithProviderID(0, encodeProviderID("eu-west-1", known))
+		launching := newMachineWithProviderID(2, "")
+		request = &OrphanCollectionRequest{
+			MachineClass: machineClass(providerSpec),
+			Secret:       providerSecret,
+			Machines:     []*v1alpha1.Machine{machine, launching},
+		}
+	})
+
+	JustBeforeEach(func() {
+		d = NewDriver(fakeec2.NewSessionProvider(fake), options)
+		d.orphans.now = func() time.Time { return now }
+	})
+
+	It("should only report orphans older than the grace period in dry-run mode", func() {
+		detected := testutil.ToFloat64(orphanedResourcesDetected.WithLabelValues(resourceTypeVolume))
+
+		response, err := d.CollectOrphans(ctx, request)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(orphanIDs(response)).To(Equal([]string{unknown, "vol-orphan"}))
+		for _, orphan := range response.Orphans {
+			Expect(orphan.Deleted).To(BeFalse())
+		}
+
+		Expect(liveInstances(fake)).To(HaveLen(3))
+		Expect(availableVolumes()).To(HaveLen(4))
+		Expect(testutil.ToFloat64(orphanedResourcesDetected.WithLabelValues(resourceTypeVolume))).To(Equal(detected + 1))
+	})
+
+	It("should reject requests without machines", func() {
+		request.Machines = nil
+
+		_, err := d.CollectOrphans(ctx, request)
+		statusErr, ok := status.FromError(err)
+		Expect(ok).To(BeTrue())
+		Expect(statusErr.Code()).To(Equal(codes.InvalidArgument))
+	})
+
+	It("should consider instances of existing machines orphaned if the machine has another provider ID", func() {
+		request.Machines = append(request.Machines, newMachineWithProviderID(1, encodeProviderID("eu-west-1", "i-0000000000000dead")))
+
+		response, err := d.CollectOrphans(ctx, request)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(orphanIDs(response)).To(Equal([]string{unknown, "vol-orphan"}))
+	})
+
+	It("should not collect volumes retained by the cleanup policy", func() {
+		request.MachineClass = machineClass([]byte("{\"ami\":\"ami-123456789\",\"blockDevices\":[{\"ebs\":{\"volumeSize\":50,\"volumeType\":\"gp2\"}}],\"cleanupPolicy\":{\"volumes\":\"Retain\",\"networkInterfaces\":\"Delete\"},\"iam\":{\"name\":\"test-iam\"},\"keyName\":\"test-ssh-publickey\",\"machineType\":\"m4.large\",\"networkInterfaces\":[{\"securityGroupIDs\":[\"sg-00002132323\"],\"subnetID\":\"subnet-123456\"}],\"region\":\"eu-west-1\",\"tags\":{\"kubernetes.io/cluster/shoot--test\":\"1\",\"kubernetes.io/role/test\":\"1\"}}"))
+
+		response, err := d.CollectOrphans(ctx, request)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(orphanIDs(response)).To(Equal([]string{unknown}))
+	})
+
+	It("should not collect volumes that aren't deleted on termination", func() {
+		request.MachineClass = machineClass([]byte("{\"ami\":\"ami-123456789\",\"blockDevices\":[{\"ebs\":{\"deleteOnTermination\":false,\"volumeSize\":50,\"volumeType\":\"gp2\"}}],\"iam\":{\"name\":\"test-iam\"},\"keyName\":\"test-ssh-publickey\",\"machineType\":\"m4.large\",\"networkInterfaces\":[{\"securityGroupIDs\":[\"sg-00002132323\"],\"subnetID\":\"subnet-123456\"}],\"region\":\"eu-west-1\",\"tags\":{\"kubernetes.io/cluster/shoot--test\":\"1\",\"kubernetes.io/role/test\":\"1\"}}"))
+
+		response, err := d.CollectOrphans(ctx, request)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(orphanIDs(response)).To(Equal([]string{unknown}))
+	})
+
+	It("should not collect network interfaces that aren't deleted on termination", func() {
+		request.MachineClass = machineClass([]byte("{\"ami\":\"ami-123456789\",\"blockDevices\":[{\"ebs\":{\"volumeSize\":50,\"volumeType\":\"gp2\"}}],\"iam\":{\"name\":\"test-iam\"},\"keyName\":\"test-ssh-publickey\",\"machineType\":\"m4.large\",\"networkInterfaces\":[{\"deleteOnTermination\":false,\"securityGroupIDs\":[\"sg-00002132323\"],\"subnetID\":\"subnet-123456\"}],\"region\":\"eu-west-1\",\"tags\":{\"kubernetes.io/cluster/shoot--test\":\"1\",\"kubernetes.io/role/test\":\"1\"}}"))
+		d.orphans.now = func() time.Time { return now.Add(2 * time.Hour) }
+
+		response, err := d.CollectOrphans(ctx, request)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(orphanIDs(response)).ToNot(ContainElement("eni-orphan"))
+	})
+
+	It("should track the age of network interfaces from their first detection", func() {
+		response, err := d.CollectOrphans(ctx, request)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(orphanIDs(response)).ToNot(ContainElement("eni-orphan"))
+
+		d.orphans.now = func() time.Time { return now.Add(2 * time.Hour) }
+
+		response, err = d.CollectOrphans(ctx, request)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(orphanIDs(response)).To(ContainElement("eni-orphan"))
+		Expect(orphanIDs(response)).ToNot(ContainElement("eni-foreign"))
+	})
+
+	Context("without dry-run mode", func() {
+		BeforeEach(func() {
+			options.OrphanCollectionDryRun = false
+		})
+
+		It("should delete the orphans older than the grace period", func() {
+			deleted := testutil.ToFloat64(orphanedResourcesDeleted.WithLabelValues(resourceTypeInstance))
+
+			response, err := d.CollectOrphans(ctx, request)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(orphanIDs(response)).To(Equal([]string{unknown, "vol-orphan"}))
+			for _, orphan := range response.Orphans {
+				Expect(orphan.Deleted).To(BeTrue())
+			}
+
+			Expect(liveInstances(fake)).To(Equal([]string{known, young}))
+			Expect(availableVolumes()).To(Equal([]string{"vol-known", "vol-pvc", "vol-young"}))
+			Expect(testutil.ToFloat64(orphanedResourcesDeleted.WithLabelValues(resourceTypeInstance))).To(Equal(deleted + 1))
+		})
+	})
+})
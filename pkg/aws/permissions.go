@@ -0,0 +1,250 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	api "github.com/gardener/machine-controller-manager-provider-aws/pkg/aws/apis"
+	v1alpha1 "github.com/gardener/machine-controller-manager/pkg/apis/machine/v1alpha1"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/machinecodes/codes"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/machinecodes/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// errCodeDryRunOperation is returned by EC2 for DryRun calls that would have succeeded
+	errCodeDryRunOperation = "DryRunOperation"
+
+	// permissionCheckMachineName is the name of the machine in the launch request checked with DryRun
+	permissionCheckMachineName = "permission-check"
+	// defaultRootDeviceName is used for the launch request checked with DryRun if the image can't be described
+	defaultRootDeviceName = "/dev/xvda"
+
+	// Placeholders for the resources of actions checked while no such resource exists
+	placeholderInstanceID         = "i-00000000000000000"
+	placeholderVolumeID           = "vol-00000000000000000"
+	placeholderNetworkInterfaceID = "eni-00000000000000000"
+)
+
+// PermissionResult is the outcome of the check of an EC2 action
+type PermissionResult string
+
+const (
+	// PermissionAllowed means EC2 reported that the call would have succeeded
+	PermissionAllowed PermissionResult = "Allowed"
+	// PermissionDenied means EC2 rejected the call as unauthorized
+	PermissionDenied PermissionResult = "Denied"
+	// PermissionUnverified means EC2 rejected the call for another reason, e.g. because the placeholder resource of
+	// the call doesn't exist, so the permission couldn't be determined
+	PermissionUnverified PermissionResult = "Unverified"
+)
+
+// PermissionCheckRequest is the request to check the EC2 permissions of the credentials of a machine class
+type PermissionCheckRequest struct {
+	// MachineClass is the machine class whose launch request and optional features are checked
+	MachineClass *v1alpha1.MachineClass
+	// Secret is the secret of the machine class
+	Secret *corev1.Secret
+}
+
+// Permission is the result of the check of one EC2 action
+type Permission struct {
+	// Action is the IAM action, e.g. ec2:RunInstances
+	Action string `json:"action"`
+	// Result is the outcome of the DryRun call
+	Result PermissionResult `json:"result"`
+	// Message is the error returned by EC2 for denied and unverified actions
+	Message string `json:"message,omitempty"`
+}
+
+// PermissionCheckResponse is the response of a permission check
+type PermissionCheckResponse struct {
+	// Permissions are the results of the checked actions, in the order they were checked
+	Permissions []Permission `json:"permissions"`
+}
+
+// Denied returns the actions that are denied
+func (r *PermissionCheckResponse) Denied() []string {
+	var denied []string
+	for _, permission := range r.Permissions {
+		if permission.Result == PermissionDenied {
+			denied = append(denied, permission.Action)
+		}
+	}
+	return denied
+}
+
+// permissionCheck is an action checked with a DryRun call
+type permissionCheck struct {
+	action string
+	call   func() error
+}
+
+// CheckPermissions calls every EC2 action the driver relies on for the machine class with DryRun set, including
+// the actions of the optional features enabled in the driver options, and reports which of them are allowed.
+// The launch request is the one CreateMachine would send, so that conditions on its tags, instance type and spot
+// options are taken into account. Nothing is created or deleted. An error is returned if the credentials are
+// invalid or EC2 can't be reached.
+func (d *Driver) CheckPermissions(ctx context.Context, req *PermissionCheckRequest) (*PermissionCheckResponse, error) {
+	providerSpec, err := d.decodeProviderSpecAndSecret(req.MachineClass, req.Secret, true)
+	if err != nil {
+		return nil, err
+	}
+
+	svc, err := d.createSVC(req.Secret, providerSpec.Region)
+	if err != nil {
+		return nil, awsErrorToStatus(ctx, err)
+	}
+
+	launch, err := d.permissionCheckLaunch(ctx, svc, req, providerSpec)
+	if err != nil {
+		return nil, err
+	}
+	launch.DryRun = aws.Bool(true)
+
+	dryRun := aws.Bool(true)
+	checks := []permissionCheck{
+		{"ec2:DescribeImages", func() error {
+			_, err := svc.DescribeImagesWithContext(ctx, &ec2.DescribeImagesInput{DryRun: dryRun, ImageIds: aws.StringSlice([]string{providerSpec.AMI})})
+			return err
+		}},
+		{"ec2:RunInstances", func() error {
+			_, err := svc.RunInstancesWithContext(ctx, launch)
+			return err
+		}},
+		{"ec2:DescribeInstances", func() error {
+			_, err := svc.DescribeInstancesWithContext(ctx, &ec2.DescribeInstancesInput{DryRun: dryRun})
+			return err
+		}},
+		{"ec2:TerminateInstances", func() error {
+			_, err := svc.TerminateInstancesWithContext(ctx, &ec2.TerminateInstancesInput{DryRun: dryRun, InstanceIds: aws.StringSlice([]string{placeholderInstanceID})})
+			return err
+		}},
+		{"ec2:DescribeVolumes", func() error {
+			_, err := svc.DescribeVolumesWithContext(ctx, &ec2.DescribeVolumesInput{DryRun: dryRun})
+			return err
+		}},
+		{"ec2:DeleteVolume", func() error {
+			_, err := svc.DeleteVolumeWithContext(ctx, &ec2.DeleteVolumeInput{DryRun: dryRun, VolumeId: aws.String(placeholderVolumeID)})
+			return err
+		}},
+		{"ec2:DescribeNetworkInterfaces", func() error {
+			_, err := svc.DescribeNetworkInterfacesWithContext(ctx, &ec2.DescribeNetworkInterfacesInput{DryRun: dryRun})
+			return err
+		}},
+		{"ec2:DeleteNetworkInterface", func() error {
+			_, err := svc.DeleteNetworkInterfaceWithContext(ctx, &ec2.DeleteNetworkInterfaceInput{DryRun: dryRun, NetworkInterfaceId: aws.String(placeholderNetworkInterfaceID)})
+			return err
+		}},
+	}
+	if d.options.CheckInstanceEvents || d.options.StatusCheckFailureThreshold > 0 {
+		checks = append(checks, permissionCheck{"ec2:DescribeInstanceStatus", func() error {
+			_, err := svc.DescribeInstanceStatusWithContext(ctx, &ec2.DescribeInstanceStatusInput{DryRun: dryRun})
+			return err
+		}})
+	}
+	if d.options.CaptureConsoleOutput {
+		checks = append(checks, permissionCheck{"ec2:GetConsoleOutput", func() error {
+			_, err := svc.GetConsoleOutputWithContext(ctx, &ec2.GetConsoleOutputInput{DryRun: dryRun, InstanceId: aws.String(placeholderInstanceID)})
+			return err
+		}})
+	}
+	if d.options.ValidateMachineClasses {
+		checks = append(checks, []permissionCheck{
+			{"ec2:DescribeInstanceTypes", func() error {
+				_, err := svc.DescribeInstanceTypesWithContext(ctx, &ec2.DescribeInstanceTypesInput{DryRun: dryRun})
+				return err
+			}},
+			{"ec2:DescribeInstanceTypeOfferings", func() error {
+				_, err := svc.DescribeInstanceTypeOfferingsWithContext(ctx, &ec2.DescribeInstanceTypeOfferingsInput{DryRun: dryRun})
+				return err
+			}},
+			{"ec2:DescribeSubnets", func() error {
+				_, err := svc.DescribeSubnetsWithContext(ctx, &ec2.DescribeSubnetsInput{DryRun: dryRun})
+				return err
+			}},
+			{"ec2:DescribeSecurityGroups", func() error {
+				_, err := svc.DescribeSecurityGroupsWithContext(ctx, &ec2.DescribeSecurityGroupsInput{DryRun: dryRun})
+				return err
+			}},
+			{"ec2:DescribeKeyPairs", func() error {
+				_, err := svc.DescribeKeyPairsWithContext(ctx, &ec2.DescribeKeyPairsInput{DryRun: dryRun})
+				return err
+			}},
+		}...)
+	}
+
+	response := &PermissionCheckResponse{}
+	for _, check := range checks {
+		permission, err := dryRunPermission(check.action, check.call())
+		if err != nil {
+			return nil, awsErrorToStatus(ctx, err)
+		}
+		response.Permissions = append(response.Permissions, permission)
+	}
+	return response, nil
+}
+
+// permissionCheckLaunch returns the launch request of a machine of the class. The image is resolved if the
+// credentials are allowed to describe it, otherwise a default root device name is used.
+func (d *Driver) permissionCheckLaunch(ctx context.Context, svc ec2iface.EC2API, req *PermissionCheckRequest, providerSpec *api.AWSProviderSpec) (*ec2.RunInstancesInput, error) {
+	machine := &v1alpha1.Machine{ObjectMeta: metav1.ObjectMeta{Name: permissionCheckMachineName, Namespace: req.MachineClass.Namespace}}
+
+	rootDeviceName := aws.String(defaultRootDeviceName)
+	output, err := svc.DescribeImagesWithContext(ctx, &ec2.DescribeImagesInput{ImageIds: aws.StringSlice([]string{providerSpec.AMI})})
+	if err == nil && len(output.Images) > 0 && output.Images[0].RootDeviceName != nil {
+		rootDeviceName = output.Images[0].RootDeviceName
+	}
+
+	input, err := NewRunInstancesInput(providerSpec, LaunchParameters{
+		MachineName:    permissionCheckMachineName,
+		RootDeviceName: rootDeviceName,
+		UserData:       req.Secret.Data["userData"],
+		OwnerTags:      d.ownerTags(req.MachineClass, machine),
+		ClientToken:    generateClientToken(machine, 0),
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return input, nil
+}
+
+// dryRunPermission returns the permission of an action from the error of its DryRun call. Errors that don't
+// tell whether the action is allowed, such as invalid credentials or throttling, are returned.
+func dryRunPermission(action string, err error) (Permission, error) {
+	if err == nil {
+		return Permission{Action: action, Result: PermissionAllowed}, nil
+	}
+	awsErr, ok := err.(awserr.Error)
+	if !ok {
+		return Permission{}, err
+	}
+	if awsErr.Code() == errCodeDryRunOperation {
+		return Permission{Action: action, Result: PermissionAllowed}, nil
+	}
+	switch awsErrorToCode(err) {
+	case codes.PermissionDenied:
+		return Permission{Action: action, Result: PermissionDenied, Message: awsErr.Message()}, nil
+	case codes.Unauthenticated, codes.Unavailable, codes.DeadlineExceeded, codes.Canceled:
+		return Permission{}, err
+	}
+	return Permission{Action: action, Result: PermissionUnverified, Message: fmt.Sprintf("%s: %s", awsErr.Code(), awsErr.Message())}, nil
+}
@@ -0,0 +1,171 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	api "github.com/gardener/machine-controller-manager-provider-aws/pkg/aws/apis"
+	"github.com/gardener/machine-controller-manager-provider-aws/pkg/fakeec2"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/machinecodes/codes"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/machinecodes/status"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var _ = Describe("Permissions", func() {
+
+	var (
+		ctx     = context.Background()
+		fake    *fakeec2.EC2
+		options *DriverOptions
+		spec    *api.AWSProviderSpec
+		secret  = &corev1.Secret{
+			Data: map[string][]byte{
+				"providerAccessKeyId":     []byte("dummy-id"),
+				"providerSecretAccessKey": []byte("dummy-secret"),
+				"userData":                []byte("dummy-user-data"),
+			},
+		}
+	)
+
+	checkPermissions := func() (*PermissionCheckResponse, error) {
+		providerSpec, err := json.Marshal(spec)
+		Expect(err).ToNot(HaveOccurred())
+		return NewDriver(fakeec2.NewSessionProvider(fake), options).CheckPermissions(ctx, &PermissionCheckRequest{
+			MachineClass: newNamedMachineClass("test-mc", providerSpec),
+			Secret:       secret,
+		})
+	}
+	results := func(response *PermissionCheckResponse) map[string]PermissionResult {
+		results := map[string]PermissionResult{}
+		for _, permission := range response.Permissions {
+			results[permission.Action] = permission.Result
+		}
+		return results
+	}
+	unauthorized := func() error {
+		return fakeec2.NewRequestFailure("UnauthorizedOperation", "You are not authorized to perform this operation.", http.StatusForbidden)
+	}
+
+	BeforeEach(func() {
+		fake = fakeec2.New()
+		fake.AddImage(&ec2.Image{ImageId: aws.String("ami-123456789")})
+
+		options = NewDriverOptions()
+		options.InstanceCacheTTL = 0
+		spec = &api.AWSProviderSpec{
+			AMI: "ami-123456789",
+			BlockDevices: []api.AWSBlockDeviceMappingSpec{
+				{Ebs: api.AWSEbsBlockDeviceSpec{VolumeSize: 50, VolumeType: "gp2"}},
+			},
+			IAM:         api.AWSIAMProfileSpec{Name: "test-iam"},
+			KeyName:     "test-ssh-publickey",
+			MachineType: "m4.large",
+			NetworkInterfaces: []api.AWSNetworkInterfaceSpec{
+				{SecurityGroupIDs: []string{"sg-00002132323"}, SubnetID: "subnet-123456"},
+			},
+			Region: "eu-west-1",
+			Tags: map[string]string{
+				"kubernetes.io/cluster/shoot--test": "1",
+				"kubernetes.io/role/test":           "1",
+			},
+		}
+	})
+
+	Describe("#CheckPermissions", func() {
+		It("should allow all actions the driver relies on without changing anything", func() {
+			response, err := checkPermissions()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(results(response)).To(Equal(map[string]PermissionResult{
+				"ec2:DescribeImages":            PermissionAllowed,
+				"ec2:RunInstances":              PermissionAllowed,
+				"ec2:DescribeInstances":         PermissionAllowed,
+				"ec2:TerminateInstances":        PermissionAllowed,
+				"ec2:DescribeVolumes":           PermissionAllowed,
+				"ec2:DeleteVolume":              PermissionAllowed,
+				"ec2:DescribeNetworkInterfaces": PermissionAllowed,
+				"ec2:DeleteNetworkInterface":    PermissionAllowed,
+			}))
+			Expect(response.Denied()).To(BeEmpty())
+			Expect(fake.Instances()).To(BeEmpty())
+		})
+
+		It("should check the actions of the enabled optional features", func() {
+			options.CheckInstanceEvents = true
+			options.CaptureConsoleOutput = true
+			options.ValidateMachineClasses = true
+
+			response, err := checkPermissions()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(results(response)).To(HaveKeyWithValue("ec2:DescribeInstanceStatus", PermissionAllowed))
+			Expect(results(response)).To(HaveKeyWithValue("ec2:GetConsoleOutput", PermissionAllowed))
+			for _, action := range []string{"ec2:DescribeInstanceTypes", "ec2:DescribeInstanceTypeOfferings", "ec2:DescribeSubnets", "ec2:DescribeSecurityGroups", "ec2:DescribeKeyPairs"} {
+				Expect(results(response)).To(HaveKeyWithValue(action, PermissionAllowed))
+			}
+		})
+
+		It("should report denied actions with their messages", func() {
+			fake.InjectFault(fakeec2.Fault{Operation: "RunInstances", Err: unauthorized(), Match: func(input interface{}) bool {
+				return len(input.(*ec2.RunInstancesInput).TagSpecifications) > 0
+			}})
+			fake.InjectFault(fakeec2.Fault{Operation: "DeleteVolume", Err: unauthorized()})
+
+			response, err := checkPermissions()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(response.Denied()).To(ConsistOf("ec2:RunInstances", "ec2:DeleteVolume"))
+			for _, permission := range response.Permissions {
+				if permission.Result == PermissionDenied {
+					Expect(permission.Message).To(Equal("You are not authorized to perform this operation."))
+				}
+			}
+		})
+
+		It("should check the launch request if the image can't be described", func() {
+			fake.InjectFault(fakeec2.Fault{Operation: "DescribeImages", Err: unauthorized()})
+
+			response, err := checkPermissions()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(results(response)).To(HaveKeyWithValue("ec2:DescribeImages", PermissionDenied))
+			Expect(results(response)).To(HaveKeyWithValue("ec2:RunInstances", PermissionAllowed))
+		})
+
+		It("should report actions rejected for other reasons as unverified", func() {
+			fake.InjectFault(fakeec2.Fault{Operation: "TerminateInstances", Err: fakeec2.NewError(fakeec2.ErrCodeInvalidParameterValue, "Invalid value")})
+
+			response, err := checkPermissions()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(response.Permissions).To(ContainElement(Permission{
+				Action:  "ec2:TerminateInstances",
+				Result:  PermissionUnverified,
+				Message: "InvalidParameterValue: Invalid value",
+			}))
+			Expect(response.Denied()).To(BeEmpty())
+		})
+
+		It("should fail if the credentials are invalid", func() {
+			fake.InjectFault(fakeec2.Fault{Operation: "DescribeInstances", Err: fakeec2.NewRequestFailure("AuthFailure", "AWS was not able to validate the provided access credentials", http.StatusUnauthorized)})
+
+			_, err := checkPermissions()
+			statusErr, ok := status.FromError(err)
+			Expect(ok).To(BeTrue(), "%v", err)
+			Expect(statusErr.Code()).To(Equal(codes.Unauthenticated))
+		})
+	})
+})
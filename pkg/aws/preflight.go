@@ -0,0 +1,270 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	api "github.com/gardener/machine-controller-manager-provider-aws/pkg/aws/apis"
+	v1alpha1 "github.com/gardener/machine-controller-manager/pkg/apis/machine/v1alpha1"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/machinecodes/codes"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/klog"
+)
+
+// Error codes of the describe calls for resources that don't exist
+const (
+	errCodeInvalidAMIIDNotFound    = "InvalidAMIID.NotFound"
+	errCodeInvalidAMIIDMalformed   = "InvalidAMIID.Malformed"
+	errCodeInvalidInstanceType     = "InvalidInstanceType"
+	errCodeInvalidSubnetIDNotFound = "InvalidSubnetID.NotFound"
+	errCodeInvalidGroupNotFound    = "InvalidGroup.NotFound"
+	errCodeInvalidKeyPairNotFound  = "InvalidKeyPair.NotFound"
+)
+
+// ValidateMachineClass checks the provider spec of the machine class against the resources in AWS: the image, the
+// instance type and its architecture and availability zones, the subnets and security groups, the key pair and the
+// IAM instance profile. It only makes describe calls. The problems are returned with the field paths of the
+// provider spec, the error is set if the spec is invalid or the resources couldn't be looked up.
+func (d *Driver) ValidateMachineClass(ctx context.Context, machineClass *v1alpha1.MachineClass, secret *corev1.Secret) (field.ErrorList, error) {
+	providerSpec, err := d.decodeProviderSpecAndSecret(machineClass, secret, true)
+	if err != nil {
+		return nil, err
+	}
+
+	svc, err := d.createSVC(secret, providerSpec.Region)
+	if err != nil {
+		return nil, awsErrorToStatus(ctx, err)
+	}
+	iamSvc, err := d.createIAM(secret, providerSpec.Region)
+	if err != nil {
+		return nil, awsErrorToStatus(ctx, err)
+	}
+
+	v := &classValidator{svc: svc, iam: iamSvc, providerSpec: providerSpec}
+	if err := v.validate(ctx, field.NewPath("providerSpec")); err != nil {
+		return nil, awsErrorToStatus(ctx, err)
+	}
+	return v.errs, nil
+}
+
+// validateOnFirstUse validates the machine class against the resources in AWS the first time it's used for a
+// machine creation. Only successful validations are remembered, so that fixed resources are picked up.
+func (d *Driver) validateOnFirstUse(ctx context.Context, machineClass *v1alpha1.MachineClass, secret *corev1.Secret) error {
+	if !d.options.ValidateMachineClasses {
+		return nil
+	}
+
+	key := machineClassVersionKey(machineClass)
+	if _, ok := d.validatedClasses.Load(key); ok {
+		return nil
+	}
+
+	errs, err := d.ValidateMachineClass(ctx, machineClass, secret)
+	if err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return fieldErrorsToStatus(codes.InvalidArgument, fmt.Sprintf("MachineClass %s doesn't match the resources in AWS", machineClass.Name), errs)
+	}
+	d.validatedClasses.Store(key, struct{}{})
+	return nil
+}
+
+// machineClassVersionKey identifies the version of a machine class
+func machineClassVersionKey(machineClass *v1alpha1.MachineClass) string {
+	if machineClass.UID != "" {
+		return fmt.Sprintf("%s/%s", machineClass.UID, machineClass.ResourceVersion)
+	}
+	return fmt.Sprintf("%s/%s/%s", machineClass.Namespace, machineClass.Name, machineClass.ResourceVersion)
+}
+
+// classValidator collects the problems of a provider spec found by describing the referenced resources
+type classValidator struct {
+	svc          ec2iface.EC2API
+	iam          iamiface.IAMAPI
+	providerSpec *api.AWSProviderSpec
+	errs         field.ErrorList
+}
+
+// validate runs all checks, the returned error is the first failed AWS call that doesn't indicate a missing resource.
+// Checks whose describe calls are denied are skipped, as the driver doesn't need these permissions otherwise.
+func (v *classValidator) validate(ctx context.Context, path *field.Path) error {
+	image, err := v.image(ctx, path.Child("ami"))
+	if err != nil {
+		return err
+	}
+	instanceType, err := v.instanceType(ctx, path.Child("machineType"), image)
+	if err != nil {
+		return err
+	}
+	if err := v.networkInterfaces(ctx, path.Child("networkInterfaces"), instanceType); err != nil {
+		return err
+	}
+	if err := v.keyPair(ctx, path.Child("keyName")); err != nil {
+		return err
+	}
+	return v.instanceProfile(ctx, path.Child("iam"))
+}
+
+// image checks that the image exists and returns it, or nil if it doesn't
+func (v *classValidator) image(ctx context.Context, path *field.Path) (*ec2.Image, error) {
+	output, err := v.svc.DescribeImagesWithContext(ctx, &ec2.DescribeImagesInput{
+		ImageIds: aws.StringSlice([]string{v.providerSpec.AMI}),
+	})
+	if isAWSErrorCode(err, errCodeInvalidAMIIDNotFound, errCodeInvalidAMIIDMalformed) || (err == nil && len(output.Images) == 0) {
+		v.errs = append(v.errs, field.NotFound(path, v.providerSpec.AMI))
+		return nil, nil
+	} else if isDenied(err, "image "+v.providerSpec.AMI) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return output.Images[0], nil
+}
+
+// instanceType checks that the instance type exists in the region and supports the architecture of the image.
+// It returns the name of the instance type, or an empty string if it doesn't exist.
+func (v *classValidator) instanceType(ctx context.Context, path *field.Path, image *ec2.Image) (string, error) {
+	output, err := v.svc.DescribeInstanceTypesWithContext(ctx, &ec2.DescribeInstanceTypesInput{
+		InstanceTypes: aws.StringSlice([]string{v.providerSpec.MachineType}),
+	})
+	if isAWSErrorCode(err, errCodeInvalidInstanceType) || (err == nil && len(output.InstanceTypes) == 0) {
+		v.errs = append(v.errs, field.Invalid(path, v.providerSpec.MachineType, fmt.Sprintf("instance type doesn't exist in region %s", v.providerSpec.Region)))
+		return "", nil
+	} else if isDenied(err, "instance type "+v.providerSpec.MachineType) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	if image == nil || output.InstanceTypes[0].ProcessorInfo == nil {
+		return v.providerSpec.MachineType, nil
+	}
+	architecture := aws.StringValue(image.Architecture)
+	for _, supported := range output.InstanceTypes[0].ProcessorInfo.SupportedArchitectures {
+		if aws.StringValue(supported) == architecture {
+			return v.providerSpec.MachineType, nil
+		}
+	}
+	v.errs = append(v.errs, field.Invalid(path, v.providerSpec.MachineType, fmt.Sprintf("instance type doesn't support the architecture %s of image %s", architecture, v.providerSpec.AMI)))
+	return v.providerSpec.MachineType, nil
+}
+
+// networkInterfaces checks that the subnets exist, that the security groups are in the VPCs of the subnets, and that
+// the instance type is offered in the availability zones of the subnets
+func (v *classValidator) networkInterfaces(ctx context.Context, path *field.Path, instanceType string) error {
+	for i, networkInterface := range v.providerSpec.NetworkInterfaces {
+		subnetPath := path.Index(i).Child("subnetID")
+		subnets, err := v.svc.DescribeSubnetsWithContext(ctx, &ec2.DescribeSubnetsInput{
+			SubnetIds: aws.StringSlice([]string{networkInterface.SubnetID}),
+		})
+		if isAWSErrorCode(err, errCodeInvalidSubnetIDNotFound) || (err == nil && len(subnets.Subnets) == 0) {
+			v.errs = append(v.errs, field.NotFound(subnetPath, networkInterface.SubnetID))
+			continue
+		} else if isDenied(err, "subnet "+networkInterface.SubnetID) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		subnet := subnets.Subnets[0]
+
+		for j, groupID := range networkInterface.SecurityGroupIDs {
+			groupPath := path.Index(i).Child("securityGroupIDs").Index(j)
+			groups, err := v.svc.DescribeSecurityGroupsWithContext(ctx, &ec2.DescribeSecurityGroupsInput{
+				GroupIds: aws.StringSlice([]string{groupID}),
+			})
+			if isAWSErrorCode(err, errCodeInvalidGroupNotFound) || (err == nil && len(groups.SecurityGroups) == 0) {
+				v.errs = append(v.errs, field.NotFound(groupPath, groupID))
+				continue
+			} else if isDenied(err, "security group "+groupID) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			if vpcID := aws.StringValue(groups.SecurityGroups[0].VpcId); vpcID != aws.StringValue(subnet.VpcId) {
+				v.errs = append(v.errs, field.Invalid(groupPath, groupID, fmt.Sprintf("security group is in VPC %s, but subnet %s is in VPC %s", vpcID, networkInterface.SubnetID, aws.StringValue(subnet.VpcId))))
+			}
+		}
+
+		if instanceType == "" {
+			continue
+		}
+		zone := aws.StringValue(subnet.AvailabilityZone)
+		offerings, err := v.svc.DescribeInstanceTypeOfferingsWithContext(ctx, &ec2.DescribeInstanceTypeOfferingsInput{
+			LocationType: aws.String(ec2.LocationTypeAvailabilityZone),
+			Filters: []*ec2.Filter{
+				{Name: aws.String("instance-type"), Values: aws.StringSlice([]string{instanceType})},
+				{Name: aws.String("location"), Values: aws.StringSlice([]string{zone})},
+			},
+		})
+		if isDenied(err, "offerings of instance type "+instanceType) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if len(offerings.InstanceTypeOfferings) == 0 {
+			v.errs = append(v.errs, field.Invalid(subnetPath, networkInterface.SubnetID, fmt.Sprintf("instance type %s isn't offered in availability zone %s of the subnet", instanceType, zone)))
+		}
+	}
+	return nil
+}
+
+// keyPair checks that the key pair exists
+func (v *classValidator) keyPair(ctx context.Context, path *field.Path) error {
+	output, err := v.svc.DescribeKeyPairsWithContext(ctx, &ec2.DescribeKeyPairsInput{
+		KeyNames: aws.StringSlice([]string{v.providerSpec.KeyName}),
+	})
+	if isAWSErrorCode(err, errCodeInvalidKeyPairNotFound) || (err == nil && len(output.KeyPairs) == 0) {
+		v.errs = append(v.errs, field.NotFound(path, v.providerSpec.KeyName))
+		return nil
+	} else if isDenied(err, "key pair "+v.providerSpec.KeyName) {
+		return nil
+	}
+	return err
+}
+
+// instanceProfile checks that the IAM instance profile exists. The check is skipped if the SPI can't create IAM
+// clients.
+func (v *classValidator) instanceProfile(ctx context.Context, path *field.Path) error {
+	name := v.providerSpec.IAM.Name
+	if v.iam == nil {
+		klog.V(4).Infof("Skipping the check of IAM instance profile %q, the SPI doesn't create IAM clients", name)
+		return nil
+	}
+	_, err := v.iam.GetInstanceProfileWithContext(ctx, &iam.GetInstanceProfileInput{InstanceProfileName: aws.String(name)})
+	if isAWSErrorCode(err, iam.ErrCodeNoSuchEntityException) {
+		v.errs = append(v.errs, field.NotFound(path.Child("name"), name))
+		return nil
+	} else if isDenied(err, "IAM instance profile "+name) {
+		return nil
+	}
+	return err
+}
+
+// isDenied returns true if the error denies the describe call of a check, the skipped check is logged
+func isDenied(err error, resource string) bool {
+	if err == nil || awsErrorToCode(err) != codes.PermissionDenied {
+		return false
+	}
+	klog.Warningf("Skipping the check of %s: %v", resource, err)
+	return true
+}
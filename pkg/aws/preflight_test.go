@@ -0,0 +1,231 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	api "github.com/gardener/machine-controller-manager-provider-aws/pkg/aws/apis"
+	"github.com/gardener/machine-controller-manager-provider-aws/pkg/fakeec2"
+	"github.com/gardener/machine-controller-manager-provider-aws/pkg/spi"
+	v1alpha1 "github.com/gardener/machine-controller-manager/pkg/apis/machine/v1alpha1"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/driver"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/machinecodes/codes"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/machinecodes/status"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+var _ = Describe("Preflight", func() {
+
+	var (
+		ctx      = context.Background()
+		fake     *fakeec2.EC2
+		provider *fakeec2.SessionProvider
+		options  *DriverOptions
+		spec     *api.AWSProviderSpec
+		secret   = &corev1.Secret{
+			Data: map[string][]byte{
+				"providerAccessKeyId":     []byte("dummy-id"),
+				"providerSecretAccessKey": []byte("dummy-secret"),
+				"userData":                []byte("dummy-user-data"),
+			},
+		}
+	)
+
+	machineClass := func() *v1alpha1.MachineClass {
+		providerSpec, err := json.Marshal(spec)
+		Expect(err).ToNot(HaveOccurred())
+		machineClass := newNamedMachineClass("test-mc", providerSpec)
+		machineClass.UID = "2c5a4d6e-9f6c-4d1e-8e8b-1c6f4e4b1f10"
+		machineClass.ResourceVersion = "1"
+		return machineClass
+	}
+	validate := func() (field.ErrorList, error) {
+		return NewDriver(provider, options).ValidateMachineClass(ctx, machineClass(), secret)
+	}
+
+	errorCode := func(err error) codes.Code {
+		statusErr, ok := status.FromError(err)
+		Expect(ok).To(BeTrue(), "%v", err)
+		return statusErr.Code()
+	}
+
+	BeforeEach(func() {
+		fake = fakeec2.New()
+		fake.AddImage(&ec2.Image{ImageId: aws.String("ami-123456789")})
+		fake.AddInstanceType(&ec2.InstanceTypeInfo{InstanceType: aws.String("m4.large")}, "eu-west-1a")
+		fake.AddSubnet(&ec2.Subnet{SubnetId: aws.String("subnet-123456"), VpcId: aws.String("vpc-1"), AvailabilityZone: aws.String("eu-west-1a")})
+		fake.AddSecurityGroup(&ec2.SecurityGroup{GroupId: aws.String("sg-00002132323"), VpcId: aws.String("vpc-1")})
+		fake.AddKeyPair("test-ssh-publickey")
+		provider = fakeec2.NewSessionProvider(fake)
+		provider.IAM().AddInstanceProfile("test-iam")
+
+		options = NewDriverOptions()
+		options.InstanceCacheTTL = 0
+		spec = &api.AWSProviderSpec{
+			AMI: "ami-123456789",
+			BlockDevices: []api.AWSBlockDeviceMappingSpec{
+				{Ebs: api.AWSEbsBlockDeviceSpec{VolumeSize: 50, VolumeType: "gp2"}},
+			},
+			IAM:         api.AWSIAMProfileSpec{Name: "test-iam"},
+			KeyName:     "test-ssh-publickey",
+			MachineType: "m4.large",
+			NetworkInterfaces: []api.AWSNetworkInterfaceSpec{
+				{SecurityGroupIDs: []string{"sg-00002132323"}, SubnetID: "subnet-123456"},
+			},
+			Region: "eu-west-1",
+			Tags: map[string]string{
+				"kubernetes.io/cluster/shoot--test": "1",
+				"kubernetes.io/role/test":           "1",
+			},
+		}
+	})
+
+	Describe("#ValidateMachineClass", func() {
+		It("should accept a machine class matching the resources", func() {
+			errs, err := validate()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(errs).To(BeEmpty())
+			Expect(fake.Calls("RunInstances")).To(BeZero())
+		})
+
+		DescribeTable("should report the fields of mismatching resources",
+			func(prepare func(), field, detail string) {
+				prepare()
+
+				errs, err := validate()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(errs).To(HaveLen(1))
+				Expect(errs[0].Field).To(Equal(field))
+				Expect(errs[0].Detail).To(Equal(detail))
+			},
+			Entry("unknown image", func() { spec.AMI = "ami-987654321" },
+				"providerSpec.ami", ""),
+			Entry("image of another architecture", func() {
+				fake.AddImage(&ec2.Image{ImageId: aws.String("ami-987654321"), Architecture: aws.String(ec2.ArchitectureValuesArm64)})
+				spec.AMI = "ami-987654321"
+			}, "providerSpec.machineType", "instance type doesn't support the architecture arm64 of image ami-987654321"),
+			Entry("unknown instance type", func() { spec.MachineType = "m4.huge" },
+				"providerSpec.machineType", "instance type doesn't exist in region eu-west-1"),
+			Entry("unknown subnet", func() { spec.NetworkInterfaces[0].SubnetID = "subnet-654321" },
+				"providerSpec.networkInterfaces[0].subnetID", ""),
+			Entry("instance type not offered in the zone of the subnet", func() {
+				fake.AddSubnet(&ec2.Subnet{SubnetId: aws.String("subnet-654321"), VpcId: aws.String("vpc-1"), AvailabilityZone: aws.String("eu-west-1b")})
+				spec.NetworkInterfaces[0].SubnetID = "subnet-654321"
+			}, "providerSpec.networkInterfaces[0].subnetID", "instance type m4.large isn't offered in availability zone eu-west-1b of the subnet"),
+			Entry("unknown security group", func() { spec.NetworkInterfaces[0].SecurityGroupIDs = []string{"sg-unknown"} },
+				"providerSpec.networkInterfaces[0].securityGroupIDs[0]", ""),
+			Entry("security group in another VPC than the subnet", func() {
+				fake.AddSecurityGroup(&ec2.SecurityGroup{GroupId: aws.String("sg-other"), VpcId: aws.String("vpc-2")})
+				spec.NetworkInterfaces[0].SecurityGroupIDs = []string{"sg-00002132323", "sg-other"}
+			}, "providerSpec.networkInterfaces[0].securityGroupIDs[1]", "security group is in VPC vpc-2, but subnet subnet-123456 is in VPC vpc-1"),
+			Entry("unknown key pair", func() { spec.KeyName = "other-key" },
+				"providerSpec.keyName", ""),
+			Entry("unknown instance profile", func() { spec.IAM.Name = "other-iam" },
+				"providerSpec.iam.name", ""),
+		)
+
+		It("should report all problems at once", func() {
+			spec.AMI = "ami-987654321"
+			spec.KeyName = "other-key"
+			spec.IAM.Name = "other-iam"
+
+			errs, err := validate()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(errs.ToAggregate().Error()).To(And(
+				ContainSubstring(`providerSpec.ami: Not found: "ami-987654321"`),
+				ContainSubstring(`providerSpec.keyName: Not found: "other-key"`),
+				ContainSubstring(`providerSpec.iam.name: Not found: "other-iam"`),
+			))
+		})
+
+		It("should skip the instance profile check if the SPI doesn't create IAM clients", func() {
+			spec.IAM.Name = "other-iam"
+
+			errs, err := NewDriver(struct{ spi.SessionProviderInterface }{provider}, options).ValidateMachineClass(ctx, machineClass(), secret)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(errs).To(BeEmpty())
+		})
+
+		DescribeTable("should skip the checks whose describe calls are denied",
+			func(operation string, prepare func()) {
+				prepare()
+				fake.InjectFault(fakeec2.Fault{Operation: operation, Err: fakeec2.NewRequestFailure("UnauthorizedOperation", "You are not authorized to perform this operation.", http.StatusForbidden)})
+
+				errs, err := validate()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(errs).To(BeEmpty())
+			},
+			Entry("image", "DescribeImages", func() { spec.AMI = "ami-987654321" }),
+			Entry("instance type", "DescribeInstanceTypes", func() { spec.MachineType = "m4.huge" }),
+			Entry("subnet", "DescribeSubnets", func() { spec.NetworkInterfaces[0].SubnetID = "subnet-654321" }),
+			Entry("security group", "DescribeSecurityGroups", func() { spec.NetworkInterfaces[0].SecurityGroupIDs = []string{"sg-unknown"} }),
+			Entry("instance type offering", "DescribeInstanceTypeOfferings", func() {
+				fake.AddSubnet(&ec2.Subnet{SubnetId: aws.String("subnet-654321"), VpcId: aws.String("vpc-1"), AvailabilityZone: aws.String("eu-west-1b")})
+				spec.NetworkInterfaces[0].SubnetID = "subnet-654321"
+			}),
+			Entry("key pair", "DescribeKeyPairs", func() { spec.KeyName = "other-key" }),
+		)
+
+		It("should fail if the resources can't be described", func() {
+			fake.InjectFault(fakeec2.Fault{Operation: "DescribeSubnets", Err: fakeec2.NewRequestFailure("RequestLimitExceeded", "Request limit exceeded.", http.StatusServiceUnavailable)})
+
+			_, err := validate()
+			Expect(err).To(HaveOccurred())
+			Expect(errorCode(err)).To(Equal(codes.Unavailable))
+		})
+	})
+
+	Describe("validation on first use", func() {
+		It("should launch instances if the describe calls of the validation are denied", func() {
+			options.ValidateMachineClasses = true
+			for _, operation := range []string{"DescribeInstanceTypes", "DescribeInstanceTypeOfferings", "DescribeSubnets", "DescribeSecurityGroups", "DescribeKeyPairs"} {
+				fake.InjectFault(fakeec2.Fault{Operation: operation, Err: fakeec2.NewRequestFailure("UnauthorizedOperation", "You are not authorized to perform this operation.", http.StatusForbidden)})
+			}
+
+			_, err := NewDriver(provider, options).CreateMachine(ctx, &driver.CreateMachineRequest{Machine: newMachine(0), MachineClass: machineClass(), Secret: secret})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fake.Calls("RunInstances")).To(Equal(1))
+		})
+
+		It("should validate each version of a machine class once before launching instances", func() {
+			options.ValidateMachineClasses = true
+			d := NewDriver(provider, options)
+			spec.KeyName = "other-key"
+			create := func(index int) error {
+				_, err := d.CreateMachine(ctx, &driver.CreateMachineRequest{Machine: newMachine(index), MachineClass: machineClass(), Secret: secret})
+				return err
+			}
+
+			err := create(0)
+			Expect(errorCode(err)).To(Equal(codes.InvalidArgument))
+			Expect(err.Error()).To(ContainSubstring(`providerSpec.keyName: Not found: "other-key"`))
+			Expect(fake.Calls("RunInstances")).To(BeZero())
+
+			fake.AddKeyPair("other-key")
+			Expect(create(0)).To(Succeed())
+			Expect(create(1)).To(Succeed())
+			Expect(fake.Calls("DescribeKeyPairs")).To(Equal(2))
+			Expect(fake.Calls("RunInstances")).To(Equal(2))
+		})
+	})
+})
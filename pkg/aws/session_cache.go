@@ -0,0 +1,185 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	api "github.com/gardener/machine-controller-manager-provider-aws/pkg/aws/apis"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// sessionCacheEntry is an AWS session and its EC2 client cached for a secret and region
+type sessionCacheEntry struct {
+	session   *session.Session
+	svc       ec2iface.EC2API
+	owner     string
+	expiresAt time.Time
+}
+
+// sessionCache caches EC2 clients keyed by a hash of the credentials and endpoints in the secret and the region.
+// It is safe for concurrent use.
+type sessionCache struct {
+	mutex sync.Mutex
+	ttl   time.Duration
+	now   func() time.Time
+
+	// entries maps the cache key to the cached client
+	entries map[string]*sessionCacheEntry
+	// owners maps a secret reference and region to the cache key currently in use for it,
+	// this allows to drop entries as soon as the content of a secret changes
+	owners map[string]string
+}
+
+// newSessionCache returns a sessionCache whose entries expire after the given ttl
+func newSessionCache(ttl time.Duration) *sessionCache {
+	return &sessionCache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]*sessionCacheEntry),
+		owners:  make(map[string]string),
+	}
+}
+
+// get returns the cached client for the given secret and region, if present and not expired
+func (c *sessionCache) get(secret *corev1.Secret, region string) (ec2iface.EC2API, bool) {
+	entry, ok := c.lookup(secret, region)
+	if !ok {
+		return nil, false
+	}
+	return entry.svc, true
+}
+
+// getSession returns the cached session for the given secret and region, if present and not expired
+func (c *sessionCache) getSession(secret *corev1.Secret, region string) (*session.Session, bool) {
+	entry, ok := c.lookup(secret, region)
+	if !ok {
+		return nil, false
+	}
+	return entry.session, true
+}
+
+// lookup returns the entry for the given secret and region, expired entries are dropped
+func (c *sessionCache) lookup(secret *corev1.Secret, region string) (*sessionCacheEntry, bool) {
+	if c == nil || c.ttl <= 0 {
+		return nil, false
+	}
+
+	key := sessionCacheKey(secret, region)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !c.now().Before(entry.expiresAt) {
+		c.remove(key)
+		return nil, false
+	}
+	return entry, true
+}
+
+// put stores the session and client for the given secret and region. A client cached for an older
+// content of the same secret is dropped.
+func (c *sessionCache) put(secret *corev1.Secret, region string, session *session.Session, svc ec2iface.EC2API) {
+	if c == nil || c.ttl <= 0 {
+		return
+	}
+
+	var (
+		key   = sessionCacheKey(secret, region)
+		owner = secretReference(secret) + "/" + region
+		now   = c.now()
+	)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if oldKey, ok := c.owners[owner]; ok && oldKey != key {
+		c.remove(oldKey)
+	}
+
+	for k, entry := range c.entries {
+		if !now.Before(entry.expiresAt) {
+			c.remove(k)
+		}
+	}
+
+	c.entries[key] = &sessionCacheEntry{
+		session:   session,
+		svc:       svc,
+		owner:     owner,
+		expiresAt: now.Add(c.ttl),
+	}
+	c.owners[owner] = key
+}
+
+// remove deletes the entry with the given key. The caller must hold the mutex.
+func (c *sessionCache) remove(key string) {
+	entry, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	delete(c.entries, key)
+	if c.owners[entry.owner] == key {
+		delete(c.owners, entry.owner)
+	}
+}
+
+// sessionSecretKeys are the keys of the secret data the sessions are created from. Other keys, in particular the
+// user data which contains a different bootstrap token for every machine, must not affect the cache key.
+var sessionSecretKeys = []string{
+	api.AWSAccessKeyID,
+	api.AWSSecretAccessKey,
+	api.AWSAlternativeAccessKeyID,
+	api.AWSAlternativeSecretAccessKey,
+	api.AWSEC2Endpoint,
+	api.AWSSTSEndpoint,
+	api.AWSIAMEndpoint,
+	api.AWSUseFIPSEndpoint,
+	api.AWSUseDualStackEndpoint,
+	api.AWSCABundle,
+	api.AWSProxyURL,
+}
+
+// sessionCacheKey returns a hash over the credentials and endpoints in the secret and the region
+func sessionCacheKey(secret *corev1.Secret, region string) string {
+	hash := sha256.New()
+
+	for _, key := range sessionSecretKeys {
+		value, ok := secret.Data[key]
+		if !ok {
+			continue
+		}
+		hash.Write([]byte(key))
+		hash.Write([]byte{0})
+		hash.Write(value)
+		hash.Write([]byte{0})
+	}
+	hash.Write([]byte(region))
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// secretReference returns the namespace and name of the secret
+func secretReference(secret *corev1.Secret) string {
+	return secret.Namespace + "/" + secret.Name
+}
@@ -0,0 +1,174 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	awssession "github.com/aws/aws-sdk-go/aws/session"
+	"github.com/gardener/machine-controller-manager-provider-aws/pkg/fakeec2"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// countingPluginSPI counts the number of sessions created by the wrapped SessionProvider
+type countingPluginSPI struct {
+	*fakeec2.SessionProvider
+	sessions int32
+}
+
+func (c *countingPluginSPI) NewSession(secret *corev1.Secret, region string) (*awssession.Session, error) {
+	atomic.AddInt32(&c.sessions, 1)
+	return c.SessionProvider.NewSession(secret, region)
+}
+
+var _ = Describe("SessionCache", func() {
+
+	var (
+		secret *corev1.Secret
+		spi    *countingPluginSPI
+	)
+
+	BeforeEach(func() {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "cloudprovider",
+				Namespace: testNamespace,
+			},
+			Data: map[string][]byte{
+				"providerAccessKeyId":     []byte("dummy-id"),
+				"providerSecretAccessKey": []byte("dummy-secret"),
+			},
+		}
+		spi = &countingPluginSPI{SessionProvider: fakeec2.NewSessionProvider(fakeec2.New(), fakeec2.New(fakeec2.WithRegion("us-east-1")))}
+	})
+
+	It("should reuse the client for the same secret and region", func() {
+		d := NewDriver(spi, NewDriverOptions())
+
+		svc1, err := d.createSVC(secret, "eu-west-1")
+		Expect(err).ToNot(HaveOccurred())
+		svc2, err := d.createSVC(secret.DeepCopy(), "eu-west-1")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(svc2).To(BeIdenticalTo(svc1))
+		Expect(spi.sessions).To(Equal(int32(1)))
+	})
+
+	It("should reuse the client for secrets that only differ in their user data", func() {
+		d := NewDriver(spi, NewDriverOptions())
+
+		withUserData := func(userData string) *corev1.Secret {
+			secret := secret.DeepCopy()
+			secret.Data["userData"] = []byte(userData)
+			return secret
+		}
+		svc1, err := d.createSVC(withUserData("bootstrap-token-1"), "eu-west-1")
+		Expect(err).ToNot(HaveOccurred())
+		svc2, err := d.createSVC(withUserData("bootstrap-token-2"), "eu-west-1")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(svc2).To(BeIdenticalTo(svc1))
+		Expect(spi.sessions).To(Equal(int32(1)))
+	})
+
+	It("should reuse the session of the client for IAM clients", func() {
+		d := NewDriver(spi, NewDriverOptions())
+
+		_, err := d.createSVC(secret, "eu-west-1")
+		Expect(err).ToNot(HaveOccurred())
+		iam, err := d.createIAM(secret, "eu-west-1")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(iam).To(BeIdenticalTo(spi.IAM()))
+		Expect(spi.sessions).To(Equal(int32(1)))
+	})
+
+	It("should create separate clients per region", func() {
+		d := NewDriver(spi, NewDriverOptions())
+
+		svc1, err := d.createSVC(secret, "eu-west-1")
+		Expect(err).ToNot(HaveOccurred())
+		svc2, err := d.createSVC(secret, "us-east-1")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(svc2).ToNot(BeIdenticalTo(svc1))
+		Expect(spi.sessions).To(Equal(int32(2)))
+	})
+
+	It("should drop the client once the content of the secret changes", func() {
+		d := NewDriver(spi, NewDriverOptions())
+
+		_, err := d.createSVC(secret, "eu-west-1")
+		Expect(err).ToNot(HaveOccurred())
+
+		rotated := secret.DeepCopy()
+		rotated.Data["providerSecretAccessKey"] = []byte("rotated-secret")
+		_, err = d.createSVC(rotated, "eu-west-1")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(spi.sessions).To(Equal(int32(2)))
+		Expect(d.sessions.entries).To(HaveLen(1))
+		_, ok := d.sessions.get(secret, "eu-west-1")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should expire clients after the TTL", func() {
+		d := NewDriver(spi, &DriverOptions{SessionCacheTTL: time.Minute})
+		now := time.Now()
+		d.sessions.now = func() time.Time { return now }
+
+		_, err := d.createSVC(secret, "eu-west-1")
+		Expect(err).ToNot(HaveOccurred())
+
+		now = now.Add(2 * time.Minute)
+		_, err = d.createSVC(secret, "eu-west-1")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(spi.sessions).To(Equal(int32(2)))
+	})
+
+	It("should not cache when the TTL is zero", func() {
+		d := NewDriver(spi, &DriverOptions{})
+
+		for i := 0; i < 3; i++ {
+			_, err := d.createSVC(secret, "eu-west-1")
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		Expect(spi.sessions).To(Equal(int32(3)))
+	})
+
+	It("should be safe for concurrent use", func() {
+		d := NewDriver(spi, NewDriverOptions())
+
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				_, err := d.createSVC(secret, "eu-west-1")
+				Expect(err).ToNot(HaveOccurred())
+			}()
+		}
+		wg.Wait()
+
+		Expect(d.sessions.entries).To(HaveLen(1))
+	})
+})
@@ -20,6 +20,8 @@ import (
 	"strings"
 
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/gardener/machine-controller-manager-provider-aws/pkg/spi"
 	corev1 "k8s.io/api/core/v1"
 )
 
@@ -41,16 +43,44 @@ func decodeRegionAndProviderID(id string) (string, string, error) {
 	return splitProviderID[len(splitProviderID)-2], splitProviderID[len(splitProviderID)-1], nil
 }
 
-// Helper function to create SVC
+// Helper function to create SVC, clients are reused from the session cache where possible
 func (d *Driver) createSVC(secret *corev1.Secret, region string) (ec2iface.EC2API, error) {
+	if secret != nil {
+		if svc, ok := d.sessions.get(secret, region); ok {
+			return svc, nil
+		}
+	}
+
 	session, err := d.SPI.NewSession(secret, region)
 	if err != nil {
 		return nil, err
 	}
 	svc := d.SPI.NewEC2API(session)
+
+	if secret != nil {
+		d.sessions.put(secret, region, session, svc)
+	}
 	return svc, nil
 }
 
+// createIAM returns an IAM client, the session is reused from the session cache where possible. Nil is returned if
+// the SPI doesn't implement spi.IAMProviderInterface.
+func (d *Driver) createIAM(secret *corev1.Secret, region string) (iamiface.IAMAPI, error) {
+	iamProvider, ok := d.SPI.(spi.IAMProviderInterface)
+	if !ok {
+		return nil, nil
+	}
+
+	session, ok := d.sessions.getSession(secret, region)
+	if !ok {
+		var err error
+		if session, err = d.SPI.NewSession(secret, region); err != nil {
+			return nil, err
+		}
+	}
+	return iamProvider.NewIAMAPI(session), nil
+}
+
 // kubernetesVolumeIDToEBSVolumeID translates Kubernetes volume ID to EBS volume ID
 // KubernetsVolumeID forms:
 //  * aws://<zone>/<awsVolumeId>
@@ -0,0 +1,45 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Utils", func() {
+
+	Describe("#encodeProviderID and #decodeRegionAndProviderID", func() {
+		DescribeTable("##table",
+			func(region, instanceID, providerID string) {
+				Expect(encodeProviderID(region, instanceID)).To(Equal(providerID))
+
+				decodedRegion, decodedInstanceID, err := decodeRegionAndProviderID(providerID)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(decodedRegion).To(Equal(region))
+				Expect(decodedInstanceID).To(Equal(instanceID))
+			},
+			Entry("commercial partition", "eu-west-1", "i-0123456789abcdef0", "aws:///eu-west-1/i-0123456789abcdef0"),
+			Entry("China partition", "cn-north-1", "i-0123456789abcdef0", "aws:///cn-north-1/i-0123456789abcdef0"),
+			Entry("GovCloud partition", "us-gov-west-1", "i-0123456789abcdef0", "aws:///us-gov-west-1/i-0123456789abcdef0"),
+			Entry("ISO partition", "us-iso-east-1", "i-0123456789abcdef0", "aws:///us-iso-east-1/i-0123456789abcdef0"),
+		)
+
+		It("should fail to decode a provider ID without region", func() {
+			_, _, err := decodeRegionAndProviderID("i-0123456789abcdef0")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,297 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package conformance provides a ginkgo test suite verifying that a driver behaves the way the machine
+// controller manager expects: VMs are reported, listed and deleted consistently over their lifecycle,
+// deletions are idempotent, missing VMs are reported as NotFound and failures of the backend are never
+// mistaken for missing VMs.
+package conformance
+
+import (
+	"context"
+	"fmt"
+
+	v1alpha1 "github.com/gardener/machine-controller-manager/pkg/apis/machine/v1alpha1"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/driver"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/machinecodes/codes"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/machinecodes/status"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Operation is a driver call whose backend calls can be failed by the Backend
+type Operation string
+
+const (
+	// OperationCreateMachine is the CreateMachine call
+	OperationCreateMachine Operation = "CreateMachine"
+	// OperationDeleteMachine is the DeleteMachine call
+	OperationDeleteMachine Operation = "DeleteMachine"
+	// OperationGetMachineStatus is the GetMachineStatus call
+	OperationGetMachineStatus Operation = "GetMachineStatus"
+	// OperationListMachines is the ListMachines call
+	OperationListMachines Operation = "ListMachines"
+)
+
+// retryableCodes are the codes of errors after which the machine controller manager retries a call
+var retryableCodes = []codes.Code{
+	codes.Unknown,
+	codes.DeadlineExceeded,
+	codes.ResourceExhausted,
+	codes.Aborted,
+	codes.Internal,
+	codes.Unavailable,
+}
+
+// Backend gives the suite access to the cloud provider behind the driver, usually an in-memory fake
+type Backend interface {
+	// VMExists returns true if the VM with the given provider ID exists and isn't terminated
+	VMExists(providerID string) (bool, error)
+	// DeleteVM deletes the VM with the given provider ID behind the back of the driver
+	DeleteVM(providerID string) error
+	// FailOperation makes the backend calls of a driver operation fail with a transient error
+	// until the returned function is called
+	FailOperation(operation Operation) (restore func())
+}
+
+// Config is the configuration of the suite for a single spec
+type Config struct {
+	// Driver is the driver under test
+	Driver driver.Driver
+	// Backend is the cloud provider behind the driver
+	Backend Backend
+	// MachineClass is the machine class of the created machines
+	MachineClass *v1alpha1.MachineClass
+	// Secret is the secret of the machine class
+	Secret *corev1.Secret
+	// Namespace is the namespace of the created machines, it defaults to "default"
+	Namespace string
+}
+
+// DescribeDriver adds the conformance specs of a driver to the ginkgo suite. The setup function is called
+// before each spec and must return a driver with a fresh backend.
+func DescribeDriver(name string, setup func() *Config) bool {
+	return Describe(fmt.Sprintf("[Conformance] %s", name), func() {
+
+		var (
+			ctx    context.Context
+			config *Config
+		)
+
+		newMachine := func(name string) *v1alpha1.Machine {
+			namespace := config.Namespace
+			if namespace == "" {
+				namespace = metav1.NamespaceDefault
+			}
+			return &v1alpha1.Machine{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "machine.sapcloud.io", Kind: "Machine"},
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			}
+		}
+		create := func(machine *v1alpha1.Machine) (*driver.CreateMachineResponse, error) {
+			response, err := config.Driver.CreateMachine(ctx, &driver.CreateMachineRequest{
+				Machine:      machine,
+				MachineClass: config.MachineClass,
+				Secret:       config.Secret,
+			})
+			if err == nil {
+				machine.Spec.ProviderID = response.ProviderID
+				machine.Status.Node = response.NodeName
+			}
+			return response, err
+		}
+		mustCreate := func(name string) *v1alpha1.Machine {
+			machine := newMachine(name)
+			_, err := create(machine)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(machine.Spec.ProviderID).ToNot(BeEmpty())
+			return machine
+		}
+		getStatus := func(machine *v1alpha1.Machine) (*driver.GetMachineStatusResponse, error) {
+			return config.Driver.GetMachineStatus(ctx, &driver.GetMachineStatusRequest{
+				Machine:      machine,
+				MachineClass: config.MachineClass,
+				Secret:       config.Secret,
+			})
+		}
+		list := func() (map[string]string, error) {
+			response, err := config.Driver.ListMachines(ctx, &driver.ListMachinesRequest{
+				MachineClass: config.MachineClass,
+				Secret:       config.Secret,
+			})
+			if err != nil {
+				return nil, err
+			}
+			return response.MachineList, nil
+		}
+		mustList := func() map[string]string {
+			machines, err := list()
+			Expect(err).ToNot(HaveOccurred())
+			return machines
+		}
+		deleteMachine := func(machine *v1alpha1.Machine) error {
+			_, err := config.Driver.DeleteMachine(ctx, &driver.DeleteMachineRequest{
+				Machine:      machine,
+				MachineClass: config.MachineClass,
+				Secret:       config.Secret,
+			})
+			return err
+		}
+		vmExists := func(machine *v1alpha1.Machine) bool {
+			exists, err := config.Backend.VMExists(machine.Spec.ProviderID)
+			Expect(err).ToNot(HaveOccurred())
+			return exists
+		}
+		expectCode := func(err error, expected ...codes.Code) {
+			ExpectWithOffset(1, err).To(HaveOccurred())
+			statusErr, ok := status.FromError(err)
+			ExpectWithOffset(1, ok).To(BeTrue(), "error %q is no machine codes status", err)
+			ExpectWithOffset(1, expected).To(ContainElement(statusErr.Code()), "unexpected code of error %q", err)
+		}
+
+		BeforeEach(func() {
+			ctx = context.Background()
+			config = setup()
+		})
+
+		Describe("lifecycle", func() {
+			It("should create, report, list and delete a machine", func() {
+				machine := newMachine("conformance-0")
+				response, err := create(machine)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(response.ProviderID).ToNot(BeEmpty())
+				Expect(response.NodeName).ToNot(BeEmpty())
+				Expect(vmExists(machine)).To(BeTrue())
+
+				statusResponse, err := getStatus(machine)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(statusResponse.ProviderID).To(Equal(response.ProviderID))
+				Expect(statusResponse.NodeName).To(Equal(response.NodeName))
+
+				Expect(mustList()).To(Equal(map[string]string{response.ProviderID: machine.Name}))
+
+				Expect(deleteMachine(machine)).To(Succeed())
+				Expect(vmExists(machine)).To(BeFalse())
+
+				_, err = getStatus(machine)
+				expectCode(err, codes.NotFound)
+				Expect(mustList()).To(BeEmpty())
+			})
+
+			It("should report the status of a machine whose provider ID isn't known yet", func() {
+				machine := mustCreate("conformance-0")
+				providerID := machine.Spec.ProviderID
+				machine.Spec.ProviderID = ""
+
+				statusResponse, err := getStatus(machine)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(statusResponse.ProviderID).To(Equal(providerID))
+			})
+
+			It("should not create a second VM when the creation is retried", func() {
+				first := mustCreate("conformance-0")
+				second := mustCreate("conformance-0")
+
+				Expect(second.Spec.ProviderID).To(Equal(first.Spec.ProviderID))
+				Expect(mustList()).To(HaveLen(1))
+			})
+
+			It("should list every machine", func() {
+				expected := map[string]string{}
+				for i := 0; i < 3; i++ {
+					machine := mustCreate(fmt.Sprintf("conformance-%d", i))
+					expected[machine.Spec.ProviderID] = machine.Name
+				}
+				Expect(mustList()).To(Equal(expected))
+			})
+		})
+
+		Describe("missing VMs", func() {
+			It("should report a machine that was never created as not found", func() {
+				_, err := getStatus(newMachine("conformance-0"))
+				expectCode(err, codes.NotFound)
+			})
+
+			It("should report a machine whose VM was deleted behind its back as not found", func() {
+				machine := mustCreate("conformance-0")
+				Expect(config.Backend.DeleteVM(machine.Spec.ProviderID)).To(Succeed())
+
+				_, err := getStatus(machine)
+				expectCode(err, codes.NotFound)
+				Expect(mustList()).To(BeEmpty())
+				Expect(deleteMachine(machine)).To(Succeed())
+			})
+
+			It("should delete machines idempotently", func() {
+				machine := mustCreate("conformance-0")
+				Expect(deleteMachine(machine)).To(Succeed())
+				Expect(deleteMachine(machine)).To(Succeed())
+			})
+
+			It("should delete a machine that was never created", func() {
+				Expect(deleteMachine(newMachine("conformance-0"))).To(Succeed())
+			})
+		})
+
+		Describe("backend failures", func() {
+			It("should fail the creation with a retryable error without leaking a VM", func() {
+				restore := config.Backend.FailOperation(OperationCreateMachine)
+				_, err := create(newMachine("conformance-0"))
+				expectCode(err, retryableCodes...)
+				restore()
+
+				Expect(mustList()).To(BeEmpty())
+				mustCreate("conformance-0")
+				Expect(mustList()).To(HaveLen(1))
+			})
+
+			It("should not report a machine as not found if its status can't be retrieved", func() {
+				machine := mustCreate("conformance-0")
+
+				restore := config.Backend.FailOperation(OperationGetMachineStatus)
+				_, err := getStatus(machine)
+				expectCode(err, retryableCodes...)
+				restore()
+
+				_, err = getStatus(machine)
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("should fail the listing instead of returning an incomplete list", func() {
+				mustCreate("conformance-0")
+
+				restore := config.Backend.FailOperation(OperationListMachines)
+				machines, err := list()
+				expectCode(err, retryableCodes...)
+				Expect(machines).To(BeNil())
+				restore()
+
+				Expect(mustList()).To(HaveLen(1))
+			})
+
+			It("should fail the deletion with a retryable error and keep the VM", func() {
+				machine := mustCreate("conformance-0")
+
+				restore := config.Backend.FailOperation(OperationDeleteMachine)
+				expectCode(deleteMachine(machine), retryableCodes...)
+				restore()
+				Expect(vmExists(machine)).To(BeTrue())
+
+				Expect(deleteMachine(machine)).To(Succeed())
+				Expect(vmExists(machine)).To(BeFalse())
+			})
+		})
+	})
+}
@@ -0,0 +1,95 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fakeec2
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Instance returns a copy of the instance with the given ID
+func (e *EC2) Instance(instanceID string) (*ec2.Instance, bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.advance()
+
+	i, ok := e.instances[instanceID]
+	if !ok {
+		return nil, false
+	}
+	return copyOf(i.Instance).(*ec2.Instance), true
+}
+
+// Instances returns copies of all instances, including the retained terminated ones
+func (e *EC2) Instances() []*ec2.Instance {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.advance()
+
+	var ids []string
+	for id := range e.instances {
+		ids = append(ids, id)
+	}
+	var instances []*ec2.Instance
+	for _, id := range sortedKeys(ids) {
+		instances = append(instances, copyOf(e.instances[id].Instance).(*ec2.Instance))
+	}
+	return instances
+}
+
+// InterruptSpotInstance shuts down a spot instance the way EC2 does when it reclaims the capacity
+func (e *EC2) InterruptSpotInstance(instanceID string) error {
+	return e.updateInstance(instanceID, func(i *instance) error {
+		if aws.StringValue(i.InstanceLifecycle) != ec2.InstanceLifecycleTypeSpot {
+			return fmt.Errorf("instance %s is not a spot instance", instanceID)
+		}
+		switch aws.StringValue(i.State.Name) {
+		case ec2.InstanceStateNameShuttingDown, ec2.InstanceStateNameTerminated:
+			return fmt.Errorf("instance %s is already %s", instanceID, aws.StringValue(i.State.Name))
+		}
+		e.shutDown(i, stateReasonSpotInstanceTermination, "Spot instance termination")
+		return nil
+	})
+}
+
+// SetInstanceStatus sets the status checks and scheduled events reported by DescribeInstanceStatus for an instance.
+// Unset status summaries keep their defaults.
+func (e *EC2) SetInstanceStatus(instanceID string, status *ec2.InstanceStatus) error {
+	return e.updateInstance(instanceID, func(i *instance) error {
+		i.status = copyOf(status).(*ec2.InstanceStatus)
+		return nil
+	})
+}
+
+// SetConsoleOutput sets the plain console output returned by GetConsoleOutput for an instance
+func (e *EC2) SetConsoleOutput(instanceID, output string) error {
+	return e.updateInstance(instanceID, func(i *instance) error {
+		i.consoleOutput = output
+		return nil
+	})
+}
+
+func (e *EC2) updateInstance(instanceID string, update func(*instance) error) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.advance()
+
+	i, ok := e.instances[instanceID]
+	if !ok {
+		return fmt.Errorf("instance %s doesn't exist", instanceID)
+	}
+	return update(i)
+}
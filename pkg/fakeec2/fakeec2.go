@@ -0,0 +1,357 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fakeec2 provides a stateful in-memory EC2 for tests. It implements the calls of ec2iface.EC2API used by
+// the driver, tracks instances, volumes, network interfaces, images and their tags, moves instances through their
+// lifecycle and allows to inject faults into the calls through its API. Subnets, security groups, key pairs and
+// instance types can be added for the validation of machine classes, together with the instance profiles of an
+// in-memory IAM. Calls with DryRun set fail with DryRunOperation unless a fault is injected, denying permissions is
+// emulated by injecting UnauthorizedOperation faults.
+package fakeec2
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+const (
+	// DefaultRegion is the region of an EC2 created without WithRegion
+	DefaultRegion = "eu-west-1"
+	// DefaultOwnerID is the account ID owning the resources of the fake
+	DefaultOwnerID = "123456789012"
+
+	// defaultWaiterDelay is the delay between the attempts of waiters without WaiterDelay option
+	defaultWaiterDelay = 10 * time.Millisecond
+	// defaultWaiterMaxAttempts is the number of attempts of waiters without WaiterMaxAttempts option
+	defaultWaiterMaxAttempts = 40
+)
+
+// Error codes returned by the fake, they match the ones returned by EC2
+const (
+	ErrCodeInvalidInstanceIDNotFound         = "InvalidInstanceID.NotFound"
+	ErrCodeInvalidInstanceIDMalformed        = "InvalidInstanceID.Malformed"
+	ErrCodeInvalidAMIIDNotFound              = "InvalidAMIID.NotFound"
+	ErrCodeInvalidAMIIDMalformed             = "InvalidAMIID.Malformed"
+	ErrCodeInvalidVolumeNotFound             = "InvalidVolume.NotFound"
+	ErrCodeInvalidNetworkInterfaceIDNotFound = "InvalidNetworkInterfaceID.NotFound"
+	ErrCodeInvalidNetworkInterfaceInUse      = "InvalidNetworkInterface.InUse"
+	ErrCodeVolumeInUse                       = "VolumeInUse"
+	ErrCodeIncorrectInstanceState            = "IncorrectInstanceState"
+	ErrCodeIdempotentParameterMismatch       = "IdempotentParameterMismatch"
+	ErrCodeInvalidParameterValue             = "InvalidParameterValue"
+	ErrCodeInvalidParameterCombination       = "InvalidParameterCombination"
+	ErrCodeInvalidPaginationToken            = "InvalidPaginationToken"
+	ErrCodeMissingParameter                  = "MissingParameter"
+	ErrCodeInvalidSubnetIDNotFound           = "InvalidSubnetID.NotFound"
+	ErrCodeInvalidGroupNotFound              = "InvalidGroup.NotFound"
+	ErrCodeInvalidKeyPairNotFound            = "InvalidKeyPair.NotFound"
+	ErrCodeInvalidInstanceType               = "InvalidInstanceType"
+	ErrCodeDryRunOperation                   = "DryRunOperation"
+)
+
+// Lifecycle defines how long instances stay in the transitional states. A state with a zero duration is left at
+// the next call, so that the transitional state is still visible in the response of the call that entered it.
+type Lifecycle struct {
+	// Pending is the duration after which launched instances are running
+	Pending time.Duration
+	// Stopping is the duration after which stopping instances are stopped
+	Stopping time.Duration
+	// ShuttingDown is the duration after which shutting-down instances are terminated
+	ShuttingDown time.Duration
+	// TerminatedRetention is the duration for which terminated instances remain visible. Zero retains them forever.
+	TerminatedRetention time.Duration
+}
+
+// Fault describes a failure injected into the calls of an EC2 operation
+type Fault struct {
+	// Operation is the name of the affected EC2 operation, e.g. "RunInstances"
+	Operation string
+	// Err is returned by the affected calls instead of performing the operation. If nil, the calls succeed after Delay,
+	// or fail with DryRunOperation if their DryRun flag is set.
+	Err error
+	// Delay delays the affected calls, a call whose context ends during the delay fails
+	Delay time.Duration
+	// Match restricts the fault to the calls whose input it returns true for. If nil, all calls are affected.
+	Match func(input interface{}) bool
+	// Times is the number of calls affected by the fault. Zero affects all calls.
+	Times int
+}
+
+// Option configures an EC2 created by New
+type Option func(*EC2)
+
+// WithRegion sets the region of the EC2, it determines the availability zone and DNS names of the instances
+func WithRegion(region string) Option {
+	return func(e *EC2) {
+		e.region = region
+	}
+}
+
+// WithClock sets the function returning the current time, it drives the lifecycle of the instances
+func WithClock(now func() time.Time) Option {
+	return func(e *EC2) {
+		e.now = now
+	}
+}
+
+// WithLifecycle sets the durations of the transitional instance states
+func WithLifecycle(lifecycle Lifecycle) Option {
+	return func(e *EC2) {
+		e.lifecycle = lifecycle
+	}
+}
+
+// WithWaiterDelay sets the delay between the attempts of waiters called without WaiterDelay option
+func WithWaiterDelay(delay time.Duration) Option {
+	return func(e *EC2) {
+		e.waiterDelay = delay
+	}
+}
+
+// EC2 is a stateful in-memory implementation of ec2iface.EC2API. Calls of operations that aren't implemented panic.
+type EC2 struct {
+	ec2iface.EC2API
+
+	mutex       sync.Mutex
+	region      string
+	now         func() time.Time
+	lifecycle   Lifecycle
+	waiterDelay time.Duration
+
+	sequence          int
+	addresses         int
+	instances         map[string]*instance
+	volumes           map[string]*ec2.Volume
+	networkInterfaces map[string]*ec2.NetworkInterface
+	images            map[string]*ec2.Image
+	subnets           map[string]*ec2.Subnet
+	securityGroups    map[string]*ec2.SecurityGroup
+	keyPairs          map[string]*ec2.KeyPairInfo
+	instanceTypes     map[string]*instanceType
+	// clientTokens maps the client tokens of RunInstances calls to their launches
+	clientTokens map[string]*launch
+
+	faults []*Fault
+	calls  map[string]int
+}
+
+// instance is an instance tracked by the fake together with the state not exposed by ec2.Instance
+type instance struct {
+	*ec2.Instance
+	reservationID string
+	// transitionAt is the time at which the current transitional state is left, or at which a terminated
+	// instance is removed
+	transitionAt  time.Time
+	status        *ec2.InstanceStatus
+	consoleOutput string
+}
+
+// launch records a RunInstances call to serve its retries with the same client token
+type launch struct {
+	input         *ec2.RunInstancesInput
+	reservationID string
+	instanceIDs   []string
+}
+
+// New returns an empty EC2 configured by the given options
+func New(opts ...Option) *EC2 {
+	e := &EC2{
+		region:            DefaultRegion,
+		now:               time.Now,
+		waiterDelay:       defaultWaiterDelay,
+		instances:         map[string]*instance{},
+		volumes:           map[string]*ec2.Volume{},
+		networkInterfaces: map[string]*ec2.NetworkInterface{},
+		images:            map[string]*ec2.Image{},
+		subnets:           map[string]*ec2.Subnet{},
+		securityGroups:    map[string]*ec2.SecurityGroup{},
+		keyPairs:          map[string]*ec2.KeyPairInfo{},
+		instanceTypes:     map[string]*instanceType{},
+		clientTokens:      map[string]*launch{},
+		calls:             map[string]int{},
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// Region returns the region of the EC2
+func (e *EC2) Region() string {
+	return e.region
+}
+
+// NewError returns an EC2 API error with the given code and message
+func NewError(code, message string) error {
+	return awserr.New(code, message, nil)
+}
+
+// NewRequestFailure returns an EC2 API error with the given code and message that failed with the given HTTP status code
+func NewRequestFailure(code, message string, statusCode int) error {
+	return awserr.NewRequestFailure(awserr.New(code, message, nil), statusCode, "")
+}
+
+// InjectFault injects a fault into the calls of an operation. Faults are applied in the order of their injection,
+// at most one per call. The returned function removes the fault.
+func (e *EC2) InjectFault(fault Fault) func() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	f := &fault
+	e.faults = append(e.faults, f)
+	return func() {
+		e.mutex.Lock()
+		defer e.mutex.Unlock()
+		e.removeFault(f)
+	}
+}
+
+// ClearFaults removes all injected faults
+func (e *EC2) ClearFaults() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.faults = nil
+}
+
+// Calls returns the number of calls of an operation, including the failed ones. Each page of a paginated call
+// and each attempt of a waiter is counted.
+func (e *EC2) Calls(operation string) int {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.calls[operation]
+}
+
+func (e *EC2) removeFault(fault *Fault) {
+	for i, f := range e.faults {
+		if f == fault {
+			e.faults = append(e.faults[:i], e.faults[i+1:]...)
+			return
+		}
+	}
+}
+
+// begin counts a call of an operation and applies the first matching fault. It must be called without holding
+// the mutex, the returned error fails the call.
+func (e *EC2) begin(ctx aws.Context, operation string, input interface{}) error {
+	if ctx == nil {
+		ctx = aws.BackgroundContext()
+	}
+
+	e.mutex.Lock()
+	e.calls[operation]++
+	var fault *Fault
+	for _, f := range e.faults {
+		if f.Operation == operation && (f.Match == nil || f.Match(input)) {
+			fault = f
+			break
+		}
+	}
+	if fault != nil && fault.Times > 0 {
+		fault.Times--
+		if fault.Times == 0 {
+			e.removeFault(fault)
+		}
+	}
+	e.mutex.Unlock()
+
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+	if fault == nil {
+		return dryRunError(input)
+	}
+	if fault.Delay > 0 {
+		timer := time.NewTimer(fault.Delay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return contextError(ctx)
+		case <-timer.C:
+		}
+	}
+	if fault.Err == nil {
+		return dryRunError(input)
+	}
+	return fault.Err
+}
+
+// dryRunError returns the error returned by EC2 for requests that would have succeeded if their DryRun flag
+// is set. EC2 checks only the permissions of such requests, so the fake doesn't validate them either.
+func dryRunError(input interface{}) error {
+	value := reflect.ValueOf(input)
+	if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	field := value.Elem().FieldByName("DryRun")
+	if !field.IsValid() {
+		return nil
+	}
+	if dryRun, ok := field.Interface().(*bool); !ok || !aws.BoolValue(dryRun) {
+		return nil
+	}
+	return NewRequestFailure(ErrCodeDryRunOperation, "Request would have succeeded, but DryRun flag is set.", http.StatusPreconditionFailed)
+}
+
+// contextError returns the error returned by the SDK for calls with an ended context
+func contextError(ctx aws.Context) error {
+	if ctx.Err() != nil {
+		return awserr.New(request.CanceledErrorCode, "request context canceled", ctx.Err())
+	}
+	return nil
+}
+
+// nextID returns a new resource ID with the given prefix, IDs of the same prefix sort in the order of their creation
+func (e *EC2) nextID(prefix string) string {
+	e.sequence++
+	return fmt.Sprintf("%s-%017x", prefix, e.sequence)
+}
+
+// copyOf returns a deep copy of an EC2 API structure, so that callers can't modify the state of the fake
+func copyOf(src interface{}) interface{} {
+	return awsutil.CopyOf(src)
+}
+
+// sortedKeys sorts resource IDs in ascending order, the order in which the resources are described
+func sortedKeys(ids []string) []string {
+	sort.Strings(ids)
+	return ids
+}
+
+// checkIDs returns an error with the given code listing the IDs that don't exist
+func checkIDs(ids []*string, exists func(string) bool, code, kind string) error {
+	var missing []string
+	for _, id := range ids {
+		if !exists(aws.StringValue(id)) {
+			missing = append(missing, aws.StringValue(id))
+		}
+	}
+	switch len(missing) {
+	case 0:
+		return nil
+	case 1:
+		return NewError(code, fmt.Sprintf("The %s '%s' does not exist", kind, missing[0]))
+	}
+	return NewError(code, fmt.Sprintf("The %ss '%s' do not exist", kind, strings.Join(missing, ", ")))
+}
@@ -0,0 +1,579 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fakeec2
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var _ = Describe("EC2", func() {
+
+	var (
+		ctx     = context.Background()
+		now     time.Time
+		fake    *EC2
+		imageID string
+	)
+
+	errorCode := func(err error) string {
+		if awsErr, ok := err.(awserr.Error); ok {
+			return awsErr.Code()
+		}
+		return ""
+	}
+	tagSpecifications := func(machineName string) []*ec2.TagSpecification {
+		var specs []*ec2.TagSpecification
+		for _, resourceType := range []string{ec2.ResourceTypeInstance, ec2.ResourceTypeVolume, ec2.ResourceTypeNetworkInterface} {
+			specs = append(specs, &ec2.TagSpecification{
+				ResourceType: aws.String(resourceType),
+				Tags: []*ec2.Tag{
+					{Key: aws.String("Name"), Value: aws.String(machineName)},
+					{Key: aws.String("kubernetes.io/cluster/shoot--test"), Value: aws.String("1")},
+				},
+			})
+		}
+		return specs
+	}
+	runInput := func(machineName string) *ec2.RunInstancesInput {
+		return &ec2.RunInstancesInput{
+			ImageId:      aws.String(imageID),
+			InstanceType: aws.String("m5.large"),
+			MinCount:     aws.Int64(1),
+			MaxCount:     aws.Int64(1),
+			NetworkInterfaces: []*ec2.InstanceNetworkInterfaceSpecification{{
+				DeviceIndex: aws.Int64(0),
+				SubnetId:    aws.String("subnet-123456"),
+				Groups:      aws.StringSlice([]string{"sg-123456"}),
+			}},
+			BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+				{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsBlockDevice{VolumeSize: aws.Int64(50)}},
+				{DeviceName: aws.String("/dev/xvdb"), Ebs: &ec2.EbsBlockDevice{VolumeSize: aws.Int64(100), DeleteOnTermination: aws.Bool(false)}},
+			},
+			TagSpecifications: tagSpecifications(machineName),
+		}
+	}
+	run := func(machineName string) *ec2.Instance {
+		reservation, err := fake.RunInstancesWithContext(ctx, runInput(machineName))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(reservation.Instances).To(HaveLen(1))
+		return reservation.Instances[0]
+	}
+	state := func(instanceID string) string {
+		instance, ok := fake.Instance(instanceID)
+		if !ok {
+			return ""
+		}
+		return *instance.State.Name
+	}
+	describe := func(input *ec2.DescribeInstancesInput) ([]string, error) {
+		var ids []string
+		err := fake.DescribeInstancesPagesWithContext(ctx, input, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+			for _, reservation := range page.Reservations {
+				for _, instance := range reservation.Instances {
+					ids = append(ids, *instance.InstanceId)
+				}
+			}
+			return true
+		})
+		return ids, err
+	}
+
+	BeforeEach(func() {
+		now = time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+		fake = New(WithClock(func() time.Time { return now }), WithLifecycle(Lifecycle{
+			Pending:             time.Minute,
+			Stopping:            time.Minute,
+			ShuttingDown:        time.Minute,
+			TerminatedRetention: time.Hour,
+		}))
+		imageID = fake.AddImage(&ec2.Image{Name: aws.String("test-image")})
+	})
+
+	Describe("#RunInstances", func() {
+		It("should launch pending instances with their volumes and network interfaces", func() {
+			instance := run("machine-0")
+
+			Expect(*instance.State.Name).To(Equal(ec2.InstanceStateNamePending))
+			Expect(*instance.InstanceType).To(Equal("m5.large"))
+			Expect(*instance.SubnetId).To(Equal("subnet-123456"))
+			Expect(*instance.PrivateDnsName).To(Equal("ip-10-0-0-4.eu-west-1.compute.internal"))
+			Expect(*instance.LaunchTime).To(Equal(now))
+			Expect(instance.Tags).To(ContainElement(&ec2.Tag{Key: aws.String("Name"), Value: aws.String("machine-0")}))
+			Expect(instance.BlockDeviceMappings).To(HaveLen(2))
+			Expect(instance.NetworkInterfaces).To(HaveLen(1))
+
+			volumes := fake.Volumes()
+			Expect(volumes).To(HaveLen(2))
+			Expect(*volumes[0].Size).To(Equal(int64(50)))
+			Expect(*volumes[0].State).To(Equal(ec2.VolumeStateInUse))
+			Expect(*volumes[0].Attachments[0].InstanceId).To(Equal(*instance.InstanceId))
+			Expect(volumes[0].Tags).To(ContainElement(&ec2.Tag{Key: aws.String("Name"), Value: aws.String("machine-0")}))
+
+			networkInterfaces := fake.NetworkInterfaces()
+			Expect(networkInterfaces).To(HaveLen(1))
+			Expect(*networkInterfaces[0].Status).To(Equal(ec2.NetworkInterfaceStatusInUse))
+			Expect(*networkInterfaces[0].Groups[0].GroupId).To(Equal("sg-123456"))
+			Expect(networkInterfaces[0].TagSet).To(ContainElement(&ec2.Tag{Key: aws.String("Name"), Value: aws.String("machine-0")}))
+		})
+
+		It("should return the instances of the first call for retries with the same client token", func() {
+			input := runInput("machine-0")
+			input.ClientToken = aws.String("token")
+			first, err := fake.RunInstancesWithContext(ctx, input)
+			Expect(err).ToNot(HaveOccurred())
+
+			now = now.Add(2 * time.Minute)
+			retry, err := fake.RunInstancesWithContext(ctx, runInput("machine-0").SetClientToken("token"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(*retry.Instances[0].InstanceId).To(Equal(*first.Instances[0].InstanceId))
+			Expect(*retry.Instances[0].State.Name).To(Equal(ec2.InstanceStateNameRunning))
+			Expect(fake.Instances()).To(HaveLen(1))
+
+			_, err = fake.RunInstancesWithContext(ctx, runInput("machine-1").SetClientToken("token"))
+			Expect(errorCode(err)).To(Equal(ErrCodeIdempotentParameterMismatch))
+		})
+
+		DescribeTable("should reject invalid requests",
+			func(modify func(*ec2.RunInstancesInput), code string) {
+				input := runInput("machine-0")
+				modify(input)
+				_, err := fake.RunInstancesWithContext(ctx, input)
+				Expect(errorCode(err)).To(Equal(code))
+				Expect(fake.Instances()).To(BeEmpty())
+				Expect(fake.Volumes()).To(BeEmpty())
+			},
+			Entry("unknown image", func(input *ec2.RunInstancesInput) { input.ImageId = aws.String("ami-unknown") }, ErrCodeInvalidAMIIDNotFound),
+			Entry("malformed image", func(input *ec2.RunInstancesInput) { input.ImageId = aws.String("image") }, ErrCodeInvalidAMIIDMalformed),
+			Entry("missing count", func(input *ec2.RunInstancesInput) { input.MaxCount = nil }, ErrCodeMissingParameter),
+			Entry("invalid tag resource type", func(input *ec2.RunInstancesInput) {
+				input.TagSpecifications[0].ResourceType = aws.String("vpc")
+			}, ErrCodeInvalidParameterValue),
+			Entry("subnet with network interfaces", func(input *ec2.RunInstancesInput) { input.SubnetId = aws.String("subnet-1") }, ErrCodeInvalidParameterCombination),
+			Entry("unknown network interface", func(input *ec2.RunInstancesInput) {
+				input.NetworkInterfaces[0] = &ec2.InstanceNetworkInterfaceSpecification{DeviceIndex: aws.Int64(0), NetworkInterfaceId: aws.String("eni-unknown")}
+			}, ErrCodeInvalidNetworkInterfaceIDNotFound),
+		)
+	})
+
+	Describe("lifecycle", func() {
+		It("should move instances through their states and release their resources", func() {
+			instanceID := *run("machine-0").InstanceId
+			Expect(state(instanceID)).To(Equal(ec2.InstanceStateNamePending))
+
+			now = now.Add(time.Minute)
+			Expect(state(instanceID)).To(Equal(ec2.InstanceStateNameRunning))
+
+			output, err := fake.TerminateInstancesWithContext(ctx, &ec2.TerminateInstancesInput{InstanceIds: aws.StringSlice([]string{instanceID})})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(*output.TerminatingInstances[0].PreviousState.Name).To(Equal(ec2.InstanceStateNameRunning))
+			Expect(*output.TerminatingInstances[0].CurrentState.Name).To(Equal(ec2.InstanceStateNameShuttingDown))
+			Expect(fake.Volumes()).To(HaveLen(2))
+
+			now = now.Add(time.Minute)
+			instance, _ := fake.Instance(instanceID)
+			Expect(*instance.State.Name).To(Equal(ec2.InstanceStateNameTerminated))
+			Expect(*instance.StateReason.Code).To(Equal(stateReasonUserInitiated))
+			Expect(instance.BlockDeviceMappings).To(BeEmpty())
+
+			volumes := fake.Volumes()
+			Expect(volumes).To(HaveLen(1))
+			Expect(*volumes[0].Size).To(Equal(int64(100)))
+			Expect(*volumes[0].State).To(Equal(ec2.VolumeStateAvailable))
+			Expect(volumes[0].Attachments).To(BeEmpty())
+			Expect(fake.NetworkInterfaces()).To(BeEmpty())
+
+			now = now.Add(time.Hour)
+			Expect(state(instanceID)).To(BeEmpty())
+			_, err = fake.DescribeInstancesWithContext(ctx, &ec2.DescribeInstancesInput{InstanceIds: aws.StringSlice([]string{instanceID})})
+			Expect(errorCode(err)).To(Equal(ErrCodeInvalidInstanceIDNotFound))
+		})
+
+		It("should stop instances", func() {
+			instanceID := *run("machine-0").InstanceId
+			_, err := fake.StopInstancesWithContext(ctx, &ec2.StopInstancesInput{InstanceIds: aws.StringSlice([]string{instanceID})})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(state(instanceID)).To(Equal(ec2.InstanceStateNameStopping))
+
+			now = now.Add(time.Minute)
+			Expect(state(instanceID)).To(Equal(ec2.InstanceStateNameStopped))
+			Expect(fake.Volumes()).To(HaveLen(2))
+		})
+
+		It("should interrupt spot instances", func() {
+			input := runInput("machine-0")
+			input.InstanceMarketOptions = &ec2.InstanceMarketOptionsRequest{MarketType: aws.String(ec2.MarketTypeSpot)}
+			reservation, err := fake.RunInstancesWithContext(ctx, input)
+			Expect(err).ToNot(HaveOccurred())
+			instanceID := *reservation.Instances[0].InstanceId
+			Expect(fake.InterruptSpotInstance(*run("machine-1").InstanceId)).To(HaveOccurred())
+
+			Expect(fake.InterruptSpotInstance(instanceID)).To(Succeed())
+			instance, _ := fake.Instance(instanceID)
+			Expect(*instance.State.Name).To(Equal(ec2.InstanceStateNameShuttingDown))
+			Expect(*instance.StateReason.Code).To(Equal(stateReasonSpotInstanceTermination))
+		})
+
+		It("should wait until instances are terminated", func() {
+			fake = New(WithLifecycle(Lifecycle{ShuttingDown: 50 * time.Millisecond}), WithWaiterDelay(5*time.Millisecond))
+			imageID = fake.AddImage(&ec2.Image{})
+			input := &ec2.DescribeInstancesInput{InstanceIds: aws.StringSlice([]string{*run("machine-0").InstanceId})}
+
+			Expect(errorCode(fake.WaitUntilInstanceTerminatedWithContext(ctx, input))).To(Equal(request.WaiterResourceNotReadyErrorCode))
+
+			_, err := fake.TerminateInstancesWithContext(ctx, &ec2.TerminateInstancesInput{InstanceIds: input.InstanceIds})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fake.WaitUntilInstanceTerminatedWithContext(ctx, input)).To(Succeed())
+			Expect(fake.Volumes()).To(HaveLen(1))
+			Expect(fake.Instances()).To(HaveLen(1))
+		})
+	})
+
+	Describe("#DescribeInstances", func() {
+		BeforeEach(func() {
+			for _, name := range []string{"machine-0", "machine-1", "other-0", "machine-2"} {
+				run(name)
+			}
+			now = now.Add(time.Minute)
+		})
+
+		DescribeTable("should select instances by filters",
+			func(filters []*ec2.Filter, expectedCount int, expectedCode string) {
+				ids, err := describe(&ec2.DescribeInstancesInput{Filters: filters})
+				Expect(errorCode(err)).To(Equal(expectedCode))
+				Expect(ids).To(HaveLen(expectedCount))
+			},
+			Entry("no filters", nil, 4, ""),
+			Entry("tag value", []*ec2.Filter{{Name: aws.String("tag:Name"), Values: aws.StringSlice([]string{"machine-1"})}}, 1, ""),
+			Entry("tag value wildcard", []*ec2.Filter{{Name: aws.String("tag:Name"), Values: aws.StringSlice([]string{"machine-*"})}}, 3, ""),
+			Entry("single character wildcard", []*ec2.Filter{{Name: aws.String("tag:Name"), Values: aws.StringSlice([]string{"?ther-0"})}}, 1, ""),
+			Entry("any of the values", []*ec2.Filter{{Name: aws.String("tag:Name"), Values: aws.StringSlice([]string{"machine-0", "other-0"})}}, 2, ""),
+			Entry("tag key", []*ec2.Filter{{Name: aws.String("tag-key"), Values: aws.StringSlice([]string{"kubernetes.io/cluster/shoot--test"})}}, 4, ""),
+			Entry("missing tag key", []*ec2.Filter{{Name: aws.String("tag-key"), Values: aws.StringSlice([]string{"kubernetes.io/cluster/shoot--other"})}}, 0, ""),
+			Entry("all filters", []*ec2.Filter{
+				{Name: aws.String("tag:Name"), Values: aws.StringSlice([]string{"machine-*"})},
+				{Name: aws.String("instance-state-name"), Values: aws.StringSlice([]string{"pending"})},
+			}, 0, ""),
+			Entry("state", []*ec2.Filter{{Name: aws.String("instance-state-name"), Values: aws.StringSlice([]string{"running"})}}, 4, ""),
+			Entry("unknown filter", []*ec2.Filter{{Name: aws.String("unknown"), Values: aws.StringSlice([]string{"x"})}}, 0, ErrCodeInvalidParameterValue),
+		)
+
+		It("should paginate the instances", func() {
+			var pages int
+			err := fake.DescribeInstancesPagesWithContext(ctx, &ec2.DescribeInstancesInput{MaxResults: aws.Int64(5)}, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+				pages++
+				return true
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pages).To(Equal(1))
+
+			for i := 0; i < 4; i++ {
+				run("more")
+			}
+			ids, err := describe(&ec2.DescribeInstancesInput{MaxResults: aws.Int64(5)})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ids).To(HaveLen(8))
+			Expect(fake.Calls("DescribeInstances")).To(Equal(3))
+		})
+
+		DescribeTable("should reject invalid requests",
+			func(input *ec2.DescribeInstancesInput, code string) {
+				_, err := fake.DescribeInstancesWithContext(ctx, input)
+				Expect(errorCode(err)).To(Equal(code))
+			},
+			Entry("unknown instance", &ec2.DescribeInstancesInput{InstanceIds: aws.StringSlice([]string{"i-unknown"})}, ErrCodeInvalidInstanceIDNotFound),
+			Entry("malformed instance ID", &ec2.DescribeInstancesInput{InstanceIds: aws.StringSlice([]string{"instance"})}, ErrCodeInvalidInstanceIDMalformed),
+			Entry("page size too small", &ec2.DescribeInstancesInput{MaxResults: aws.Int64(4)}, ErrCodeInvalidParameterValue),
+			Entry("instance IDs with page size", &ec2.DescribeInstancesInput{InstanceIds: aws.StringSlice([]string{"i-1"}), MaxResults: aws.Int64(5)}, ErrCodeInvalidParameterCombination),
+			Entry("invalid token", &ec2.DescribeInstancesInput{NextToken: aws.String("!")}, ErrCodeInvalidPaginationToken),
+		)
+	})
+
+	Describe("volumes and network interfaces", func() {
+		It("should describe and delete left behind resources", func() {
+			volumeID := fake.AddVolume(&ec2.Volume{Tags: []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("machine-0")}}})
+			networkInterfaceID := fake.AddNetworkInterface(&ec2.NetworkInterface{TagSet: []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("machine-0")}}})
+			run("machine-0")
+
+			filters := []*ec2.Filter{
+				{Name: aws.String("status"), Values: aws.StringSlice([]string{"available"})},
+				{Name: aws.String("tag:Name"), Values: aws.StringSlice([]string{"machine-0"})},
+			}
+			volumes, err := fake.DescribeVolumesWithContext(ctx, &ec2.DescribeVolumesInput{Filters: filters})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(volumes.Volumes).To(HaveLen(1))
+			Expect(*volumes.Volumes[0].VolumeId).To(Equal(volumeID))
+			networkInterfaces, err := fake.DescribeNetworkInterfacesWithContext(ctx, &ec2.DescribeNetworkInterfacesInput{Filters: filters})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(networkInterfaces.NetworkInterfaces).To(HaveLen(1))
+			Expect(*networkInterfaces.NetworkInterfaces[0].NetworkInterfaceId).To(Equal(networkInterfaceID))
+
+			_, err = fake.DeleteVolumeWithContext(ctx, &ec2.DeleteVolumeInput{VolumeId: aws.String(volumeID)})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = fake.DeleteVolumeWithContext(ctx, &ec2.DeleteVolumeInput{VolumeId: aws.String(volumeID)})
+			Expect(errorCode(err)).To(Equal(ErrCodeInvalidVolumeNotFound))
+			_, err = fake.DeleteNetworkInterfaceWithContext(ctx, &ec2.DeleteNetworkInterfaceInput{NetworkInterfaceId: aws.String(networkInterfaceID)})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = fake.DeleteNetworkInterfaceWithContext(ctx, &ec2.DeleteNetworkInterfaceInput{NetworkInterfaceId: aws.String(networkInterfaceID)})
+			Expect(errorCode(err)).To(Equal(ErrCodeInvalidNetworkInterfaceIDNotFound))
+		})
+
+		It("should refuse to delete attached resources", func() {
+			instance := run("machine-0")
+
+			_, err := fake.DeleteVolumeWithContext(ctx, &ec2.DeleteVolumeInput{VolumeId: instance.BlockDeviceMappings[0].Ebs.VolumeId})
+			Expect(errorCode(err)).To(Equal(ErrCodeVolumeInUse))
+			_, err = fake.DeleteNetworkInterfaceWithContext(ctx, &ec2.DeleteNetworkInterfaceInput{NetworkInterfaceId: instance.NetworkInterfaces[0].NetworkInterfaceId})
+			Expect(errorCode(err)).To(Equal(ErrCodeInvalidNetworkInterfaceInUse))
+		})
+
+		It("should attach existing network interfaces and keep them on termination", func() {
+			networkInterfaceID := fake.AddNetworkInterface(&ec2.NetworkInterface{SubnetId: aws.String("subnet-123456")})
+			input := runInput("machine-0")
+			input.NetworkInterfaces = []*ec2.InstanceNetworkInterfaceSpecification{{DeviceIndex: aws.Int64(0), NetworkInterfaceId: aws.String(networkInterfaceID)}}
+			reservation, err := fake.RunInstancesWithContext(ctx, input)
+			Expect(err).ToNot(HaveOccurred())
+			instanceID := reservation.Instances[0].InstanceId
+
+			_, err = fake.RunInstancesWithContext(ctx, input)
+			Expect(errorCode(err)).To(Equal(ErrCodeInvalidNetworkInterfaceInUse))
+
+			_, err = fake.TerminateInstancesWithContext(ctx, &ec2.TerminateInstancesInput{InstanceIds: []*string{instanceID}})
+			Expect(err).ToNot(HaveOccurred())
+			now = now.Add(time.Minute)
+			networkInterfaces := fake.NetworkInterfaces()
+			Expect(networkInterfaces).To(HaveLen(1))
+			Expect(*networkInterfaces[0].Status).To(Equal(ec2.NetworkInterfaceStatusAvailable))
+		})
+	})
+
+	Describe("tags", func() {
+		It("should create and delete tags", func() {
+			instanceID := *run("machine-0").InstanceId
+			volumeID := fake.AddVolume(&ec2.Volume{})
+			resources := aws.StringSlice([]string{instanceID, volumeID})
+
+			_, err := fake.CreateTagsWithContext(ctx, &ec2.CreateTagsInput{
+				Resources: resources,
+				Tags:      []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("renamed")}, {Key: aws.String("team"), Value: aws.String("a")}},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			ids, err := describe(&ec2.DescribeInstancesInput{Filters: []*ec2.Filter{{Name: aws.String("tag:Name"), Values: aws.StringSlice([]string{"renamed"})}}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ids).To(ConsistOf(instanceID))
+
+			_, err = fake.DeleteTagsWithContext(ctx, &ec2.DeleteTagsInput{
+				Resources: resources,
+				Tags:      []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("other")}, {Key: aws.String("team")}},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			volumes, err := fake.DescribeVolumesWithContext(ctx, &ec2.DescribeVolumesInput{VolumeIds: aws.StringSlice([]string{volumeID})})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(volumes.Volumes[0].Tags).To(ConsistOf(&ec2.Tag{Key: aws.String("Name"), Value: aws.String("renamed")}))
+
+			_, err = fake.CreateTagsWithContext(ctx, &ec2.CreateTagsInput{Resources: aws.StringSlice([]string{"vol-unknown"}), Tags: []*ec2.Tag{}})
+			Expect(errorCode(err)).To(Equal(ErrCodeInvalidVolumeNotFound))
+		})
+	})
+
+	Describe("networks, key pairs and instance types", func() {
+		It("should describe the added subnets and security groups", func() {
+			subnetID := fake.AddSubnet(&ec2.Subnet{VpcId: aws.String("vpc-1"), AvailabilityZone: aws.String("eu-west-1b")})
+			groupID := fake.AddSecurityGroup(&ec2.SecurityGroup{VpcId: aws.String("vpc-1")})
+
+			subnets, err := fake.DescribeSubnetsWithContext(ctx, &ec2.DescribeSubnetsInput{SubnetIds: aws.StringSlice([]string{subnetID})})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(subnets.Subnets).To(HaveLen(1))
+			Expect(*subnets.Subnets[0].AvailabilityZone).To(Equal("eu-west-1b"))
+
+			groups, err := fake.DescribeSecurityGroupsWithContext(ctx, &ec2.DescribeSecurityGroupsInput{Filters: []*ec2.Filter{{Name: aws.String("vpc-id"), Values: aws.StringSlice([]string{"vpc-1"})}}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(groups.SecurityGroups).To(HaveLen(1))
+			Expect(*groups.SecurityGroups[0].GroupId).To(Equal(groupID))
+
+			_, err = fake.DescribeSubnetsWithContext(ctx, &ec2.DescribeSubnetsInput{SubnetIds: aws.StringSlice([]string{"subnet-unknown"})})
+			Expect(errorCode(err)).To(Equal(ErrCodeInvalidSubnetIDNotFound))
+			_, err = fake.DescribeSecurityGroupsWithContext(ctx, &ec2.DescribeSecurityGroupsInput{GroupIds: aws.StringSlice([]string{"sg-unknown"})})
+			Expect(errorCode(err)).To(Equal(ErrCodeInvalidGroupNotFound))
+		})
+
+		It("should describe the added key pairs", func() {
+			fake.AddKeyPair("test-key")
+
+			keyPairs, err := fake.DescribeKeyPairsWithContext(ctx, &ec2.DescribeKeyPairsInput{KeyNames: aws.StringSlice([]string{"test-key"})})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(keyPairs.KeyPairs).To(HaveLen(1))
+
+			_, err = fake.DescribeKeyPairsWithContext(ctx, &ec2.DescribeKeyPairsInput{KeyNames: aws.StringSlice([]string{"other-key"})})
+			Expect(errorCode(err)).To(Equal(ErrCodeInvalidKeyPairNotFound))
+		})
+
+		It("should describe the added instance types and their offerings", func() {
+			fake.AddInstanceType(&ec2.InstanceTypeInfo{InstanceType: aws.String("m5.large")}, "eu-west-1a", "eu-west-1b")
+
+			instanceTypes, err := fake.DescribeInstanceTypesWithContext(ctx, &ec2.DescribeInstanceTypesInput{InstanceTypes: aws.StringSlice([]string{"m5.large"})})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(instanceTypes.InstanceTypes).To(HaveLen(1))
+			Expect(aws.StringValueSlice(instanceTypes.InstanceTypes[0].ProcessorInfo.SupportedArchitectures)).To(ConsistOf(ec2.ArchitectureTypeX8664))
+
+			offerings, err := fake.DescribeInstanceTypeOfferingsWithContext(ctx, &ec2.DescribeInstanceTypeOfferingsInput{
+				LocationType: aws.String(ec2.LocationTypeAvailabilityZone),
+				Filters: []*ec2.Filter{
+					{Name: aws.String("instance-type"), Values: aws.StringSlice([]string{"m5.large"})},
+					{Name: aws.String("location"), Values: aws.StringSlice([]string{"eu-west-1b", "eu-west-1c"})},
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(offerings.InstanceTypeOfferings).To(Equal([]*ec2.InstanceTypeOffering{{
+				InstanceType: aws.String("m5.large"),
+				Location:     aws.String("eu-west-1b"),
+				LocationType: aws.String(ec2.LocationTypeAvailabilityZone),
+			}}))
+
+			_, err = fake.DescribeInstanceTypesWithContext(ctx, &ec2.DescribeInstanceTypesInput{InstanceTypes: aws.StringSlice([]string{"m5.huge"})})
+			Expect(errorCode(err)).To(Equal(ErrCodeInvalidInstanceType))
+		})
+	})
+
+	Describe("status and console output", func() {
+		It("should report the status of running instances", func() {
+			instanceID := *run("machine-0").InstanceId
+			statuses := func(input *ec2.DescribeInstanceStatusInput) []*ec2.InstanceStatus {
+				var result []*ec2.InstanceStatus
+				err := fake.DescribeInstanceStatusPagesWithContext(ctx, input, func(page *ec2.DescribeInstanceStatusOutput, lastPage bool) bool {
+					result = append(result, page.InstanceStatuses...)
+					return true
+				})
+				Expect(err).ToNot(HaveOccurred())
+				return result
+			}
+
+			Expect(statuses(&ec2.DescribeInstanceStatusInput{})).To(BeEmpty())
+			Expect(statuses(&ec2.DescribeInstanceStatusInput{IncludeAllInstances: aws.Bool(true)})).To(HaveLen(1))
+
+			now = now.Add(time.Minute)
+			result := statuses(&ec2.DescribeInstanceStatusInput{InstanceIds: aws.StringSlice([]string{instanceID})})
+			Expect(result).To(HaveLen(1))
+			Expect(*result[0].InstanceStatus.Status).To(Equal(ec2.SummaryStatusOk))
+
+			Expect(fake.SetInstanceStatus(instanceID, &ec2.InstanceStatus{
+				SystemStatus: &ec2.InstanceStatusSummary{Status: aws.String(ec2.SummaryStatusImpaired)},
+				Events:       []*ec2.InstanceStatusEvent{{Code: aws.String(ec2.EventCodeInstanceRetirement), NotBefore: aws.Time(now.Add(time.Hour))}},
+			})).To(Succeed())
+			result = statuses(&ec2.DescribeInstanceStatusInput{Filters: []*ec2.Filter{{Name: aws.String("event.code"), Values: aws.StringSlice([]string{"instance-retirement"})}}})
+			Expect(result).To(HaveLen(1))
+			Expect(*result[0].SystemStatus.Status).To(Equal(ec2.SummaryStatusImpaired))
+			Expect(*result[0].InstanceStatus.Status).To(Equal(ec2.SummaryStatusOk))
+		})
+
+		It("should return the console output", func() {
+			instanceID := *run("machine-0").InstanceId
+			output, err := fake.GetConsoleOutputWithContext(ctx, &ec2.GetConsoleOutputInput{InstanceId: aws.String(instanceID)})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(output.Output).To(BeNil())
+
+			Expect(fake.SetConsoleOutput(instanceID, "kernel panic")).To(Succeed())
+			output, err = fake.GetConsoleOutputWithContext(ctx, &ec2.GetConsoleOutputInput{InstanceId: aws.String(instanceID)})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(*output.Output).To(Equal(base64.StdEncoding.EncodeToString([]byte("kernel panic"))))
+		})
+	})
+
+	Describe("#InjectFault", func() {
+		It("should fail the given number of matching calls", func() {
+			injected := NewRequestFailure("RequestLimitExceeded", "Request limit exceeded.", 503)
+			fake.InjectFault(Fault{
+				Operation: "RunInstances",
+				Err:       injected,
+				Times:     2,
+				Match: func(input interface{}) bool {
+					return aws.StringValue(input.(*ec2.RunInstancesInput).InstanceType) == "m5.large"
+				},
+			})
+
+			for i := 0; i < 2; i++ {
+				_, err := fake.RunInstancesWithContext(ctx, runInput("machine-0"))
+				Expect(err).To(Equal(injected))
+			}
+			run("machine-0")
+			Expect(fake.Calls("RunInstances")).To(Equal(3))
+			Expect(fake.Instances()).To(HaveLen(1))
+		})
+
+		It("should fail calls until the fault is removed", func() {
+			remove := fake.InjectFault(Fault{Operation: "DescribeInstances", Err: errors.New("injected")})
+			_, err := describe(&ec2.DescribeInstancesInput{})
+			Expect(err).To(MatchError("injected"))
+			_, err = describe(&ec2.DescribeInstancesInput{})
+			Expect(err).To(MatchError("injected"))
+
+			remove()
+			_, err = describe(&ec2.DescribeInstancesInput{})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should delay calls until their context ends", func() {
+			fake.InjectFault(Fault{Operation: "DescribeInstances", Delay: time.Hour})
+			timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+			defer cancel()
+
+			_, err := fake.DescribeInstancesWithContext(timeoutCtx, &ec2.DescribeInstancesInput{})
+			Expect(errorCode(err)).To(Equal(request.CanceledErrorCode))
+		})
+
+		It("should fail dry-run calls with DryRunOperation unless a fault is injected", func() {
+			input := runInput("machine-0")
+			input.DryRun = aws.Bool(true)
+			_, err := fake.RunInstancesWithContext(ctx, input)
+			Expect(errorCode(err)).To(Equal(ErrCodeDryRunOperation))
+			Expect(fake.Instances()).To(BeEmpty())
+
+			_, err = fake.TerminateInstancesWithContext(ctx, &ec2.TerminateInstancesInput{DryRun: aws.Bool(true), InstanceIds: aws.StringSlice([]string{"i-00000000000000000"})})
+			Expect(errorCode(err)).To(Equal(ErrCodeDryRunOperation))
+
+			fake.InjectFault(Fault{Operation: "RunInstances", Err: NewRequestFailure("UnauthorizedOperation", "You are not authorized to perform this operation.", 403)})
+			_, err = fake.RunInstancesWithContext(ctx, input)
+			Expect(errorCode(err)).To(Equal("UnauthorizedOperation"))
+		})
+	})
+
+	Describe("SessionProvider", func() {
+		It("should serve the EC2 of the region", func() {
+			provider := NewSessionProvider(fake)
+
+			session, err := provider.NewSession(&corev1.Secret{}, DefaultRegion)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(provider.NewEC2API(session)).To(BeIdenticalTo(fake))
+			Expect(provider.NewIAMAPI(session)).To(BeIdenticalTo(provider.IAM()))
+
+			_, err = provider.NewSession(&corev1.Secret{}, "us-east-1")
+			Expect(err).To(HaveOccurred())
+
+			provider.FailSessions(errors.New("injected"))
+			_, err = provider.NewSession(&corev1.Secret{}, DefaultRegion)
+			Expect(err).To(MatchError("injected"))
+		})
+	})
+})
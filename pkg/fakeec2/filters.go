@@ -0,0 +1,154 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fakeec2
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// attributes returns the values of a filterable attribute of a resource, ok is false for unknown attributes
+type attributes func(name string) (values []string, ok bool)
+
+// matchesFilters returns true if a resource with the given tags and attributes matches all filters. As in EC2,
+// a filter matches if any of its values matches any value of the attribute, and values may contain the wildcards
+// '*' and '?'. Unknown filter names are rejected.
+func matchesFilters(filters []*ec2.Filter, tags []*ec2.Tag, attrs attributes) (bool, error) {
+	matches := true
+	for _, filter := range filters {
+		name := aws.StringValue(filter.Name)
+
+		var values []string
+		switch {
+		case strings.HasPrefix(name, "tag:"):
+			if value, ok := tagValue(tags, strings.TrimPrefix(name, "tag:")); ok {
+				values = []string{value}
+			}
+		case name == "tag-key":
+			for _, tag := range tags {
+				values = append(values, aws.StringValue(tag.Key))
+			}
+		case name == "tag-value":
+			for _, tag := range tags {
+				values = append(values, aws.StringValue(tag.Value))
+			}
+		default:
+			var ok bool
+			if values, ok = attrs(name); !ok {
+				return false, NewError(ErrCodeInvalidParameterValue, fmt.Sprintf("The filter '%s' is invalid", name))
+			}
+		}
+
+		if len(filter.Values) == 0 {
+			return false, NewError(ErrCodeInvalidParameterValue, fmt.Sprintf("The filter '%s' has no values", name))
+		}
+		if !matchesAny(aws.StringValueSlice(filter.Values), values) {
+			// Keep validating the remaining filters
+			matches = false
+		}
+	}
+	return matches, nil
+}
+
+// matchesAny returns true if any of the patterns matches any of the values
+func matchesAny(patterns, values []string) bool {
+	for _, pattern := range patterns {
+		expr := wildcardExpression(pattern)
+		for _, value := range values {
+			if expr.MatchString(value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wildcardExpression converts a filter value with the wildcards '*' and '?' into a regular expression. As in EC2,
+// wildcards are escaped with a backslash.
+func wildcardExpression(pattern string) *regexp.Regexp {
+	var expr strings.Builder
+	expr.WriteString("^")
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '*':
+			expr.WriteString(".*")
+		case r == '?':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	if escaped {
+		expr.WriteString(regexp.QuoteMeta("\\"))
+	}
+	expr.WriteString("$")
+	return regexp.MustCompile(expr.String())
+}
+
+// tagValue returns the value of the tag with the given key
+func tagValue(tags []*ec2.Tag, key string) (string, bool) {
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) == key {
+			return aws.StringValue(tag.Value), true
+		}
+	}
+	return "", false
+}
+
+// single returns an attribute with a single value, or none if the value is empty
+func single(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return []string{value}
+}
+
+// page returns the bounds of the page of the sorted IDs requested by maxResults and nextToken, and the token of
+// the next page. IDs are used as tokens, so that pages remain stable while resources are added or removed.
+func page(ids []string, maxResults *int64, nextToken *string, minResults, maxAllowed int64) (int, int, *string, error) {
+	start := 0
+	if nextToken != nil {
+		last, err := base64.RawURLEncoding.DecodeString(*nextToken)
+		if err != nil || len(last) == 0 {
+			return 0, 0, nil, NewError(ErrCodeInvalidPaginationToken, fmt.Sprintf("Invalid pagination token %q", *nextToken))
+		}
+		for start < len(ids) && ids[start] <= string(last) {
+			start++
+		}
+	}
+
+	if maxResults == nil {
+		return start, len(ids), nil, nil
+	}
+	if *maxResults < minResults || *maxResults > maxAllowed {
+		return 0, 0, nil, NewError(ErrCodeInvalidParameterValue, fmt.Sprintf("Value ( %d ) for parameter maxResults is invalid. Expecting a value between %d and %d.", *maxResults, minResults, maxAllowed))
+	}
+
+	end := start + int(*maxResults)
+	if end >= len(ids) {
+		return start, len(ids), nil, nil
+	}
+	return start, end, aws.String(base64.RawURLEncoding.EncodeToString([]byte(ids[end-1]))), nil
+}
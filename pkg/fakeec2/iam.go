@@ -0,0 +1,95 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fakeec2
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+)
+
+var _ iamiface.IAMAPI = &IAM{}
+
+// IAM is an in-memory implementation of the IAM calls used by the driver, it serves the instance profiles
+// referenced by machine classes. Calls of operations that aren't implemented panic.
+type IAM struct {
+	iamiface.IAMAPI
+
+	mutex            sync.Mutex
+	sequence         int
+	instanceProfiles map[string]*iam.InstanceProfile
+	calls            map[string]int
+}
+
+// NewIAM returns an IAM without instance profiles
+func NewIAM() *IAM {
+	return &IAM{
+		instanceProfiles: map[string]*iam.InstanceProfile{},
+		calls:            map[string]int{},
+	}
+}
+
+// AddInstanceProfile adds an instance profile with the given name and returns its ARN
+func (i *IAM) AddInstanceProfile(name string) string {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.sequence++
+	arn := fmt.Sprintf("arn:aws:iam::%s:instance-profile/%s", DefaultOwnerID, name)
+	i.instanceProfiles[name] = &iam.InstanceProfile{
+		Arn:                 aws.String(arn),
+		InstanceProfileId:   aws.String(fmt.Sprintf("AIPA%017X", i.sequence)),
+		InstanceProfileName: aws.String(name),
+		Path:                aws.String("/"),
+		Roles:               []*iam.Role{},
+	}
+	return arn
+}
+
+// Calls returns the number of calls of an operation, including the failed ones
+func (i *IAM) Calls(operation string) int {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	return i.calls[operation]
+}
+
+// GetInstanceProfileWithContext returns the instance profile with the given name
+func (i *IAM) GetInstanceProfileWithContext(ctx aws.Context, input *iam.GetInstanceProfileInput, opts ...request.Option) (*iam.GetInstanceProfileOutput, error) {
+	if ctx == nil {
+		ctx = aws.BackgroundContext()
+	}
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.calls["GetInstanceProfile"]++
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+	name := aws.StringValue(input.InstanceProfileName)
+	profile, ok := i.instanceProfiles[name]
+	if !ok {
+		return nil, NewRequestFailure(iam.ErrCodeNoSuchEntityException, fmt.Sprintf("Instance Profile %s cannot be found.", name), http.StatusNotFound)
+	}
+	return &iam.GetInstanceProfileOutput{InstanceProfile: copyOf(profile).(*iam.InstanceProfile)}, nil
+}
+
+// GetInstanceProfile returns an instance profile, see GetInstanceProfileWithContext
+func (i *IAM) GetInstanceProfile(input *iam.GetInstanceProfileInput) (*iam.GetInstanceProfileOutput, error) {
+	return i.GetInstanceProfileWithContext(aws.BackgroundContext(), input)
+}
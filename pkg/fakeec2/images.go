@@ -0,0 +1,143 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fakeec2
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// AddImage adds an image instances can be launched from and returns its ID. Missing ID, state, owner, root device
+// and block device mappings are defaulted to an available EBS backed image with an 8 GiB root volume.
+func (e *EC2) AddImage(image *ec2.Image) string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	i := copyOf(image).(*ec2.Image)
+	if i.ImageId == nil {
+		i.ImageId = aws.String(e.nextID("ami"))
+	}
+	if i.State == nil {
+		i.State = aws.String(ec2.ImageStateAvailable)
+	}
+	if i.OwnerId == nil {
+		i.OwnerId = aws.String(DefaultOwnerID)
+	}
+	if i.Architecture == nil {
+		i.Architecture = aws.String(ec2.ArchitectureValuesX8664)
+	}
+	if i.VirtualizationType == nil {
+		i.VirtualizationType = aws.String(ec2.VirtualizationTypeHvm)
+	}
+	if i.RootDeviceType == nil {
+		i.RootDeviceType = aws.String(ec2.DeviceTypeEbs)
+	}
+	if i.RootDeviceName == nil {
+		i.RootDeviceName = aws.String("/dev/xvda")
+	}
+	if i.BlockDeviceMappings == nil {
+		i.BlockDeviceMappings = []*ec2.BlockDeviceMapping{{
+			DeviceName: i.RootDeviceName,
+			Ebs: &ec2.EbsBlockDevice{
+				DeleteOnTermination: aws.Bool(true),
+				VolumeSize:          aws.Int64(defaultVolumeSize),
+				VolumeType:          aws.String(ec2.VolumeTypeGp2),
+			},
+		}}
+	}
+	e.images[*i.ImageId] = i
+	return *i.ImageId
+}
+
+// DescribeImagesWithContext describes the images selected by their IDs, owners and filters
+func (e *EC2) DescribeImagesWithContext(ctx aws.Context, input *ec2.DescribeImagesInput, opts ...request.Option) (*ec2.DescribeImagesOutput, error) {
+	if err := e.begin(ctx, "DescribeImages", input); err != nil {
+		return nil, err
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	for _, id := range input.ImageIds {
+		if _, err := e.image(aws.StringValue(id)); err != nil {
+			return nil, err
+		}
+	}
+
+	candidates := aws.StringValueSlice(input.ImageIds)
+	if len(candidates) == 0 {
+		for id := range e.images {
+			candidates = append(candidates, id)
+		}
+	}
+
+	output := &ec2.DescribeImagesOutput{}
+	for _, id := range sortedKeys(candidates) {
+		image := e.images[id]
+		if len(input.Owners) > 0 && !matchesAny(aws.StringValueSlice(input.Owners), []string{aws.StringValue(image.OwnerId), "self"}) {
+			continue
+		}
+		matches, err := matchesFilters(input.Filters, image.Tags, imageAttribute(image))
+		if err != nil {
+			return nil, err
+		}
+		if matches && (len(output.Images) == 0 || *output.Images[len(output.Images)-1].ImageId != id) {
+			output.Images = append(output.Images, copyOf(image).(*ec2.Image))
+		}
+	}
+	return output, nil
+}
+
+// DescribeImages describes images, see DescribeImagesWithContext
+func (e *EC2) DescribeImages(input *ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
+	return e.DescribeImagesWithContext(aws.BackgroundContext(), input)
+}
+
+// image returns the image with the given ID or the error returned by EC2 for unknown images
+func (e *EC2) image(id string) (*ec2.Image, error) {
+	if !strings.HasPrefix(id, "ami-") {
+		return nil, NewError(ErrCodeInvalidAMIIDMalformed, fmt.Sprintf("Invalid id: \"%s\" (expecting \"ami-...\")", id))
+	}
+	image, ok := e.images[id]
+	if !ok {
+		return nil, NewError(ErrCodeInvalidAMIIDNotFound, fmt.Sprintf("The image id '%s' does not exist", id))
+	}
+	return image, nil
+}
+
+// imageAttribute returns the attributes of an image for the DescribeImages filters
+func imageAttribute(image *ec2.Image) attributes {
+	return func(name string) ([]string, bool) {
+		switch name {
+		case "image-id":
+			return single(aws.StringValue(image.ImageId)), true
+		case "name":
+			return single(aws.StringValue(image.Name)), true
+		case "state":
+			return single(aws.StringValue(image.State)), true
+		case "owner-id":
+			return single(aws.StringValue(image.OwnerId)), true
+		case "architecture":
+			return single(aws.StringValue(image.Architecture)), true
+		case "root-device-name":
+			return single(aws.StringValue(image.RootDeviceName)), true
+		case "root-device-type":
+			return single(aws.StringValue(image.RootDeviceType)), true
+		}
+		return nil, false
+	}
+}
@@ -0,0 +1,151 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fakeec2
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// instanceType is an instance type together with the availability zones it's offered in
+type instanceType struct {
+	*ec2.InstanceTypeInfo
+	zones []string
+}
+
+// AddInstanceType adds an instance type offered in the given availability zones, or in the first zone of the region
+// if none are given. Missing supported architectures are defaulted to x86_64.
+func (e *EC2) AddInstanceType(info *ec2.InstanceTypeInfo, zones ...string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	t := copyOf(info).(*ec2.InstanceTypeInfo)
+	if t.ProcessorInfo == nil {
+		t.ProcessorInfo = &ec2.ProcessorInfo{}
+	}
+	if t.ProcessorInfo.SupportedArchitectures == nil {
+		t.ProcessorInfo.SupportedArchitectures = aws.StringSlice([]string{ec2.ArchitectureTypeX8664})
+	}
+	if len(zones) == 0 {
+		zones = []string{e.region + "a"}
+	}
+	e.instanceTypes[aws.StringValue(t.InstanceType)] = &instanceType{InstanceTypeInfo: t, zones: zones}
+}
+
+// DescribeInstanceTypesWithContext describes the instance types selected by their names
+func (e *EC2) DescribeInstanceTypesWithContext(ctx aws.Context, input *ec2.DescribeInstanceTypesInput, opts ...request.Option) (*ec2.DescribeInstanceTypesOutput, error) {
+	if err := e.begin(ctx, "DescribeInstanceTypes", input); err != nil {
+		return nil, err
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	var missing []string
+	for _, name := range aws.StringValueSlice(input.InstanceTypes) {
+		if _, ok := e.instanceTypes[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, NewError(ErrCodeInvalidInstanceType, fmt.Sprintf("The following supplied instance types do not exist: [%s]", strings.Join(missing, ", ")))
+	}
+
+	names := aws.StringValueSlice(input.InstanceTypes)
+	if len(names) == 0 {
+		for name := range e.instanceTypes {
+			names = append(names, name)
+		}
+	}
+
+	output := &ec2.DescribeInstanceTypesOutput{}
+	for _, name := range sortedKeys(names) {
+		if len(output.InstanceTypes) == 0 || *output.InstanceTypes[len(output.InstanceTypes)-1].InstanceType != name {
+			output.InstanceTypes = append(output.InstanceTypes, copyOf(e.instanceTypes[name].InstanceTypeInfo).(*ec2.InstanceTypeInfo))
+		}
+	}
+	return output, nil
+}
+
+// DescribeInstanceTypes describes instance types, see DescribeInstanceTypesWithContext
+func (e *EC2) DescribeInstanceTypes(input *ec2.DescribeInstanceTypesInput) (*ec2.DescribeInstanceTypesOutput, error) {
+	return e.DescribeInstanceTypesWithContext(aws.BackgroundContext(), input)
+}
+
+// DescribeInstanceTypeOfferingsWithContext describes the availability zones or the region the instance types are
+// offered in, selected by the instance-type and location filters
+func (e *EC2) DescribeInstanceTypeOfferingsWithContext(ctx aws.Context, input *ec2.DescribeInstanceTypeOfferingsInput, opts ...request.Option) (*ec2.DescribeInstanceTypeOfferingsOutput, error) {
+	if err := e.begin(ctx, "DescribeInstanceTypeOfferings", input); err != nil {
+		return nil, err
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	locationType := aws.StringValue(input.LocationType)
+	if locationType == "" {
+		locationType = ec2.LocationTypeRegion
+	}
+	if locationType != ec2.LocationTypeRegion && locationType != ec2.LocationTypeAvailabilityZone {
+		return nil, NewError(ErrCodeInvalidParameterValue, fmt.Sprintf("The location type '%s' is not supported", locationType))
+	}
+
+	var names []string
+	for name := range e.instanceTypes {
+		names = append(names, name)
+	}
+
+	output := &ec2.DescribeInstanceTypeOfferingsOutput{}
+	for _, name := range sortedKeys(names) {
+		locations := []string{e.region}
+		if locationType == ec2.LocationTypeAvailabilityZone {
+			locations = e.instanceTypes[name].zones
+		}
+		for _, location := range locations {
+			offering := &ec2.InstanceTypeOffering{
+				InstanceType: aws.String(name),
+				Location:     aws.String(location),
+				LocationType: aws.String(locationType),
+			}
+			matches, err := matchesFilters(input.Filters, nil, instanceTypeOfferingAttribute(offering))
+			if err != nil {
+				return nil, err
+			}
+			if matches {
+				output.InstanceTypeOfferings = append(output.InstanceTypeOfferings, offering)
+			}
+		}
+	}
+	return output, nil
+}
+
+// DescribeInstanceTypeOfferings describes instance type offerings, see DescribeInstanceTypeOfferingsWithContext
+func (e *EC2) DescribeInstanceTypeOfferings(input *ec2.DescribeInstanceTypeOfferingsInput) (*ec2.DescribeInstanceTypeOfferingsOutput, error) {
+	return e.DescribeInstanceTypeOfferingsWithContext(aws.BackgroundContext(), input)
+}
+
+// instanceTypeOfferingAttribute returns the attributes of an offering for the DescribeInstanceTypeOfferings filters
+func instanceTypeOfferingAttribute(offering *ec2.InstanceTypeOffering) attributes {
+	return func(name string) ([]string, bool) {
+		switch name {
+		case "instance-type":
+			return single(aws.StringValue(offering.InstanceType)), true
+		case "location":
+			return single(aws.StringValue(offering.Location)), true
+		}
+		return nil, false
+	}
+}
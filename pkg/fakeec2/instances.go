@@ -0,0 +1,854 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fakeec2
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	// defaultInstanceType is the type of instances launched without instance type, as in EC2
+	defaultInstanceType = "m1.small"
+	// defaultVolumeSize is the size in GiB of volumes launched without size and snapshot
+	defaultVolumeSize = 8
+
+	// stateReasonUserInitiated is the state reason of instances stopped or terminated through the API
+	stateReasonUserInitiated = "Client.UserInitiatedShutdown"
+	// stateReasonSpotInstanceTermination is the state reason of interrupted spot instances
+	stateReasonSpotInstanceTermination = "Server.SpotInstanceTermination"
+)
+
+// stateCodes maps the instance state names to their codes
+var stateCodes = map[string]int64{
+	ec2.InstanceStateNamePending:      0,
+	ec2.InstanceStateNameRunning:      16,
+	ec2.InstanceStateNameShuttingDown: 32,
+	ec2.InstanceStateNameTerminated:   48,
+	ec2.InstanceStateNameStopping:     64,
+	ec2.InstanceStateNameStopped:      80,
+}
+
+// RunInstancesWithContext launches instances together with their volumes and network interfaces.
+// Retries with the client token of a previous call return the instances launched by that call.
+func (e *EC2) RunInstancesWithContext(ctx aws.Context, input *ec2.RunInstancesInput, opts ...request.Option) (*ec2.Reservation, error) {
+	if err := e.begin(ctx, "RunInstances", input); err != nil {
+		return nil, err
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.advance()
+
+	token := aws.StringValue(input.ClientToken)
+	if l, ok := e.clientTokens[token]; ok && token != "" {
+		if !awsutil.DeepEqual(l.input, input) {
+			return nil, NewError(ErrCodeIdempotentParameterMismatch, "Arguments on this idempotent request are inconsistent with arguments used in previous request(s).")
+		}
+		return e.reservation(l.reservationID, l.instanceIDs), nil
+	}
+
+	image, err := e.validateRunInstances(input)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &launch{
+		input:         copyOf(input).(*ec2.RunInstancesInput),
+		reservationID: e.nextID("r"),
+	}
+	for index := int64(0); index < aws.Int64Value(input.MaxCount); index++ {
+		l.instanceIDs = append(l.instanceIDs, e.launchInstance(input, image, l.reservationID, index))
+	}
+	if token != "" {
+		e.clientTokens[token] = l
+	}
+	return e.reservation(l.reservationID, l.instanceIDs), nil
+}
+
+// RunInstances launches instances, see RunInstancesWithContext
+func (e *EC2) RunInstances(input *ec2.RunInstancesInput) (*ec2.Reservation, error) {
+	return e.RunInstancesWithContext(aws.BackgroundContext(), input)
+}
+
+// validateRunInstances validates a RunInstances call and returns the image to launch
+func (e *EC2) validateRunInstances(input *ec2.RunInstancesInput) (*ec2.Image, error) {
+	switch {
+	case input.MinCount == nil:
+		return nil, missingParameter("MinCount")
+	case input.MaxCount == nil:
+		return nil, missingParameter("MaxCount")
+	case *input.MinCount < 1 || *input.MaxCount < *input.MinCount:
+		return nil, NewError(ErrCodeInvalidParameterValue, fmt.Sprintf("Invalid instance count, MinCount %d and MaxCount %d", *input.MinCount, *input.MaxCount))
+	case input.ImageId == nil:
+		return nil, missingParameter("ImageId")
+	}
+
+	image, err := e.image(*input.ImageId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, spec := range input.TagSpecifications {
+		switch aws.StringValue(spec.ResourceType) {
+		case ec2.ResourceTypeInstance, ec2.ResourceTypeVolume, ec2.ResourceTypeNetworkInterface:
+		default:
+			return nil, NewError(ErrCodeInvalidParameterValue, fmt.Sprintf("'%s' is not a valid taggable resource type for this operation.", aws.StringValue(spec.ResourceType)))
+		}
+	}
+
+	if len(input.NetworkInterfaces) > 0 && (input.SubnetId != nil || len(input.SecurityGroupIds) > 0) {
+		return nil, NewError(ErrCodeInvalidParameterCombination, "Network interfaces and an instance-level subnet ID or security groups may not be specified on the same request")
+	}
+	for _, spec := range input.NetworkInterfaces {
+		if spec.NetworkInterfaceId == nil {
+			continue
+		}
+		networkInterface, ok := e.networkInterfaces[*spec.NetworkInterfaceId]
+		switch {
+		case !ok:
+			return nil, NewError(ErrCodeInvalidNetworkInterfaceIDNotFound, fmt.Sprintf("The networkInterface ID '%s' does not exist", *spec.NetworkInterfaceId))
+		case aws.StringValue(networkInterface.Status) != ec2.NetworkInterfaceStatusAvailable:
+			return nil, NewError(ErrCodeInvalidNetworkInterfaceInUse, fmt.Sprintf("Interface: %s in use.", *spec.NetworkInterfaceId))
+		case *input.MaxCount > 1:
+			return nil, NewError(ErrCodeInvalidParameterCombination, "Existing network interfaces can only be attached to a single instance")
+		}
+	}
+	return image, nil
+}
+
+// launchInstance creates a pending instance with its volumes and network interfaces and returns its ID
+func (e *EC2) launchInstance(input *ec2.RunInstancesInput, image *ec2.Image, reservationID string, index int64) string {
+	now := e.now()
+	availabilityZone := e.region + "a"
+	if input.Placement != nil && input.Placement.AvailabilityZone != nil {
+		availabilityZone = *input.Placement.AvailabilityZone
+	}
+
+	i := &instance{
+		Instance: &ec2.Instance{
+			InstanceId:         aws.String(e.nextID("i")),
+			ImageId:            input.ImageId,
+			InstanceType:       aws.String(defaultInstanceType),
+			KeyName:            input.KeyName,
+			LaunchTime:         aws.Time(now),
+			AmiLaunchIndex:     aws.Int64(index),
+			Architecture:       image.Architecture,
+			Hypervisor:         aws.String(ec2.HypervisorTypeXen),
+			VirtualizationType: image.VirtualizationType,
+			RootDeviceName:     image.RootDeviceName,
+			RootDeviceType:     aws.String(ec2.DeviceTypeEbs),
+			EbsOptimized:       aws.Bool(aws.BoolValue(input.EbsOptimized)),
+			ClientToken:        input.ClientToken,
+			Placement:          &ec2.Placement{AvailabilityZone: aws.String(availabilityZone), Tenancy: aws.String(ec2.TenancyDefault)},
+			Monitoring:         &ec2.Monitoring{State: aws.String(ec2.MonitoringStateDisabled)},
+			Tags:               tagsFor(input.TagSpecifications, ec2.ResourceTypeInstance),
+		},
+		reservationID: reservationID,
+		transitionAt:  now.Add(e.lifecycle.Pending),
+	}
+	setState(i.Instance, ec2.InstanceStateNamePending)
+	if input.InstanceType != nil {
+		i.InstanceType = input.InstanceType
+	}
+	if profile := input.IamInstanceProfile; profile != nil {
+		arn := aws.StringValue(profile.Arn)
+		if arn == "" {
+			arn = fmt.Sprintf("arn:aws:iam::%s:instance-profile/%s", DefaultOwnerID, aws.StringValue(profile.Name))
+		}
+		i.IamInstanceProfile = &ec2.IamInstanceProfile{Arn: aws.String(arn), Id: aws.String(strings.ToUpper(e.nextID("aipa")))}
+	}
+	if options := input.InstanceMarketOptions; options != nil && aws.StringValue(options.MarketType) == ec2.MarketTypeSpot {
+		i.InstanceLifecycle = aws.String(ec2.InstanceLifecycleTypeSpot)
+		i.SpotInstanceRequestId = aws.String(e.nextID("sir"))
+	}
+
+	e.attachNetworkInterfaces(i, input, now)
+	e.attachVolumes(i, input, image, now)
+	e.instances[*i.InstanceId] = i
+	return *i.InstanceId
+}
+
+// attachNetworkInterfaces attaches the network interfaces requested for an instance, creating the new ones
+func (e *EC2) attachNetworkInterfaces(i *instance, input *ec2.RunInstancesInput, now time.Time) {
+	specs := input.NetworkInterfaces
+	if len(specs) == 0 {
+		specs = []*ec2.InstanceNetworkInterfaceSpecification{{
+			DeviceIndex:         aws.Int64(0),
+			SubnetId:            input.SubnetId,
+			Groups:              input.SecurityGroupIds,
+			DeleteOnTermination: aws.Bool(true),
+		}}
+	}
+
+	for _, spec := range specs {
+		var networkInterface *ec2.NetworkInterface
+		deleteOnTermination := aws.BoolValue(spec.DeleteOnTermination)
+		if spec.NetworkInterfaceId != nil {
+			networkInterface = e.networkInterfaces[*spec.NetworkInterfaceId]
+		} else {
+			if spec.DeleteOnTermination == nil {
+				deleteOnTermination = true
+			}
+			networkInterface = e.newNetworkInterface(spec.SubnetId, aws.StringValue(i.Placement.AvailabilityZone), spec.Description, spec.Groups)
+			networkInterface.TagSet = tagsFor(input.TagSpecifications, ec2.ResourceTypeNetworkInterface)
+			e.networkInterfaces[*networkInterface.NetworkInterfaceId] = networkInterface
+		}
+
+		attachment := &ec2.NetworkInterfaceAttachment{
+			AttachmentId:        aws.String(e.nextID("eni-attach")),
+			AttachTime:          aws.Time(now),
+			DeleteOnTermination: aws.Bool(deleteOnTermination),
+			DeviceIndex:         spec.DeviceIndex,
+			InstanceId:          i.InstanceId,
+			InstanceOwnerId:     aws.String(DefaultOwnerID),
+			Status:              aws.String(ec2.AttachmentStatusAttached),
+		}
+		networkInterface.Attachment = attachment
+		networkInterface.Status = aws.String(ec2.NetworkInterfaceStatusInUse)
+
+		i.NetworkInterfaces = append(i.NetworkInterfaces, &ec2.InstanceNetworkInterface{
+			NetworkInterfaceId: networkInterface.NetworkInterfaceId,
+			SubnetId:           networkInterface.SubnetId,
+			PrivateIpAddress:   networkInterface.PrivateIpAddress,
+			PrivateDnsName:     networkInterface.PrivateDnsName,
+			Status:             aws.String(ec2.NetworkInterfaceStatusInUse),
+			Description:        networkInterface.Description,
+			OwnerId:            aws.String(DefaultOwnerID),
+			Groups:             networkInterface.Groups,
+			Attachment: &ec2.InstanceNetworkInterfaceAttachment{
+				AttachmentId:        attachment.AttachmentId,
+				AttachTime:          attachment.AttachTime,
+				DeleteOnTermination: attachment.DeleteOnTermination,
+				DeviceIndex:         attachment.DeviceIndex,
+				Status:              attachment.Status,
+			},
+		})
+		if aws.Int64Value(spec.DeviceIndex) == 0 {
+			i.SubnetId = networkInterface.SubnetId
+			i.PrivateIpAddress = networkInterface.PrivateIpAddress
+			i.PrivateDnsName = networkInterface.PrivateDnsName
+		}
+	}
+}
+
+// attachVolumes creates and attaches the EBS volumes of the block device mappings of an instance, falling back
+// to the ones of its image
+func (e *EC2) attachVolumes(i *instance, input *ec2.RunInstancesInput, image *ec2.Image, now time.Time) {
+	mappings := input.BlockDeviceMappings
+	if len(mappings) == 0 {
+		mappings = image.BlockDeviceMappings
+	}
+
+	for _, mapping := range mappings {
+		if mapping.Ebs == nil {
+			continue
+		}
+		deleteOnTermination := mapping.Ebs.DeleteOnTermination == nil || *mapping.Ebs.DeleteOnTermination
+
+		volume := &ec2.Volume{
+			VolumeId:         aws.String(e.nextID("vol")),
+			AvailabilityZone: i.Placement.AvailabilityZone,
+			CreateTime:       aws.Time(now),
+			Encrypted:        aws.Bool(aws.BoolValue(mapping.Ebs.Encrypted)),
+			Iops:             mapping.Ebs.Iops,
+			KmsKeyId:         mapping.Ebs.KmsKeyId,
+			Size:             aws.Int64(defaultVolumeSize),
+			SnapshotId:       mapping.Ebs.SnapshotId,
+			VolumeType:       aws.String(ec2.VolumeTypeGp2),
+			State:            aws.String(ec2.VolumeStateInUse),
+			Tags:             tagsFor(input.TagSpecifications, ec2.ResourceTypeVolume),
+			Attachments: []*ec2.VolumeAttachment{{
+				AttachTime:          aws.Time(now),
+				DeleteOnTermination: aws.Bool(deleteOnTermination),
+				Device:              mapping.DeviceName,
+				InstanceId:          i.InstanceId,
+				State:               aws.String(ec2.VolumeAttachmentStateAttached),
+			}},
+		}
+		if mapping.Ebs.VolumeSize != nil {
+			volume.Size = mapping.Ebs.VolumeSize
+		}
+		if mapping.Ebs.VolumeType != nil {
+			volume.VolumeType = mapping.Ebs.VolumeType
+		}
+		volume.Attachments[0].VolumeId = volume.VolumeId
+		e.volumes[*volume.VolumeId] = volume
+
+		i.BlockDeviceMappings = append(i.BlockDeviceMappings, &ec2.InstanceBlockDeviceMapping{
+			DeviceName: mapping.DeviceName,
+			Ebs: &ec2.EbsInstanceBlockDevice{
+				AttachTime:          aws.Time(now),
+				DeleteOnTermination: aws.Bool(deleteOnTermination),
+				Status:              aws.String(ec2.AttachmentStatusAttached),
+				VolumeId:            volume.VolumeId,
+			},
+		})
+	}
+}
+
+// DescribeInstancesWithContext describes the instances selected by their IDs and filters
+func (e *EC2) DescribeInstancesWithContext(ctx aws.Context, input *ec2.DescribeInstancesInput, opts ...request.Option) (*ec2.DescribeInstancesOutput, error) {
+	if err := e.begin(ctx, "DescribeInstances", input); err != nil {
+		return nil, err
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.advance()
+
+	if len(input.InstanceIds) > 0 && input.MaxResults != nil {
+		return nil, NewError(ErrCodeInvalidParameterCombination, "The parameter instancesSet cannot be used with the parameter maxResults")
+	}
+	ids, err := e.selectInstances(input.InstanceIds, input.Filters, false)
+	if err != nil {
+		return nil, err
+	}
+	start, end, nextToken, err := page(ids, input.MaxResults, input.NextToken, 5, 1000)
+	if err != nil {
+		return nil, err
+	}
+
+	output := &ec2.DescribeInstancesOutput{NextToken: nextToken}
+	reservations := map[string]*ec2.Reservation{}
+	for _, id := range ids[start:end] {
+		i := e.instances[id]
+		reservation, ok := reservations[i.reservationID]
+		if !ok {
+			reservation = &ec2.Reservation{ReservationId: aws.String(i.reservationID), OwnerId: aws.String(DefaultOwnerID)}
+			reservations[i.reservationID] = reservation
+			output.Reservations = append(output.Reservations, reservation)
+		}
+		reservation.Instances = append(reservation.Instances, copyOf(i.Instance).(*ec2.Instance))
+	}
+	return output, nil
+}
+
+// DescribeInstances describes instances, see DescribeInstancesWithContext
+func (e *EC2) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	return e.DescribeInstancesWithContext(aws.BackgroundContext(), input)
+}
+
+// DescribeInstancesPagesWithContext iterates over the pages of DescribeInstancesWithContext
+func (e *EC2) DescribeInstancesPagesWithContext(ctx aws.Context, input *ec2.DescribeInstancesInput, fn func(*ec2.DescribeInstancesOutput, bool) bool, opts ...request.Option) error {
+	pageInput := *input
+	for {
+		output, err := e.DescribeInstancesWithContext(ctx, &pageInput, opts...)
+		if err != nil {
+			return err
+		}
+		lastPage := output.NextToken == nil
+		if !fn(output, lastPage) || lastPage {
+			return nil
+		}
+		pageInput.NextToken = output.NextToken
+	}
+}
+
+// DescribeInstancesPages iterates over the pages of DescribeInstances
+func (e *EC2) DescribeInstancesPages(input *ec2.DescribeInstancesInput, fn func(*ec2.DescribeInstancesOutput, bool) bool) error {
+	return e.DescribeInstancesPagesWithContext(aws.BackgroundContext(), input, fn)
+}
+
+// selectInstances returns the sorted IDs of the given instances, or all instances if none are given, that match
+// the filters. If runningOnly is set, only running instances are selected.
+func (e *EC2) selectInstances(instanceIDs []*string, filters []*ec2.Filter, runningOnly bool) ([]string, error) {
+	for _, id := range instanceIDs {
+		if !strings.HasPrefix(aws.StringValue(id), "i-") {
+			return nil, NewError(ErrCodeInvalidInstanceIDMalformed, fmt.Sprintf("Invalid id: \"%s\"", aws.StringValue(id)))
+		}
+	}
+	if err := checkIDs(instanceIDs, e.hasInstance, ErrCodeInvalidInstanceIDNotFound, "instance ID"); err != nil {
+		return nil, err
+	}
+
+	candidates := aws.StringValueSlice(instanceIDs)
+	if len(candidates) == 0 {
+		for id := range e.instances {
+			candidates = append(candidates, id)
+		}
+	}
+
+	var ids []string
+	selected := map[string]bool{}
+	for _, id := range candidates {
+		i := e.instances[id]
+		if selected[id] || (runningOnly && aws.StringValue(i.State.Name) != ec2.InstanceStateNameRunning) {
+			continue
+		}
+		matches, err := matchesFilters(filters, i.Tags, i.attribute)
+		if err != nil {
+			return nil, err
+		}
+		if matches {
+			selected[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return sortedKeys(ids), nil
+}
+
+func (e *EC2) hasInstance(id string) bool {
+	_, ok := e.instances[id]
+	return ok
+}
+
+// attribute returns the values of the DescribeInstances filter with the given name
+func (i *instance) attribute(name string) ([]string, bool) {
+	switch name {
+	case "instance-id":
+		return single(aws.StringValue(i.InstanceId)), true
+	case "instance-state-name":
+		return single(aws.StringValue(i.State.Name)), true
+	case "instance-state-code":
+		return single(fmt.Sprint(aws.Int64Value(i.State.Code))), true
+	case "instance-type":
+		return single(aws.StringValue(i.InstanceType)), true
+	case "image-id":
+		return single(aws.StringValue(i.ImageId)), true
+	case "key-name":
+		return single(aws.StringValue(i.KeyName)), true
+	case "reservation-id":
+		return single(i.reservationID), true
+	case "client-token":
+		return single(aws.StringValue(i.ClientToken)), true
+	case "availability-zone":
+		return single(aws.StringValue(i.Placement.AvailabilityZone)), true
+	case "private-dns-name":
+		return single(aws.StringValue(i.PrivateDnsName)), true
+	case "private-ip-address":
+		return single(aws.StringValue(i.PrivateIpAddress)), true
+	case "subnet-id":
+		return single(aws.StringValue(i.SubnetId)), true
+	case "instance-lifecycle":
+		return single(aws.StringValue(i.InstanceLifecycle)), true
+	case "network-interface.network-interface-id":
+		var values []string
+		for _, networkInterface := range i.NetworkInterfaces {
+			values = append(values, aws.StringValue(networkInterface.NetworkInterfaceId))
+		}
+		return values, true
+	case "block-device-mapping.volume-id":
+		var values []string
+		for _, mapping := range i.BlockDeviceMappings {
+			values = append(values, aws.StringValue(mapping.Ebs.VolumeId))
+		}
+		return values, true
+	}
+	return nil, false
+}
+
+// reservation returns a copy of a reservation with its remaining instances
+func (e *EC2) reservation(reservationID string, instanceIDs []string) *ec2.Reservation {
+	reservation := &ec2.Reservation{ReservationId: aws.String(reservationID), OwnerId: aws.String(DefaultOwnerID)}
+	for _, id := range instanceIDs {
+		if i, ok := e.instances[id]; ok {
+			reservation.Instances = append(reservation.Instances, copyOf(i.Instance).(*ec2.Instance))
+		}
+	}
+	return reservation
+}
+
+// TerminateInstancesWithContext moves the given instances to shutting-down, they are terminated after the
+// ShuttingDown duration of the lifecycle
+func (e *EC2) TerminateInstancesWithContext(ctx aws.Context, input *ec2.TerminateInstancesInput, opts ...request.Option) (*ec2.TerminateInstancesOutput, error) {
+	if err := e.begin(ctx, "TerminateInstances", input); err != nil {
+		return nil, err
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.advance()
+
+	if len(input.InstanceIds) == 0 {
+		return nil, missingParameter("InstanceIds")
+	}
+	if _, err := e.selectInstances(input.InstanceIds, nil, false); err != nil {
+		return nil, err
+	}
+
+	output := &ec2.TerminateInstancesOutput{}
+	for _, id := range aws.StringValueSlice(input.InstanceIds) {
+		i := e.instances[id]
+		previous := copyOf(i.State).(*ec2.InstanceState)
+		switch aws.StringValue(i.State.Name) {
+		case ec2.InstanceStateNameShuttingDown, ec2.InstanceStateNameTerminated:
+		default:
+			e.shutDown(i, stateReasonUserInitiated, "User initiated shutdown")
+		}
+		output.TerminatingInstances = append(output.TerminatingInstances, &ec2.InstanceStateChange{
+			InstanceId:    aws.String(id),
+			PreviousState: previous,
+			CurrentState:  copyOf(i.State).(*ec2.InstanceState),
+		})
+	}
+	return output, nil
+}
+
+// TerminateInstances terminates instances, see TerminateInstancesWithContext
+func (e *EC2) TerminateInstances(input *ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error) {
+	return e.TerminateInstancesWithContext(aws.BackgroundContext(), input)
+}
+
+// StopInstancesWithContext moves the given instances to stopping, they are stopped after the Stopping duration
+// of the lifecycle
+func (e *EC2) StopInstancesWithContext(ctx aws.Context, input *ec2.StopInstancesInput, opts ...request.Option) (*ec2.StopInstancesOutput, error) {
+	if err := e.begin(ctx, "StopInstances", input); err != nil {
+		return nil, err
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.advance()
+
+	if len(input.InstanceIds) == 0 {
+		return nil, missingParameter("InstanceIds")
+	}
+	if _, err := e.selectInstances(input.InstanceIds, nil, false); err != nil {
+		return nil, err
+	}
+	for _, id := range aws.StringValueSlice(input.InstanceIds) {
+		switch aws.StringValue(e.instances[id].State.Name) {
+		case ec2.InstanceStateNameShuttingDown, ec2.InstanceStateNameTerminated:
+			return nil, NewError(ErrCodeIncorrectInstanceState, fmt.Sprintf("This instance '%s' is not in a state from which it can be stopped.", id))
+		}
+	}
+
+	output := &ec2.StopInstancesOutput{}
+	for _, id := range aws.StringValueSlice(input.InstanceIds) {
+		i := e.instances[id]
+		previous := copyOf(i.State).(*ec2.InstanceState)
+		switch aws.StringValue(i.State.Name) {
+		case ec2.InstanceStateNamePending, ec2.InstanceStateNameRunning:
+			setState(i.Instance, ec2.InstanceStateNameStopping)
+			i.setStateReason(stateReasonUserInitiated, "User initiated shutdown", e.now())
+			i.transitionAt = e.now().Add(e.lifecycle.Stopping)
+		}
+		output.StoppingInstances = append(output.StoppingInstances, &ec2.InstanceStateChange{
+			InstanceId:    aws.String(id),
+			PreviousState: previous,
+			CurrentState:  copyOf(i.State).(*ec2.InstanceState),
+		})
+	}
+	return output, nil
+}
+
+// StopInstances stops instances, see StopInstancesWithContext
+func (e *EC2) StopInstances(input *ec2.StopInstancesInput) (*ec2.StopInstancesOutput, error) {
+	return e.StopInstancesWithContext(aws.BackgroundContext(), input)
+}
+
+// WaitUntilInstanceTerminatedWithContext waits until all given instances are terminated, like the waiter of the SDK.
+// It fails as soon as an instance is pending or stopping.
+func (e *EC2) WaitUntilInstanceTerminatedWithContext(ctx aws.Context, input *ec2.DescribeInstancesInput, opts ...request.WaiterOption) error {
+	w := request.Waiter{
+		Name:        "WaitUntilInstanceTerminated",
+		MaxAttempts: defaultWaiterMaxAttempts,
+		Delay:       request.ConstantWaiterDelay(e.waiterDelay),
+	}
+	w.ApplyOptions(opts...)
+
+	for attempt := 1; ; attempt++ {
+		output, err := e.DescribeInstancesWithContext(ctx, input)
+		if err != nil {
+			return err
+		}
+
+		terminated := true
+		for _, reservation := range output.Reservations {
+			for _, i := range reservation.Instances {
+				switch aws.StringValue(i.State.Name) {
+				case ec2.InstanceStateNameTerminated:
+				case ec2.InstanceStateNamePending, ec2.InstanceStateNameStopping:
+					return awserr.New(request.WaiterResourceNotReadyErrorCode, "failed waiting for successful resource state", nil)
+				default:
+					terminated = false
+				}
+			}
+		}
+		if terminated {
+			return nil
+		}
+		if attempt >= w.MaxAttempts {
+			return awserr.New(request.WaiterResourceNotReadyErrorCode, "exceeded wait attempts", nil)
+		}
+		if err := aws.SleepWithContext(ctx, w.Delay(attempt)); err != nil {
+			return awserr.New(request.CanceledErrorCode, "waiter context canceled", err)
+		}
+	}
+}
+
+// WaitUntilInstanceTerminated waits until instances are terminated, see WaitUntilInstanceTerminatedWithContext
+func (e *EC2) WaitUntilInstanceTerminated(input *ec2.DescribeInstancesInput) error {
+	return e.WaitUntilInstanceTerminatedWithContext(aws.BackgroundContext(), input)
+}
+
+// DescribeInstanceStatusWithContext describes the status of the selected instances, only running instances are
+// described unless IncludeAllInstances is set
+func (e *EC2) DescribeInstanceStatusWithContext(ctx aws.Context, input *ec2.DescribeInstanceStatusInput, opts ...request.Option) (*ec2.DescribeInstanceStatusOutput, error) {
+	if err := e.begin(ctx, "DescribeInstanceStatus", input); err != nil {
+		return nil, err
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.advance()
+
+	if len(input.InstanceIds) > 0 && input.MaxResults != nil {
+		return nil, NewError(ErrCodeInvalidParameterCombination, "The parameter instancesSet cannot be used with the parameter maxResults")
+	}
+	ids, err := e.selectInstances(input.InstanceIds, nil, !aws.BoolValue(input.IncludeAllInstances))
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []*ec2.InstanceStatus
+	var matchingIDs []string
+	for _, id := range ids {
+		status := e.instances[id].instanceStatus()
+		matches, err := matchesFilters(input.Filters, nil, statusAttribute(status))
+		if err != nil {
+			return nil, err
+		}
+		if matches {
+			statuses = append(statuses, status)
+			matchingIDs = append(matchingIDs, id)
+		}
+	}
+
+	start, end, nextToken, err := page(matchingIDs, input.MaxResults, input.NextToken, 5, 1000)
+	if err != nil {
+		return nil, err
+	}
+	return &ec2.DescribeInstanceStatusOutput{InstanceStatuses: statuses[start:end], NextToken: nextToken}, nil
+}
+
+// DescribeInstanceStatus describes the status of instances, see DescribeInstanceStatusWithContext
+func (e *EC2) DescribeInstanceStatus(input *ec2.DescribeInstanceStatusInput) (*ec2.DescribeInstanceStatusOutput, error) {
+	return e.DescribeInstanceStatusWithContext(aws.BackgroundContext(), input)
+}
+
+// DescribeInstanceStatusPagesWithContext iterates over the pages of DescribeInstanceStatusWithContext
+func (e *EC2) DescribeInstanceStatusPagesWithContext(ctx aws.Context, input *ec2.DescribeInstanceStatusInput, fn func(*ec2.DescribeInstanceStatusOutput, bool) bool, opts ...request.Option) error {
+	pageInput := *input
+	for {
+		output, err := e.DescribeInstanceStatusWithContext(ctx, &pageInput, opts...)
+		if err != nil {
+			return err
+		}
+		lastPage := output.NextToken == nil
+		if !fn(output, lastPage) || lastPage {
+			return nil
+		}
+		pageInput.NextToken = output.NextToken
+	}
+}
+
+// DescribeInstanceStatusPages iterates over the pages of DescribeInstanceStatus
+func (e *EC2) DescribeInstanceStatusPages(input *ec2.DescribeInstanceStatusInput, fn func(*ec2.DescribeInstanceStatusOutput, bool) bool) error {
+	return e.DescribeInstanceStatusPagesWithContext(aws.BackgroundContext(), input, fn)
+}
+
+// instanceStatus returns the status of the instance. Running instances pass their status checks unless
+// SetInstanceStatus reported otherwise.
+func (i *instance) instanceStatus() *ec2.InstanceStatus {
+	summary := func(status string) *ec2.InstanceStatusSummary {
+		s := &ec2.InstanceStatusSummary{Status: aws.String(status)}
+		if status == ec2.SummaryStatusOk {
+			s.Details = []*ec2.InstanceStatusDetails{{Name: aws.String(ec2.StatusNameReachability), Status: aws.String(ec2.StatusTypePassed)}}
+		}
+		return s
+	}
+
+	status := &ec2.InstanceStatus{
+		AvailabilityZone: i.Placement.AvailabilityZone,
+		InstanceId:       i.InstanceId,
+		InstanceState:    copyOf(i.State).(*ec2.InstanceState),
+		InstanceStatus:   summary(ec2.SummaryStatusNotApplicable),
+		SystemStatus:     summary(ec2.SummaryStatusNotApplicable),
+	}
+	if aws.StringValue(i.State.Name) == ec2.InstanceStateNameRunning {
+		status.InstanceStatus = summary(ec2.SummaryStatusOk)
+		status.SystemStatus = summary(ec2.SummaryStatusOk)
+	}
+	if i.status != nil {
+		if i.status.InstanceStatus != nil {
+			status.InstanceStatus = copyOf(i.status.InstanceStatus).(*ec2.InstanceStatusSummary)
+		}
+		if i.status.SystemStatus != nil {
+			status.SystemStatus = copyOf(i.status.SystemStatus).(*ec2.InstanceStatusSummary)
+		}
+		for _, event := range i.status.Events {
+			status.Events = append(status.Events, copyOf(event).(*ec2.InstanceStatusEvent))
+		}
+	}
+	return status
+}
+
+// statusAttribute returns the attributes of an instance status for the DescribeInstanceStatus filters
+func statusAttribute(status *ec2.InstanceStatus) attributes {
+	details := func(summary *ec2.InstanceStatusSummary) []string {
+		var values []string
+		for _, detail := range summary.Details {
+			values = append(values, aws.StringValue(detail.Status))
+		}
+		return values
+	}
+
+	return func(name string) ([]string, bool) {
+		switch name {
+		case "availability-zone":
+			return single(aws.StringValue(status.AvailabilityZone)), true
+		case "instance-state-name":
+			return single(aws.StringValue(status.InstanceState.Name)), true
+		case "instance-state-code":
+			return single(fmt.Sprint(aws.Int64Value(status.InstanceState.Code))), true
+		case "instance-status.status":
+			return single(aws.StringValue(status.InstanceStatus.Status)), true
+		case "instance-status.reachability":
+			return details(status.InstanceStatus), true
+		case "system-status.status":
+			return single(aws.StringValue(status.SystemStatus.Status)), true
+		case "system-status.reachability":
+			return details(status.SystemStatus), true
+		case "event.code":
+			var values []string
+			for _, event := range status.Events {
+				values = append(values, aws.StringValue(event.Code))
+			}
+			return values, true
+		}
+		return nil, false
+	}
+}
+
+// GetConsoleOutputWithContext returns the base64 encoded console output of an instance set by SetConsoleOutput
+func (e *EC2) GetConsoleOutputWithContext(ctx aws.Context, input *ec2.GetConsoleOutputInput, opts ...request.Option) (*ec2.GetConsoleOutputOutput, error) {
+	if err := e.begin(ctx, "GetConsoleOutput", input); err != nil {
+		return nil, err
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.advance()
+
+	if input.InstanceId == nil {
+		return nil, missingParameter("InstanceId")
+	}
+	if _, err := e.selectInstances([]*string{input.InstanceId}, nil, false); err != nil {
+		return nil, err
+	}
+
+	output := &ec2.GetConsoleOutputOutput{InstanceId: input.InstanceId, Timestamp: aws.Time(e.now())}
+	if consoleOutput := e.instances[*input.InstanceId].consoleOutput; consoleOutput != "" {
+		output.Output = aws.String(base64.StdEncoding.EncodeToString([]byte(consoleOutput)))
+	}
+	return output, nil
+}
+
+// GetConsoleOutput returns the console output of an instance, see GetConsoleOutputWithContext
+func (e *EC2) GetConsoleOutput(input *ec2.GetConsoleOutputInput) (*ec2.GetConsoleOutputOutput, error) {
+	return e.GetConsoleOutputWithContext(aws.BackgroundContext(), input)
+}
+
+// advance moves all instances whose transitional state has ended to their next state. It must be called with the
+// mutex held, before serving a call.
+func (e *EC2) advance() {
+	now := e.now()
+	for id, i := range e.instances {
+		for !now.Before(i.transitionAt) && e.transition(id, i) {
+		}
+	}
+}
+
+// transition moves an instance whose transitional state has ended to its next state, and returns false if the
+// instance has no next state
+func (e *EC2) transition(id string, i *instance) bool {
+	switch aws.StringValue(i.State.Name) {
+	case ec2.InstanceStateNamePending:
+		setState(i.Instance, ec2.InstanceStateNameRunning)
+	case ec2.InstanceStateNameStopping:
+		setState(i.Instance, ec2.InstanceStateNameStopped)
+		return false
+	case ec2.InstanceStateNameShuttingDown:
+		setState(i.Instance, ec2.InstanceStateNameTerminated)
+		e.release(i)
+		if e.lifecycle.TerminatedRetention <= 0 {
+			return false
+		}
+		i.transitionAt = i.transitionAt.Add(e.lifecycle.TerminatedRetention)
+	case ec2.InstanceStateNameTerminated:
+		if e.lifecycle.TerminatedRetention > 0 {
+			delete(e.instances, id)
+		}
+		return false
+	default:
+		return false
+	}
+	return true
+}
+
+// shutDown moves an instance to shutting-down for the given reason
+func (e *EC2) shutDown(i *instance, code, message string) {
+	setState(i.Instance, ec2.InstanceStateNameShuttingDown)
+	i.setStateReason(code, message, e.now())
+	i.transitionAt = e.now().Add(e.lifecycle.ShuttingDown)
+}
+
+// release deletes or detaches the volumes and network interfaces of a terminated instance according to their
+// DeleteOnTermination flags
+func (e *EC2) release(i *instance) {
+	for _, mapping := range i.BlockDeviceMappings {
+		id := aws.StringValue(mapping.Ebs.VolumeId)
+		if aws.BoolValue(mapping.Ebs.DeleteOnTermination) {
+			delete(e.volumes, id)
+		} else if volume, ok := e.volumes[id]; ok {
+			volume.State = aws.String(ec2.VolumeStateAvailable)
+			volume.Attachments = nil
+		}
+	}
+	for _, instanceNetworkInterface := range i.NetworkInterfaces {
+		id := aws.StringValue(instanceNetworkInterface.NetworkInterfaceId)
+		if aws.BoolValue(instanceNetworkInterface.Attachment.DeleteOnTermination) {
+			delete(e.networkInterfaces, id)
+		} else if networkInterface, ok := e.networkInterfaces[id]; ok {
+			networkInterface.Status = aws.String(ec2.NetworkInterfaceStatusAvailable)
+			networkInterface.Attachment = nil
+		}
+	}
+
+	i.BlockDeviceMappings = []*ec2.InstanceBlockDeviceMapping{}
+	i.NetworkInterfaces = nil
+	i.PrivateDnsName = aws.String("")
+	i.PrivateIpAddress = nil
+	i.SubnetId = nil
+}
+
+// setState sets the state name and code of an instance
+func setState(i *ec2.Instance, name string) {
+	i.State = &ec2.InstanceState{Name: aws.String(name), Code: aws.Int64(stateCodes[name])}
+}
+
+// setStateReason sets the reason of the last state transition of an instance
+func (i *instance) setStateReason(code, message string, now time.Time) {
+	i.StateReason = &ec2.StateReason{Code: aws.String(code), Message: aws.String(code + ": " + message)}
+	i.StateTransitionReason = aws.String(fmt.Sprintf("%s (%s)", message, now.UTC().Format("2006-01-02 15:04:05 MST")))
+}
+
+// missingParameter returns the error of EC2 for a call without a required parameter
+func missingParameter(name string) error {
+	return NewError(ErrCodeMissingParameter, fmt.Sprintf("The request must contain the parameter %s", name))
+}
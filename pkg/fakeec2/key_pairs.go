@@ -0,0 +1,73 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fakeec2
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// AddKeyPair adds a key pair with the given name and returns its ID
+func (e *EC2) AddKeyPair(name string) string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	id := e.nextID("key")
+	e.keyPairs[name] = &ec2.KeyPairInfo{
+		KeyName:        aws.String(name),
+		KeyPairId:      aws.String(id),
+		KeyFingerprint: aws.String(fmt.Sprintf("%040x", e.sequence)),
+	}
+	return id
+}
+
+// DescribeKeyPairsWithContext describes the key pairs selected by their names
+func (e *EC2) DescribeKeyPairsWithContext(ctx aws.Context, input *ec2.DescribeKeyPairsInput, opts ...request.Option) (*ec2.DescribeKeyPairsOutput, error) {
+	if err := e.begin(ctx, "DescribeKeyPairs", input); err != nil {
+		return nil, err
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	hasKeyPair := func(name string) bool {
+		_, ok := e.keyPairs[name]
+		return ok
+	}
+	if err := checkIDs(input.KeyNames, hasKeyPair, ErrCodeInvalidKeyPairNotFound, "key pair"); err != nil {
+		return nil, err
+	}
+
+	names := aws.StringValueSlice(input.KeyNames)
+	if len(names) == 0 {
+		for name := range e.keyPairs {
+			names = append(names, name)
+		}
+	}
+
+	output := &ec2.DescribeKeyPairsOutput{}
+	for _, name := range sortedKeys(names) {
+		if len(output.KeyPairs) == 0 || *output.KeyPairs[len(output.KeyPairs)-1].KeyName != name {
+			output.KeyPairs = append(output.KeyPairs, copyOf(e.keyPairs[name]).(*ec2.KeyPairInfo))
+		}
+	}
+	return output, nil
+}
+
+// DescribeKeyPairs describes key pairs, see DescribeKeyPairsWithContext
+func (e *EC2) DescribeKeyPairs(input *ec2.DescribeKeyPairsInput) (*ec2.DescribeKeyPairsOutput, error) {
+	return e.DescribeKeyPairsWithContext(aws.BackgroundContext(), input)
+}
@@ -0,0 +1,258 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fakeec2
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// AddNetworkInterface adds an available network interface, e.g. one left behind by a deleted instance, and returns
+// its ID. Missing ID, status, availability zone and private address are defaulted.
+func (e *EC2) AddNetworkInterface(networkInterface *ec2.NetworkInterface) string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	n := copyOf(networkInterface).(*ec2.NetworkInterface)
+	defaults := e.newNetworkInterface(n.SubnetId, aws.StringValue(n.AvailabilityZone), n.Description, nil)
+	if n.NetworkInterfaceId == nil {
+		n.NetworkInterfaceId = defaults.NetworkInterfaceId
+	}
+	if n.Status == nil {
+		n.Status = aws.String(ec2.NetworkInterfaceStatusAvailable)
+	}
+	if n.AvailabilityZone == nil {
+		n.AvailabilityZone = defaults.AvailabilityZone
+	}
+	if n.PrivateIpAddress == nil {
+		n.PrivateIpAddress = defaults.PrivateIpAddress
+		n.PrivateDnsName = defaults.PrivateDnsName
+	}
+	if n.InterfaceType == nil {
+		n.InterfaceType = defaults.InterfaceType
+	}
+	if n.OwnerId == nil {
+		n.OwnerId = defaults.OwnerId
+	}
+	e.networkInterfaces[*n.NetworkInterfaceId] = n
+	return *n.NetworkInterfaceId
+}
+
+// NetworkInterfaces returns copies of all network interfaces
+func (e *EC2) NetworkInterfaces() []*ec2.NetworkInterface {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.advance()
+
+	var networkInterfaces []*ec2.NetworkInterface
+	for _, id := range e.networkInterfaceIDs() {
+		networkInterfaces = append(networkInterfaces, copyOf(e.networkInterfaces[id]).(*ec2.NetworkInterface))
+	}
+	return networkInterfaces
+}
+
+// newNetworkInterface returns a new network interface with the next private address, it isn't added to the fake
+func (e *EC2) newNetworkInterface(subnetID *string, availabilityZone string, description *string, groupIDs []*string) *ec2.NetworkInterface {
+	if availabilityZone == "" {
+		availabilityZone = e.region + "a"
+	}
+	if description == nil {
+		description = aws.String("")
+	}
+
+	// Skip the addresses reserved by AWS at the start of every subnet
+	e.addresses++
+	host := e.addresses + 3
+	ip := fmt.Sprintf("10.0.%d.%d", host/256%256, host%256)
+
+	networkInterface := &ec2.NetworkInterface{
+		NetworkInterfaceId: aws.String(e.nextID("eni")),
+		AvailabilityZone:   aws.String(availabilityZone),
+		Description:        description,
+		InterfaceType:      aws.String(ec2.NetworkInterfaceTypeInterface),
+		OwnerId:            aws.String(DefaultOwnerID),
+		PrivateIpAddress:   aws.String(ip),
+		PrivateDnsName:     aws.String(fmt.Sprintf("ip-%s.%s.compute.internal", strings.Replace(ip, ".", "-", -1), e.region)),
+		Status:             aws.String(ec2.NetworkInterfaceStatusAvailable),
+		SubnetId:           subnetID,
+	}
+	for _, id := range groupIDs {
+		networkInterface.Groups = append(networkInterface.Groups, &ec2.GroupIdentifier{GroupId: id})
+	}
+	return networkInterface
+}
+
+// DescribeNetworkInterfacesWithContext describes the network interfaces selected by their IDs and filters
+func (e *EC2) DescribeNetworkInterfacesWithContext(ctx aws.Context, input *ec2.DescribeNetworkInterfacesInput, opts ...request.Option) (*ec2.DescribeNetworkInterfacesOutput, error) {
+	if err := e.begin(ctx, "DescribeNetworkInterfaces", input); err != nil {
+		return nil, err
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.advance()
+
+	if len(input.NetworkInterfaceIds) > 0 && input.MaxResults != nil {
+		return nil, NewError(ErrCodeInvalidParameterCombination, "The parameter networkInterfaceIdSet cannot be used with the parameter maxResults")
+	}
+	if err := checkIDs(input.NetworkInterfaceIds, e.hasNetworkInterface, ErrCodeInvalidNetworkInterfaceIDNotFound, "networkInterface ID"); err != nil {
+		return nil, err
+	}
+
+	candidates := aws.StringValueSlice(input.NetworkInterfaceIds)
+	if len(candidates) == 0 {
+		candidates = e.networkInterfaceIDs()
+	}
+	var ids []string
+	for _, id := range sortedKeys(candidates) {
+		networkInterface := e.networkInterfaces[id]
+		matches, err := matchesFilters(input.Filters, networkInterface.TagSet, networkInterfaceAttribute(networkInterface))
+		if err != nil {
+			return nil, err
+		}
+		if matches && (len(ids) == 0 || ids[len(ids)-1] != id) {
+			ids = append(ids, id)
+		}
+	}
+
+	start, end, nextToken, err := page(ids, input.MaxResults, input.NextToken, 5, 1000)
+	if err != nil {
+		return nil, err
+	}
+	output := &ec2.DescribeNetworkInterfacesOutput{NextToken: nextToken}
+	for _, id := range ids[start:end] {
+		output.NetworkInterfaces = append(output.NetworkInterfaces, copyOf(e.networkInterfaces[id]).(*ec2.NetworkInterface))
+	}
+	return output, nil
+}
+
+// DescribeNetworkInterfaces describes network interfaces, see DescribeNetworkInterfacesWithContext
+func (e *EC2) DescribeNetworkInterfaces(input *ec2.DescribeNetworkInterfacesInput) (*ec2.DescribeNetworkInterfacesOutput, error) {
+	return e.DescribeNetworkInterfacesWithContext(aws.BackgroundContext(), input)
+}
+
+// DescribeNetworkInterfacesPagesWithContext iterates over the pages of DescribeNetworkInterfacesWithContext
+func (e *EC2) DescribeNetworkInterfacesPagesWithContext(ctx aws.Context, input *ec2.DescribeNetworkInterfacesInput, fn func(*ec2.DescribeNetworkInterfacesOutput, bool) bool, opts ...request.Option) error {
+	pageInput := *input
+	for {
+		output, err := e.DescribeNetworkInterfacesWithContext(ctx, &pageInput, opts...)
+		if err != nil {
+			return err
+		}
+		lastPage := output.NextToken == nil
+		if !fn(output, lastPage) || lastPage {
+			return nil
+		}
+		pageInput.NextToken = output.NextToken
+	}
+}
+
+// DescribeNetworkInterfacesPages iterates over the pages of DescribeNetworkInterfaces
+func (e *EC2) DescribeNetworkInterfacesPages(input *ec2.DescribeNetworkInterfacesInput, fn func(*ec2.DescribeNetworkInterfacesOutput, bool) bool) error {
+	return e.DescribeNetworkInterfacesPagesWithContext(aws.BackgroundContext(), input, fn)
+}
+
+// DeleteNetworkInterfaceWithContext deletes an available network interface
+func (e *EC2) DeleteNetworkInterfaceWithContext(ctx aws.Context, input *ec2.DeleteNetworkInterfaceInput, opts ...request.Option) (*ec2.DeleteNetworkInterfaceOutput, error) {
+	if err := e.begin(ctx, "DeleteNetworkInterface", input); err != nil {
+		return nil, err
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.advance()
+
+	if input.NetworkInterfaceId == nil {
+		return nil, missingParameter("NetworkInterfaceId")
+	}
+	networkInterface, ok := e.networkInterfaces[*input.NetworkInterfaceId]
+	if !ok {
+		return nil, NewError(ErrCodeInvalidNetworkInterfaceIDNotFound, fmt.Sprintf("The networkInterface ID '%s' does not exist", *input.NetworkInterfaceId))
+	}
+	if networkInterface.Attachment != nil {
+		return nil, NewError(ErrCodeInvalidNetworkInterfaceInUse, fmt.Sprintf("Interface: %s in use.", *input.NetworkInterfaceId))
+	}
+	delete(e.networkInterfaces, *input.NetworkInterfaceId)
+	return &ec2.DeleteNetworkInterfaceOutput{}, nil
+}
+
+// DeleteNetworkInterface deletes a network interface, see DeleteNetworkInterfaceWithContext
+func (e *EC2) DeleteNetworkInterface(input *ec2.DeleteNetworkInterfaceInput) (*ec2.DeleteNetworkInterfaceOutput, error) {
+	return e.DeleteNetworkInterfaceWithContext(aws.BackgroundContext(), input)
+}
+
+func (e *EC2) hasNetworkInterface(id string) bool {
+	_, ok := e.networkInterfaces[id]
+	return ok
+}
+
+func (e *EC2) networkInterfaceIDs() []string {
+	var ids []string
+	for id := range e.networkInterfaces {
+		ids = append(ids, id)
+	}
+	return sortedKeys(ids)
+}
+
+// networkInterfaceAttribute returns the attributes of a network interface for the DescribeNetworkInterfaces filters
+func networkInterfaceAttribute(networkInterface *ec2.NetworkInterface) attributes {
+	attachment := func(value func(*ec2.NetworkInterfaceAttachment) string) []string {
+		if networkInterface.Attachment == nil {
+			return nil
+		}
+		return single(value(networkInterface.Attachment))
+	}
+
+	return func(name string) ([]string, bool) {
+		switch name {
+		case "network-interface-id":
+			return single(aws.StringValue(networkInterface.NetworkInterfaceId)), true
+		case "status":
+			return single(aws.StringValue(networkInterface.Status)), true
+		case "subnet-id":
+			return single(aws.StringValue(networkInterface.SubnetId)), true
+		case "vpc-id":
+			return single(aws.StringValue(networkInterface.VpcId)), true
+		case "availability-zone":
+			return single(aws.StringValue(networkInterface.AvailabilityZone)), true
+		case "description":
+			return single(aws.StringValue(networkInterface.Description)), true
+		case "interface-type":
+			return single(aws.StringValue(networkInterface.InterfaceType)), true
+		case "private-ip-address":
+			return single(aws.StringValue(networkInterface.PrivateIpAddress)), true
+		case "private-dns-name":
+			return single(aws.StringValue(networkInterface.PrivateDnsName)), true
+		case "group-id":
+			var values []string
+			for _, group := range networkInterface.Groups {
+				values = append(values, aws.StringValue(group.GroupId))
+			}
+			return values, true
+		case "attachment.instance-id":
+			return attachment(func(a *ec2.NetworkInterfaceAttachment) string { return aws.StringValue(a.InstanceId) }), true
+		case "attachment.attachment-id":
+			return attachment(func(a *ec2.NetworkInterfaceAttachment) string { return aws.StringValue(a.AttachmentId) }), true
+		case "attachment.status":
+			return attachment(func(a *ec2.NetworkInterfaceAttachment) string { return aws.StringValue(a.Status) }), true
+		case "attachment.delete-on-termination":
+			return attachment(func(a *ec2.NetworkInterfaceAttachment) string {
+				return fmt.Sprint(aws.BoolValue(a.DeleteOnTermination))
+			}), true
+		}
+		return nil, false
+	}
+}
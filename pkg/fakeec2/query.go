@@ -0,0 +1,228 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fakeec2
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// iso8601 is the timestamp format of the EC2 Query protocol
+const iso8601 = "2006-01-02T15:04:05.999999999Z"
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// decodeQuery sets the fields of an SDK input structure from the parameters of an EC2 Query request. It is the
+// inverse of the serialization of the SDK: lists are flattened into numbered parameters and nested structures
+// are prefixed with the name of their field, e.g. Filter.1.Value.2.
+func decodeQuery(values url.Values, value reflect.Value, prefix string) error {
+	t := value.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" || field.Tag.Get("ignore") != "" {
+			continue
+		}
+
+		name := queryName(field)
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		if err := decodeQueryValue(values, value.Field(i), name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryName returns the name of the query parameter of a field, following the naming rules of the SDK for EC2
+func queryName(field reflect.StructField) string {
+	name := field.Tag.Get("queryName")
+	if name == "" {
+		if field.Tag.Get("flattened") != "" && field.Tag.Get("locationNameList") != "" {
+			name = field.Tag.Get("locationNameList")
+		} else {
+			name = field.Tag.Get("locationName")
+		}
+		if name != "" {
+			name = strings.ToUpper(name[0:1]) + name[1:]
+		}
+	}
+	if name == "" {
+		name = field.Name
+	}
+	return name
+}
+
+func decodeQueryValue(values url.Values, value reflect.Value, name string) error {
+	switch value.Kind() {
+	case reflect.Ptr:
+		elem := value.Type().Elem()
+		if elem.Kind() == reflect.Struct && elem != timeType {
+			if !hasParameterPrefix(values, name+".") {
+				return nil
+			}
+			value.Set(reflect.New(elem))
+			return decodeQuery(values, value.Elem(), name)
+		}
+
+		raw, ok := values[name]
+		if !ok {
+			return nil
+		}
+		scalar, err := parseScalar(elem, raw[0])
+		if err != nil {
+			return NewError(ErrCodeInvalidParameterValue, fmt.Sprintf("Invalid value '%s' for %s", raw[0], name))
+		}
+		ptr := reflect.New(elem)
+		ptr.Elem().Set(scalar)
+		value.Set(ptr)
+
+	case reflect.Slice:
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			raw, ok := values[name]
+			if !ok {
+				return nil
+			}
+			blob, err := base64.StdEncoding.DecodeString(raw[0])
+			if err != nil {
+				return NewError(ErrCodeInvalidParameterValue, fmt.Sprintf("Invalid base64 value for %s", name))
+			}
+			value.SetBytes(blob)
+			return nil
+		}
+
+		for n := 1; ; n++ {
+			itemName := name + "." + strconv.Itoa(n)
+			if _, ok := values[itemName]; !ok && !hasParameterPrefix(values, itemName+".") {
+				return nil
+			}
+			item := reflect.New(value.Type().Elem()).Elem()
+			if err := decodeQueryValue(values, item, itemName); err != nil {
+				return err
+			}
+			value.Set(reflect.Append(value, item))
+		}
+	}
+	return nil
+}
+
+// hasParameterPrefix returns true if any query parameter starts with the given prefix
+func hasParameterPrefix(values url.Values, prefix string) bool {
+	for key := range values {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseScalar(t reflect.Type, raw string) (reflect.Value, error) {
+	switch {
+	case t == timeType:
+		parsed, err := time.Parse(iso8601, raw)
+		return reflect.ValueOf(parsed), err
+	case t.Kind() == reflect.String:
+		return reflect.ValueOf(raw).Convert(t), nil
+	case t.Kind() == reflect.Int64:
+		parsed, err := strconv.ParseInt(raw, 10, 64)
+		return reflect.ValueOf(parsed), err
+	case t.Kind() == reflect.Float64:
+		parsed, err := strconv.ParseFloat(raw, 64)
+		return reflect.ValueOf(parsed), err
+	case t.Kind() == reflect.Bool:
+		parsed, err := strconv.ParseBool(raw)
+		return reflect.ValueOf(parsed), err
+	}
+	return reflect.Value{}, fmt.Errorf("unsupported type %s", t)
+}
+
+// encodeXML writes the fields of an SDK output structure as XML elements named by their locationName tags, the
+// way EC2 does. Lists are wrapped into an element whose members are named by the locationNameList tag.
+func encodeXML(encoder *xml.Encoder, value reflect.Value) error {
+	t := value.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" || field.Name == "_" {
+			continue
+		}
+
+		name := field.Tag.Get("locationName")
+		if name == "" {
+			name = strings.ToLower(field.Name[0:1]) + field.Name[1:]
+		}
+		if err := encodeXMLValue(encoder, name, value.Field(i), field.Tag.Get("locationNameList")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeXMLValue(encoder *xml.Encoder, name string, value reflect.Value, memberName string) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+
+	switch value.Kind() {
+	case reflect.Ptr:
+		if value.IsNil() {
+			return nil
+		}
+		elem := value.Elem()
+		if elem.Kind() == reflect.Struct && elem.Type() != timeType {
+			if err := encoder.EncodeToken(start); err != nil {
+				return err
+			}
+			if err := encodeXML(encoder, elem); err != nil {
+				return err
+			}
+			return encoder.EncodeToken(start.End())
+		}
+		return encoder.EncodeElement(formatScalar(elem), start)
+
+	case reflect.Slice:
+		if value.IsNil() {
+			return nil
+		}
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			return encoder.EncodeElement(base64.StdEncoding.EncodeToString(value.Bytes()), start)
+		}
+		if memberName == "" {
+			memberName = "item"
+		}
+		if err := encoder.EncodeToken(start); err != nil {
+			return err
+		}
+		for i := 0; i < value.Len(); i++ {
+			if err := encodeXMLValue(encoder, memberName, value.Index(i), ""); err != nil {
+				return err
+			}
+		}
+		return encoder.EncodeToken(start.End())
+	}
+	return nil
+}
+
+func formatScalar(value reflect.Value) string {
+	switch v := value.Interface().(type) {
+	case time.Time:
+		return v.UTC().Format(iso8601)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(value.Interface())
+}
@@ -0,0 +1,247 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fakeec2
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+const (
+	// xmlNamespace is the namespace of the EC2 API version of the SDK
+	xmlNamespace = "http://ec2.amazonaws.com/doc/2016-11-15/"
+	// iamXMLNamespace is the namespace of the IAM API version of the SDK
+	iamXMLNamespace = "https://iam.amazonaws.com/doc/2010-05-08/"
+
+	errCodeAuthFailure   = "AuthFailure"
+	errCodeInvalidAction = "InvalidAction"
+	errCodeInternalError = "InternalError"
+)
+
+// serverActions are the EC2 actions served by the Server
+var serverActions = map[string]bool{
+	"RunInstances":                  true,
+	"DescribeInstances":             true,
+	"TerminateInstances":            true,
+	"StopInstances":                 true,
+	"DescribeInstanceStatus":        true,
+	"GetConsoleOutput":              true,
+	"DescribeImages":                true,
+	"DescribeSubnets":               true,
+	"DescribeSecurityGroups":        true,
+	"DescribeKeyPairs":              true,
+	"DescribeInstanceTypes":         true,
+	"DescribeInstanceTypeOfferings": true,
+	"DescribeVolumes":               true,
+	"DeleteVolume":                  true,
+	"DescribeNetworkInterfaces":     true,
+	"DeleteNetworkInterface":        true,
+	"CreateTags":                    true,
+	"DeleteTags":                    true,
+}
+
+// credentialScope matches the region and service in the credential scope of a signature version 4 Authorization header
+var credentialScope = regexp.MustCompile(`Credential=[^/]+/[^/]+/([^/]+)/([^/]+)/`)
+
+// Server serves in-memory EC2s over the EC2 Query protocol, so that the real serialization of the AWS SDK can be
+// tested offline by pointing the EC2 endpoint of a session at it. Requests are routed to the EC2 of the region
+// they are signed for, signatures are not verified. Requests signed for IAM are served by the IAM set with SetIAM.
+type Server struct {
+	regions  map[string]*EC2
+	iam      *IAM
+	requests int64
+}
+
+// NewServer returns a Server serving the given EC2s in their regions
+func NewServer(fakes ...*EC2) *Server {
+	s := &Server{regions: map[string]*EC2{}}
+	for _, fake := range fakes {
+		s.regions[fake.Region()] = fake
+	}
+	return s
+}
+
+// SetIAM sets the IAM serving the requests signed for IAM, so that the IAM endpoint of a session can point at the
+// server as well
+func (s *Server) SetIAM(fake *IAM) {
+	s.iam = fake
+}
+
+// ServeHTTP serves an EC2 Query request
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	requestID := fmt.Sprintf("00000000-0000-4000-8000-%012x", atomic.AddInt64(&s.requests, 1))
+	w.Header().Set("X-Amzn-Requestid", requestID)
+
+	if err := r.ParseForm(); err != nil {
+		writeError(w, requestID, NewRequestFailure(ErrCodeInvalidParameterValue, err.Error(), http.StatusBadRequest))
+		return
+	}
+
+	scope := credentialScope.FindStringSubmatch(r.Header.Get("Authorization"))
+	if scope == nil {
+		writeError(w, requestID, NewRequestFailure(errCodeAuthFailure, "AWS was not able to validate the provided access credentials", http.StatusUnauthorized))
+		return
+	}
+	if scope[2] == "iam" {
+		s.serveIAM(w, r, requestID)
+		return
+	}
+	fake, ok := s.regions[scope[1]]
+	if !ok {
+		writeError(w, requestID, NewRequestFailure(errCodeAuthFailure, fmt.Sprintf("AWS was not able to validate the provided access credentials for region %s", scope[1]), http.StatusUnauthorized))
+		return
+	}
+
+	action := r.Form.Get("Action")
+	if !serverActions[action] {
+		writeError(w, requestID, NewError(errCodeInvalidAction, fmt.Sprintf("The action %s is not valid for this web service.", action)))
+		return
+	}
+
+	method := reflect.ValueOf(fake).MethodByName(action + "WithContext")
+	input := reflect.New(method.Type().In(1).Elem())
+	if err := decodeQuery(r.Form, input.Elem(), ""); err != nil {
+		writeError(w, requestID, err)
+		return
+	}
+
+	results := method.Call([]reflect.Value{reflect.ValueOf(r.Context()), input})
+	if err, _ := results[1].Interface().(error); err != nil {
+		writeError(w, requestID, err)
+		return
+	}
+
+	var body bytes.Buffer
+	encoder := xml.NewEncoder(&body)
+	root := xml.StartElement{
+		Name: xml.Name{Local: action + "Response"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: xmlNamespace}},
+	}
+	err := encoder.EncodeToken(root)
+	if err == nil {
+		err = encoder.EncodeElement(requestID, xml.StartElement{Name: xml.Name{Local: "requestId"}})
+	}
+	if err == nil {
+		err = encodeXML(encoder, results[0].Elem())
+	}
+	if err == nil {
+		err = encoder.EncodeToken(root.End())
+	}
+	if err == nil {
+		err = encoder.Flush()
+	}
+	if err != nil {
+		writeError(w, requestID, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/xml;charset=UTF-8")
+	_, _ = w.Write([]byte(xml.Header))
+	_, _ = w.Write(body.Bytes())
+}
+
+// errorResponse is the body of the responses of failed EC2 Query requests
+type errorResponse struct {
+	XMLName   xml.Name `xml:"Response"`
+	Code      string   `xml:"Errors>Error>Code"`
+	Message   string   `xml:"Errors>Error>Message"`
+	RequestID string   `xml:"RequestID"`
+}
+
+// writeError writes the response of a failed request. API errors fail with their HTTP status code or 400,
+// other errors fail as internal errors.
+func writeError(w http.ResponseWriter, requestID string, err error) {
+	response := errorResponse{Code: errCodeInternalError, Message: err.Error(), RequestID: requestID}
+	statusCode := http.StatusInternalServerError
+	if awsErr, ok := err.(awserr.Error); ok {
+		response.Code = awsErr.Code()
+		response.Message = awsErr.Message()
+		statusCode = http.StatusBadRequest
+	}
+	if requestFailure, ok := err.(awserr.RequestFailure); ok {
+		statusCode = requestFailure.StatusCode()
+	}
+
+	w.Header().Set("Content-Type", "text/xml;charset=UTF-8")
+	w.WriteHeader(statusCode)
+	_, _ = w.Write([]byte(xml.Header))
+	_ = xml.NewEncoder(w).Encode(response)
+}
+
+// instanceProfileResponse is the body of the responses of GetInstanceProfile requests
+type instanceProfileResponse struct {
+	XMLName   xml.Name `xml:"GetInstanceProfileResponse"`
+	Namespace string   `xml:"xmlns,attr"`
+	Arn       string   `xml:"GetInstanceProfileResult>InstanceProfile>Arn"`
+	ID        string   `xml:"GetInstanceProfileResult>InstanceProfile>InstanceProfileId"`
+	Name      string   `xml:"GetInstanceProfileResult>InstanceProfile>InstanceProfileName"`
+	Path      string   `xml:"GetInstanceProfileResult>InstanceProfile>Path"`
+	Roles     string   `xml:"GetInstanceProfileResult>InstanceProfile>Roles"`
+	RequestID string   `xml:"ResponseMetadata>RequestId"`
+}
+
+// iamErrorResponse is the body of the responses of failed IAM Query requests
+type iamErrorResponse struct {
+	XMLName   xml.Name `xml:"ErrorResponse"`
+	Namespace string   `xml:"xmlns,attr"`
+	Type      string   `xml:"Error>Type"`
+	Code      string   `xml:"Error>Code"`
+	Message   string   `xml:"Error>Message"`
+	RequestID string   `xml:"RequestId"`
+}
+
+// serveIAM serves an IAM Query request, only GetInstanceProfile is supported
+func (s *Server) serveIAM(w http.ResponseWriter, r *http.Request, requestID string) {
+	w.Header().Set("Content-Type", "text/xml")
+
+	var (
+		response   interface{}
+		statusCode = http.StatusOK
+	)
+	action := r.Form.Get("Action")
+	if s.iam == nil || action != "GetInstanceProfile" {
+		response = iamErrorResponse{Namespace: iamXMLNamespace, Type: "Sender", Code: errCodeInvalidAction, Message: fmt.Sprintf("Could not find operation %s", action), RequestID: requestID}
+		statusCode = http.StatusBadRequest
+	} else if output, err := s.iam.GetInstanceProfileWithContext(r.Context(), &iam.GetInstanceProfileInput{InstanceProfileName: aws.String(r.Form.Get("InstanceProfileName"))}); err != nil {
+		response = iamErrorResponse{Namespace: iamXMLNamespace, Type: "Sender", Code: errCodeInternalError, Message: err.Error(), RequestID: requestID}
+		statusCode = http.StatusInternalServerError
+		if requestFailure, ok := err.(awserr.RequestFailure); ok {
+			response = iamErrorResponse{Namespace: iamXMLNamespace, Type: "Sender", Code: requestFailure.Code(), Message: requestFailure.Message(), RequestID: requestID}
+			statusCode = requestFailure.StatusCode()
+		}
+	} else {
+		profile := output.InstanceProfile
+		response = instanceProfileResponse{
+			Namespace: iamXMLNamespace,
+			Arn:       aws.StringValue(profile.Arn),
+			ID:        aws.StringValue(profile.InstanceProfileId),
+			Name:      aws.StringValue(profile.InstanceProfileName),
+			Path:      aws.StringValue(profile.Path),
+			RequestID: requestID,
+		}
+	}
+
+	w.WriteHeader(statusCode)
+	_, _ = w.Write([]byte(xml.Header))
+	_ = xml.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,232 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fakeec2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/iam"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server", func() {
+
+	var (
+		ctx    = context.Background()
+		now    time.Time
+		fake   *EC2
+		server *httptest.Server
+		client *ec2.EC2
+	)
+
+	newClient := func(region string) *ec2.EC2 {
+		return ec2.New(session.Must(session.NewSession(&aws.Config{
+			Region:      aws.String(region),
+			Endpoint:    aws.String(server.URL),
+			Credentials: credentials.NewStaticCredentials("dummy-id", "dummy-secret", ""),
+			MaxRetries:  aws.Int(0),
+		})))
+	}
+
+	BeforeEach(func() {
+		now = time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+		fake = New(WithClock(func() time.Time { return now }))
+		fake.AddImage(&ec2.Image{ImageId: aws.String("ami-123456789"), Name: aws.String("test-image")})
+		server = httptest.NewServer(NewServer(fake))
+		client = newClient(DefaultRegion)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should serve the calls of the driver through the SDK", func() {
+		images, err := client.DescribeImagesWithContext(ctx, &ec2.DescribeImagesInput{ImageIds: aws.StringSlice([]string{"ami-123456789"})})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(images.Images).To(HaveLen(1))
+		Expect(*images.Images[0].RootDeviceName).To(Equal("/dev/xvda"))
+
+		var instanceIDs []string
+		for _, name := range []string{"machine-0", "machine-1", "machine-2", "machine-3", "machine-4", "machine-5"} {
+			reservation, err := client.RunInstancesWithContext(ctx, &ec2.RunInstancesInput{
+				ImageId:      aws.String("ami-123456789"),
+				InstanceType: aws.String("m5.large"),
+				MinCount:     aws.Int64(1),
+				MaxCount:     aws.Int64(1),
+				UserData:     aws.String("dXNlci1kYXRh"),
+				NetworkInterfaces: []*ec2.InstanceNetworkInterfaceSpecification{{
+					DeviceIndex: aws.Int64(0),
+					SubnetId:    aws.String("subnet-123456"),
+					Groups:      aws.StringSlice([]string{"sg-1", "sg-2"}),
+				}},
+				BlockDeviceMappings: []*ec2.BlockDeviceMapping{{
+					DeviceName: aws.String("/dev/xvda"),
+					Ebs:        &ec2.EbsBlockDevice{VolumeSize: aws.Int64(50), DeleteOnTermination: aws.Bool(false)},
+				}},
+				TagSpecifications: []*ec2.TagSpecification{{
+					ResourceType: aws.String(ec2.ResourceTypeInstance),
+					Tags:         []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String(name)}},
+				}},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			instance := reservation.Instances[0]
+			Expect(*instance.State.Name).To(Equal(ec2.InstanceStateNamePending))
+			Expect(*instance.LaunchTime).To(Equal(now))
+			Expect(instance.Tags).To(ConsistOf(&ec2.Tag{Key: aws.String("Name"), Value: aws.String(name)}))
+			Expect(instance.NetworkInterfaces[0].Groups).To(HaveLen(2))
+			instanceIDs = append(instanceIDs, *instance.InstanceId)
+		}
+
+		var described []string
+		err = client.DescribeInstancesPagesWithContext(ctx, &ec2.DescribeInstancesInput{
+			MaxResults: aws.Int64(5),
+			Filters:    []*ec2.Filter{{Name: aws.String("tag:Name"), Values: aws.StringSlice([]string{"machine-*"})}},
+		}, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+			for _, reservation := range page.Reservations {
+				for _, instance := range reservation.Instances {
+					Expect(*instance.State.Name).To(Equal(ec2.InstanceStateNameRunning))
+					described = append(described, *instance.InstanceId)
+				}
+			}
+			return true
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(described).To(Equal(instanceIDs))
+		Expect(fake.Calls("DescribeInstances")).To(Equal(2))
+
+		Expect(fake.SetConsoleOutput(instanceIDs[0], "kernel panic")).To(Succeed())
+		consoleOutput, err := client.GetConsoleOutputWithContext(ctx, &ec2.GetConsoleOutputInput{InstanceId: aws.String(instanceIDs[0])})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*consoleOutput.Output).To(Equal("a2VybmVsIHBhbmlj"))
+
+		var statuses []*ec2.InstanceStatus
+		err = client.DescribeInstanceStatusPagesWithContext(ctx, &ec2.DescribeInstanceStatusInput{InstanceIds: aws.StringSlice(instanceIDs[:2])}, func(page *ec2.DescribeInstanceStatusOutput, lastPage bool) bool {
+			statuses = append(statuses, page.InstanceStatuses...)
+			return true
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(statuses).To(HaveLen(2))
+		Expect(*statuses[0].SystemStatus.Details[0].Status).To(Equal(ec2.StatusTypePassed))
+
+		terminated, err := client.TerminateInstancesWithContext(ctx, &ec2.TerminateInstancesInput{InstanceIds: aws.StringSlice(instanceIDs[:1])})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*terminated.TerminatingInstances[0].CurrentState.Name).To(Equal(ec2.InstanceStateNameShuttingDown))
+		Expect(client.WaitUntilInstanceTerminatedWithContext(ctx, &ec2.DescribeInstancesInput{InstanceIds: aws.StringSlice(instanceIDs[:1])})).To(Succeed())
+
+		volumes, err := client.DescribeVolumesWithContext(ctx, &ec2.DescribeVolumesInput{Filters: []*ec2.Filter{{Name: aws.String("status"), Values: aws.StringSlice([]string{"available"})}}})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(volumes.Volumes).To(HaveLen(1))
+		Expect(*volumes.Volumes[0].Size).To(Equal(int64(50)))
+		_, err = client.DeleteVolumeWithContext(ctx, &ec2.DeleteVolumeInput{VolumeId: volumes.Volumes[0].VolumeId})
+		Expect(err).ToNot(HaveOccurred())
+
+		networkInterfaces, err := client.DescribeNetworkInterfacesWithContext(ctx, &ec2.DescribeNetworkInterfacesInput{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(networkInterfaces.NetworkInterfaces).To(HaveLen(5))
+	})
+
+	It("should return the errors of the EC2", func() {
+		_, err := client.DescribeInstancesWithContext(ctx, &ec2.DescribeInstancesInput{InstanceIds: aws.StringSlice([]string{"i-unknown"})})
+		Expect(err).To(HaveOccurred())
+		requestFailure, ok := err.(awserr.RequestFailure)
+		Expect(ok).To(BeTrue())
+		Expect(requestFailure.Code()).To(Equal(ErrCodeInvalidInstanceIDNotFound))
+		Expect(requestFailure.Message()).To(Equal("The instance ID 'i-unknown' does not exist"))
+		Expect(requestFailure.StatusCode()).To(Equal(http.StatusBadRequest))
+		Expect(requestFailure.RequestID()).ToNot(BeEmpty())
+
+		fake.InjectFault(Fault{Operation: "RunInstances", Err: NewRequestFailure("InsufficientInstanceCapacity", "We currently do not have sufficient capacity.", http.StatusServiceUnavailable), Times: 1})
+		_, err = client.RunInstancesWithContext(ctx, &ec2.RunInstancesInput{ImageId: aws.String("ami-123456789"), MinCount: aws.Int64(1), MaxCount: aws.Int64(1)})
+		Expect(err).To(HaveOccurred())
+		requestFailure = err.(awserr.RequestFailure)
+		Expect(requestFailure.Code()).To(Equal("InsufficientInstanceCapacity"))
+		Expect(requestFailure.StatusCode()).To(Equal(http.StatusServiceUnavailable))
+	})
+
+	It("should reject requests for other regions and unsupported actions", func() {
+		_, err := newClient("us-east-1").DescribeInstancesWithContext(ctx, &ec2.DescribeInstancesInput{})
+		Expect(err).To(HaveOccurred())
+		Expect(err.(awserr.Error).Code()).To(Equal(errCodeAuthFailure))
+
+		_, err = client.DescribeVpcsWithContext(ctx, &ec2.DescribeVpcsInput{})
+		Expect(err).To(HaveOccurred())
+		Expect(err.(awserr.Error).Code()).To(Equal(errCodeInvalidAction))
+
+		response, err := http.PostForm(server.URL, url.Values{"Action": {"DescribeInstances"}})
+		Expect(err).ToNot(HaveOccurred())
+		defer response.Body.Close()
+		Expect(response.StatusCode).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should serve instance profiles of the IAM", func() {
+		fakeIAM := NewIAM()
+		arn := fakeIAM.AddInstanceProfile("test-iam")
+		server.Config.Handler.(*Server).SetIAM(fakeIAM)
+		client := iam.New(session.Must(session.NewSession(&aws.Config{
+			Region:      aws.String(DefaultRegion),
+			Endpoint:    aws.String(server.URL),
+			Credentials: credentials.NewStaticCredentials("dummy-id", "dummy-secret", ""),
+			MaxRetries:  aws.Int(0),
+		})))
+
+		output, err := client.GetInstanceProfileWithContext(ctx, &iam.GetInstanceProfileInput{InstanceProfileName: aws.String("test-iam")})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*output.InstanceProfile.Arn).To(Equal(arn))
+		Expect(*output.InstanceProfile.InstanceProfileName).To(Equal("test-iam"))
+
+		_, err = client.GetInstanceProfileWithContext(ctx, &iam.GetInstanceProfileInput{InstanceProfileName: aws.String("other-iam")})
+		Expect(err).To(HaveOccurred())
+		requestFailure := err.(awserr.RequestFailure)
+		Expect(requestFailure.Code()).To(Equal(iam.ErrCodeNoSuchEntityException))
+		Expect(requestFailure.StatusCode()).To(Equal(http.StatusNotFound))
+	})
+
+	It("should decode nested and numbered query parameters", func() {
+		input := &ec2.DescribeInstancesInput{}
+		err := decodeQuery(url.Values{
+			"Filter.1.Name":    {"tag:Name"},
+			"Filter.1.Value.1": {"a"},
+			"Filter.1.Value.2": {"b"},
+			"Filter.2.Name":    {"instance-state-name"},
+			"Filter.2.Value.1": {"running"},
+			"MaxResults":       {"5"},
+			"DryRun":           {"false"},
+		}, reflect.ValueOf(input).Elem(), "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(input).To(Equal(&ec2.DescribeInstancesInput{
+			Filters: []*ec2.Filter{
+				{Name: aws.String("tag:Name"), Values: aws.StringSlice([]string{"a", "b"})},
+				{Name: aws.String("instance-state-name"), Values: aws.StringSlice([]string{"running"})},
+			},
+			MaxResults: aws.Int64(5),
+			DryRun:     aws.Bool(false),
+		}))
+
+		err = decodeQuery(url.Values{"MaxResults": {"many"}}, reflect.ValueOf(input).Elem(), "")
+		Expect(err).To(HaveOccurred())
+		Expect(strings.Contains(err.Error(), ErrCodeInvalidParameterValue)).To(BeTrue())
+	})
+})
@@ -0,0 +1,88 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fakeec2
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/gardener/machine-controller-manager-provider-aws/pkg/spi"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var (
+	_ ec2iface.EC2API              = &EC2{}
+	_ spi.SessionProviderInterface = &SessionProvider{}
+)
+
+// SessionProvider implements spi.SessionProviderInterface with in-memory EC2s, one per region, and an in-memory IAM
+// shared by all regions
+type SessionProvider struct {
+	mutex   sync.Mutex
+	regions map[string]*EC2
+	iam     *IAM
+	err     error
+}
+
+// NewSessionProvider returns a SessionProvider serving the given EC2s in their regions and an IAM without
+// instance profiles
+func NewSessionProvider(fakes ...*EC2) *SessionProvider {
+	p := &SessionProvider{regions: map[string]*EC2{}, iam: NewIAM()}
+	for _, fake := range fakes {
+		p.regions[fake.Region()] = fake
+	}
+	return p
+}
+
+// IAM returns the IAM served by the provider
+func (p *SessionProvider) IAM() *IAM {
+	return p.iam
+}
+
+// FailSessions makes NewSession fail with the given error, nil lets it succeed again
+func (p *SessionProvider) FailSessions(err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.err = err
+}
+
+// NewSession returns a session for a region served by the provider
+func (p *SessionProvider) NewSession(secret *corev1.Secret, region string) (*session.Session, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.err != nil {
+		return nil, p.err
+	}
+	if _, ok := p.regions[region]; !ok {
+		return nil, fmt.Errorf("Region %q doesn't exist while trying to create session", region)
+	}
+	return &session.Session{Config: &aws.Config{Region: aws.String(region)}}, nil
+}
+
+// NewEC2API returns the EC2 of the region of the session
+func (p *SessionProvider) NewEC2API(s *session.Session) ec2iface.EC2API {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.regions[aws.StringValue(s.Config.Region)]
+}
+
+// NewIAMAPI returns the IAM of the provider
+func (p *SessionProvider) NewIAMAPI(s *session.Session) iamiface.IAMAPI {
+	return p.iam
+}
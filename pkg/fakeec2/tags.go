@@ -0,0 +1,158 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fakeec2
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// CreateTagsWithContext adds or overwrites tags of instances, volumes, network interfaces and images
+func (e *EC2) CreateTagsWithContext(ctx aws.Context, input *ec2.CreateTagsInput, opts ...request.Option) (*ec2.CreateTagsOutput, error) {
+	if err := e.begin(ctx, "CreateTags", input); err != nil {
+		return nil, err
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.advance()
+
+	tagLists, err := e.tagLists(input.Resources)
+	if err != nil {
+		return nil, err
+	}
+	for _, tags := range tagLists {
+		for _, tag := range input.Tags {
+			*tags = setTag(*tags, aws.StringValue(tag.Key), aws.StringValue(tag.Value))
+		}
+	}
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+// CreateTags adds or overwrites tags, see CreateTagsWithContext
+func (e *EC2) CreateTags(input *ec2.CreateTagsInput) (*ec2.CreateTagsOutput, error) {
+	return e.CreateTagsWithContext(aws.BackgroundContext(), input)
+}
+
+// DeleteTagsWithContext deletes tags of instances, volumes, network interfaces and images. Tags given with a value
+// are only deleted if their value matches, tags given without value are deleted regardless of their value.
+// Without tags, all tags are deleted.
+func (e *EC2) DeleteTagsWithContext(ctx aws.Context, input *ec2.DeleteTagsInput, opts ...request.Option) (*ec2.DeleteTagsOutput, error) {
+	if err := e.begin(ctx, "DeleteTags", input); err != nil {
+		return nil, err
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.advance()
+
+	tagLists, err := e.tagLists(input.Resources)
+	if err != nil {
+		return nil, err
+	}
+	for _, tags := range tagLists {
+		var kept []*ec2.Tag
+		for _, tag := range *tags {
+			if !deletesTag(input.Tags, tag) {
+				kept = append(kept, tag)
+			}
+		}
+		*tags = kept
+	}
+	return &ec2.DeleteTagsOutput{}, nil
+}
+
+// DeleteTags deletes tags, see DeleteTagsWithContext
+func (e *EC2) DeleteTags(input *ec2.DeleteTagsInput) (*ec2.DeleteTagsOutput, error) {
+	return e.DeleteTagsWithContext(aws.BackgroundContext(), input)
+}
+
+// tagLists returns the tag lists of the given resources, or the error of the first resource that doesn't exist
+func (e *EC2) tagLists(resources []*string) ([]*[]*ec2.Tag, error) {
+	if len(resources) == 0 {
+		return nil, missingParameter("ResourceId")
+	}
+
+	var tagLists []*[]*ec2.Tag
+	for _, id := range aws.StringValueSlice(resources) {
+		switch {
+		case strings.HasPrefix(id, "i-"):
+			i, ok := e.instances[id]
+			if !ok {
+				return nil, NewError(ErrCodeInvalidInstanceIDNotFound, fmt.Sprintf("The instance ID '%s' does not exist", id))
+			}
+			tagLists = append(tagLists, &i.Tags)
+		case strings.HasPrefix(id, "vol-"):
+			volume, ok := e.volumes[id]
+			if !ok {
+				return nil, NewError(ErrCodeInvalidVolumeNotFound, fmt.Sprintf("The volume '%s' does not exist.", id))
+			}
+			tagLists = append(tagLists, &volume.Tags)
+		case strings.HasPrefix(id, "eni-"):
+			networkInterface, ok := e.networkInterfaces[id]
+			if !ok {
+				return nil, NewError(ErrCodeInvalidNetworkInterfaceIDNotFound, fmt.Sprintf("The networkInterface ID '%s' does not exist", id))
+			}
+			tagLists = append(tagLists, &networkInterface.TagSet)
+		case strings.HasPrefix(id, "ami-"):
+			image, err := e.image(id)
+			if err != nil {
+				return nil, err
+			}
+			tagLists = append(tagLists, &image.Tags)
+		default:
+			return nil, NewError(ErrCodeInvalidParameterValue, fmt.Sprintf("Invalid id: \"%s\"", id))
+		}
+	}
+	return tagLists, nil
+}
+
+// tagsFor returns copies of the tags specified for a resource type
+func tagsFor(specs []*ec2.TagSpecification, resourceType string) []*ec2.Tag {
+	var tags []*ec2.Tag
+	for _, spec := range specs {
+		if aws.StringValue(spec.ResourceType) == resourceType {
+			for _, tag := range spec.Tags {
+				tags = setTag(tags, aws.StringValue(tag.Key), aws.StringValue(tag.Value))
+			}
+		}
+	}
+	return tags
+}
+
+// setTag sets a tag in a tag list, overwriting the value of an existing tag with the same key
+func setTag(tags []*ec2.Tag, key, value string) []*ec2.Tag {
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) == key {
+			tag.Value = aws.String(value)
+			return tags
+		}
+	}
+	return append(tags, &ec2.Tag{Key: aws.String(key), Value: aws.String(value)})
+}
+
+// deletesTag returns true if a tag is deleted by a DeleteTags call with the given tags
+func deletesTag(deleted []*ec2.Tag, tag *ec2.Tag) bool {
+	if len(deleted) == 0 {
+		return true
+	}
+	for _, d := range deleted {
+		if aws.StringValue(d.Key) == aws.StringValue(tag.Key) && (d.Value == nil || aws.StringValue(d.Value) == aws.StringValue(tag.Value)) {
+			return true
+		}
+	}
+	return false
+}
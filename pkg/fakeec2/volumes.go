@@ -0,0 +1,213 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fakeec2
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// AddVolume adds an available volume, e.g. one left behind by a deleted instance, and returns its ID.
+// Missing ID, state, size, type, availability zone and creation time are defaulted.
+func (e *EC2) AddVolume(volume *ec2.Volume) string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	v := copyOf(volume).(*ec2.Volume)
+	if v.VolumeId == nil {
+		v.VolumeId = aws.String(e.nextID("vol"))
+	}
+	if v.State == nil {
+		v.State = aws.String(ec2.VolumeStateAvailable)
+	}
+	if v.Size == nil {
+		v.Size = aws.Int64(defaultVolumeSize)
+	}
+	if v.VolumeType == nil {
+		v.VolumeType = aws.String(ec2.VolumeTypeGp2)
+	}
+	if v.AvailabilityZone == nil {
+		v.AvailabilityZone = aws.String(e.region + "a")
+	}
+	if v.CreateTime == nil {
+		v.CreateTime = aws.Time(e.now())
+	}
+	e.volumes[*v.VolumeId] = v
+	return *v.VolumeId
+}
+
+// Volumes returns copies of all volumes
+func (e *EC2) Volumes() []*ec2.Volume {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.advance()
+
+	var volumes []*ec2.Volume
+	for _, id := range e.volumeIDs() {
+		volumes = append(volumes, copyOf(e.volumes[id]).(*ec2.Volume))
+	}
+	return volumes
+}
+
+// DescribeVolumesWithContext describes the volumes selected by their IDs and filters
+func (e *EC2) DescribeVolumesWithContext(ctx aws.Context, input *ec2.DescribeVolumesInput, opts ...request.Option) (*ec2.DescribeVolumesOutput, error) {
+	if err := e.begin(ctx, "DescribeVolumes", input); err != nil {
+		return nil, err
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.advance()
+
+	if len(input.VolumeIds) > 0 && input.MaxResults != nil {
+		return nil, NewError(ErrCodeInvalidParameterCombination, "The parameter volumeSet cannot be used with the parameter maxResults")
+	}
+	if err := checkIDs(input.VolumeIds, e.hasVolume, ErrCodeInvalidVolumeNotFound, "volume"); err != nil {
+		return nil, err
+	}
+
+	candidates := aws.StringValueSlice(input.VolumeIds)
+	if len(candidates) == 0 {
+		candidates = e.volumeIDs()
+	}
+	var ids []string
+	for _, id := range sortedKeys(candidates) {
+		volume := e.volumes[id]
+		matches, err := matchesFilters(input.Filters, volume.Tags, volumeAttribute(volume))
+		if err != nil {
+			return nil, err
+		}
+		if matches && (len(ids) == 0 || ids[len(ids)-1] != id) {
+			ids = append(ids, id)
+		}
+	}
+
+	start, end, nextToken, err := page(ids, input.MaxResults, input.NextToken, 5, 500)
+	if err != nil {
+		return nil, err
+	}
+	output := &ec2.DescribeVolumesOutput{NextToken: nextToken}
+	for _, id := range ids[start:end] {
+		output.Volumes = append(output.Volumes, copyOf(e.volumes[id]).(*ec2.Volume))
+	}
+	return output, nil
+}
+
+// DescribeVolumes describes volumes, see DescribeVolumesWithContext
+func (e *EC2) DescribeVolumes(input *ec2.DescribeVolumesInput) (*ec2.DescribeVolumesOutput, error) {
+	return e.DescribeVolumesWithContext(aws.BackgroundContext(), input)
+}
+
+// DescribeVolumesPagesWithContext iterates over the pages of DescribeVolumesWithContext
+func (e *EC2) DescribeVolumesPagesWithContext(ctx aws.Context, input *ec2.DescribeVolumesInput, fn func(*ec2.DescribeVolumesOutput, bool) bool, opts ...request.Option) error {
+	pageInput := *input
+	for {
+		output, err := e.DescribeVolumesWithContext(ctx, &pageInput, opts...)
+		if err != nil {
+			return err
+		}
+		lastPage := output.NextToken == nil
+		if !fn(output, lastPage) || lastPage {
+			return nil
+		}
+		pageInput.NextToken = output.NextToken
+	}
+}
+
+// DescribeVolumesPages iterates over the pages of DescribeVolumes
+func (e *EC2) DescribeVolumesPages(input *ec2.DescribeVolumesInput, fn func(*ec2.DescribeVolumesOutput, bool) bool) error {
+	return e.DescribeVolumesPagesWithContext(aws.BackgroundContext(), input, fn)
+}
+
+// DeleteVolumeWithContext deletes an available volume
+func (e *EC2) DeleteVolumeWithContext(ctx aws.Context, input *ec2.DeleteVolumeInput, opts ...request.Option) (*ec2.DeleteVolumeOutput, error) {
+	if err := e.begin(ctx, "DeleteVolume", input); err != nil {
+		return nil, err
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.advance()
+
+	if input.VolumeId == nil {
+		return nil, missingParameter("VolumeId")
+	}
+	volume, ok := e.volumes[*input.VolumeId]
+	if !ok {
+		return nil, NewError(ErrCodeInvalidVolumeNotFound, fmt.Sprintf("The volume '%s' does not exist.", *input.VolumeId))
+	}
+	if len(volume.Attachments) > 0 {
+		return nil, NewError(ErrCodeVolumeInUse, fmt.Sprintf("Volume %s is currently attached to %s", *input.VolumeId, aws.StringValue(volume.Attachments[0].InstanceId)))
+	}
+	delete(e.volumes, *input.VolumeId)
+	return &ec2.DeleteVolumeOutput{}, nil
+}
+
+// DeleteVolume deletes a volume, see DeleteVolumeWithContext
+func (e *EC2) DeleteVolume(input *ec2.DeleteVolumeInput) (*ec2.DeleteVolumeOutput, error) {
+	return e.DeleteVolumeWithContext(aws.BackgroundContext(), input)
+}
+
+func (e *EC2) hasVolume(id string) bool {
+	_, ok := e.volumes[id]
+	return ok
+}
+
+func (e *EC2) volumeIDs() []string {
+	var ids []string
+	for id := range e.volumes {
+		ids = append(ids, id)
+	}
+	return sortedKeys(ids)
+}
+
+// volumeAttribute returns the attributes of a volume for the DescribeVolumes filters
+func volumeAttribute(volume *ec2.Volume) attributes {
+	attachments := func(value func(*ec2.VolumeAttachment) string) []string {
+		var values []string
+		for _, attachment := range volume.Attachments {
+			values = append(values, value(attachment))
+		}
+		return values
+	}
+
+	return func(name string) ([]string, bool) {
+		switch name {
+		case "volume-id":
+			return single(aws.StringValue(volume.VolumeId)), true
+		case "status":
+			return single(aws.StringValue(volume.State)), true
+		case "size":
+			return single(fmt.Sprint(aws.Int64Value(volume.Size))), true
+		case "volume-type":
+			return single(aws.StringValue(volume.VolumeType)), true
+		case "availability-zone":
+			return single(aws.StringValue(volume.AvailabilityZone)), true
+		case "encrypted":
+			return single(fmt.Sprint(aws.BoolValue(volume.Encrypted))), true
+		case "snapshot-id":
+			return single(aws.StringValue(volume.SnapshotId)), true
+		case "attachment.instance-id":
+			return attachments(func(a *ec2.VolumeAttachment) string { return aws.StringValue(a.InstanceId) }), true
+		case "attachment.device":
+			return attachments(func(a *ec2.VolumeAttachment) string { return aws.StringValue(a.Device) }), true
+		case "attachment.status":
+			return attachments(func(a *ec2.VolumeAttachment) string { return aws.StringValue(a.State) }), true
+		case "attachment.delete-on-termination":
+			return attachments(func(a *ec2.VolumeAttachment) string { return fmt.Sprint(aws.BoolValue(a.DeleteOnTermination)) }), true
+		}
+		return nil, false
+	}
+}
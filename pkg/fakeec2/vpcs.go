@@ -0,0 +1,180 @@
+/*
+Copyright (c) 2020 SAP SE or an SAP affiliate company. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fakeec2
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// AddSubnet adds a subnet and returns its ID. Missing ID, state and availability zone are defaulted to an available
+// subnet in the first zone of the region.
+func (e *EC2) AddSubnet(subnet *ec2.Subnet) string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	s := copyOf(subnet).(*ec2.Subnet)
+	if s.SubnetId == nil {
+		s.SubnetId = aws.String(e.nextID("subnet"))
+	}
+	if s.State == nil {
+		s.State = aws.String(ec2.SubnetStateAvailable)
+	}
+	if s.AvailabilityZone == nil {
+		s.AvailabilityZone = aws.String(e.region + "a")
+	}
+	if s.OwnerId == nil {
+		s.OwnerId = aws.String(DefaultOwnerID)
+	}
+	e.subnets[*s.SubnetId] = s
+	return *s.SubnetId
+}
+
+// AddSecurityGroup adds a security group and returns its ID. A missing ID is generated.
+func (e *EC2) AddSecurityGroup(group *ec2.SecurityGroup) string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	g := copyOf(group).(*ec2.SecurityGroup)
+	if g.GroupId == nil {
+		g.GroupId = aws.String(e.nextID("sg"))
+	}
+	if g.GroupName == nil {
+		g.GroupName = g.GroupId
+	}
+	if g.OwnerId == nil {
+		g.OwnerId = aws.String(DefaultOwnerID)
+	}
+	e.securityGroups[*g.GroupId] = g
+	return *g.GroupId
+}
+
+// DescribeSubnetsWithContext describes the subnets selected by their IDs and filters
+func (e *EC2) DescribeSubnetsWithContext(ctx aws.Context, input *ec2.DescribeSubnetsInput, opts ...request.Option) (*ec2.DescribeSubnetsOutput, error) {
+	if err := e.begin(ctx, "DescribeSubnets", input); err != nil {
+		return nil, err
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if err := checkIDs(input.SubnetIds, e.hasSubnet, ErrCodeInvalidSubnetIDNotFound, "subnet ID"); err != nil {
+		return nil, err
+	}
+
+	candidates := aws.StringValueSlice(input.SubnetIds)
+	if len(candidates) == 0 {
+		for id := range e.subnets {
+			candidates = append(candidates, id)
+		}
+	}
+
+	output := &ec2.DescribeSubnetsOutput{}
+	for _, id := range sortedKeys(candidates) {
+		subnet := e.subnets[id]
+		matches, err := matchesFilters(input.Filters, subnet.Tags, subnetAttribute(subnet))
+		if err != nil {
+			return nil, err
+		}
+		if matches && (len(output.Subnets) == 0 || *output.Subnets[len(output.Subnets)-1].SubnetId != id) {
+			output.Subnets = append(output.Subnets, copyOf(subnet).(*ec2.Subnet))
+		}
+	}
+	return output, nil
+}
+
+// DescribeSubnets describes subnets, see DescribeSubnetsWithContext
+func (e *EC2) DescribeSubnets(input *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+	return e.DescribeSubnetsWithContext(aws.BackgroundContext(), input)
+}
+
+// DescribeSecurityGroupsWithContext describes the security groups selected by their IDs and filters
+func (e *EC2) DescribeSecurityGroupsWithContext(ctx aws.Context, input *ec2.DescribeSecurityGroupsInput, opts ...request.Option) (*ec2.DescribeSecurityGroupsOutput, error) {
+	if err := e.begin(ctx, "DescribeSecurityGroups", input); err != nil {
+		return nil, err
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if err := checkIDs(input.GroupIds, e.hasSecurityGroup, ErrCodeInvalidGroupNotFound, "security group"); err != nil {
+		return nil, err
+	}
+
+	candidates := aws.StringValueSlice(input.GroupIds)
+	if len(candidates) == 0 {
+		for id := range e.securityGroups {
+			candidates = append(candidates, id)
+		}
+	}
+
+	output := &ec2.DescribeSecurityGroupsOutput{}
+	for _, id := range sortedKeys(candidates) {
+		group := e.securityGroups[id]
+		matches, err := matchesFilters(input.Filters, group.Tags, securityGroupAttribute(group))
+		if err != nil {
+			return nil, err
+		}
+		if matches && (len(output.SecurityGroups) == 0 || *output.SecurityGroups[len(output.SecurityGroups)-1].GroupId != id) {
+			output.SecurityGroups = append(output.SecurityGroups, copyOf(group).(*ec2.SecurityGroup))
+		}
+	}
+	return output, nil
+}
+
+// DescribeSecurityGroups describes security groups, see DescribeSecurityGroupsWithContext
+func (e *EC2) DescribeSecurityGroups(input *ec2.DescribeSecurityGroupsInput) (*ec2.DescribeSecurityGroupsOutput, error) {
+	return e.DescribeSecurityGroupsWithContext(aws.BackgroundContext(), input)
+}
+
+func (e *EC2) hasSubnet(id string) bool {
+	_, ok := e.subnets[id]
+	return ok
+}
+
+func (e *EC2) hasSecurityGroup(id string) bool {
+	_, ok := e.securityGroups[id]
+	return ok
+}
+
+// subnetAttribute returns the attributes of a subnet for the DescribeSubnets filters
+func subnetAttribute(subnet *ec2.Subnet) attributes {
+	return func(name string) ([]string, bool) {
+		switch name {
+		case "subnet-id":
+			return single(aws.StringValue(subnet.SubnetId)), true
+		case "vpc-id":
+			return single(aws.StringValue(subnet.VpcId)), true
+		case "availability-zone":
+			return single(aws.StringValue(subnet.AvailabilityZone)), true
+		case "state":
+			return single(aws.StringValue(subnet.State)), true
+		}
+		return nil, false
+	}
+}
+
+// securityGroupAttribute returns the attributes of a security group for the DescribeSecurityGroups filters
+func securityGroupAttribute(group *ec2.SecurityGroup) attributes {
+	return func(name string) ([]string, bool) {
+		switch name {
+		case "group-id":
+			return single(aws.StringValue(group.GroupId)), true
+		case "group-name":
+			return single(aws.StringValue(group.GroupName)), true
+		case "vpc-id":
+			return single(aws.StringValue(group.VpcId)), true
+		}
+		return nil, false
+	}
+}
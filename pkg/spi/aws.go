@@ -8,13 +8,18 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
 	corev1 "k8s.io/api/core/v1"
 
 	api "github.com/gardener/machine-controller-manager-provider-aws/pkg/aws/apis"
 )
 
 // PluginSPIImpl is the real implementation of SPI interface that makes the calls to the AWS SDK.
-type PluginSPIImpl struct{}
+type PluginSPIImpl struct {
+	// EndpointOptions configures the endpoints used by the sessions, it can be overridden per secret
+	EndpointOptions EndpointOptions
+}
 
 // NewSession starts a new AWS session
 func (ms *PluginSPIImpl) NewSession(secret *corev1.Secret, region string) (*session.Session, error) {
@@ -35,7 +40,17 @@ func (ms *PluginSPIImpl) NewSession(secret *corev1.Secret, region string) (*sess
 		}
 	}
 
-	return session.NewSession(config)
+	endpointConfig, err := newEndpointConfig(ms.EndpointOptions, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	options, err := newSessionOptions(config, endpointConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return session.NewSessionWithOptions(options)
 }
 
 // NewEC2API Returns a EC2API object
@@ -44,6 +59,11 @@ func (ms *PluginSPIImpl) NewEC2API(session *session.Session) ec2iface.EC2API {
 	return service
 }
 
+// NewIAMAPI returns an IAMAPI object
+func (ms *PluginSPIImpl) NewIAMAPI(session *session.Session) iamiface.IAMAPI {
+	return iam.New(session)
+}
+
 // extractCredentialsFromData extracts and trims a value from the given data map. The first key that exists is being
 // returned, otherwise, the next key is tried, etc. If no key exists then an empty string is returned.
 func extractCredentialsFromData(data map[string][]byte, keys ...string) string {
@@ -0,0 +1,223 @@
+package spi
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/spf13/pflag"
+	corev1 "k8s.io/api/core/v1"
+
+	api "github.com/gardener/machine-controller-manager-provider-aws/pkg/aws/apis"
+)
+
+// dualStackDNSSuffixes maps the partition ID to the DNS suffix of its dual-stack (IPv4 and IPv6) endpoints
+var dualStackDNSSuffixes = map[string]string{
+	endpoints.AwsPartitionID:      "api.aws",
+	endpoints.AwsUsGovPartitionID: "api.aws",
+	endpoints.AwsCnPartitionID:    "api.amazonwebservices.com.cn",
+}
+
+// EndpointOptions configures how the endpoints of the AWS services are resolved.
+// Each of the options can be overridden per secret.
+type EndpointOptions struct {
+	// EC2Endpoint overrides the URL of the EC2 API, e.g. with a VPC interface endpoint
+	EC2Endpoint string
+	// STSEndpoint overrides the URL of the STS API, e.g. with a VPC interface endpoint
+	STSEndpoint string
+	// IAMEndpoint overrides the URL of the IAM API
+	IAMEndpoint string
+	// UseFIPSEndpoint selects the FIPS 140-2 validated endpoint of the EC2 API
+	UseFIPSEndpoint bool
+	// UseDualStackEndpoint selects the dual-stack (IPv4 and IPv6) endpoint of the EC2 API
+	UseDualStackEndpoint bool
+	// CABundleFile is the path to a PEM encoded CA bundle used to verify the endpoints
+	CABundleFile string
+	// ProxyURL is the URL of the HTTP(S) proxy used to reach the endpoints
+	ProxyURL string
+}
+
+// AddFlags adds the flags for the endpoint options to the given FlagSet
+func (o *EndpointOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.EC2Endpoint, "aws-ec2-endpoint", o.EC2Endpoint, "URL of the EC2 API, overrides the endpoint resolved from the region.")
+	fs.StringVar(&o.STSEndpoint, "aws-sts-endpoint", o.STSEndpoint, "URL of the STS API, overrides the endpoint resolved from the region.")
+	fs.StringVar(&o.IAMEndpoint, "aws-iam-endpoint", o.IAMEndpoint, "URL of the IAM API, overrides the global endpoint of the partition.")
+	fs.BoolVar(&o.UseFIPSEndpoint, "aws-use-fips-endpoint", o.UseFIPSEndpoint, "Use the FIPS endpoint of the EC2 API.")
+	fs.BoolVar(&o.UseDualStackEndpoint, "aws-use-dualstack-endpoint", o.UseDualStackEndpoint, "Use the dual-stack endpoint of the EC2 API.")
+	fs.StringVar(&o.CABundleFile, "aws-ca-bundle", o.CABundleFile, "Path to a PEM encoded CA bundle used to verify the AWS endpoints.")
+	fs.StringVar(&o.ProxyURL, "aws-proxy-url", o.ProxyURL, "URL of the proxy used to reach the AWS endpoints.")
+}
+
+// endpointConfig is the resolved endpoint configuration for a single session
+type endpointConfig struct {
+	EndpointOptions
+	caBundle []byte
+}
+
+// newEndpointConfig merges the endpoint options with the overrides from the secret
+func newEndpointConfig(options EndpointOptions, secret *corev1.Secret) (*endpointConfig, error) {
+	var (
+		config = &endpointConfig{EndpointOptions: options}
+		data   map[string][]byte
+		err    error
+	)
+
+	if secret != nil {
+		data = secret.Data
+	}
+
+	if value := extractCredentialsFromData(data, api.AWSEC2Endpoint); value != "" {
+		config.EC2Endpoint = value
+	}
+	if value := extractCredentialsFromData(data, api.AWSSTSEndpoint); value != "" {
+		config.STSEndpoint = value
+	}
+	if value := extractCredentialsFromData(data, api.AWSIAMEndpoint); value != "" {
+		config.IAMEndpoint = value
+	}
+	if value := extractCredentialsFromData(data, api.AWSUseFIPSEndpoint); value != "" {
+		if config.UseFIPSEndpoint, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("invalid value for secret key %s: %v", api.AWSUseFIPSEndpoint, err)
+		}
+	}
+	if value := extractCredentialsFromData(data, api.AWSUseDualStackEndpoint); value != "" {
+		if config.UseDualStackEndpoint, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("invalid value for secret key %s: %v", api.AWSUseDualStackEndpoint, err)
+		}
+	}
+	if value := extractCredentialsFromData(data, api.AWSProxyURL); value != "" {
+		config.ProxyURL = value
+	}
+
+	if value, ok := data[api.AWSCABundle]; ok && len(bytes.TrimSpace(value)) > 0 {
+		config.caBundle = value
+	} else if config.CABundleFile != "" {
+		if config.caBundle, err = ioutil.ReadFile(config.CABundleFile); err != nil {
+			return nil, fmt.Errorf("unable to read CA bundle: %v", err)
+		}
+	}
+
+	return config, nil
+}
+
+// apply sets the endpoint configuration on the session options
+func (c *endpointConfig) apply(options *session.Options) error {
+	options.Config.EndpointResolver = endpoints.ResolverFunc(c.resolve)
+
+	if c.ProxyURL != "" {
+		proxyURL, err := url.Parse(c.ProxyURL)
+		if err != nil {
+			return fmt.Errorf("invalid proxy URL: %v", err)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyURL(proxyURL)
+		options.Config.HTTPClient = &http.Client{Transport: transport}
+	}
+
+	if len(c.caBundle) > 0 {
+		options.CustomCABundle = bytes.NewReader(c.caBundle)
+	}
+
+	return nil
+}
+
+// resolve returns the endpoint for the given service and region. Overrides take precedence
+// over the FIPS and dual-stack switches, which in turn take precedence over the default resolver.
+func (c *endpointConfig) resolve(service, region string, opts ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+	partitionID := PartitionForRegion(region)
+
+	switch {
+	case service == ec2.EndpointsID && c.EC2Endpoint != "":
+		return overriddenEndpoint(c.EC2Endpoint, service, region, partitionID), nil
+	case service == sts.EndpointsID && c.STSEndpoint != "":
+		return overriddenEndpoint(c.STSEndpoint, service, region, partitionID), nil
+	case service == iam.EndpointsID && c.IAMEndpoint != "":
+		return overriddenEndpoint(c.IAMEndpoint, service, region, partitionID), nil
+	case service == ec2.EndpointsID && (c.UseFIPSEndpoint || c.UseDualStackEndpoint):
+		return c.resolveEC2Variant(region, partitionID, opts...)
+	}
+
+	return endpoints.DefaultResolver().EndpointFor(service, region, opts...)
+}
+
+// resolveEC2Variant returns the FIPS and/or dual-stack endpoint of the EC2 API
+func (c *endpointConfig) resolveEC2Variant(region, partitionID string, opts ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+	if c.UseFIPSEndpoint && !c.UseDualStackEndpoint {
+		// Prefer the FIPS endpoints modelled by the SDK
+		resolved, err := endpoints.DefaultResolver().EndpointFor(ec2.EndpointsID, "fips-"+region, append(opts, endpoints.StrictMatchingOption)...)
+		if err == nil {
+			return resolved, nil
+		}
+	}
+
+	partition, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region)
+	if !ok {
+		return endpoints.ResolvedEndpoint{}, fmt.Errorf("unable to determine the partition of region %q", region)
+	}
+
+	hostPrefix := ec2.EndpointsID
+	if c.UseFIPSEndpoint {
+		hostPrefix += "-fips"
+	}
+
+	dnsSuffix := partition.DNSSuffix()
+	if c.UseDualStackEndpoint {
+		suffix, ok := dualStackDNSSuffixes[partition.ID()]
+		if !ok {
+			return endpoints.ResolvedEndpoint{}, fmt.Errorf("dual-stack endpoints are not supported in partition %q", partition.ID())
+		}
+		dnsSuffix = suffix
+	}
+
+	return endpoints.ResolvedEndpoint{
+		URL:           fmt.Sprintf("https://%s.%s.%s", hostPrefix, region, dnsSuffix),
+		PartitionID:   partition.ID(),
+		SigningRegion: region,
+		SigningName:   ec2.EndpointsID,
+		SigningMethod: "v4",
+	}, nil
+}
+
+// overriddenEndpoint returns the resolved endpoint for a custom URL
+func overriddenEndpoint(endpoint, service, region, partitionID string) endpoints.ResolvedEndpoint {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	return endpoints.ResolvedEndpoint{
+		URL:           endpoint,
+		PartitionID:   partitionID,
+		SigningRegion: region,
+		SigningName:   service,
+		SigningMethod: "v4",
+	}
+}
+
+// PartitionForRegion returns the ID of the AWS partition (aws, aws-cn, aws-us-gov, ...) the region belongs to.
+// The standard partition is returned for unknown regions.
+func PartitionForRegion(region string) string {
+	if partition, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region); ok {
+		return partition.ID()
+	}
+	return endpoints.AwsPartitionID
+}
+
+// newSessionOptions returns the session options for the given region and endpoint configuration
+func newSessionOptions(config *aws.Config, endpointConfig *endpointConfig) (session.Options, error) {
+	options := session.Options{
+		Config: *config,
+	}
+	if err := endpointConfig.apply(&options); err != nil {
+		return session.Options{}, err
+	}
+	return options, nil
+}
@@ -0,0 +1,164 @@
+package spi
+
+import (
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+
+	api "github.com/gardener/machine-controller-manager-provider-aws/pkg/aws/apis"
+)
+
+var _ = Describe("Endpoints", func() {
+
+	Describe("#resolve", func() {
+		type data struct {
+			options  EndpointOptions
+			secret   *corev1.Secret
+			service  string
+			region   string
+			url      string
+			signing  string
+			errorMsg string
+		}
+
+		DescribeTable("##table",
+			func(data *data) {
+				config, err := newEndpointConfig(data.options, data.secret)
+				Expect(err).ToNot(HaveOccurred())
+
+				resolved, err := config.resolve(data.service, data.region)
+				if data.errorMsg != "" {
+					Expect(err).To(MatchError(data.errorMsg))
+					return
+				}
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resolved.URL).To(Equal(data.url))
+				Expect(resolved.SigningRegion).To(Equal(data.signing))
+			},
+			Entry("default endpoint", &data{
+				service: "ec2",
+				region:  "eu-west-1",
+				url:     "https://ec2.eu-west-1.amazonaws.com",
+				signing: "eu-west-1",
+			}),
+			Entry("default endpoint in the China partition", &data{
+				service: "ec2",
+				region:  "cn-north-1",
+				url:     "https://ec2.cn-north-1.amazonaws.com.cn",
+				signing: "cn-north-1",
+			}),
+			Entry("EC2 endpoint override from the options", &data{
+				options: EndpointOptions{EC2Endpoint: "vpce-123.ec2.eu-west-1.vpce.amazonaws.com"},
+				service: "ec2",
+				region:  "eu-west-1",
+				url:     "https://vpce-123.ec2.eu-west-1.vpce.amazonaws.com",
+				signing: "eu-west-1",
+			}),
+			Entry("EC2 endpoint override from the secret takes precedence", &data{
+				options: EndpointOptions{EC2Endpoint: "https://ec2.example.com"},
+				secret: &corev1.Secret{Data: map[string][]byte{
+					api.AWSEC2Endpoint: []byte("http://localhost:4566\n"),
+				}},
+				service: "ec2",
+				region:  "eu-west-1",
+				url:     "http://localhost:4566",
+				signing: "eu-west-1",
+			}),
+			Entry("STS endpoint override", &data{
+				options: EndpointOptions{STSEndpoint: "https://sts.example.com"},
+				service: "sts",
+				region:  "eu-west-1",
+				url:     "https://sts.example.com",
+				signing: "eu-west-1",
+			}),
+			Entry("IAM endpoint override from the secret", &data{
+				secret: &corev1.Secret{Data: map[string][]byte{
+					api.AWSIAMEndpoint: []byte("http://localhost:4566"),
+				}},
+				service: "iam",
+				region:  "eu-west-1",
+				url:     "http://localhost:4566",
+				signing: "eu-west-1",
+			}),
+			Entry("FIPS endpoint modelled by the SDK", &data{
+				options: EndpointOptions{UseFIPSEndpoint: true},
+				service: "ec2",
+				region:  "us-east-1",
+				url:     "https://ec2-fips.us-east-1.amazonaws.com",
+				signing: "us-east-1",
+			}),
+			Entry("FIPS endpoint selected from the secret in GovCloud", &data{
+				secret: &corev1.Secret{Data: map[string][]byte{
+					api.AWSUseFIPSEndpoint: []byte("true"),
+				}},
+				service: "ec2",
+				region:  "us-gov-west-1",
+				url:     "https://ec2-fips.us-gov-west-1.amazonaws.com",
+				signing: "us-gov-west-1",
+			}),
+			Entry("dual-stack endpoint", &data{
+				options: EndpointOptions{UseDualStackEndpoint: true},
+				service: "ec2",
+				region:  "eu-west-1",
+				url:     "https://ec2.eu-west-1.api.aws",
+				signing: "eu-west-1",
+			}),
+			Entry("FIPS dual-stack endpoint", &data{
+				options: EndpointOptions{UseFIPSEndpoint: true, UseDualStackEndpoint: true},
+				service: "ec2",
+				region:  "us-east-1",
+				url:     "https://ec2-fips.us-east-1.api.aws",
+				signing: "us-east-1",
+			}),
+			Entry("dual-stack endpoint in an unsupported partition", &data{
+				options:  EndpointOptions{UseDualStackEndpoint: true},
+				service:  "ec2",
+				region:   "us-iso-east-1",
+				errorMsg: "dual-stack endpoints are not supported in partition \"aws-iso\"",
+			}),
+		)
+
+		It("should reject invalid boolean values in the secret", func() {
+			_, err := newEndpointConfig(EndpointOptions{}, &corev1.Secret{Data: map[string][]byte{
+				api.AWSUseFIPSEndpoint: []byte("yes please"),
+			}})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("#PartitionForRegion", func() {
+		DescribeTable("##table",
+			func(region, partition string) {
+				Expect(PartitionForRegion(region)).To(Equal(partition))
+			},
+			Entry("commercial region", "eu-west-1", endpoints.AwsPartitionID),
+			Entry("China region", "cn-northwest-1", endpoints.AwsCnPartitionID),
+			Entry("GovCloud region", "us-gov-east-1", endpoints.AwsUsGovPartitionID),
+			Entry("unknown region", "xx-unknown", endpoints.AwsPartitionID),
+		)
+	})
+
+	Describe("#NewSession", func() {
+		It("should apply the proxy and the endpoint overrides", func() {
+			plugin := &PluginSPIImpl{EndpointOptions: EndpointOptions{ProxyURL: "http://proxy.example.com:3128"}}
+			session, err := plugin.NewSession(&corev1.Secret{Data: map[string][]byte{
+				api.AWSEC2Endpoint: []byte("https://ec2.example.com"),
+			}}, "eu-west-1")
+			Expect(err).ToNot(HaveOccurred())
+
+			client := session.ClientConfig("ec2")
+			Expect(client.Endpoint).To(Equal("https://ec2.example.com"))
+			Expect(session.Config.HTTPClient.Transport).ToNot(BeNil())
+		})
+
+		It("should fail for an invalid CA bundle", func() {
+			plugin := &PluginSPIImpl{}
+			_, err := plugin.NewSession(&corev1.Secret{Data: map[string][]byte{
+				api.AWSCABundle: []byte("not a certificate"),
+			}}, "eu-west-1")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -3,6 +3,7 @@ package spi
 import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
 	corev1 "k8s.io/api/core/v1"
 )
 
@@ -11,3 +12,9 @@ type SessionProviderInterface interface {
 	NewSession(*corev1.Secret, string) (*session.Session, error)
 	NewEC2API(*session.Session) ec2iface.EC2API
 }
+
+// IAMProviderInterface is optionally implemented by a SessionProviderInterface to create IAM clients.
+// Checks that need IAM are skipped for providers that don't implement it.
+type IAMProviderInterface interface {
+	NewIAMAPI(*session.Session) iamiface.IAMAPI
+}
@@ -0,0 +1,13 @@
+package spi
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestMachineControllerManagerProviderAWSSPI(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Machine Controller Manager Provider AWS SPI")
+}
@@ -44,6 +44,7 @@ import (
 	"time"
 
 	"github.com/gardener/machine-controller-manager-provider-aws/test/integration/helpers"
+	v1alpha1 "github.com/gardener/machine-controller-manager/pkg/apis/machine/v1alpha1"
 	. "github.com/onsi/ginkgo"
 	"github.com/onsi/ginkgo/config"
 	. "github.com/onsi/gomega"
@@ -273,7 +274,13 @@ var _ = Describe("Machine Resource", func() {
 					if err == nil {
 						secret, err := controlKubeCluster.Clientset.CoreV1().Secrets(machineClass.SecretRef.Namespace).Get(machineClass.SecretRef.Name, metav1.GetOptions{})
 						if err == nil {
-							err := helpers.CheckForOrphanedResources(machineClass, secret)
+							machineList, err := controlKubeCluster.McmClient.MachineV1alpha1().Machines("default").List(metav1.ListOptions{})
+							Expect(err).NotTo(HaveOccurred())
+							machines := make([]*v1alpha1.Machine, 0, len(machineList.Items))
+							for i := range machineList.Items {
+								machines = append(machines, &machineList.Items[i])
+							}
+							err = helpers.CheckForOrphanedResources(machineClass, secret, machines)
 							//Check there is no error occured
 							Expect(err).NotTo(HaveOccurred())
 						}
@@ -33,11 +33,11 @@ func parseK8sYaml(filepath string) ([]runtime.Object, []*schema.GroupVersionKind
 	acceptedK8sTypes := regexp.MustCompile(`(Role|ClusterRole|RoleBinding|ClusterRoleBinding|ServiceAccount|CustomResourceDefinition)`)
 	acceptedMCMTypes := regexp.MustCompile(`(MachineClass|Machine)`)
 	fileAsString := string(fileR[:])
-	sepYamlfiles := strings.Split(fileAsString, "---")
+	sepYamlfiles := regexp.MustCompile(`(?m)^---\s*$`).Split(fileAsString, -1)
 	retObj := make([]runtime.Object, 0, len(sepYamlfiles))
 	retKind := make([]*schema.GroupVersionKind, 0, len(sepYamlfiles))
 	for _, f := range sepYamlfiles {
-		if f == "\n" || f == "" {
+		if strings.TrimSpace(f) == "" {
 			// ignore empty cases
 			continue
 		}
@@ -0,0 +1,92 @@
+package helpers
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	"sigs.k8s.io/testing_frameworks/integration"
+)
+
+// KubebuilderAssetsEnv is the environment variable holding the directory of the etcd and kube-apiserver
+// binaries, it's read by the envtest package of controller-runtime
+const KubebuilderAssetsEnv = "KUBEBUILDER_ASSETS"
+
+// LocalControlPlane is a kube-apiserver backed by etcd running on the local host. It's started by
+// controller-runtime's envtest from the binaries in KUBEBUILDER_ASSETS and needs neither network access
+// nor credentials. The envtest version matching the Kubernetes 1.16 libraries of this module serves the
+// API server insecurely, so the binaries must be of Kubernetes 1.19 or older.
+type LocalControlPlane struct {
+	// KubeconfigPath is the path of the kubeconfig for the API server, it's written by Start
+	KubeconfigPath string
+	// CRDDirectoryPaths are the directories of the CRDs installed by Start
+	CRDDirectoryPaths []string
+	// Output receives the logs of etcd and the API server
+	Output io.Writer
+
+	environment *envtest.Environment
+	dir         string
+}
+
+// NewLocalControlPlane returns a LocalControlPlane installing the CRDs in the given directories
+func NewLocalControlPlane(crdDirectoryPaths ...string) *LocalControlPlane {
+	return &LocalControlPlane{CRDDirectoryPaths: crdDirectoryPaths, Output: ioutil.Discard}
+}
+
+// Start starts etcd and the API server, installs the CRDs and waits until they are established
+func (p *LocalControlPlane) Start() error {
+	dir, err := ioutil.TempDir("", "local-control-plane")
+	if err != nil {
+		return err
+	}
+	p.dir = dir
+
+	p.environment = &envtest.Environment{
+		ControlPlane: integration.ControlPlane{
+			APIServer: &integration.APIServer{Args: envtest.DefaultKubeAPIServerFlags, Out: p.Output, Err: p.Output},
+			Etcd:      &integration.Etcd{Out: p.Output, Err: p.Output},
+		},
+		CRDDirectoryPaths: p.CRDDirectoryPaths,
+		CRDInstallOptions: envtest.CRDInstallOptions{ErrorIfPathMissing: true},
+	}
+	if _, err := p.environment.Start(); err != nil {
+		return err
+	}
+
+	p.KubeconfigPath = filepath.Join(dir, "kubeconfig")
+	return clientcmd.WriteToFile(clientcmdapi.Config{
+		Clusters:       map[string]*clientcmdapi.Cluster{"local": {Server: p.environment.ControlPlane.APIURL().String()}},
+		AuthInfos:      map[string]*clientcmdapi.AuthInfo{"local": {}},
+		Contexts:       map[string]*clientcmdapi.Context{"local": {Cluster: "local", AuthInfo: "local"}},
+		CurrentContext: "local",
+	}, p.KubeconfigPath)
+}
+
+// Stop stops the API server and etcd and removes their data
+func (p *LocalControlPlane) Stop() error {
+	if p.environment != nil {
+		if err := p.environment.Stop(); err != nil {
+			return err
+		}
+		p.environment = nil
+	}
+	if p.dir == "" {
+		return nil
+	}
+	return os.RemoveAll(p.dir)
+}
+
+// FreePort returns a port that is free on the local host
+func FreePort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
@@ -2,6 +2,7 @@ package helpers
 
 import (
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -43,3 +44,36 @@ func (c *Cluster) NumberOfNodes() int16 {
 	}
 	return int16(len(nodes.Items))
 }
+
+// JoinMachines registers a ready node for every machine in the namespace whose VM has been created but whose
+// node doesn't exist yet. It stands in for the kubelets in clusters without real VMs.
+func (c *Cluster) JoinMachines(namespace string) error {
+	machines, err := c.McmClient.MachineV1alpha1().Machines(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, machine := range machines.Items {
+		if machine.Status.Node == "" || machine.DeletionTimestamp != nil {
+			continue
+		}
+		node := &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: machine.Status.Node},
+			Spec:       v1.NodeSpec{ProviderID: machine.Spec.ProviderID},
+			Status: v1.NodeStatus{
+				Conditions: []v1.NodeCondition{
+					{
+						Type:               v1.NodeReady,
+						Status:             v1.ConditionTrue,
+						LastHeartbeatTime:  metav1.Now(),
+						LastTransitionTime: metav1.Now(),
+						Reason:             "KubeletReady",
+					},
+				},
+			},
+		}
+		if _, err := c.Clientset.CoreV1().Nodes().Create(node); err != nil && !apierrors.IsAlreadyExists(err) {
+			return err
+		}
+	}
+	return nil
+}
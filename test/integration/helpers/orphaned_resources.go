@@ -1,34 +1,40 @@
 package helpers
 
 import (
+	"context"
+	"fmt"
+
+	providerDriver "github.com/gardener/machine-controller-manager-provider-aws/pkg/aws"
+	"github.com/gardener/machine-controller-manager-provider-aws/pkg/spi"
 	v1alpha1 "github.com/gardener/machine-controller-manager/pkg/apis/machine/v1alpha1"
 	v1 "k8s.io/api/core/v1"
 )
 
-// CheckForOrphanedResources will search the cloud provider for orphaned resources that are left behind after the test cases
-func CheckForOrphanedResources(machineClass *v1alpha1.MachineClass, secret *v1.Secret) error {
-	// Check for VM instances with matching tags/labels
-	// Describe volumes attached to VM instance & delete the volumes
-	// Finally delete the VM instance
-
-	clusterTag := "tag:kubernetes.io/cluster/shoot--mcm-test--tonia-aws"
-	clusterTagValue := "1"
-
-	err := DescribeInstancesWithTag("tag:mcmtest", "integration-test", machineClass, secret)
-	if err != nil {
-		return err
-	}
+// CheckForOrphanedResources will search the cloud provider for orphaned resources that are left behind after the test cases,
+// the machines are all machine objects that still exist
+func CheckForOrphanedResources(machineClass *v1alpha1.MachineClass, secret *v1.Secret, machines []*v1alpha1.Machine) error {
+	// Orphans are only reported, all of them regardless of their age as the test cases are finished
+	options := providerDriver.NewDriverOptions()
+	options.OrphanCollectionDryRun = true
+	options.OrphanGracePeriod = 0
 
-	// Check for available volumes in cloud provider with tag/label [Status:available]
-	err = DescribeAvailableVolumes(clusterTag, clusterTagValue, machineClass, secret)
+	driver := providerDriver.NewDriver(&spi.PluginSPIImpl{}, options)
+	response, err := driver.CollectOrphans(context.TODO(), &providerDriver.OrphanCollectionRequest{
+		MachineClass: machineClass,
+		Secret:       secret,
+		Machines:     machines,
+	})
 	if err != nil {
 		return err
 	}
 
-	// Check for available vpc and network interfaces in cloud provider with tag
-	err = AdditionalResourcesCheck(clusterTag, clusterTagValue)
-	if err != nil {
-		return err
+	if len(response.Orphans) == 0 {
+		fmt.Printf("\nNo orphaned resources are available.")
+	} else {
+		fmt.Printf("\nOrphaned resources: ")
+		for _, orphan := range response.Orphans {
+			fmt.Printf("\n%s %s of machine %s, %s old", orphan.ResourceType, orphan.ID, orphan.MachineName, orphan.Age)
+		}
 	}
 
 	return nil
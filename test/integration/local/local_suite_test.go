@@ -0,0 +1,18 @@
+package local_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/gardener/machine-controller-manager-provider-aws/test/integration/helpers"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestLocal(t *testing.T) {
+	if os.Getenv(helpers.KubebuilderAssetsEnv) == "" {
+		t.Skipf("%s isn't set, skipping the local integration tests, run them with make test-integration-local", helpers.KubebuilderAssetsEnv)
+	}
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Local Controller Suite")
+}
@@ -0,0 +1,314 @@
+/**
+	Overview
+		- Tests the provider specific Machine Controller together with the Machine Controller Manager
+		  without a cloud provider or real clusters
+	Prerequisites
+		- KUBEBUILDER_ASSETS points to the etcd and kube-apiserver binaries distributed for envtest
+		  (Kubernetes 1.19 or older)
+	BeforeSuite
+		- Start etcd and kube-apiserver locally with envtest, the cluster acts as control and target cluster
+		- Install the CRDs of the Machine Controller Manager module version in go.mod
+		- Serve an in-memory EC2 fake over HTTP
+		- Apply the machine class and its secret
+		- Start the Machine Controller in-process with the AWS driver pointed at the EC2 fake
+		- Build and start the Machine Controller Manager
+		- Register a ready node for every created VM in place of the kubelets
+	AfterSuite
+		- Stop the Machine Controller Manager, the EC2 fake and the control plane
+
+	Test: Machine
+		- The machine reaches Running and its VM is terminated once it is deleted
+	Test: MachineSet
+		- The machines of a machine set reach Running and are cleaned up with the machine set
+	Test: MachineDeployment
+		- The machines of a machine deployment reach Running and are cleaned up with the machine deployment
+**/
+
+package local_test
+
+import (
+	"encoding/json"
+	"log"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	mcaws "github.com/gardener/machine-controller-manager-provider-aws/pkg/aws"
+	api "github.com/gardener/machine-controller-manager-provider-aws/pkg/aws/apis"
+	"github.com/gardener/machine-controller-manager-provider-aws/pkg/fakeec2"
+	"github.com/gardener/machine-controller-manager-provider-aws/pkg/spi"
+	"github.com/gardener/machine-controller-manager-provider-aws/test/integration/helpers"
+	v1alpha1 "github.com/gardener/machine-controller-manager/pkg/apis/machine/v1alpha1"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/app"
+	"github.com/gardener/machine-controller-manager/pkg/util/provider/app/options"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	namespace          = "default"
+	repoPath           = "../../.."
+	mcmModule          = "github.com/gardener/machine-controller-manager"
+	mcmPackage         = mcmModule + "/cmd/machine-controller-manager"
+	eventuallyTimeout  = 2 * time.Minute
+	eventuallyInterval = time.Second
+)
+
+var (
+	controlPlane *helpers.LocalControlPlane
+	cluster      *helpers.Cluster
+	fake         *fakeec2.EC2
+	ec2Server    *httptest.Server
+	mcmCommand   *exec.Cmd
+	stopJoining  = make(chan struct{})
+)
+
+var _ = BeforeSuite(func() {
+	log.SetOutput(GinkgoWriter)
+
+	By("Starting the local control plane with the CRDs")
+	crdDirectory, err := mcmCRDDirectory()
+	Expect(err).ToNot(HaveOccurred())
+	controlPlane = helpers.NewLocalControlPlane(crdDirectory)
+	controlPlane.Output = GinkgoWriter
+	Expect(controlPlane.Start()).To(Succeed())
+	cluster, err = helpers.NewCluster(controlPlane.KubeconfigPath)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(cluster.FillClientSets()).To(Succeed())
+
+	By("Applying the machine class and its secret")
+	Expect(cluster.ApplyYamlFile(filepath.Join(repoPath, "kubernetes", "machine-class.yaml"))).To(Succeed())
+	machineClasses, err := cluster.McmClient.MachineV1alpha1().MachineClasses(namespace).List(metav1.ListOptions{})
+	Expect(err).ToNot(HaveOccurred())
+	Expect(machineClasses.Items).To(HaveLen(1))
+	machineClass := machineClasses.Items[0]
+	_, err = cluster.Clientset.CoreV1().Secrets(machineClass.SecretRef.Namespace).Create(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: machineClass.SecretRef.Name, Namespace: machineClass.SecretRef.Namespace},
+		Data: map[string][]byte{
+			api.AWSAccessKeyID:     []byte("dummy-id"),
+			api.AWSSecretAccessKey: []byte("dummy-secret"),
+			"userData":             []byte("dummy-user-data"),
+		},
+	})
+	Expect(err).ToNot(HaveOccurred())
+
+	By("Serving the EC2 fake")
+	var providerSpec api.AWSProviderSpec
+	Expect(json.Unmarshal(machineClass.ProviderSpec.Raw, &providerSpec)).To(Succeed())
+	fake = fakeec2.New(fakeec2.WithRegion(providerSpec.Region))
+	fake.AddImage(&ec2.Image{ImageId: aws.String(providerSpec.AMI)})
+	ec2Server = httptest.NewServer(fakeec2.NewServer(fake))
+
+	By("Starting the Machine Controller")
+	Expect(startMachineController()).To(Succeed())
+
+	By("Starting the Machine Controller Manager")
+	Expect(startMachineControllerManager()).To(Succeed())
+
+	go wait.Until(func() {
+		if err := cluster.JoinMachines(namespace); err != nil {
+			log.Printf("Failed to join machines: %v", err)
+		}
+	}, eventuallyInterval, stopJoining)
+})
+
+var _ = AfterSuite(func() {
+	close(stopJoining)
+	if mcmCommand != nil {
+		Expect(mcmCommand.Process.Kill()).To(Succeed())
+		_ = mcmCommand.Wait()
+	}
+	if ec2Server != nil {
+		ec2Server.Close()
+	}
+	if controlPlane != nil {
+		Expect(controlPlane.Stop()).To(Succeed())
+	}
+})
+
+var _ = Describe("Machine Controller", func() {
+
+	Describe("Machine", func() {
+		It("should create a running machine and clean it up", func() {
+			Expect(cluster.ApplyYamlFile(filepath.Join(repoPath, "kubernetes", "machine.yaml"))).To(Succeed())
+			Eventually(runningMachines(labels.Everything()), eventuallyTimeout, eventuallyInterval).Should(Equal(1))
+			Expect(liveInstances()).To(Equal(1))
+
+			Expect(cluster.McmClient.MachineV1alpha1().Machines(namespace).Delete("test1-machine1", &metav1.DeleteOptions{})).To(Succeed())
+			expectCleanedUp()
+		})
+	})
+
+	Describe("MachineSet", func() {
+		It("should create running machines and clean them up", func() {
+			machineLabels := map[string]string{"test-label": "test-machine-set"}
+			_, err := cluster.McmClient.MachineV1alpha1().MachineSets(namespace).Create(&v1alpha1.MachineSet{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-machine-set", Namespace: namespace},
+				Spec: v1alpha1.MachineSetSpec{
+					Replicas: 2,
+					Selector: &metav1.LabelSelector{MatchLabels: machineLabels},
+					Template: v1alpha1.MachineTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{Labels: machineLabels},
+						Spec: v1alpha1.MachineSpec{
+							Class: v1alpha1.ClassSpec{Kind: "MachineClass", Name: "test-mc"},
+						},
+					},
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(runningMachines(labels.SelectorFromSet(machineLabels)), eventuallyTimeout, eventuallyInterval).Should(Equal(2))
+			Eventually(func() (int32, error) {
+				machineSet, err := cluster.McmClient.MachineV1alpha1().MachineSets(namespace).Get("test-machine-set", metav1.GetOptions{})
+				if err != nil {
+					return 0, err
+				}
+				return machineSet.Status.ReadyReplicas, nil
+			}, eventuallyTimeout, eventuallyInterval).Should(BeEquivalentTo(2))
+			Expect(liveInstances()).To(Equal(2))
+
+			Expect(cluster.McmClient.MachineV1alpha1().MachineSets(namespace).Delete("test-machine-set", &metav1.DeleteOptions{})).To(Succeed())
+			expectCleanedUp()
+		})
+	})
+
+	Describe("MachineDeployment", func() {
+		It("should create running machines and clean them up", func() {
+			Expect(cluster.ApplyYamlFile(filepath.Join(repoPath, "kubernetes", "machine-deployment.yaml"))).To(Succeed())
+			Eventually(runningMachines(labels.Everything()), eventuallyTimeout, eventuallyInterval).Should(Equal(3))
+			Eventually(func() (int32, error) {
+				machineDeployment, err := cluster.McmClient.MachineV1alpha1().MachineDeployments(namespace).Get("test-machine-deployment", metav1.GetOptions{})
+				if err != nil {
+					return 0, err
+				}
+				return machineDeployment.Status.ReadyReplicas, nil
+			}, eventuallyTimeout, eventuallyInterval).Should(BeEquivalentTo(3))
+			Expect(liveInstances()).To(Equal(3))
+
+			Expect(cluster.McmClient.MachineV1alpha1().MachineDeployments(namespace).Delete("test-machine-deployment", &metav1.DeleteOptions{})).To(Succeed())
+			expectCleanedUp()
+		})
+	})
+})
+
+// mcmCRDDirectory returns the directory of the CRDs of the machine controller manager module in the module cache.
+// It's the version the machine controller manager is built from, including the machine classes of all providers it
+// waits for.
+func mcmCRDDirectory() (string, error) {
+	list := exec.Command("go", "list", "-m", "-f", "{{.Dir}}", mcmModule)
+	list.Dir = repoPath
+	list.Env = append(os.Environ(), "GOFLAGS=-mod=mod")
+	list.Stderr = GinkgoWriter
+	output, err := list.Output()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(strings.TrimSpace(string(output)), "kubernetes", "crds"), nil
+}
+
+// startMachineController runs the machine controller of this repository in-process
+func startMachineController() error {
+	port, err := helpers.FreePort()
+	if err != nil {
+		return err
+	}
+	s := options.NewMCServer()
+	s.ControlKubeconfig = controlPlane.KubeconfigPath
+	s.TargetKubeconfig = controlPlane.KubeconfigPath
+	s.Namespace = namespace
+	s.Address = "127.0.0.1"
+	s.Port = int32(port)
+	s.LeaderElection.LeaderElect = false
+
+	driverOptions := mcaws.NewDriverOptions()
+	driverOptions.InstanceCacheTTL = 0
+	pluginSPI := &spi.PluginSPIImpl{EndpointOptions: spi.EndpointOptions{EC2Endpoint: ec2Server.URL}}
+	driver := mcaws.NewDriver(pluginSPI, driverOptions)
+
+	go func() {
+		defer GinkgoRecover()
+		// Run only returns if the options are invalid
+		Expect(app.Run(s, driver)).To(Succeed())
+	}()
+	return nil
+}
+
+// startMachineControllerManager builds the machine controller manager from the module cache and runs it.
+// It runs in its own process as it registers the same metrics as the machine controller.
+func startMachineControllerManager() error {
+	port, err := helpers.FreePort()
+	if err != nil {
+		return err
+	}
+	binary := filepath.Join(filepath.Dir(controlPlane.KubeconfigPath), "machine-controller-manager")
+	build := exec.Command("go", "build", "-o", binary, mcmPackage)
+	build.Dir = repoPath
+	build.Env = append(os.Environ(), "GOFLAGS=-mod=mod")
+	build.Stdout = GinkgoWriter
+	build.Stderr = GinkgoWriter
+	if err := build.Run(); err != nil {
+		return err
+	}
+
+	mcmCommand = exec.Command(binary,
+		"--control-kubeconfig="+controlPlane.KubeconfigPath,
+		"--target-kubeconfig="+controlPlane.KubeconfigPath,
+		"--namespace="+namespace,
+		"--address=127.0.0.1",
+		"--port="+strconv.Itoa(port),
+		"--leader-elect=false",
+	)
+	mcmCommand.Stdout = GinkgoWriter
+	mcmCommand.Stderr = GinkgoWriter
+	return mcmCommand.Start()
+}
+
+// runningMachines returns a function counting the machines with the given labels in the Running phase
+func runningMachines(selector labels.Selector) func() (int, error) {
+	return func() (int, error) {
+		machines, err := cluster.McmClient.MachineV1alpha1().Machines(namespace).List(metav1.ListOptions{LabelSelector: selector.String()})
+		if err != nil {
+			return 0, err
+		}
+		running := 0
+		for _, machine := range machines.Items {
+			if machine.Status.CurrentStatus.Phase == v1alpha1.MachineRunning {
+				running++
+			}
+		}
+		return running, nil
+	}
+}
+
+// liveInstances returns the number of instances of the EC2 fake that aren't terminated
+func liveInstances() int {
+	live := 0
+	for _, instance := range fake.Instances() {
+		if aws.StringValue(instance.State.Name) != ec2.InstanceStateNameTerminated {
+			live++
+		}
+	}
+	return live
+}
+
+// expectCleanedUp waits until all machines, their nodes and their instances are gone
+func expectCleanedUp() {
+	EventuallyWithOffset(1, func() (int, error) {
+		machines, err := cluster.McmClient.MachineV1alpha1().Machines(namespace).List(metav1.ListOptions{})
+		if err != nil {
+			return 0, err
+		}
+		return len(machines.Items), nil
+	}, eventuallyTimeout, eventuallyInterval).Should(BeZero())
+	EventuallyWithOffset(1, liveInstances, eventuallyTimeout, eventuallyInterval).Should(BeZero())
+	EventuallyWithOffset(1, cluster.NumberOfNodes, eventuallyTimeout, eventuallyInterval).Should(BeZero())
+}